@@ -5,4 +5,7 @@ go 1.24
 require (
 	github.com/lib/pq v1.10.9
 	github.com/mattn/go-sqlite3 v1.14.18
+	golang.org/x/net v0.38.0
 )
+
+require golang.org/x/text v0.23.0 // indirect
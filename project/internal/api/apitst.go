@@ -1,15 +1,31 @@
 package api
 
 import (
+	"bufio"
 	"bytes"
+	"compress/gzip"
+	"context"
 	"database/sql"
+	"encoding/base64"
+	"encoding/csv"
 	"encoding/json"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/aarushishahhh/linkwatch/project/internal/checker"
+	"github.com/aarushishahhh/linkwatch/project/internal/events"
 	"github.com/aarushishahhh/linkwatch/project/internal/models"
+	"github.com/aarushishahhh/linkwatch/project/internal/policy"
 	"github.com/aarushishahhh/linkwatch/project/internal/storage"
 
 	_ "github.com/mattn/go-sqlite3"
@@ -177,172 +193,2531 @@ func TestCreateTarget(t *testing.T) {
 	})
 }
 
+func TestCreateTargetWWW(t *testing.T) {
+	store := setupTestStore(t)
+	router := NewRouterWithConfig(store, Config{WWW: storage.WWWStrip})
+
+	post := func(body string) (int, models.CreateTargetResponse) {
+		req := httptest.NewRequest("POST", "/v1/targets", bytes.NewBufferString(body))
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+
+		var response models.CreateTargetResponse
+		json.Unmarshal(rec.Body.Bytes(), &response)
+		return rec.Code, response
+	}
+
+	code1, first := post(`{"url": "https://www.example.com/docs"}`)
+	code2, second := post(`{"url": "https://example.com/docs"}`)
+
+	if code1 != http.StatusCreated || code2 != http.StatusOK {
+		t.Errorf("expected 201 then 200, got %d then %d", code1, code2)
+	}
+
+	if first.ID != second.ID {
+		t.Error("expected www and non-www to register as one target")
+	}
+
+	// Per-submission override keeps www distinct
+	code3, third := post(`{"url": "https://www.example.com/docs/", "www": "keep"}`)
+	if code3 != http.StatusCreated || third.ID == first.ID {
+		t.Errorf("expected override to create a separate target, got status %d", code3)
+	}
+
+	code4, _ := post(`{"url": "https://example.com", "www": "sometimes"}`)
+	if code4 != http.StatusBadRequest {
+		t.Errorf("expected status %d for invalid www mode, got %d", http.StatusBadRequest, code4)
+	}
+}
+
+func TestCreateTargetBlocklist(t *testing.T) {
+	store := setupTestStore(t)
+	blocklist, err := policy.NewBlocklist([]string{"evil.example.com", "*.zip"})
+	if err != nil {
+		t.Fatalf("failed to build blocklist: %v", err)
+	}
+	router := NewRouterWithConfig(store, Config{Blocklist: blocklist})
+
+	tests := []struct {
+		url      string
+		expected int
+	}{
+		{"https://evil.example.com/login", http.StatusUnprocessableEntity}, // blocklisted domain
+		{"https://files.archive.zip/", http.StatusUnprocessableEntity},     // blocklisted TLD wildcard
+		{"https://example.com/", http.StatusCreated},                       // permitted host
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/v1/targets", bytes.NewBufferString(`{"url": "`+tt.url+`"}`))
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+
+			if rec.Code != tt.expected {
+				t.Errorf("expected status %d, got %d", tt.expected, rec.Code)
+			}
+		})
+	}
+}
+
+func TestCreateTargetBlockPrivate(t *testing.T) {
+	store := setupTestStore(t)
+	router := NewRouterWithConfig(store, Config{BlockPrivate: true})
+
+	tests := []struct {
+		url      string
+		expected int
+	}{
+		{"http://127.0.0.1:8080/", http.StatusBadRequest},
+		{"http://169.254.169.254/latest/meta-data", http.StatusBadRequest},
+		{"http://10.0.0.5", http.StatusBadRequest},
+		{"http://[::1]/", http.StatusBadRequest},
+		{"http://93.184.216.34", http.StatusCreated},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			body, _ := json.Marshal(models.CreateTargetRequest{URL: tt.url})
+			req := httptest.NewRequest("POST", "/v1/targets", bytes.NewBuffer(body))
+			req.Header.Set("Content-Type", "application/json")
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+
+			if rec.Code != tt.expected {
+				t.Errorf("expected status %d, got %d: %s", tt.expected, rec.Code, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestCreateTargetHeaders(t *testing.T) {
+	store := setupTestStore(t)
+	router := NewRouter(store)
+
+	create := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/v1/targets", bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := create(`{"url": "https://example.com", "headers": {"authorization": "Bearer s3cret", "x-env": "prod"}}`)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusCreated, rec.Code, rec.Body.String())
+	}
+	if strings.Contains(rec.Body.String(), "s3cret") {
+		t.Errorf("expected the Authorization value to be redacted, got %s", rec.Body.String())
+	}
+
+	var response models.CreateTargetResponse
+	json.Unmarshal(rec.Body.Bytes(), &response)
+	if response.Headers["Authorization"] != models.Redacted || response.Headers["X-Env"] != "prod" {
+		t.Errorf("expected redacted Authorization and plain X-Env, got %v", response.Headers)
+	}
+
+	// The real value is kept for checks
+	target, err := store.GetTarget(context.Background(), response.ID)
+	if err != nil || target == nil {
+		t.Fatalf("failed to get target: %v", err)
+	}
+	if target.Headers["Authorization"] != "Bearer s3cret" {
+		t.Errorf("expected stored Authorization value, got %q", target.Headers["Authorization"])
+	}
+
+	for _, body := range []string{
+		`{"url": "https://example.org", "headers": {"Bad Name": "x"}}`,
+		`{"url": "https://example.org", "headers": {"X-Test": "a\r\nInjected: 1"}}`,
+		`{"url": "https://example.org", "headers": {"Host": "internal"}}`,
+	} {
+		if rec := create(body); rec.Code != http.StatusBadRequest {
+			t.Errorf("expected status %d for %s, got %d", http.StatusBadRequest, body, rec.Code)
+		}
+	}
+}
+
+func TestCreateTargetLimit(t *testing.T) {
+	store := setupTestStore(t)
+	store.SetMaxTargets(1)
+	router := NewRouter(store)
+
+	do := func(path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", path, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := do("/v1/targets", `{"url": "https://example.com"}`); rec.Code != http.StatusCreated {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusCreated, rec.Code, rec.Body.String())
+	}
+	if rec := do("/v1/targets", `{"url": "https://example.com"}`); rec.Code != http.StatusOK {
+		t.Errorf("expected a duplicate create to succeed with %d, got %d", http.StatusOK, rec.Code)
+	}
+
+	rec := do("/v1/targets", `{"url": "https://example.org"}`)
+	if rec.Code != http.StatusForbidden || !strings.Contains(rec.Body.String(), string(CodeTargetLimit)) {
+		t.Errorf("expected 403 %s, got %d: %s", CodeTargetLimit, rec.Code, rec.Body.String())
+	}
+
+	// A batch creates what fits and fails only the URLs past the limit
+	rec = do("/v1/targets:batchCreate", `{"urls": ["https://example.org", "https://example.com"]}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+	var resp models.BatchCreateTargetsResponse
+	json.NewDecoder(rec.Body).Decode(&resp)
+	if resp.Failed != 1 || resp.Existing != 1 || resp.Created != 0 {
+		t.Errorf("expected 1 failed and 1 existing, got %+v", resp)
+	}
+	if item := resp.Items[0]; item.Status != models.BatchItemFailed || item.Code != string(CodeTargetLimit) {
+		t.Errorf("expected %s for the new URL, got %+v", CodeTargetLimit, item)
+	}
+	if item := resp.Items[1]; item.Status != models.BatchItemExisting || item.ID == "" {
+		t.Errorf("expected the existing target, got %+v", item)
+	}
+}
+
+func TestCreateTargetBasicAuth(t *testing.T) {
+	store := setupTestStore(t)
+	router := NewRouter(store)
+
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := do("POST", "/v1/targets", `{"url": "https://example.com", "username": "monitor", "password": "s3cret"}`)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusCreated, rec.Code, rec.Body.String())
+	}
+	var response map[string]interface{}
+	json.Unmarshal(rec.Body.Bytes(), &response)
+	if response["username"] != "monitor" || response["password"] != models.Redacted {
+		t.Errorf("expected the username and a redacted password, got %s", rec.Body.String())
+	}
+
+	for _, path := range []string{"/v1/targets", "/v1/targets?format=ndjson"} {
+		if rec := do("GET", path, ""); strings.Contains(rec.Body.String(), "s3cret") {
+			t.Errorf("expected %s not to show the password, got %s", path, rec.Body.String())
+		}
+	}
+
+	for _, body := range []string{
+		`{"url": "https://example.org", "password": "s3cret"}`,
+		`{"url": "https://example.org", "username": ""}`,
+		`{"url": "https://example.org", "username": "a:b", "password": "s3cret"}`,
+		`{"url": "https://example.org", "username": "monitor", "headers": {"Authorization": "Bearer x"}}`,
+	} {
+		if rec := do("POST", "/v1/targets", body); rec.Code != http.StatusBadRequest {
+			t.Errorf("expected status %d for %s, got %d", http.StatusBadRequest, body, rec.Code)
+		}
+	}
+}
+
+func TestCreateTargetBodyAssertions(t *testing.T) {
+	store := setupTestStore(t)
+	router := NewRouter(store)
+
+	create := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/v1/targets", bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := create(`{"url": "https://example.com", "expected_body": "Welcome", "body_regex": "v[0-9]+\\.[0-9]+"}`)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusCreated, rec.Code, rec.Body.String())
+	}
+
+	var response models.CreateTargetResponse
+	json.Unmarshal(rec.Body.Bytes(), &response)
+	target, err := store.GetTarget(context.Background(), response.ID)
+	if err != nil || target == nil {
+		t.Fatalf("failed to get target: %v", err)
+	}
+	if target.ExpectedBody == nil || *target.ExpectedBody != "Welcome" {
+		t.Errorf("expected stored expected_body, got %v", target.ExpectedBody)
+	}
+	if target.BodyRegex == nil || *target.BodyRegex != `v[0-9]+\.[0-9]+` {
+		t.Errorf("expected stored body_regex, got %v", target.BodyRegex)
+	}
+
+	for _, body := range []string{
+		`{"url": "https://example.org", "expected_body": ""}`,
+		`{"url": "https://example.org", "body_regex": "("}`,
+	} {
+		if rec := create(body); rec.Code != http.StatusBadRequest {
+			t.Errorf("expected status %d for %s, got %d", http.StatusBadRequest, body, rec.Code)
+		}
+	}
+}
+
+func TestCreateTargetMaintenanceWindows(t *testing.T) {
+	store := setupTestStore(t)
+	router := NewRouter(store)
+
+	create := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/v1/targets", bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := create(`{"url": "https://example.com", "maintenance_windows": [{"start": "23:00", "end": "01:00", "days": ["sat", "sun"]}]}`)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusCreated, rec.Code, rec.Body.String())
+	}
+
+	var response models.CreateTargetResponse
+	json.Unmarshal(rec.Body.Bytes(), &response)
+	if len(response.MaintenanceWindows) != 1 {
+		t.Fatalf("expected the window in the response, got %+v", response.MaintenanceWindows)
+	}
+	target, err := store.GetTarget(context.Background(), response.ID)
+	if err != nil || target == nil {
+		t.Fatalf("failed to get target: %v", err)
+	}
+	if fmt.Sprint(target.MaintenanceWindows) != "[{23:00 01:00 [sat sun]}]" {
+		t.Errorf("expected stored maintenance windows, got %+v", target.MaintenanceWindows)
+	}
+
+	for _, body := range []string{
+		`{"url": "https://example.org", "maintenance_windows": [{"start": "25:00", "end": "01:00"}]}`,
+		`{"url": "https://example.org", "maintenance_windows": [{"start": "01:00", "end": "01:00"}]}`,
+		`{"url": "https://example.org", "maintenance_windows": [{"start": "01:00", "end": "02:00", "days": ["someday"]}]}`,
+	} {
+		rec := create(body)
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("expected status %d for %s, got %d", http.StatusBadRequest, body, rec.Code)
+		}
+		if !strings.Contains(rec.Body.String(), "maintenance_windows[0]") {
+			t.Errorf("expected the error to name the window, got %s", rec.Body.String())
+		}
+	}
+}
+
+func TestCreateTargetIPVersion(t *testing.T) {
+	store := setupTestStore(t)
+	router := NewRouter(store)
+
+	create := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/v1/targets", bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := create(`{"url": "https://example.com", "ip_version": "v6"}`)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusCreated, rec.Code, rec.Body.String())
+	}
+	var response models.CreateTargetResponse
+	json.Unmarshal(rec.Body.Bytes(), &response)
+	target, err := store.GetTarget(context.Background(), response.ID)
+	if err != nil || target == nil {
+		t.Fatalf("failed to get target: %v", err)
+	}
+	if target.IPVersion == nil || *target.IPVersion != models.IPVersionV6 {
+		t.Errorf("expected stored ip_version v6, got %v", target.IPVersion)
+	}
+
+	// The family a check used is kept with its result
+	v6 := models.IPVersionV6
+	store.SaveCheckResult(context.Background(), target.ID, models.CheckResult{CheckedAt: time.Now().UTC(), StatusCode: intPtr(200), IPVersion: &v6})
+	latest, err := store.GetLatestCheckResult(context.Background(), target.ID, true)
+	if err != nil || latest == nil || latest.IPVersion == nil || *latest.IPVersion != v6 {
+		t.Errorf("expected the result's ip_version to be saved, got %+v (%v)", latest, err)
+	}
+
+	for _, body := range []string{
+		`{"url": "https://example.org", "ip_version": "ipv4"}`,
+		`{"url": "https://example.org", "ip_version": ""}`,
+	} {
+		if rec := create(body); rec.Code != http.StatusBadRequest {
+			t.Errorf("expected status %d for %s, got %d", http.StatusBadRequest, body, rec.Code)
+		}
+	}
+}
+
+func TestCreateTargetInvalidHost(t *testing.T) {
+	store := setupTestStore(t)
+	router := NewRouter(store)
+
+	create := func(rawURL string) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(models.CreateTargetRequest{URL: rawURL})
+		req := httptest.NewRequest("POST", "/v1/targets", bytes.NewReader(body))
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	tests := []struct {
+		name string
+		url  string
+		code ErrorCode
+	}{
+		{"unclosed bracket", "http://[::1/", CodeInvalidURL},
+		{"empty host", "http:///path", CodeInvalidHost},
+		{"port only", "http://:8080/", CodeInvalidHost},
+		{"overlong host", "http://" + strings.Repeat("a.", 5000) + "com/", CodeInvalidHost},
+		{"overlong label", "http://" + strings.Repeat("a", 64) + ".com/", CodeInvalidHost},
+		{"empty label", "http://example..com/", CodeInvalidHost},
+		{"leading hyphen", "http://-example.com/", CodeInvalidHost},
+		{"bad character", "http://exa*mple.com/", CodeInvalidHost},
+		{"port zero", "http://example.com:0/", CodeInvalidHost},
+		{"port out of range", "http://example.com:70000/", CodeInvalidHost},
+		{"trailing hyphen", "http://example-.com/", CodeInvalidHost},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := create(tt.url)
+			if rec.Code != http.StatusBadRequest {
+				t.Fatalf("expected status %d, got %d: %s", http.StatusBadRequest, rec.Code, rec.Body.String())
+			}
+			var body map[string]string
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+				t.Fatalf("failed to unmarshal response: %v", err)
+			}
+			if body["code"] != string(tt.code) {
+				t.Errorf("expected code %s, got %q (%s)", tt.code, body["code"], body["error"])
+			}
+		})
+	}
+
+	for _, rawURL := range []string{
+		"http://example.com./",
+		"http://under_score.example.com/",
+		"http://127.0.0.1:8080/",
+		"http://[::1]:8080/",
+		"http://xn--bcher-kva.example/",
+	} {
+		if rec := create(rawURL); rec.Code != http.StatusCreated {
+			t.Errorf("expected %s to be accepted, got %d: %s", rawURL, rec.Code, rec.Body.String())
+		}
+	}
+
+	list, err := store.ListTargets(context.Background(), nil, 100, "")
+	if err != nil {
+		t.Fatalf("failed to list targets: %v", err)
+	}
+	if len(list.Items) != 5 {
+		t.Errorf("expected only the 5 valid targets to be stored, got %d", len(list.Items))
+	}
+}
+
+func TestErrorCodes(t *testing.T) {
+	store := setupTestStore(t)
+	router := NewRouter(store)
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		status int
+		code   ErrorCode
+	}{
+		{"malformed JSON", "POST", "/v1/targets", `{`, http.StatusBadRequest, CodeInvalidJSON},
+		{"missing url", "POST", "/v1/targets", `{}`, http.StatusBadRequest, CodeInvalidURL},
+		{"bad scheme", "POST", "/v1/targets", `{"url": "ftp://example.com"}`, http.StatusBadRequest, CodeInvalidURL},
+		{"bad field", "POST", "/v1/targets", `{"url": "https://example.com", "check_interval": "1ms"}`, http.StatusBadRequest, CodeInvalidRequest},
+		{"unknown target", "GET", "/v1/targets/t_missing/stats", "", http.StatusNotFound, CodeTargetNotFound},
+		{"bad parameter", "GET", "/v1/targets/t_missing/results?since=yesterday", "", http.StatusBadRequest, CodeInvalidParameter},
+		{"unknown profile", "GET", "/v1/profiles/p_missing", "", http.StatusNotFound, CodeProfileNotFound},
+		{"unknown job", "GET", "/v1/jobs/j_missing", "", http.StatusNotFound, CodeJobNotFound},
+		{"unknown route", "GET", "/v1/nothing", "", http.StatusNotFound, CodeNotFound},
+		{"wrong method", "PUT", "/v1/targets", "", http.StatusMethodNotAllowed, CodeMethodNotAllowed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, bytes.NewBufferString(tt.body))
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+
+			if rec.Code != tt.status {
+				t.Fatalf("expected status %d, got %d: %s", tt.status, rec.Code, rec.Body.String())
+			}
+			var body map[string]string
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+				t.Fatalf("failed to unmarshal response: %v", err)
+			}
+			if body["code"] != string(tt.code) {
+				t.Errorf("expected code %s, got %q", tt.code, body["code"])
+			}
+			if body["error"] == "" {
+				t.Error("expected a human-readable error message")
+			}
+		})
+	}
+
+	// Unmatched routes still go through the middleware
+	req := httptest.NewRequest("PUT", "/v1/targets", nil)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if allow := rec.Header().Get("Allow"); !strings.Contains(allow, "GET") || !strings.Contains(allow, "POST") {
+		t.Errorf("expected Allow to list the route's methods, got %q", allow)
+	}
+	if rec.Header().Get("Access-Control-Allow-Origin") == "" || rec.Header().Get(RequestIDHeader) == "" {
+		t.Error("expected CORS and request ID headers on unmatched routes")
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("expected a JSON content type, got %q", ct)
+	}
+}
+
+func TestExportsIgnoreRequestTimeout(t *testing.T) {
+	store := setupTestStore(t)
+	// Shorter than any export takes
+	router := NewRouterWithConfig(store, Config{RequestTimeout: time.Nanosecond})
+
+	var ids []string
+	for i := 0; i < 50; i++ {
+		url := fmt.Sprintf("https://example.com/%d", i)
+		target, _, err := store.CreateTarget(context.Background(), url, url, nil)
+		if err != nil {
+			t.Fatalf("failed to create target: %v", err)
+		}
+		ids = append(ids, target.ID)
+	}
+	var checks []storage.TargetCheck
+	for i := 0; i < 50; i++ {
+		checks = append(checks, storage.TargetCheck{TargetID: ids[0], Result: models.CheckResult{
+			CheckedAt: time.Now().UTC().Add(-time.Duration(i) * time.Minute), StatusCode: intPtr(200), LatencyMs: 40,
+		}})
+	}
+	if err := store.SaveCheckResults(context.Background(), checks); err != nil {
+		t.Fatalf("failed to save results: %v", err)
+	}
+
+	get := func(path, accept string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path, nil)
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	for _, tt := range []struct {
+		name   string
+		path   string
+		accept string
+		lines  int
+	}{
+		{"ndjson format", "/v1/targets?format=ndjson", "", 50},
+		{"ndjson accept", "/v1/targets", "application/x-ndjson", 50},
+		{"csv", "/v1/targets/" + ids[0] + "/results?format=csv", "", 51},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := get(tt.path, tt.accept)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+			}
+			if lines := strings.Count(rec.Body.String(), "\n"); lines != tt.lines {
+				t.Errorf("expected %d lines, got %d", tt.lines, lines)
+			}
+		})
+	}
+
+	// Paged listings are still held to the timeout
+	if rec := get("/v1/targets", ""); rec.Code == http.StatusOK {
+		t.Error("expected a paged listing to run out of time")
+	}
+}
+
+func TestRequestLimits(t *testing.T) {
+	store := setupTestStore(t)
+	router := NewRouterWithConfig(store, Config{RequestTimeout: 200 * time.Millisecond, MaxBodyBytes: 64})
+
+	t.Run("oversized body", func(t *testing.T) {
+		body := `{"url": "https://example.com/` + strings.Repeat("a", 100) + `"}`
+		req := httptest.NewRequest("POST", "/v1/targets", bytes.NewBufferString(body))
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusRequestEntityTooLarge {
+			t.Fatalf("expected status %d, got %d", http.StatusRequestEntityTooLarge, rec.Code)
+		}
+		var response map[string]string
+		json.Unmarshal(rec.Body.Bytes(), &response)
+		if response["code"] != string(CodeBodyTooLarge) {
+			t.Errorf("expected code %s, got %q", CodeBodyTooLarge, response["code"])
+		}
+	})
+
+	t.Run("body within limit", func(t *testing.T) {
+		req := httptest.NewRequest("POST", "/v1/targets", bytes.NewBufferString(`{"url": "https://example.com"}`))
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusCreated {
+			t.Fatalf("expected status %d, got %d: %s", http.StatusCreated, rec.Code, rec.Body.String())
+		}
+	})
+
+	t.Run("stalled body", func(t *testing.T) {
+		server := httptest.NewServer(router)
+		defer server.Close()
+
+		// Send part of the body and then stall
+		body, writer := io.Pipe()
+		defer writer.Close()
+		go writer.Write([]byte(`{"url": `))
+
+		start := time.Now()
+		resp, err := http.Post(server.URL+"/v1/targets", "application/json", body)
+		if err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("expected status %d, got %d", http.StatusBadRequest, resp.StatusCode)
+		}
+		if elapsed := time.Since(start); elapsed > 2*time.Second {
+			t.Errorf("expected the request to be cut off after the timeout, took %s", elapsed)
+		}
+	})
+}
+
+func TestRequestID(t *testing.T) {
+	var seen string
+	handler := withLogging(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = RequestIDFromContext(r.Context())
+	}))
+
+	tests := []struct {
+		name    string
+		inbound string
+		keep    bool
+	}{
+		{"propagated", "trace-abc-123", true},
+		{"generated", "", false},
+		{"control characters", "abc\x1b[31m", false},
+		{"too long", strings.Repeat("a", maxRequestIDLength+1), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/healthz", nil)
+			if tt.inbound != "" {
+				req.Header.Set(RequestIDHeader, tt.inbound)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			id := rec.Header().Get(RequestIDHeader)
+			if id == "" {
+				t.Fatal("expected a request ID on the response")
+			}
+			if seen != id {
+				t.Errorf("expected the context to carry %q, got %q", id, seen)
+			}
+			if tt.keep && id != tt.inbound {
+				t.Errorf("expected the inbound ID %q to be kept, got %q", tt.inbound, id)
+			}
+			if !tt.keep && !strings.HasPrefix(id, "req_") {
+				t.Errorf("expected a generated ID, got %q", id)
+			}
+		})
+	}
+
+	if id := RequestIDFromContext(context.Background()); id != "" {
+		t.Errorf("expected no request ID outside a request, got %q", id)
+	}
+}
+
+func TestBatchCreateTargets(t *testing.T) {
+	store := setupTestStore(t)
+	blocklist, err := policy.NewBlocklist([]string{"blocked.example"})
+	if err != nil {
+		t.Fatalf("failed to build blocklist: %v", err)
+	}
+	router := NewRouterWithConfig(store, Config{Blocklist: blocklist})
+
+	existing, _, _ := store.CreateTarget(context.Background(), "https://example.com/", "https://example.com/", nil)
+
+	post := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/v1/targets:batchCreate", strings.NewReader(body))
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := post(`{"urls": ["https://example.com/", "https://example.org/a", "HTTPS://EXAMPLE.ORG/a", "ftp://example.net", "https://blocked.example/"]}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+
+	var resp models.BatchCreateTargetsResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("failed to unmarshal response: %v", err)
+	}
+	if resp.Created != 1 || resp.Existing != 2 || resp.Failed != 2 || len(resp.Items) != 5 {
+		t.Fatalf("expected 1 created, 2 existing and 2 failed, got %+v", resp)
+	}
+
+	items := resp.Items
+	if items[0].Status != models.BatchItemExisting || items[0].ID != existing.ID {
+		t.Errorf("expected the first URL to match the existing target, got %+v", items[0])
+	}
+	if items[1].Status != models.BatchItemCreated || items[1].ID == "" || items[1].CreatedAt == nil {
+		t.Errorf("expected the second URL to be created, got %+v", items[1])
+	}
+	if items[2].Status != models.BatchItemExisting || items[2].ID != items[1].ID {
+		t.Errorf("expected an equivalent URL in the same batch to reuse the new target, got %+v", items[2])
+	}
+	if items[3].Status != models.BatchItemFailed || items[3].Code != string(CodeInvalidURL) || items[3].ID != "" {
+		t.Errorf("expected the ftp URL to fail with %s, got %+v", CodeInvalidURL, items[3])
+	}
+	if items[4].Status != models.BatchItemFailed || items[4].Code != string(CodeHostBlocked) {
+		t.Errorf("expected the blocklisted URL to fail with %s, got %+v", CodeHostBlocked, items[4])
+	}
+
+	if rec := post(`{"urls": []}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("expected status %d for an empty batch, got %d", http.StatusBadRequest, rec.Code)
+	}
+	if rec := post(`{"urls": "https://example.com"}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("expected status %d for invalid JSON, got %d", http.StatusBadRequest, rec.Code)
+	}
+}
+
+func TestAPIKeyAuth(t *testing.T) {
+	store := setupTestStore(t)
+	router := NewRouterWithConfig(store, Config{APIKeys: ParseAPIKeys(" key-one, ,key-two ")})
+
+	request := func(method, path, auth string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	tests := []struct {
+		name     string
+		auth     string
+		expected int
+	}{
+		{"no header", "", http.StatusUnauthorized},
+		{"wrong key", "Bearer key-three", http.StatusUnauthorized},
+		{"prefix of a key", "Bearer key", http.StatusUnauthorized},
+		{"not bearer", "Basic a2V5LW9uZQ==", http.StatusUnauthorized},
+		{"first key", "Bearer key-one", http.StatusOK},
+		{"second key", "bearer key-two", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := request("GET", "/v1/targets", tt.auth)
+			if rec.Code != tt.expected {
+				t.Fatalf("expected status %d, got %d", tt.expected, rec.Code)
+			}
+			if tt.expected == http.StatusUnauthorized {
+				var body map[string]string
+				json.Unmarshal(rec.Body.Bytes(), &body)
+				if body["code"] != string(CodeUnauthorized) {
+					t.Errorf("expected code %s, got %q", CodeUnauthorized, body["code"])
+				}
+				if rec.Header().Get("WWW-Authenticate") == "" {
+					t.Error("expected a WWW-Authenticate challenge")
+				}
+			}
+		})
+	}
+
+	if rec := request("GET", "/healthz", ""); rec.Code != http.StatusOK {
+		t.Errorf("expected /healthz to stay open, got %d", rec.Code)
+	}
+	if rec := request("GET", "/readyz", ""); rec.Code != http.StatusOK {
+		t.Errorf("expected /readyz to stay open, got %d", rec.Code)
+	}
+	if rec := request("GET", "/openapi.json", ""); rec.Code != http.StatusOK {
+		t.Errorf("expected /openapi.json to stay open, got %d", rec.Code)
+	}
+	if rec := request("OPTIONS", "/v1/targets", ""); rec.Code != http.StatusOK {
+		t.Errorf("expected CORS preflights to pass without a key, got %d", rec.Code)
+	}
+
+	open := NewRouterWithConfig(store, Config{APIKeys: ParseAPIKeys("")})
+	rec := httptest.NewRecorder()
+	open.ServeHTTP(rec, httptest.NewRequest("GET", "/v1/targets", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("expected the API to be open without keys, got %d", rec.Code)
+	}
+}
+
+func TestCreateRateLimit(t *testing.T) {
+	store := setupTestStore(t)
+	router := NewRouterWithConfig(store, Config{CreateRateLimit: 0.5, CreateRateBurst: 2})
+
+	create := func(remoteAddr, auth string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/v1/targets", strings.NewReader(`{"url": "https://example.com"}`))
+		req.RemoteAddr = remoteAddr
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	for i := 0; i < 2; i++ {
+		if rec := create("192.0.2.1:1234", ""); rec.Code == http.StatusTooManyRequests {
+			t.Fatalf("expected request %d within the burst to pass", i+1)
+		}
+	}
+	rec := create("192.0.2.1:5678", "")
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected status %d past the burst, got %d", http.StatusTooManyRequests, rec.Code)
+	}
+	if retry := rec.Header().Get("Retry-After"); retry != "2" {
+		t.Errorf("expected Retry-After 2, got %q", retry)
+	}
+
+	if rec := create("192.0.2.2:1234", ""); rec.Code == http.StatusTooManyRequests {
+		t.Error("expected another address to have its own limit")
+	}
+	// Without API keys nothing checks the token, so rotating it doesn't
+	// get a new bucket
+	for _, token := range []string{"Bearer a", "Bearer b", "Bearer c"} {
+		if rec := create("192.0.2.1:1234", token); rec.Code != http.StatusTooManyRequests {
+			t.Errorf("expected status %d with a made up token, got %d", http.StatusTooManyRequests, rec.Code)
+		}
+	}
+
+	req := httptest.NewRequest("GET", "/v1/targets", nil)
+	req.RemoteAddr = "192.0.2.1:1234"
+	get := httptest.NewRecorder()
+	router.ServeHTTP(get, req)
+	if get.Code != http.StatusOK {
+		t.Errorf("expected reads not to be limited, got %d", get.Code)
+	}
+
+	t.Run("api keys", func(t *testing.T) {
+		router = NewRouterWithConfig(store, Config{CreateRateLimit: 0.5, CreateRateBurst: 1, APIKeys: []string{"key-a", "key-b"}})
+
+		if rec := create("192.0.2.3:1234", "Bearer key-a"); rec.Code == http.StatusTooManyRequests {
+			t.Fatal("expected the first request to pass")
+		}
+		if rec := create("192.0.2.3:1234", "Bearer key-a"); rec.Code != http.StatusTooManyRequests {
+			t.Errorf("expected status %d past the burst, got %d", http.StatusTooManyRequests, rec.Code)
+		}
+		if rec := create("192.0.2.3:1234", "Bearer key-b"); rec.Code == http.StatusTooManyRequests {
+			t.Error("expected an API key to have its own limit")
+		}
+	})
+
+	// Tokens refill at the configured rate
+	limiter := newRateLimiter(1, 1, false)
+	now := time.Now()
+	limiter.now = func() time.Time { return now }
+	if ok, _ := limiter.allow("c"); !ok {
+		t.Fatal("expected the first request to pass")
+	}
+	if ok, wait := limiter.allow("c"); ok || wait != time.Second {
+		t.Fatalf("expected to wait 1s for a token, got ok=%t wait=%s", ok, wait)
+	}
+	now = now.Add(time.Second)
+	if ok, _ := limiter.allow("c"); !ok {
+		t.Error("expected a token after a second")
+	}
+	if newRateLimiter(0, 10, false) != nil {
+		t.Error("expected no limiter for a zero rate")
+	}
+}
+
+func TestCreateTargetDependsOn(t *testing.T) {
+	store := setupTestStore(t)
+	router := NewRouter(store)
+
+	gateway, _, _ := store.CreateTarget(context.Background(), "https://gateway.example.com", "https://gateway.example.com", nil)
+
+	t.Run("existing dependency", func(t *testing.T) {
+		reqBody := `{"url": "https://app.example.com", "depends_on": "` + gateway.ID + `"}`
+		req := httptest.NewRequest("POST", "/v1/targets", bytes.NewBufferString(reqBody))
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusCreated {
+			t.Fatalf("expected status %d, got %d", http.StatusCreated, rec.Code)
+		}
+
+		var response models.CreateTargetResponse
+		json.Unmarshal(rec.Body.Bytes(), &response)
+
+		if response.DependsOn == nil || *response.DependsOn != gateway.ID {
+			t.Errorf("expected depends_on %q, got %v", gateway.ID, response.DependsOn)
+		}
+	})
+
+	t.Run("unknown dependency", func(t *testing.T) {
+		reqBody := `{"url": "https://other.example.com", "depends_on": "t_missing"}`
+		req := httptest.NewRequest("POST", "/v1/targets", bytes.NewBufferString(reqBody))
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("expected status %d, got %d", http.StatusBadRequest, rec.Code)
+		}
+	})
+}
+
+func TestCreateTargetSuccessStatus(t *testing.T) {
+	store := setupTestStore(t)
+	router := NewRouter(store)
+
+	create := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/v1/targets", bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := create(`{"url": "https://teapot.example.com/", "success_status": "200, 418"}`)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusCreated, rec.Code, rec.Body.String())
+	}
+
+	var response models.CreateTargetResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+		t.Fatalf("failed to unmarshal response: %v", err)
+	}
+
+	if response.SuccessStatus == nil || *response.SuccessStatus != "200,418" {
+		t.Errorf("expected normalized success_status 200,418, got %v", response.SuccessStatus)
+	}
+
+	stored, err := store.GetTarget(context.Background(), response.ID)
+	if err != nil || stored == nil || stored.SuccessStatus == nil || *stored.SuccessStatus != "200,418" {
+		t.Errorf("expected success_status to be stored, got %+v (%v)", stored, err)
+	}
+
+	rec = create(`{"url": "https://other.example.com/", "success_status": "2xx"}`)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("expected status %d for invalid success_status, got %d", http.StatusBadRequest, rec.Code)
+	}
+}
+
+func TestCreateTargetCheckInterval(t *testing.T) {
+	store := setupTestStore(t)
+	router := NewRouter(store)
+
+	create := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/v1/targets", bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := create(`{"url": "https://interval.example.com/", "check_interval": "90s"}`)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusCreated, rec.Code, rec.Body.String())
+	}
+
+	var response models.CreateTargetResponse
+	json.Unmarshal(rec.Body.Bytes(), &response)
+	if response.CheckInterval == nil || *response.CheckInterval != "1m30s" {
+		t.Errorf("expected normalized check_interval 1m30s, got %v", response.CheckInterval)
+	}
+
+	for _, interval := range []string{"soon", "500ms", "-1m"} {
+		rec := create(`{"url": "https://other.example.com/", "check_interval": "` + interval + `"}`)
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("check_interval %q: expected status %d, got %d", interval, http.StatusBadRequest, rec.Code)
+		}
+	}
+}
+
+func TestUpdateTargetPaused(t *testing.T) {
+	store := setupTestStore(t)
+	router := NewRouter(store)
+
+	target, _, _ := store.CreateTarget(context.Background(), "https://example.com", "https://example.com", nil)
+
+	patch := func(id, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("PATCH", "/v1/targets/"+id, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	for _, paused := range []bool{true, false} {
+		rec := patch(target.ID, fmt.Sprintf(`{"paused": %t}`, paused))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("expected status %d, got %d", http.StatusOK, rec.Code)
+		}
+
+		var response models.Target
+		if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+			t.Fatalf("failed to unmarshal response: %v", err)
+		}
+		if response.Paused != paused {
+			t.Errorf("expected paused %t, got %t", paused, response.Paused)
+		}
+	}
+
+	if rec := patch("t_missing", `{"paused": true}`); rec.Code != http.StatusNotFound {
+		t.Errorf("expected status %d for missing target, got %d", http.StatusNotFound, rec.Code)
+	}
+	if rec := patch(target.ID, `{}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("expected status %d for empty update, got %d", http.StatusBadRequest, rec.Code)
+	}
+}
+
+func TestCheckTarget(t *testing.T) {
+	store := setupTestStore(t)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	chk := checker.New(store, checker.Config{Interval: time.Hour, MaxConcurrency: 1, HTTPTimeout: time.Second})
+	router := NewRouterWithConfig(store, Config{Checker: chk})
+
+	target, _, _ := store.CreateTarget(context.Background(), server.URL, server.URL, nil)
+
+	check := func(router http.Handler, id string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/v1/targets/"+id+"/check", nil)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := check(router, target.ID)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+
+	var result models.CheckResult
+	if err := json.Unmarshal(rec.Body.Bytes(), &result); err != nil {
+		t.Fatalf("failed to unmarshal response: %v", err)
+	}
+	if result.StatusCode == nil || *result.StatusCode != http.StatusOK {
+		t.Errorf("expected checked status 200, got %v", result.StatusCode)
+	}
+
+	if latest, err := store.GetLatestCheckResult(context.Background(), target.ID, true); err != nil || latest == nil {
+		t.Errorf("expected the manual check to be saved, got %v (error %v)", latest, err)
+	}
+
+	if rec := check(router, "t_missing"); rec.Code != http.StatusNotFound {
+		t.Errorf("expected status %d for missing target, got %d", http.StatusNotFound, rec.Code)
+	}
+	if rec := check(NewRouter(store), target.ID); rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected status %d without a checker, got %d", http.StatusServiceUnavailable, rec.Code)
+	}
+}
+
+func TestCreateTargetWakesChecker(t *testing.T) {
+	// A single connection keeps the in-memory database shared with the checker
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("failed to open test database: %v", err)
+	}
+	db.SetMaxOpenConns(1)
+	store := storage.New(db)
+	if err := store.Migrate(); err != nil {
+		t.Fatalf("failed to migrate test database: %v", err)
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	// With no targets the checker would sleep for the whole hour
+	chk := checker.New(store, checker.Config{Interval: time.Hour, MaxConcurrency: 1, HTTPTimeout: time.Second})
+	chk.Start(context.Background())
+	defer chk.Stop(context.Background())
+	router := NewRouterWithConfig(store, Config{Checker: chk})
+	for chk.Status().LastCycleCompletedAt == nil {
+		time.Sleep(time.Millisecond)
+	}
+
+	req := httptest.NewRequest("POST", "/v1/targets", bytes.NewBufferString(`{"url": "`+server.URL+`"}`))
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusCreated, rec.Code, rec.Body.String())
+	}
+	var created models.CreateTargetResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &created); err != nil {
+		t.Fatalf("failed to unmarshal response: %v", err)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		result, err := store.GetLatestCheckResult(context.Background(), created.ID, true)
+		if err != nil {
+			t.Fatalf("failed to get result: %v", err)
+		}
+		if result != nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expected the new target to be checked without waiting for the interval")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestDryRunCheck(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("failed to open test database: %v", err)
+	}
+	store := storage.New(db)
+	if err := store.Migrate(); err != nil {
+		t.Fatalf("failed to migrate test database: %v", err)
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	}))
+	defer server.Close()
+
+	chk := checker.New(store, checker.Config{Interval: time.Hour, MaxConcurrency: 1, HTTPTimeout: time.Second})
+	router := NewRouterWithConfig(store, Config{Checker: chk})
+
+	dryRun := func(router http.Handler, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/v1/check", bytes.NewBufferString(body))
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := dryRun(router, `{"url": "`+server.URL+`/health"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+	var result models.CheckResult
+	if err := json.Unmarshal(rec.Body.Bytes(), &result); err != nil {
+		t.Fatalf("failed to unmarshal response: %v", err)
+	}
+	if result.StatusCode == nil || *result.StatusCode != http.StatusTeapot {
+		t.Errorf("expected checked status 418, got %v", result.StatusCode)
+	}
+	if result.CheckedAt.IsZero() || result.FinalURL == nil || *result.FinalURL != server.URL+"/health" {
+		t.Errorf("expected a complete result, got %+v", result)
+	}
+
+	// Nothing is created or saved
+	list, err := store.ListTargets(context.Background(), nil, 10, "")
+	if err != nil {
+		t.Fatalf("failed to list targets: %v", err)
+	}
+	if len(list.Items) != 0 {
+		t.Errorf("expected no targets after a dry run, got %d", len(list.Items))
+	}
+	var saved int
+	if err := db.QueryRow("SELECT COUNT(*) FROM check_results").Scan(&saved); err != nil {
+		t.Fatalf("failed to count results: %v", err)
+	}
+	if saved != 0 {
+		t.Errorf("expected no saved results after a dry run, got %d", saved)
+	}
+
+	// URLs are validated like new targets
+	for body, code := range map[string]ErrorCode{
+		`{}`:                              CodeInvalidURL,
+		`{"url": "ftp://example.com"}`:    CodeInvalidURL,
+		`{"url": "http://exa*mple.com/"}`: CodeInvalidHost,
+	} {
+		rec := dryRun(router, body)
+		if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), string(code)) {
+			t.Errorf("expected 400 %s for %s, got %d: %s", code, body, rec.Code, rec.Body.String())
+		}
+	}
+
+	if rec := dryRun(NewRouter(store), `{"url": "`+server.URL+`"}`); rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected status %d without a checker, got %d", http.StatusServiceUnavailable, rec.Code)
+	}
+}
+
+func TestDebugChecker(t *testing.T) {
+	store := setupTestStore(t)
+	chk := checker.New(store, checker.Config{Interval: time.Hour, MaxConcurrency: 1, HTTPTimeout: time.Second})
+
+	get := func(router http.Handler) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/v1/debug/checker", nil)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := get(NewRouterWithConfig(store, Config{Checker: chk})); rec.Code != http.StatusNotFound {
+		t.Errorf("expected status %d without Debug, got %d", http.StatusNotFound, rec.Code)
+	}
+
+	rec := get(NewRouterWithConfig(store, Config{Checker: chk, Debug: true}))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+	var status models.CheckerStatus
+	if err := json.Unmarshal(rec.Body.Bytes(), &status); err != nil {
+		t.Fatalf("failed to unmarshal response: %v", err)
+	}
+	if status.CycleRunning || status.LastCycleCompletedAt != nil {
+		t.Errorf("expected no cycles yet, got %+v", status)
+	}
+
+	if rec := get(NewRouterWithConfig(store, Config{Debug: true})); rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected status %d without a checker, got %d", http.StatusServiceUnavailable, rec.Code)
+	}
+
+	// Gated by API keys like everything else
+	router := NewRouterWithConfig(store, Config{Checker: chk, Debug: true, APIKeys: []string{"secret"}})
+	if rec := get(router); rec.Code != http.StatusUnauthorized {
+		t.Errorf("expected status %d without a key, got %d", http.StatusUnauthorized, rec.Code)
+	}
+}
+
+func TestPauseChecks(t *testing.T) {
+	store := setupTestStore(t)
+	chk := checker.New(store, checker.Config{Interval: time.Hour, MaxConcurrency: 1, HTTPTimeout: time.Second})
+
+	post := func(router http.Handler, path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", path, nil)
+		req.Header.Set("Authorization", "Bearer secret")
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+	paused := func(rec *httptest.ResponseRecorder) bool {
+		t.Helper()
+		if rec.Code != http.StatusOK {
+			t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+		}
+		var response models.PauseResponse
+		if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+			t.Fatalf("failed to unmarshal response: %v", err)
+		}
+		return response.Paused
+	}
+
+	router := NewRouterWithConfig(store, Config{Checker: chk, APIKeys: []string{"secret"}})
+	if !paused(post(router, "/v1/admin/pause")) || !chk.Paused() {
+		t.Error("expected checks to be paused")
+	}
+	// Pausing twice is harmless
+	if !paused(post(router, "/v1/admin/pause")) {
+		t.Error("expected checks to stay paused")
+	}
+	if paused(post(router, "/v1/admin/resume")) || chk.Paused() {
+		t.Error("expected checks to be resumed")
+	}
+
+	if rec := post(NewRouterWithConfig(store, Config{APIKeys: []string{"secret"}}), "/v1/admin/pause"); rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected status %d without a checker, got %d", http.StatusServiceUnavailable, rec.Code)
+	}
+
+	req := httptest.NewRequest("POST", "/v1/admin/pause", nil)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnauthorized || chk.Paused() {
+		t.Errorf("expected status %d without a key and checks left running, got %d", http.StatusUnauthorized, rec.Code)
+	}
+
+	// Without API keys anyone could stop monitoring, so admin endpoints
+	// aren't served at all
+	open := NewRouterWithConfig(store, Config{Checker: chk})
+	for _, path := range []string{"/v1/admin/pause", "/v1/admin/resume"} {
+		rec := post(open, path)
+		if rec.Code != http.StatusForbidden || !strings.Contains(rec.Body.String(), string(CodeAdminDisabled)) {
+			t.Errorf("expected status %d %s for %s without API keys, got %d", http.StatusForbidden, CodeAdminDisabled, path, rec.Code)
+		}
+	}
+	if chk.Paused() {
+		t.Error("expected checks to be left running")
+	}
+}
+
+func TestProfiles(t *testing.T) {
+	store := setupTestStore(t)
+	router := NewRouter(store)
+
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := do("POST", "/v1/profiles", `{"name": "strict", "success_status": "200", "timeout_ms": 500}`)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusCreated, rec.Code, rec.Body.String())
+	}
+
+	var profile models.Profile
+	if err := json.Unmarshal(rec.Body.Bytes(), &profile); err != nil {
+		t.Fatalf("failed to unmarshal profile: %v", err)
+	}
+
+	rec = do("POST", "/v1/targets", `{"url": "https://profiled.example.com/", "profile_id": "`+profile.ID+`", "timeout_ms": 2000}`)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusCreated, rec.Code, rec.Body.String())
+	}
+
+	var created models.CreateTargetResponse
+	json.Unmarshal(rec.Body.Bytes(), &created)
+	target, _ := store.GetTarget(context.Background(), created.ID)
+
+	rec = do("PUT", "/v1/profiles/"+profile.ID, `{"name": "lenient", "success_status": "200-299,418", "timeout_ms": 500}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+
+	// The target inherits the edited status ranges but keeps its own timeout
+	resolved, err := store.ResolveTarget(context.Background(), *target)
+	if err != nil {
+		t.Fatalf("failed to resolve target: %v", err)
+	}
+	if resolved.SuccessStatus == nil || *resolved.SuccessStatus != "200-299,418" {
+		t.Errorf("expected inherited success_status, got %v", resolved.SuccessStatus)
+	}
+	if resolved.TimeoutMs == nil || *resolved.TimeoutMs != 2000 {
+		t.Errorf("expected the target's own timeout_ms to win, got %v", resolved.TimeoutMs)
+	}
+
+	rec = do("GET", "/v1/profiles", "")
+	var list models.ProfileList
+	json.Unmarshal(rec.Body.Bytes(), &list)
+	if len(list.Items) != 1 || list.Items[0].Name != "lenient" {
+		t.Errorf("unexpected profile list: %+v", list)
+	}
+
+	if rec := do("DELETE", "/v1/profiles/"+profile.ID, ""); rec.Code != http.StatusNoContent {
+		t.Fatalf("expected status %d, got %d", http.StatusNoContent, rec.Code)
+	}
+	if rec := do("GET", "/v1/profiles/"+profile.ID, ""); rec.Code != http.StatusNotFound {
+		t.Errorf("expected status %d after delete, got %d", http.StatusNotFound, rec.Code)
+	}
+
+	// Deleting detaches the profile from its targets
+	target, _ = store.GetTarget(context.Background(), created.ID)
+	if target.ProfileID != nil {
+		t.Errorf("expected profile_id to be cleared, got %v", *target.ProfileID)
+	}
+
+	for _, tt := range []struct{ method, path, body string }{
+		{"POST", "/v1/profiles", `{"success_status": "200"}`},
+		{"POST", "/v1/profiles", `{"name": "bad", "timeout_ms": 0}`},
+		{"POST", "/v1/targets", `{"url": "https://other.example.com/", "profile_id": "p_missing"}`},
+	} {
+		if rec := do(tt.method, tt.path, tt.body); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s %s %s: expected status %d, got %d", tt.method, tt.path, tt.body, http.StatusBadRequest, rec.Code)
+		}
+	}
+}
+
+func TestCreateTargetIfNotExists(t *testing.T) {
+	store := setupTestStore(t)
+	router := NewRouter(store)
+
+	create := func(query string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/v1/targets"+query, bytes.NewBufferString(`{"url": "https://unique.example.com/page"}`))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := create("?if_not_exists=true")
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("expected status %d for new target, got %d", http.StatusCreated, rec.Code)
+	}
+
+	rec = create("?if_not_exists=true")
+	if rec.Code != http.StatusConflict {
+		t.Fatalf("expected status %d for duplicate under if_not_exists, got %d", http.StatusConflict, rec.Code)
+	}
+
+	var body map[string]string
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("failed to unmarshal response: %v", err)
+	}
+	if body["error"] != "target already exists" {
+		t.Errorf("unexpected error message %q", body["error"])
+	}
+	if body["code"] != string(CodeTargetExists) {
+		t.Errorf("expected code %s, got %q", CodeTargetExists, body["code"])
+	}
+
+	// Without the flag duplicates still return the existing target
+	rec = create("")
+	if rec.Code != http.StatusOK {
+		t.Errorf("expected status %d for duplicate without flag, got %d", http.StatusOK, rec.Code)
+	}
+}
+
+func TestCreateTargetAsync(t *testing.T) {
+	store := setupTestStore(t)
+	router := NewRouter(store)
+
+	reqBody := `{"url": "https://async.example.com"}`
+	req := httptest.NewRequest("POST", "/v1/targets", bytes.NewBufferString(reqBody))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Prefer", "respond-async")
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("expected status %d, got %d", http.StatusAccepted, rec.Code)
+	}
+
+	location := rec.Header().Get("Location")
+	if !strings.HasPrefix(location, "/v1/jobs/") {
+		t.Fatalf("expected Location pointing at a job, got %q", location)
+	}
+
+	// Poll the job until it completes
+	var job models.Job
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		req := httptest.NewRequest("GET", location, nil)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusOK {
+			t.Fatalf("expected status %d for job, got %d", http.StatusOK, rec.Code)
+		}
+
+		if err := json.Unmarshal(rec.Body.Bytes(), &job); err != nil {
+			t.Fatalf("failed to unmarshal job: %v", err)
+		}
+
+		if job.Status != models.JobStatusPending {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if job.Status != models.JobStatusSucceeded {
+		t.Fatalf("expected job to succeed, got status %q", job.Status)
+	}
+
+	if job.TargetID == nil {
+		t.Fatal("expected job to report the created target id")
+	}
+
+	target, err := store.GetTarget(context.Background(), *job.TargetID)
+	if err != nil || target == nil {
+		t.Fatalf("expected target %q to exist, err %v", *job.TargetID, err)
+	}
+
+	if target.URL != "https://async.example.com" {
+		t.Errorf("expected URL %q, got %q", "https://async.example.com", target.URL)
+	}
+}
+
+func TestGetJobNotFound(t *testing.T) {
+	store := setupTestStore(t)
+	router := NewRouter(store)
+
+	req := httptest.NewRequest("GET", "/v1/jobs/job_missing", nil)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("expected status %d, got %d", http.StatusNotFound, rec.Code)
+	}
+}
+
+func TestJobStoreExpiry(t *testing.T) {
+	jobs := newJobStore()
+	now := time.Date(2025, 8, 17, 12, 0, 0, 0, time.UTC)
+	jobs.now = func() time.Time { return now }
+
+	done := jobs.create()
+	pending := jobs.create()
+	if done.ID == pending.ID || !strings.HasPrefix(done.ID, "job_") {
+		t.Fatalf("expected distinct job IDs, got %q and %q", done.ID, pending.ID)
+	}
+	jobs.complete(done.ID, "t_1", nil)
+
+	now = now.Add(jobTTL - time.Second)
+	if _, ok := jobs.get(done.ID); !ok {
+		t.Error("expected a completed job to be kept within the TTL")
+	}
+
+	now = now.Add(time.Second)
+	if _, ok := jobs.get(done.ID); ok {
+		t.Error("expected a completed job to expire after the TTL")
+	}
+	if _, ok := jobs.get(pending.ID); !ok {
+		t.Error("expected a pending job not to expire")
+	}
+
+	// The next create sweeps expired jobs out of memory
+	jobs.create()
+	if _, ok := jobs.jobs[done.ID]; ok {
+		t.Error("expected the expired job to be dropped")
+	}
+	if len(jobs.jobs) != 2 {
+		t.Errorf("expected 2 jobs left, got %d", len(jobs.jobs))
+	}
+}
+
 func TestListTargets(t *testing.T) {
 	store := setupTestStore(t)
 	router := NewRouter(store)
 
-	// Create test targets
-	urls := []string{
-		"https://example.com",
-		"https://test.com",
-		"https://example.org",
+	// Create test targets
+	urls := []string{
+		"https://example.com",
+		"https://test.com",
+		"https://example.org",
+	}
+
+	for _, url := range urls {
+		canonical, _ := storage.CanonicalizeURL(url)
+		store.CreateTarget(context.Background(), url, canonical, nil)
+		time.Sleep(1 * time.Millisecond) // Ensure different timestamps
+	}
+
+	t.Run("list all targets", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/v1/targets", nil)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusOK {
+			t.Errorf("expected status %d, got %d", http.StatusOK, rec.Code)
+		}
+
+		var response models.TargetList
+		if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+			t.Fatalf("failed to unmarshal response: %v", err)
+		}
+
+		if len(response.Items) != 3 {
+			t.Errorf("expected 3 targets, got %d", len(response.Items))
+		}
+	})
+
+	t.Run("filter by host", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/v1/targets?host=example.com", nil)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusOK {
+			t.Errorf("expected status %d, got %d", http.StatusOK, rec.Code)
+		}
+
+		var response models.TargetList
+		json.Unmarshal(rec.Body.Bytes(), &response)
+
+		if len(response.Items) != 1 {
+			t.Errorf("expected 1 target for host filter, got %d", len(response.Items))
+		}
+
+		if response.Items[0].URL != "https://example.com" {
+			t.Errorf("expected filtered result to be https://example.com, got %s", response.Items[0].URL)
+		}
+	})
+
+	t.Run("pagination", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/v1/targets?limit=2", nil)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusOK {
+			t.Errorf("expected status %d, got %d", http.StatusOK, rec.Code)
+		}
+
+		var response models.TargetList
+		json.Unmarshal(rec.Body.Bytes(), &response)
+
+		if len(response.Items) != 2 {
+			t.Errorf("expected 2 targets with limit=2, got %d", len(response.Items))
+		}
+
+		if response.NextPageToken == "" {
+			t.Error("expected non-empty next page token")
+		}
+
+		// Get next page
+		req2 := httptest.NewRequest("GET", "/v1/targets?limit=2&page_token="+response.NextPageToken, nil)
+		rec2 := httptest.NewRecorder()
+		router.ServeHTTP(rec2, req2)
+
+		var response2 models.TargetList
+		json.Unmarshal(rec2.Body.Bytes(), &response2)
+
+		if len(response2.Items) != 1 {
+			t.Errorf("expected 1 target on second page, got %d", len(response2.Items))
+		}
+	})
+}
+
+func TestListTargetsHostSuffix(t *testing.T) {
+	store := setupTestStore(t)
+	router := NewRouter(store)
+
+	for _, u := range []string{"https://example.com", "https://api.example.com/v1", "https://notexample.com/"} {
+		canonical, _ := storage.CanonicalizeURL(u)
+		store.CreateTarget(context.Background(), u, canonical, nil)
+	}
+
+	for query, expected := range map[string]int{
+		"host_suffix=example.com":                      2,
+		"host_suffix=example.com&format=ndjson":        2,
+		"host_suffix=example.com&include_total=true":   2,
+		"host=example.com&host_suffix=example.com":     1,
+		"host_suffix=com":                              3,
+		"host_suffix=api.example.com&host=example.com": 0,
+	} {
+		req := httptest.NewRequest("GET", "/v1/targets?"+query, nil)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: expected status %d, got %d", query, http.StatusOK, rec.Code)
+		}
+
+		got := strings.Count(rec.Body.String(), "\n")
+		if !strings.Contains(query, "ndjson") {
+			var response models.TargetList
+			if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+				t.Fatalf("%s: failed to unmarshal response: %v", query, err)
+			}
+			got = len(response.Items)
+			if response.TotalCount != nil && *response.TotalCount != expected {
+				t.Errorf("%s: expected a total of %d, got %d", query, expected, *response.TotalCount)
+			}
+		}
+		if got != expected {
+			t.Errorf("%s: expected %d targets, got %d", query, expected, got)
+		}
+	}
+}
+
+func TestListTargetsTotalCount(t *testing.T) {
+	store := setupTestStore(t)
+	router := NewRouter(store)
+
+	for _, u := range []string{"https://example.com/a", "https://example.com/b", "https://example.org/"} {
+		store.CreateTarget(context.Background(), u, u, nil)
+	}
+
+	list := func(query string) models.TargetList {
+		req := httptest.NewRequest("GET", "/v1/targets?"+query, nil)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("expected status %d, got %d", http.StatusOK, rec.Code)
+		}
+		var response models.TargetList
+		if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+			t.Fatalf("failed to unmarshal response: %v", err)
+		}
+		return response
+	}
+
+	page := list("limit=2&include_total=true")
+	if !page.HasMore || page.TotalCount == nil || *page.TotalCount != 3 {
+		t.Errorf("expected more pages and a total of 3, got has_more=%t total=%v", page.HasMore, page.TotalCount)
+	}
+
+	last := list("limit=2&include_total=true&page_token=" + page.NextPageToken)
+	if last.HasMore || last.TotalCount == nil || *last.TotalCount != 3 {
+		t.Errorf("expected the last page with the same total, got has_more=%t total=%v", last.HasMore, last.TotalCount)
+	}
+
+	if filtered := list("host=example.com&include_total=true"); filtered.TotalCount == nil || *filtered.TotalCount != 2 {
+		t.Errorf("expected the total to respect the host filter, got %v", filtered.TotalCount)
+	}
+	if plain := list("limit=2"); plain.TotalCount != nil || !plain.HasMore {
+		t.Errorf("expected has_more without a total by default, got has_more=%t total=%v", plain.HasMore, plain.TotalCount)
+	}
+}
+
+func TestListTargetsInvalidPageToken(t *testing.T) {
+	store := setupTestStore(t)
+	router := NewRouter(store)
+
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{"over length", strings.Repeat("a", 10000)},
+		{"not base64", "not a token!"},
+		{"legacy raw cursor", "2025-08-17T12:00:00Z_t_123"},
+		{"not JSON", base64.RawURLEncoding.EncodeToString([]byte("notjson"))},
+		{"bad timestamp", base64.RawURLEncoding.EncodeToString([]byte(`{"created_at":"yesterday","id":"t_123"}`))},
+		{"missing id", base64.RawURLEncoding.EncodeToString([]byte(`{"created_at":"2025-08-17T12:00:00Z"}`))},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/v1/targets?page_token="+url.QueryEscape(tt.token), nil)
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+
+			if rec.Code != http.StatusBadRequest {
+				t.Fatalf("expected status %d, got %d", http.StatusBadRequest, rec.Code)
+			}
+
+			var body map[string]string
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+				t.Fatalf("failed to unmarshal response: %v", err)
+			}
+
+			if body["code"] != string(CodeInvalidPageToken) {
+				t.Errorf("expected code %s, got %q", CodeInvalidPageToken, body["code"])
+			}
+		})
+	}
+}
+
+func TestListTargetsSort(t *testing.T) {
+	store := setupTestStore(t)
+	router := NewRouter(store)
+
+	for _, u := range []string{"https://b.example/", "https://a.example/", "https://c.example/"} {
+		store.CreateTarget(context.Background(), u, u, nil)
+		time.Sleep(time.Millisecond)
+	}
+
+	get := func(query string) (*httptest.ResponseRecorder, models.TargetList) {
+		req := httptest.NewRequest("GET", "/v1/targets?"+query, nil)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		var response models.TargetList
+		json.Unmarshal(rec.Body.Bytes(), &response)
+		return rec, response
+	}
+
+	rec, first := get("sort=url&order=desc&limit=2")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, rec.Code)
+	}
+	_, second := get("sort=url&order=desc&limit=2&page_token=" + first.NextPageToken)
+	var got []string
+	for _, target := range append(first.Items, second.Items...) {
+		got = append(got, target.URL)
+	}
+	if expected := "https://c.example/,https://b.example/,https://a.example/"; strings.Join(got, ",") != expected {
+		t.Errorf("expected %s, got %s", expected, strings.Join(got, ","))
+	}
+
+	if _, newest := get("order=desc&limit=1"); len(newest.Items) != 1 || newest.Items[0].URL != "https://c.example/" {
+		t.Errorf("expected the newest target first, got %+v", newest.Items)
+	}
+
+	if rec, _ := get("sort=name"); rec.Code != http.StatusBadRequest {
+		t.Errorf("expected status %d for an unknown sort, got %d", http.StatusBadRequest, rec.Code)
+	}
+	if rec, _ := get("order=up"); rec.Code != http.StatusBadRequest {
+		t.Errorf("expected status %d for an unknown order, got %d", http.StatusBadRequest, rec.Code)
+	}
+	if rec, _ := get("sort=url&page_token=" + first.NextPageToken); rec.Code != http.StatusBadRequest {
+		t.Errorf("expected status %d for a token from another order, got %d", http.StatusBadRequest, rec.Code)
+	}
+}
+
+func TestListTargetsLastCheck(t *testing.T) {
+	store := setupTestStore(t)
+	cache := storage.NewResultCache()
+	router := NewRouterWithConfig(store, Config{ResultCache: cache})
+
+	target, _, _ := store.CreateTarget(context.Background(), "https://example.com", "https://example.com", nil)
+
+	list := func() models.Target {
+		req := httptest.NewRequest("GET", "/v1/targets?include=last_check", nil)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusOK {
+			t.Fatalf("expected status %d, got %d", http.StatusOK, rec.Code)
+		}
+
+		var response models.TargetList
+		if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+			t.Fatalf("failed to unmarshal response: %v", err)
+		}
+		if len(response.Items) != 1 {
+			t.Fatalf("expected 1 target, got %d", len(response.Items))
+		}
+		return response.Items[0]
+	}
+
+	checkedAt := time.Now().UTC().Truncate(time.Millisecond)
+	store.SaveCheckResult(context.Background(), target.ID, models.CheckResult{CheckedAt: checkedAt, StatusCode: intPtr(200), LatencyMs: 42})
+
+	t.Run("miss falls back to the database", func(t *testing.T) {
+		item := list()
+		if item.LastCheck == nil || item.LastCheck.LatencyMs != 42 {
+			t.Fatalf("expected last check from the database, got %+v", item.LastCheck)
+		}
+
+		if _, ok := cache.Get(target.ID); !ok {
+			t.Error("expected the miss to populate the cache")
+		}
+	})
+
+	t.Run("cached value is served and matches the database", func(t *testing.T) {
+		newer := models.CheckResult{CheckedAt: checkedAt.Add(time.Second), StatusCode: intPtr(503), LatencyMs: 99}
+		store.SaveCheckResult(context.Background(), target.ID, newer)
+		cache.Set(target.ID, newer)
+
+		item := list()
+		if item.LastCheck == nil || item.LastCheck.LatencyMs != 99 {
+			t.Fatalf("expected cached last check, got %+v", item.LastCheck)
+		}
+
+		latest, err := store.GetLatestCheckResult(context.Background(), target.ID, true)
+		if err != nil || latest == nil {
+			t.Fatalf("failed to get latest result: %v", err)
+		}
+		if !latest.CheckedAt.Equal(item.LastCheck.CheckedAt) || latest.LatencyMs != item.LastCheck.LatencyMs {
+			t.Errorf("cached result %+v doesn't match database %+v", item.LastCheck, latest)
+		}
+	})
+
+	t.Run("served from cache without a database read", func(t *testing.T) {
+		// Only the cache knows about this result
+		cache.Set(target.ID, models.CheckResult{CheckedAt: checkedAt.Add(time.Minute), StatusCode: intPtr(200), LatencyMs: 7})
+
+		if item := list(); item.LastCheck == nil || item.LastCheck.LatencyMs != 7 {
+			t.Errorf("expected the cached result, got %+v", item.LastCheck)
+		}
+	})
+
+	t.Run("omitted without include", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/v1/targets", nil)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+
+		if strings.Contains(rec.Body.String(), `"last_check"`) {
+			t.Errorf("expected no last_check without include, got %s", rec.Body.String())
+		}
+	})
+}
+
+func TestCompression(t *testing.T) {
+	store := setupTestStore(t)
+	router := NewRouterWithConfig(store, Config{Compress: true})
+
+	for i := 0; i < 50; i++ {
+		u := "https://example.com/page" + strconv.Itoa(i)
+		store.CreateTarget(context.Background(), u, u, nil)
+	}
+
+	get := func(path, acceptEncoding string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path, nil)
+		if acceptEncoding != "" {
+			req.Header.Set("Accept-Encoding", acceptEncoding)
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := get("/v1/targets?limit=100", "deflate, gzip")
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Encoding") != "gzip" {
+		t.Fatalf("expected a gzipped 200, got %d with encoding %q", rec.Code, rec.Header().Get("Content-Encoding"))
+	}
+	if !strings.Contains(rec.Header().Get("Vary"), "Accept-Encoding") {
+		t.Error("expected Vary: Accept-Encoding")
+	}
+	zr, err := gzip.NewReader(rec.Body)
+	if err != nil {
+		t.Fatalf("failed to open gzip body: %v", err)
+	}
+	var list models.TargetList
+	if err := json.NewDecoder(zr).Decode(&list); err != nil {
+		t.Fatalf("failed to decode gzipped body: %v", err)
+	}
+	if len(list.Items) != 50 {
+		t.Errorf("expected 50 targets, got %d", len(list.Items))
+	}
+
+	// Streams are compressed as they are flushed
+	rec = get("/v1/targets?format=ndjson", "gzip")
+	if rec.Header().Get("Content-Encoding") != "gzip" {
+		t.Fatalf("expected a gzipped stream, got encoding %q", rec.Header().Get("Content-Encoding"))
+	}
+	zr, err = gzip.NewReader(rec.Body)
+	if err != nil {
+		t.Fatalf("failed to open gzip stream: %v", err)
+	}
+	lines := 0
+	for scanner := bufio.NewScanner(zr); scanner.Scan(); lines++ {
+	}
+	if lines != 50 {
+		t.Errorf("expected 50 streamed targets, got %d", lines)
+	}
+
+	if rec := get("/healthz", "gzip"); rec.Header().Get("Content-Encoding") != "" || rec.Body.String() != "OK" {
+		t.Errorf("expected a plain health check, got encoding %q", rec.Header().Get("Content-Encoding"))
+	}
+
+	for _, tt := range []struct{ name, path, acceptEncoding string }{
+		{"small error", "/v1/jobs/j_missing", "gzip"},
+		{"not accepted", "/v1/targets?limit=100", ""},
+		{"refused", "/v1/targets?limit=100", "gzip;q=0, identity"},
+	} {
+		rec := get(tt.path, tt.acceptEncoding)
+		if enc := rec.Header().Get("Content-Encoding"); enc != "" {
+			t.Errorf("%s: expected no compression, got %q", tt.name, enc)
+		}
+		if !json.Valid(rec.Body.Bytes()) {
+			t.Errorf("%s: expected a plain JSON body", tt.name)
+		}
+	}
+}
+
+func TestListTargetsNDJSON(t *testing.T) {
+	store := setupTestStore(t)
+	router := NewRouter(store)
+
+	created := make(map[string]bool)
+	for i := 0; i < 250; i++ {
+		url := "https://example.com/page" + strconv.Itoa(i)
+		target, _, err := store.CreateTarget(context.Background(), url, url, nil)
+		if err != nil {
+			t.Fatalf("failed to create target: %v", err)
+		}
+		created[target.ID] = true
+	}
+	other, _, _ := store.CreateTarget(context.Background(), "https://other.org/page", "https://other.org/page", nil)
+	created[other.ID] = true
+
+	read := func(req *http.Request) map[string]int {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusOK {
+			t.Fatalf("expected status %d, got %d", http.StatusOK, rec.Code)
+		}
+
+		if ct := rec.Header().Get("Content-Type"); ct != "application/x-ndjson" {
+			t.Errorf("expected NDJSON content type, got %q", ct)
+		}
+
+		seen := make(map[string]int)
+		scanner := bufio.NewScanner(rec.Body)
+		for scanner.Scan() {
+			var target models.Target
+			if err := json.Unmarshal(scanner.Bytes(), &target); err != nil {
+				t.Fatalf("invalid NDJSON line %q: %v", scanner.Text(), err)
+			}
+			seen[target.ID]++
+		}
+		return seen
+	}
+
+	t.Run("streams every target exactly once", func(t *testing.T) {
+		seen := read(httptest.NewRequest("GET", "/v1/targets?format=ndjson&limit=5", nil))
+
+		if len(seen) != len(created) {
+			t.Errorf("expected %d targets, got %d", len(created), len(seen))
+		}
+		for id := range created {
+			if seen[id] != 1 {
+				t.Errorf("expected target %s exactly once, got %d", id, seen[id])
+			}
+		}
+	})
+
+	t.Run("accept header with host filter", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/v1/targets?host=other.org", nil)
+		req.Header.Set("Accept", "application/x-ndjson")
+		seen := read(req)
+
+		if len(seen) != 1 || seen[other.ID] != 1 {
+			t.Errorf("expected only %s, got %v", other.ID, seen)
+		}
+	})
+}
+
+func TestGetLatestCheckResult(t *testing.T) {
+	store := setupTestStore(t)
+	router := NewRouter(store)
+
+	target, _, _ := store.CreateTarget(context.Background(), "https://example.com", "https://example.com", nil)
+	get := func(targetID string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest("GET", "/v1/targets/"+targetID+"/results/latest", nil))
+		return rec
+	}
+	code := func(rec *httptest.ResponseRecorder) string {
+		var body map[string]string
+		json.Unmarshal(rec.Body.Bytes(), &body)
+		return body["code"]
+	}
+
+	rec := get(target.ID)
+	if rec.Code != http.StatusNotFound || code(rec) != string(CodeNoResults) {
+		t.Errorf("expected 404 %s before the first check, got %d %s", CodeNoResults, rec.Code, rec.Body.String())
+	}
+
+	now := time.Now().UTC()
+	store.SaveCheckResult(context.Background(), target.ID, models.CheckResult{CheckedAt: now.Add(-time.Minute), StatusCode: intPtr(200), LatencyMs: 10})
+	store.SaveCheckResult(context.Background(), target.ID, models.CheckResult{CheckedAt: now, StatusCode: intPtr(503), LatencyMs: 20})
+
+	rec = get(target.ID)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, rec.Code)
+	}
+	var result models.CheckResult
+	if err := json.Unmarshal(rec.Body.Bytes(), &result); err != nil {
+		t.Fatalf("failed to unmarshal response: %v", err)
+	}
+	if result.ID == 0 || result.StatusCode == nil || *result.StatusCode != 503 || result.LatencyMs != 20 {
+		t.Errorf("expected the latest result with its id, got %+v", result)
+	}
+
+	rec = get("t_missing")
+	if rec.Code != http.StatusNotFound || code(rec) != string(CodeTargetNotFound) {
+		t.Errorf("expected 404 %s for an unknown target, got %d %s", CodeTargetNotFound, rec.Code, rec.Body.String())
+	}
+}
+
+func TestGetCheckResultsLatencyRange(t *testing.T) {
+	store := setupTestStore(t)
+	router := NewRouter(store)
+
+	target, _, _ := store.CreateTarget(context.Background(), "https://example.com", "https://example.com", nil)
+
+	now := time.Now().UTC()
+	for i, latency := range []int{20, 150, 400, 900, 3000} {
+		result := models.CheckResult{
+			CheckedAt:  now.Add(-time.Duration(i) * time.Minute),
+			StatusCode: intPtr(200),
+			LatencyMs:  latency,
+		}
+		if latency == 400 || latency == 3000 {
+			result.StatusCode = nil
+			result.Error = stringPtr("timeout")
+		}
+		store.SaveCheckResult(context.Background(), target.ID, result)
+	}
+
+	tests := []struct {
+		name     string
+		query    string
+		expected []int
+	}{
+		{"min only", "min_latency_ms=400", []int{400, 900, 3000}},
+		{"max only", "max_latency_ms=150", []int{20, 150}},
+		{"range", "min_latency_ms=100&max_latency_ms=900", []int{150, 400, 900}},
+		{"range with since", "min_latency_ms=100&max_latency_ms=900&since=" + now.Add(-150*time.Second).Format(time.RFC3339), []int{150, 400}},
+		{"failed", "failed=true", []int{400, 3000}},
+		{"succeeded", "failed=false", []int{20, 150, 900}},
+		{"failed with range", "failed=true&max_latency_ms=900", []int{400}},
+		{"slow but successful", "failed=false&min_latency_ms=150", []int{150, 900}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/v1/targets/"+target.ID+"/results?"+tt.query, nil)
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+
+			if rec.Code != http.StatusOK {
+				t.Fatalf("expected status %d, got %d", http.StatusOK, rec.Code)
+			}
+
+			var response models.CheckResultList
+			if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+				t.Fatalf("failed to unmarshal response: %v", err)
+			}
+
+			var latencies []int
+			for _, item := range response.Items {
+				latencies = append(latencies, item.LatencyMs)
+			}
+			if fmt.Sprint(latencies) != fmt.Sprint(tt.expected) {
+				t.Errorf("expected latencies %v, got %v", tt.expected, latencies)
+			}
+		})
+	}
+
+	for _, query := range []string{"min_latency_ms=-1", "min_latency_ms=1.5", "max_latency_ms=fast", "min_latency_ms=500&max_latency_ms=100", "failed=maybe"} {
+		t.Run("invalid "+query, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/v1/targets/"+target.ID+"/results?"+query, nil)
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+
+			if rec.Code != http.StatusBadRequest {
+				t.Errorf("expected status %d, got %d", http.StatusBadRequest, rec.Code)
+			}
+		})
+	}
+}
+
+func TestGetCheckResults(t *testing.T) {
+	store := setupTestStore(t)
+	router := NewRouter(store)
+
+	// Create target
+	target, _, _ := store.CreateTarget(context.Background(), "https://example.com", "https://example.com", nil)
+
+	// Create check results
+	now := time.Now().UTC()
+	results := []models.CheckResult{
+		{
+			CheckedAt:  now,
+			StatusCode: intPtr(200),
+			LatencyMs:  150,
+		},
+		{
+			CheckedAt:  now.Add(-time.Minute),
+			StatusCode: intPtr(404),
+			LatencyMs:  100,
+		},
+	}
+
+	for _, result := range results {
+		store.SaveCheckResult(context.Background(), target.ID, result)
+	}
+
+	t.Run("get all results", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/v1/targets/"+target.ID+"/results", nil)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusOK {
+			t.Errorf("expected status %d, got %d", http.StatusOK, rec.Code)
+		}
+
+		var response models.CheckResultList
+		if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+			t.Fatalf("failed to unmarshal response: %v", err)
+		}
+
+		if len(response.Items) != 2 {
+			t.Errorf("expected 2 results, got %d", len(response.Items))
+		}
+
+		// Should be ordered most recent first
+		if response.Items[0].CheckedAt.Before(response.Items[1].CheckedAt) {
+			t.Error("results not properly ordered")
+		}
+
+		if response.Items[0].ID == 0 || response.Items[0].ID == response.Items[1].ID {
+			t.Errorf("expected distinct result ids, got %d and %d", response.Items[0].ID, response.Items[1].ID)
+		}
+	})
+
+	t.Run("filter by since", func(t *testing.T) {
+		since := now.Add(-30 * time.Second)
+		req := httptest.NewRequest("GET", "/v1/targets/"+target.ID+"/results?since="+since.Format(time.RFC3339), nil)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusOK {
+			t.Errorf("expected status %d, got %d", http.StatusOK, rec.Code)
+		}
+
+		var response models.CheckResultList
+		json.Unmarshal(rec.Body.Bytes(), &response)
+
+		if len(response.Items) != 1 {
+			t.Errorf("expected 1 result since %v, got %d", since, len(response.Items))
+		}
+	})
+
+	t.Run("invalid since parameter", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/v1/targets/"+target.ID+"/results?since=invalid", nil)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("expected status %d for invalid since, got %d", http.StatusBadRequest, rec.Code)
+		}
+	})
+}
+
+func TestGetCheckResultsCSV(t *testing.T) {
+	store := setupTestStore(t)
+	router := NewRouter(store)
+
+	target, _, _ := store.CreateTarget(context.Background(), "https://example.com", "https://example.com", nil)
+	now := time.Date(2025, 8, 17, 12, 0, 0, 0, time.UTC)
+	store.SaveCheckResult(context.Background(), target.ID, models.CheckResult{CheckedAt: now.Add(-time.Minute), LatencyMs: 5000, Error: stringPtr("connection timeout, retried")})
+	store.SaveCheckResult(context.Background(), target.ID, models.CheckResult{CheckedAt: now, StatusCode: intPtr(200), LatencyMs: 42})
+	store.SaveCheckResult(context.Background(), target.ID, models.CheckResult{CheckedAt: now.Add(-time.Hour), StatusCode: intPtr(500), LatencyMs: 900, Error: stringPtr("=HYPERLINK(1)")})
+
+	req := httptest.NewRequest("GET", "/v1/targets/"+target.ID+"/results?format=csv&min_latency_ms=1", nil)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, rec.Code)
+	}
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/csv") {
+		t.Errorf("expected a CSV content type, got %q", ct)
+	}
+
+	records, err := csv.NewReader(rec.Body).ReadAll()
+	if err != nil {
+		t.Fatalf("failed to parse CSV: %v", err)
+	}
+	expected := [][]string{
+		{"checked_at", "status_code", "latency_ms", "error"},
+		{"2025-08-17T12:00:00Z", "200", "42", ""},
+		{"2025-08-17T11:59:00Z", "", "5000", "connection timeout, retried"},
+		{"2025-08-17T11:00:00Z", "500", "900", "'=HYPERLINK(1)"},
+	}
+	if fmt.Sprint(records) != fmt.Sprint(expected) {
+		t.Errorf("expected %v, got %v", expected, records)
+	}
+
+	req = httptest.NewRequest("GET", "/v1/targets/"+target.ID+"/results?format=xml", nil)
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("expected status %d for an unknown format, got %d", http.StatusBadRequest, rec.Code)
+	}
+}
+
+func TestGetCheckResultsPagination(t *testing.T) {
+	store := setupTestStore(t)
+	router := NewRouter(store)
+
+	target, _, _ := store.CreateTarget(context.Background(), "https://example.com", "https://example.com", nil)
+	now := time.Now().UTC()
+	for i := 0; i < 3; i++ {
+		store.SaveCheckResult(context.Background(), target.ID, models.CheckResult{CheckedAt: now.Add(-time.Duration(i) * time.Minute), LatencyMs: i})
+	}
+
+	get := func(query string) (*httptest.ResponseRecorder, models.CheckResultList) {
+		req := httptest.NewRequest("GET", "/v1/targets/"+target.ID+"/results?"+query, nil)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+
+		var response models.CheckResultList
+		json.Unmarshal(rec.Body.Bytes(), &response)
+		return rec, response
+	}
+
+	_, first := get("limit=2")
+	if len(first.Items) != 2 || first.NextPageToken == "" {
+		t.Fatalf("expected 2 results and a next page token, got %+v", first)
+	}
+
+	_, second := get("limit=2&page_token=" + first.NextPageToken)
+	if len(second.Items) != 1 || second.Items[0].LatencyMs != 2 {
+		t.Errorf("expected the oldest result on the second page, got %+v", second.Items)
+	}
+	if second.NextPageToken != "" {
+		t.Errorf("expected no next page token on the last page, got %q", second.NextPageToken)
+	}
+
+	if rec, _ := get("page_token=bogus"); rec.Code != http.StatusBadRequest {
+		t.Errorf("expected status %d for invalid page token, got %d", http.StatusBadRequest, rec.Code)
+	}
+}
+
+func TestGetCheckStats(t *testing.T) {
+	store := setupTestStore(t)
+	router := NewRouter(store)
+
+	target, _, _ := store.CreateTarget(context.Background(), "https://example.com", "https://example.com", nil)
+	now := time.Now().UTC()
+	store.SaveCheckResult(context.Background(), target.ID, models.CheckResult{CheckedAt: now.Add(-time.Minute), StatusCode: intPtr(200), LatencyMs: 40})
+	store.SaveCheckResult(context.Background(), target.ID, models.CheckResult{CheckedAt: now.Add(-2 * time.Hour), StatusCode: intPtr(500), LatencyMs: 900})
+
+	get := func(path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path, nil)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
 	}
 
-	for _, url := range urls {
-		canonical, _ := storage.CanonicalizeURL(url)
-		store.CreateTarget(url, canonical, nil)
-		time.Sleep(1 * time.Millisecond) // Ensure different timestamps
+	rec := get("/v1/targets/" + target.ID + "/stats?window=1h")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, rec.Code)
 	}
 
-	t.Run("list all targets", func(t *testing.T) {
-		req := httptest.NewRequest("GET", "/v1/targets", nil)
+	var stats models.CheckStats
+	if err := json.Unmarshal(rec.Body.Bytes(), &stats); err != nil {
+		t.Fatalf("failed to unmarshal response: %v", err)
+	}
+	if stats.TotalChecks != 1 || stats.UptimePercent == nil || *stats.UptimePercent != 100 {
+		t.Errorf("expected 1 check at 100%% uptime in the window, got %+v", stats)
+	}
+
+	// Widening the window takes in the older failure, one flap before the
+	// recovery
+	rec = get("/v1/targets/" + target.ID + "/stats?window=3h")
+	stats = models.CheckStats{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &stats); err != nil {
+		t.Fatalf("failed to unmarshal response: %v", err)
+	}
+	if stats.FlapCount != 1 || stats.StabilityPercent == nil || *stats.StabilityPercent != 0 {
+		t.Errorf("expected 1 flap and 0%% stability over 3h, got %+v", stats)
+	}
+
+	if rec := get("/v1/targets/" + target.ID + "/stats"); rec.Code != http.StatusOK {
+		t.Errorf("expected status %d with the default window, got %d", http.StatusOK, rec.Code)
+	}
+	if rec := get("/v1/targets/" + target.ID + "/stats?window=soon"); rec.Code != http.StatusBadRequest {
+		t.Errorf("expected status %d for invalid window, got %d", http.StatusBadRequest, rec.Code)
+	}
+	if rec := get("/v1/targets/t_missing/stats"); rec.Code != http.StatusNotFound {
+		t.Errorf("expected status %d for missing target, got %d", http.StatusNotFound, rec.Code)
+	}
+}
+
+func TestGetHostStats(t *testing.T) {
+	store := setupTestStore(t)
+	router := NewRouter(store)
+
+	a, _, _ := store.CreateTarget(context.Background(), "https://example.com/a", "https://example.com/a", nil)
+	b, _, _ := store.CreateTarget(context.Background(), "https://example.com/b", "https://example.com/b", nil)
+	other, _, _ := store.CreateTarget(context.Background(), "https://example.org", "https://example.org", nil)
+	now := time.Now().UTC()
+	store.SaveCheckResult(context.Background(), a.ID, models.CheckResult{CheckedAt: now.Add(-time.Minute), StatusCode: intPtr(200), LatencyMs: 40})
+	store.SaveCheckResult(context.Background(), b.ID, models.CheckResult{CheckedAt: now.Add(-time.Minute), StatusCode: intPtr(200), LatencyMs: 60})
+	store.SaveCheckResult(context.Background(), other.ID, models.CheckResult{CheckedAt: now.Add(-2 * time.Hour), StatusCode: intPtr(200), LatencyMs: 900})
+
+	get := func(path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path, nil)
 		rec := httptest.NewRecorder()
 		router.ServeHTTP(rec, req)
-
-		if rec.Code != http.StatusOK {
-			t.Errorf("expected status %d, got %d", http.StatusOK, rec.Code)
+		return rec
+	}
+	decode := func(rec *httptest.ResponseRecorder) models.HostLatencyStatsList {
+		var list models.HostLatencyStatsList
+		if err := json.Unmarshal(rec.Body.Bytes(), &list); err != nil {
+			t.Fatalf("failed to unmarshal response: %v", err)
 		}
+		return list
+	}
 
-		var response models.TargetList
-		if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+	rec := get("/v1/hosts/stats?window=1h")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, rec.Code)
+	}
+	list := decode(rec)
+	if len(list.Items) != 1 || list.Items[0].Host != "example.com" || list.Items[0].TargetCount != 2 || list.Items[0].P95LatencyMs != 60 {
+		t.Errorf("expected only example.com with 2 targets and p95 60 in the window, got %+v", list.Items)
+	}
+
+	// The default window of 24h takes in the older, slower check
+	list = decode(get("/v1/hosts/stats"))
+	if len(list.Items) != 2 || list.Items[0].Host != "example.org" {
+		t.Errorf("expected example.org first over 24h, got %+v", list.Items)
+	}
+
+	if rec := get("/v1/hosts/stats?window=-1h"); rec.Code != http.StatusBadRequest {
+		t.Errorf("expected status %d for invalid window, got %d", http.StatusBadRequest, rec.Code)
+	}
+}
+
+func TestDeleteCheckResults(t *testing.T) {
+	store := setupTestStore(t)
+	router := NewRouter(store)
+
+	target, _, _ := store.CreateTarget(context.Background(), "https://example.com", "https://example.com", nil)
+	now := time.Now().UTC()
+	store.SaveCheckResult(context.Background(), target.ID, models.CheckResult{CheckedAt: now.Add(-3 * time.Hour), StatusCode: intPtr(500), LatencyMs: 900})
+	store.SaveCheckResult(context.Background(), target.ID, models.CheckResult{CheckedAt: now.Add(-2 * time.Hour), StatusCode: intPtr(500), LatencyMs: 800})
+	store.SaveCheckResult(context.Background(), target.ID, models.CheckResult{CheckedAt: now, StatusCode: intPtr(200), LatencyMs: 40})
+
+	del := func(path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("DELETE", path, nil)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+	deleted := func(rec *httptest.ResponseRecorder) int64 {
+		var resp models.DeleteResultsResponse
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
 			t.Fatalf("failed to unmarshal response: %v", err)
 		}
+		return resp.Deleted
+	}
 
-		if len(response.Items) != 3 {
-			t.Errorf("expected 3 targets, got %d", len(response.Items))
-		}
+	before := url.QueryEscape(now.Add(-time.Hour).Format(time.RFC3339))
+	rec := del("/v1/targets/" + target.ID + "/results?before=" + before)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, rec.Code)
+	}
+	if n := deleted(rec); n != 2 {
+		t.Errorf("expected 2 results older than before to be deleted, got %d", n)
+	}
+
+	rec = del("/v1/targets/" + target.ID + "/results")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, rec.Code)
+	}
+	if n := deleted(rec); n != 1 {
+		t.Errorf("expected the remaining result to be deleted, got %d", n)
+	}
+
+	if remaining, _ := store.GetCheckResults(context.Background(), target.ID, nil, 10); len(remaining.Items) != 0 {
+		t.Errorf("expected no results left, got %d", len(remaining.Items))
+	}
+	if kept, _ := store.GetTarget(context.Background(), target.ID); kept == nil {
+		t.Error("expected the target to be kept")
+	}
+
+	if rec := del("/v1/targets/" + target.ID + "/results?before=yesterday"); rec.Code != http.StatusBadRequest {
+		t.Errorf("expected status %d for invalid before, got %d", http.StatusBadRequest, rec.Code)
+	}
+	if rec := del("/v1/targets/t_missing/results"); rec.Code != http.StatusNotFound {
+		t.Errorf("expected status %d for missing target, got %d", http.StatusNotFound, rec.Code)
+	}
+}
+
+func TestCheckFilter(t *testing.T) {
+	store := setupTestStore(t)
+	keyed := NewRouterWithConfig(store, Config{APIKeys: []string{"secret"}})
+	router := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.Header.Set("Authorization", "Bearer secret")
+		keyed.ServeHTTP(w, r)
 	})
 
-	t.Run("filter by host", func(t *testing.T) {
-		req := httptest.NewRequest("GET", "/v1/targets?host=example.com", nil)
+	t.Run("default includes everything", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/v1/admin/check-filter", nil)
 		rec := httptest.NewRecorder()
 		router.ServeHTTP(rec, req)
 
-		if rec.Code != http.StatusOK {
-			t.Errorf("expected status %d, got %d", http.StatusOK, rec.Code)
-		}
-
-		var response models.TargetList
-		json.Unmarshal(rec.Body.Bytes(), &response)
-
-		if len(response.Items) != 1 {
-			t.Errorf("expected 1 target for host filter, got %d", len(response.Items))
-		}
+		var filter models.CheckFilter
+		json.Unmarshal(rec.Body.Bytes(), &filter)
 
-		if response.Items[0].URL != "https://example.com" {
-			t.Errorf("expected filtered result to be https://example.com, got %s", response.Items[0].URL)
+		if len(filter.ExcludeHosts) != 0 {
+			t.Errorf("expected no exclusions by default, got %v", filter.ExcludeHosts)
 		}
 	})
 
-	t.Run("pagination", func(t *testing.T) {
-		req := httptest.NewRequest("GET", "/v1/targets?limit=2", nil)
+	t.Run("update filter", func(t *testing.T) {
+		reqBody := `{"exclude_hosts": ["*.Staging.example.com", "qa.example.com"]}`
+		req := httptest.NewRequest("PUT", "/v1/admin/check-filter", bytes.NewBufferString(reqBody))
 		rec := httptest.NewRecorder()
 		router.ServeHTTP(rec, req)
 
 		if rec.Code != http.StatusOK {
-			t.Errorf("expected status %d, got %d", http.StatusOK, rec.Code)
+			t.Fatalf("expected status %d, got %d", http.StatusOK, rec.Code)
 		}
 
-		var response models.TargetList
-		json.Unmarshal(rec.Body.Bytes(), &response)
-
-		if len(response.Items) != 2 {
-			t.Errorf("expected 2 targets with limit=2, got %d", len(response.Items))
+		filter, err := store.GetCheckFilter(context.Background())
+		if err != nil {
+			t.Fatalf("failed to get check filter: %v", err)
 		}
 
-		if response.NextPageToken == "" {
-			t.Error("expected non-empty next page token")
+		if len(filter.ExcludeHosts) != 2 || filter.ExcludeHosts[0] != "*.staging.example.com" {
+			t.Errorf("unexpected saved filter: %v", filter.ExcludeHosts)
 		}
+	})
 
-		// Get next page
-		req2 := httptest.NewRequest("GET", "/v1/targets?limit=2&page_token="+response.NextPageToken, nil)
-		rec2 := httptest.NewRecorder()
-		router.ServeHTTP(rec2, req2)
+	t.Run("invalid pattern", func(t *testing.T) {
+		reqBody := `{"exclude_hosts": ["https://example.com/"]}`
+		req := httptest.NewRequest("PUT", "/v1/admin/check-filter", bytes.NewBufferString(reqBody))
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
 
-		var response2 models.TargetList
-		json.Unmarshal(rec2.Body.Bytes(), &response2)
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("expected status %d, got %d", http.StatusBadRequest, rec.Code)
+		}
+	})
 
-		if len(response2.Items) != 1 {
-			t.Errorf("expected 1 target on second page, got %d", len(response2.Items))
+	t.Run("refused without api keys", func(t *testing.T) {
+		for _, method := range []string{"GET", "PUT"} {
+			req := httptest.NewRequest(method, "/v1/admin/check-filter", bytes.NewBufferString(`{"exclude_hosts": ["example.com"]}`))
+			rec := httptest.NewRecorder()
+			NewRouter(store).ServeHTTP(rec, req)
+
+			if rec.Code != http.StatusForbidden {
+				t.Errorf("expected status %d for %s, got %d", http.StatusForbidden, method, rec.Code)
+			}
 		}
 	})
 }
 
-func TestGetCheckResults(t *testing.T) {
+func TestCreateWebhook(t *testing.T) {
 	store := setupTestStore(t)
 	router := NewRouter(store)
 
-	// Create target
-	target, _, _ := store.CreateTarget("https://example.com", "https://example.com", nil)
-
-	// Create check results
-	now := time.Now().UTC()
-	results := []models.CheckResult{
-		{
-			CheckedAt:  now,
-			StatusCode: intPtr(200),
-			LatencyMs:  150,
-		},
-		{
-			CheckedAt:  now.Add(-time.Minute),
-			StatusCode: intPtr(404),
-			LatencyMs:  100,
-		},
-	}
-
-	for _, result := range results {
-		store.SaveCheckResult(target.ID, result)
-	}
+	target, _, _ := store.CreateTarget(context.Background(), "https://example.com", "https://example.com", nil)
 
-	t.Run("get all results", func(t *testing.T) {
-		req := httptest.NewRequest("GET", "/v1/targets/"+target.ID+"/results", nil)
+	t.Run("valid template", func(t *testing.T) {
+		reqBody := `{"url": "https://hooks.example.com/notify", "target_id": "` + target.ID + `", "template": "{\"text\": \"{{.Target.URL}} is {{.To}}\"}"}`
+		req := httptest.NewRequest("POST", "/v1/webhooks", bytes.NewBufferString(reqBody))
 		rec := httptest.NewRecorder()
 		router.ServeHTTP(rec, req)
 
-		if rec.Code != http.StatusOK {
-			t.Errorf("expected status %d, got %d", http.StatusOK, rec.Code)
+		if rec.Code != http.StatusCreated {
+			t.Fatalf("expected status %d, got %d: %s", http.StatusCreated, rec.Code, rec.Body.String())
 		}
 
-		var response models.CheckResultList
+		var response models.Webhook
 		if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
 			t.Fatalf("failed to unmarshal response: %v", err)
 		}
 
-		if len(response.Items) != 2 {
-			t.Errorf("expected 2 results, got %d", len(response.Items))
-		}
-
-		// Should be ordered most recent first
-		if response.Items[0].CheckedAt.Before(response.Items[1].CheckedAt) {
-			t.Error("results not properly ordered")
+		if response.ID == "" {
+			t.Error("expected non-empty ID")
 		}
 	})
 
-	t.Run("filter by since", func(t *testing.T) {
-		since := now.Add(-30 * time.Second)
-		req := httptest.NewRequest("GET", "/v1/targets/"+target.ID+"/results?since="+since.Format(time.RFC3339), nil)
+	t.Run("invalid template", func(t *testing.T) {
+		reqBody := `{"url": "https://hooks.example.com/notify", "template": "{{.Target.Missing}}"}`
+		req := httptest.NewRequest("POST", "/v1/webhooks", bytes.NewBufferString(reqBody))
 		rec := httptest.NewRecorder()
 		router.ServeHTTP(rec, req)
 
-		if rec.Code != http.StatusOK {
-			t.Errorf("expected status %d, got %d", http.StatusOK, rec.Code)
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("expected status %d for invalid template, got %d", http.StatusBadRequest, rec.Code)
 		}
+	})
 
-		var response models.CheckResultList
-		json.Unmarshal(rec.Body.Bytes(), &response)
-
-		if len(response.Items) != 1 {
-			t.Errorf("expected 1 result since %v, got %d", since, len(response.Items))
+	t.Run("host policy", func(t *testing.T) {
+		blocklist, err := policy.NewBlocklist([]string{"evil.example.com"})
+		if err != nil {
+			t.Fatalf("failed to build blocklist: %v", err)
+		}
+		router := NewRouterWithConfig(store, Config{Blocklist: blocklist, BlockPrivate: true})
+
+		for _, tt := range []struct {
+			url    string
+			status int
+			code   ErrorCode
+		}{
+			{"https://evil.example.com/notify", http.StatusUnprocessableEntity, CodeHostBlocked},
+			{"http://169.254.169.254/latest/meta-data", http.StatusBadRequest, CodePrivateAddress},
+			{"http://127.0.0.1:8080/admin", http.StatusBadRequest, CodePrivateAddress},
+		} {
+			req := httptest.NewRequest("POST", "/v1/webhooks", bytes.NewBufferString(`{"url": "`+tt.url+`"}`))
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+
+			if rec.Code != tt.status || !strings.Contains(rec.Body.String(), string(tt.code)) {
+				t.Errorf("expected %d %s for %s, got %d: %s", tt.status, tt.code, tt.url, rec.Code, rec.Body.String())
+			}
 		}
 	})
 
-	t.Run("invalid since parameter", func(t *testing.T) {
-		req := httptest.NewRequest("GET", "/v1/targets/"+target.ID+"/results?since=invalid", nil)
+	t.Run("unknown target", func(t *testing.T) {
+		reqBody := `{"url": "https://hooks.example.com/notify", "target_id": "t_missing"}`
+		req := httptest.NewRequest("POST", "/v1/webhooks", bytes.NewBufferString(reqBody))
 		rec := httptest.NewRecorder()
 		router.ServeHTTP(rec, req)
 
-		if rec.Code != http.StatusBadRequest {
-			t.Errorf("expected status %d for invalid since, got %d", http.StatusBadRequest, rec.Code)
+		if rec.Code != http.StatusNotFound {
+			t.Errorf("expected status %d for unknown target, got %d", http.StatusNotFound, rec.Code)
 		}
 	})
 }
@@ -364,6 +2739,191 @@ func TestHealth(t *testing.T) {
 	}
 }
 
+func TestReady(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("failed to open test database: %v", err)
+	}
+	store := storage.New(db)
+	if err := store.Migrate(); err != nil {
+		t.Fatalf("failed to migrate test database: %v", err)
+	}
+	router := NewRouter(store)
+
+	probe := func() (int, models.Readiness) {
+		req := httptest.NewRequest("GET", "/readyz", nil)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+
+		var readiness models.Readiness
+		if err := json.Unmarshal(rec.Body.Bytes(), &readiness); err != nil {
+			t.Fatalf("failed to decode readiness: %v", err)
+		}
+		return rec.Code, readiness
+	}
+
+	code, readiness := probe()
+	if code != http.StatusOK {
+		t.Errorf("expected status %d, got %d", http.StatusOK, code)
+	}
+	if readiness.Status != "ready" || readiness.Checks["database"] != "ok" {
+		t.Errorf("unexpected readiness: %+v", readiness)
+	}
+
+	db.Close()
+
+	code, readiness = probe()
+	if code != http.StatusServiceUnavailable {
+		t.Errorf("expected status %d once the database is gone, got %d", http.StatusServiceUnavailable, code)
+	}
+	if readiness.Status != "unavailable" || readiness.Checks["database"] != "unreachable" {
+		t.Errorf("unexpected readiness: %+v", readiness)
+	}
+
+	// Liveness doesn't depend on the database
+	req := httptest.NewRequest("GET", "/healthz", nil)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Errorf("expected /healthz to stay up, got %d", rec.Code)
+	}
+}
+
+// TestOpenAPISpec checks that the served spec parses and documents exactly
+// the routes NewRouter registers, so it can't drift from router.go.
+func TestOpenAPISpec(t *testing.T) {
+	router := NewRouter(setupTestStore(t))
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest("GET", "/openapi.json", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", rec.Code)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("expected application/json, got %q", ct)
+	}
+
+	var spec struct {
+		OpenAPI string                                `json:"openapi"`
+		Paths   map[string]map[string]json.RawMessage `json:"paths"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &spec); err != nil {
+		t.Fatalf("spec isn't valid JSON: %v", err)
+	}
+	if !strings.HasPrefix(spec.OpenAPI, "3.") {
+		t.Errorf("expected an OpenAPI 3 document, got version %q", spec.OpenAPI)
+	}
+
+	documented := make(map[string]bool)
+	for path, item := range spec.Paths {
+		for method := range item {
+			if method != "parameters" {
+				documented[strings.ToUpper(method)+" "+path] = true
+			}
+		}
+	}
+
+	file, err := parser.ParseFile(token.NewFileSet(), "router.go", nil, 0)
+	if err != nil {
+		t.Fatalf("failed to parse router.go: %v", err)
+	}
+	registered := make(map[string]bool)
+	ast.Inspect(file, func(n ast.Node) bool {
+		call, ok := n.(*ast.CallExpr)
+		if !ok || len(call.Args) == 0 {
+			return true
+		}
+		sel, ok := call.Fun.(*ast.SelectorExpr)
+		if !ok || sel.Sel.Name != "HandleFunc" {
+			return true
+		}
+		if lit, ok := call.Args[0].(*ast.BasicLit); ok && lit.Kind == token.STRING {
+			pattern, _ := strconv.Unquote(lit.Value)
+			registered[pattern] = true
+		}
+		return true
+	})
+	if len(registered) == 0 {
+		t.Fatal("found no routes in router.go")
+	}
+
+	for route := range registered {
+		if !documented[route] {
+			t.Errorf("route %q is missing from openapi.json", route)
+		}
+	}
+	for route := range documented {
+		if !registered[route] {
+			t.Errorf("openapi.json documents %q, which isn't registered", route)
+		}
+	}
+}
+
+func TestStreamEvents(t *testing.T) {
+	store := setupTestStore(t)
+
+	disabled := httptest.NewRecorder()
+	NewRouter(store).ServeHTTP(disabled, httptest.NewRequest("GET", "/v1/events", nil))
+	if disabled.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected status %d without a hub, got %d", http.StatusServiceUnavailable, disabled.Code)
+	}
+
+	hub := events.NewHub()
+	// The stream must outlive the request timeout
+	server := httptest.NewServer(NewRouterWithConfig(store, Config{Events: hub, RequestTimeout: 50 * time.Millisecond}))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/v1/events?target_id=t_2")
+	if err != nil {
+		t.Fatalf("failed to open stream: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, resp.StatusCode)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("expected text/event-stream, got %q", ct)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	checkedAt := time.Date(2025, 8, 17, 12, 0, 0, 0, time.UTC)
+	hub.Publish(models.CheckEvent{TargetID: "t_1", Result: models.CheckResult{CheckedAt: checkedAt, StatusCode: intPtr(500)}})
+	hub.Publish(models.CheckEvent{TargetID: "t_2", Result: models.CheckResult{CheckedAt: checkedAt, StatusCode: intPtr(200)}})
+
+	reader := bufio.NewReader(resp.Body)
+	var lines []string
+	for len(lines) < 2 {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("failed to read stream: %v", err)
+		}
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+
+	if lines[0] != "event: check" {
+		t.Errorf("expected a check event, got %q", lines[0])
+	}
+	var event models.CheckEvent
+	if err := json.Unmarshal([]byte(strings.TrimPrefix(lines[1], "data: ")), &event); err != nil {
+		t.Fatalf("failed to decode event %q: %v", lines[1], err)
+	}
+	if event.TargetID != "t_2" || event.Result.StatusCode == nil || *event.Result.StatusCode != 200 {
+		t.Errorf("expected only t_2's result, got %+v", event)
+	}
+
+	// Closing the hub ends the stream
+	hub.Close()
+	if _, err := io.ReadAll(reader); err != nil {
+		t.Errorf("expected the stream to end cleanly, got %v", err)
+	}
+}
+
 func intPtr(i int) *int {
 	return &i
 }
+
+func stringPtr(s string) *string {
+	return &s
+}
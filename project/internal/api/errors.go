@@ -0,0 +1,33 @@
+package api
+
+// ErrorCode is the machine-readable "code" of an error response. Codes are
+// stable, unlike the accompanying messages, so clients can branch on them.
+type ErrorCode string
+
+const (
+	CodeInternal             ErrorCode = "internal_error"
+	CodeUnauthorized         ErrorCode = "unauthorized"
+	CodeInvalidJSON          ErrorCode = "invalid_json"
+	CodeBodyTooLarge         ErrorCode = "body_too_large"
+	CodeInvalidURL           ErrorCode = "invalid_url"
+	CodeInvalidHost          ErrorCode = "invalid_host"      // The URL parsed but its host can't be checked
+	CodeInvalidRequest       ErrorCode = "invalid_request"   // A body field failed validation
+	CodeInvalidParameter     ErrorCode = "invalid_parameter" // A query parameter failed validation
+	CodeInvalidPageToken     ErrorCode = "invalid_page_token"
+	CodeTargetNotFound       ErrorCode = "target_not_found"
+	CodeProfileNotFound      ErrorCode = "profile_not_found"
+	CodeJobNotFound          ErrorCode = "job_not_found"
+	CodeNoResults            ErrorCode = "no_results" // The target exists but has never been checked
+	CodeNotFound             ErrorCode = "not_found"  // No route matches the path
+	CodeMethodNotAllowed     ErrorCode = "method_not_allowed"
+	CodeTargetExists         ErrorCode = "target_exists"
+	CodeTargetLimit          ErrorCode = "target_limit_reached"
+	CodeHostBlocked          ErrorCode = "host_blocked"
+	CodeRateLimited          ErrorCode = "rate_limited"
+	CodePrivateAddress       ErrorCode = "private_address"
+	CodeHostBusy             ErrorCode = "host_busy"
+	CodeManualChecksDisabled ErrorCode = "manual_checks_disabled"
+	CodeEventsDisabled       ErrorCode = "events_disabled"
+	CodeCheckerDisabled      ErrorCode = "checker_disabled"
+	CodeAdminDisabled        ErrorCode = "admin_disabled" // Admin endpoints need API_KEYS set
+)
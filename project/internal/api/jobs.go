@@ -0,0 +1,95 @@
+package api
+
+import (
+	"sync"
+	"time"
+
+	"github.com/aarushishahhh/linkwatch/project/internal/models"
+	"github.com/aarushishahhh/linkwatch/project/internal/storage"
+)
+
+// jobTTL is how long a completed job can still be polled before it is
+// dropped
+const jobTTL = time.Hour
+
+// jobStore keeps the status of asynchronous operations in memory. Jobs are
+// short-lived status resources, so they don't survive a restart.
+type jobStore struct {
+	mu        sync.RWMutex
+	jobs      map[string]*models.Job
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+func newJobStore() *jobStore {
+	return &jobStore{jobs: make(map[string]*models.Job), now: time.Now}
+}
+
+func (s *jobStore) create() models.Job {
+	now := s.now()
+	job := &models.Job{
+		ID:        storage.GenerateID("job_"),
+		Status:    models.JobStatusPending,
+		CreatedAt: now.UTC(),
+	}
+
+	s.mu.Lock()
+	if now.Sub(s.lastSweep) >= jobTTL {
+		s.sweep(now)
+	}
+	s.jobs[job.ID] = job
+	s.mu.Unlock()
+
+	return *job
+}
+
+// get returns the job with the given ID, unless it completed more than
+// jobTTL ago.
+func (s *jobStore) get(id string) (models.Job, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	job, ok := s.jobs[id]
+	if !ok || s.expired(job, s.now()) {
+		return models.Job{}, false
+	}
+	return *job, true
+}
+
+func (s *jobStore) complete(id string, targetID string, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	job, ok := s.jobs[id]
+	if !ok {
+		return
+	}
+
+	now := s.now().UTC()
+	job.CompletedAt = &now
+	if err != nil {
+		errorMsg := err.Error()
+		job.Status = models.JobStatusFailed
+		job.Error = &errorMsg
+		return
+	}
+	job.Status = models.JobStatusSucceeded
+	job.TargetID = &targetID
+}
+
+// sweep drops expired jobs, so a long-running process doesn't keep every
+// job it ever created.
+func (s *jobStore) sweep(now time.Time) {
+	for id, job := range s.jobs {
+		if s.expired(job, now) {
+			delete(s.jobs, id)
+		}
+	}
+	s.lastSweep = now
+}
+
+// expired reports whether job completed more than jobTTL before now.
+// Pending jobs never expire.
+func (s *jobStore) expired(job *models.Job, now time.Time) bool {
+	return job.CompletedAt != nil && now.Sub(*job.CompletedAt) >= jobTTL
+}
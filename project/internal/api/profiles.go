@@ -0,0 +1,135 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/aarushishahhh/linkwatch/project/internal/models"
+	"github.com/aarushishahhh/linkwatch/project/internal/policy"
+)
+
+// validateCheckSettings checks the settings shared by targets and profiles,
+// returning success_status in its normalized form.
+func validateCheckSettings(successStatus *string, timeoutMs *int) (*string, error) {
+	if timeoutMs != nil && *timeoutMs <= 0 {
+		return nil, fmt.Errorf("timeout_ms must be positive")
+	}
+
+	if successStatus == nil {
+		return nil, nil
+	}
+	ranges, err := policy.ParseStatusRanges(*successStatus)
+	if err != nil {
+		return nil, fmt.Errorf("invalid success_status: %v", err)
+	}
+	normalized := ranges.String()
+	return &normalized, nil
+}
+
+// decodeProfileRequest reads and validates a profile body, writing a 400 and
+// returning false when it is invalid.
+func decodeProfileRequest(w http.ResponseWriter, r *http.Request) (models.ProfileRequest, bool) {
+	var req models.ProfileRequest
+	if !decodeJSON(w, r, &req) {
+		return req, false
+	}
+
+	if req.Name == "" {
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest, "name is required")
+		return req, false
+	}
+
+	successStatus, err := validateCheckSettings(req.SuccessStatus, req.TimeoutMs)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+		return req, false
+	}
+	req.SuccessStatus = successStatus
+	return req, true
+}
+
+func (h *Handler) CreateProfile(w http.ResponseWriter, r *http.Request) {
+	req, ok := decodeProfileRequest(w, r)
+	if !ok {
+		return
+	}
+
+	profile, err := h.store.CreateProfile(r.Context(), req)
+	if err != nil {
+		requestLogger(r).Error("failed to create profile", "error", err, "name", req.Name)
+		writeError(w, http.StatusInternalServerError, CodeInternal, "internal error")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(profile)
+}
+
+func (h *Handler) ListProfiles(w http.ResponseWriter, r *http.Request) {
+	profiles, err := h.store.ListProfiles(r.Context())
+	if err != nil {
+		requestLogger(r).Error("failed to list profiles", "error", err)
+		writeError(w, http.StatusInternalServerError, CodeInternal, "internal error")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(profiles)
+}
+
+func (h *Handler) GetProfile(w http.ResponseWriter, r *http.Request) {
+	profileID := r.PathValue("profile_id")
+	profile, err := h.store.GetProfile(r.Context(), profileID)
+	if err != nil {
+		requestLogger(r).Error("failed to get profile", "error", err, "profile_id", profileID)
+		writeError(w, http.StatusInternalServerError, CodeInternal, "internal error")
+		return
+	}
+	if profile == nil {
+		writeError(w, http.StatusNotFound, CodeProfileNotFound, "profile not found")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(profile)
+}
+
+func (h *Handler) UpdateProfile(w http.ResponseWriter, r *http.Request) {
+	req, ok := decodeProfileRequest(w, r)
+	if !ok {
+		return
+	}
+
+	profileID := r.PathValue("profile_id")
+	profile, err := h.store.UpdateProfile(r.Context(), profileID, req)
+	if err != nil {
+		requestLogger(r).Error("failed to update profile", "error", err, "profile_id", profileID)
+		writeError(w, http.StatusInternalServerError, CodeInternal, "internal error")
+		return
+	}
+	if profile == nil {
+		writeError(w, http.StatusNotFound, CodeProfileNotFound, "profile not found")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(profile)
+}
+
+func (h *Handler) DeleteProfile(w http.ResponseWriter, r *http.Request) {
+	profileID := r.PathValue("profile_id")
+	found, err := h.store.DeleteProfile(r.Context(), profileID)
+	if err != nil {
+		requestLogger(r).Error("failed to delete profile", "error", err, "profile_id", profileID)
+		writeError(w, http.StatusInternalServerError, CodeInternal, "internal error")
+		return
+	}
+	if !found {
+		writeError(w, http.StatusNotFound, CodeProfileNotFound, "profile not found")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
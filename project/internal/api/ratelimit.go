@@ -0,0 +1,116 @@
+package api
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// rateLimiter is a token bucket per client: each holds up to burst tokens
+// and refills at rate per second, and every request takes one.
+type rateLimiter struct {
+	rate  float64
+	burst float64
+	// byKey tells clients apart by API key, which withAuth has checked
+	// only when API keys are configured
+	byKey bool
+
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// sweepInterval is how often buckets that have refilled completely, and so
+// are indistinguishable from new ones, are dropped
+const sweepInterval = time.Minute
+
+// newRateLimiter returns a limiter allowing rate requests per second with
+// bursts of up to burst, or nil (no limit) when rate isn't positive. byKey
+// counts requests against their API key rather than their remote address.
+func newRateLimiter(rate float64, burst int, byKey bool) *rateLimiter {
+	if rate <= 0 {
+		return nil
+	}
+	return &rateLimiter{
+		rate:    rate,
+		burst:   math.Max(float64(burst), 1),
+		byKey:   byKey,
+		buckets: make(map[string]*bucket),
+		now:     time.Now,
+	}
+}
+
+// allow takes a token from key's bucket, or reports how long until one is
+// available.
+func (l *rateLimiter) allow(key string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	if now.Sub(l.lastSweep) >= sweepInterval {
+		l.sweep(now)
+	}
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+	}
+	b.tokens--
+	return true, 0
+}
+
+func (l *rateLimiter) sweep(now time.Time) {
+	for key, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, key)
+		}
+	}
+	l.lastSweep = now
+}
+
+// limit rejects requests over the client's rate with a 429. Clients are
+// told apart by API key when the limiter is byKey and by remote address
+// otherwise. A nil limiter lets everything through.
+func (l *rateLimiter) limit(next http.HandlerFunc) http.HandlerFunc {
+	if l == nil {
+		return next
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		if ok, wait := l.allow(l.clientKey(r)); !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			writeError(w, http.StatusTooManyRequests, CodeRateLimited, "rate limit exceeded")
+			return
+		}
+		next(w, r)
+	}
+}
+
+// clientKey identifies the client a request counts against. Forwarding
+// headers are ignored since any client can set them, and so are unchecked
+// bearer tokens, which a client could change on every request.
+func (l *rateLimiter) clientKey(r *http.Request) string {
+	if token, ok := bearerToken(r); ok && l.byKey {
+		return "key:" + token
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "ip:" + host
+}
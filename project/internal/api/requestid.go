@@ -0,0 +1,52 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// RequestIDHeader carries the request ID in both directions
+const RequestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength bounds inbound IDs, which end up in every log line
+const maxRequestIDLength = 128
+
+type requestIDKey struct{}
+
+// RequestIDFromContext returns the ID of the request ctx belongs to, or ""
+// outside a request.
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// requestID returns the caller's X-Request-ID when it is usable, so a trace
+// can be followed across services, and a fresh one otherwise.
+func requestID(r *http.Request) string {
+	if id := r.Header.Get(RequestIDHeader); validRequestID(id) {
+		return id
+	}
+	return fmt.Sprintf("req_%d", time.Now().UnixNano())
+}
+
+// validRequestID accepts non-empty printable ASCII up to maxRequestIDLength,
+// keeping control characters out of logs.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] < ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// requestLogger is the default logger tagged with the request's ID.
+func requestLogger(r *http.Request) *slog.Logger {
+	return slog.With("request_id", RequestIDFromContext(r.Context()))
+}
@@ -1,155 +1,1276 @@
 package api
 
 import (
+	"context"
+	"encoding/csv"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log/slog"
+	"mime"
+	"net"
 	"net/http"
 	"net/url"
+	"regexp"
 	"strconv"
-	_ "strings"
+	"strings"
 	"time"
 
+	"github.com/aarushishahhh/linkwatch/project/internal/checker"
+	"github.com/aarushishahhh/linkwatch/project/internal/events"
 	"github.com/aarushishahhh/linkwatch/project/internal/models"
+	"github.com/aarushishahhh/linkwatch/project/internal/policy"
 	"github.com/aarushishahhh/linkwatch/project/internal/storage"
+	"github.com/aarushishahhh/linkwatch/project/internal/webhook"
 )
 
+type Config struct {
+	// WWW is the default www normalization for new targets; requests may override it
+	WWW storage.WWWMode
+
+	// StripParams are query parameters removed from new target URLs during
+	// canonicalization
+	StripParams []string
+
+	// Blocklist rejects targets on disallowed hosts, domains or TLDs
+	Blocklist *policy.Blocklist
+
+	// BlockPrivate rejects targets whose host resolves to a private, loopback
+	// or link-local address
+	BlockPrivate bool
+
+	// TrendWindow is the number of checks per window used to compute the
+	// latency trend on results; zero disables it
+	TrendWindow int
+
+	// ResultCache serves ?include=last_check without a database read when
+	// set; misses fall back to the database
+	ResultCache *storage.ResultCache
+
+	// Events feeds GET /v1/events with saved check results; the endpoint
+	// answers 503 when it is nil
+	Events *events.Hub
+
+	// Checker runs POST /v1/targets/{target_id}/check; the endpoint answers
+	// 503 when it is nil
+	Checker *checker.Checker
+
+	// RequestTimeout bounds reading each request and its context; MaxBodyBytes
+	// caps request bodies. Zero disables either limit.
+	RequestTimeout time.Duration
+	MaxBodyBytes   int64
+
+	// APIKeys are the bearer tokens accepted on every endpoint but the
+	// health probes; the API is open when empty
+	APIKeys []string
+
+	// CreateRateLimit is how many target creates per second each client may
+	// make, in bursts of up to CreateRateBurst; unlimited when zero
+	CreateRateLimit float64
+	CreateRateBurst int
+
+	// Compress gzips responses for clients that accept it
+	Compress bool
+
+	// Debug serves GET /v1/debug/checker; the path is unknown otherwise
+	Debug bool
+}
+
+// checkNowWait is how long a manual check waits for the target's host to be
+// free before giving up with a 409.
+const checkNowWait = 2 * time.Second
+
 type Handler struct {
-	store *storage.Storage
+	store  *storage.Storage
+	config Config
+	jobs   *jobStore
+}
+
+func NewRouter(store *storage.Storage) http.Handler {
+	return NewRouterWithConfig(store, Config{})
+}
+
+func NewRouterWithConfig(store *storage.Storage, config Config) http.Handler {
+	h := &Handler{store: store, config: config, jobs: newJobStore()}
+
+	// Creates share one limit, so batches can't be used to get around it
+	createLimit := newRateLimiter(config.CreateRateLimit, config.CreateRateBurst, len(config.APIKeys) > 0)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /v1/targets", createLimit.limit(h.CreateTarget))
+	mux.HandleFunc("GET /v1/targets", h.ListTargets)
+	mux.HandleFunc("POST /v1/targets:batchCreate", createLimit.limit(h.BatchCreateTargets))
+	mux.HandleFunc("PATCH /v1/targets/{target_id}", h.UpdateTarget)
+	mux.HandleFunc("POST /v1/targets/{target_id}/check", h.CheckTarget)
+	mux.HandleFunc("POST /v1/check", h.DryRunCheck)
+	mux.HandleFunc("GET /v1/targets/{target_id}/results", h.GetCheckResults)
+	mux.HandleFunc("GET /v1/targets/{target_id}/results/latest", h.GetLatestCheckResult)
+	mux.HandleFunc("DELETE /v1/targets/{target_id}/results", h.DeleteCheckResults)
+	mux.HandleFunc("GET /v1/targets/{target_id}/stats", h.GetCheckStats)
+	mux.HandleFunc("GET /v1/hosts/stats", h.GetHostStats)
+	mux.HandleFunc("GET /v1/jobs/{job_id}", h.GetJob)
+	mux.HandleFunc("GET /v1/events", h.StreamEvents)
+	mux.HandleFunc("GET /v1/admin/check-filter", h.GetCheckFilter)
+	mux.HandleFunc("PUT /v1/admin/check-filter", h.UpdateCheckFilter)
+	mux.HandleFunc("POST /v1/admin/pause", h.PauseChecks)
+	mux.HandleFunc("POST /v1/admin/resume", h.ResumeChecks)
+	mux.HandleFunc("POST /v1/webhooks", h.CreateWebhook)
+	mux.HandleFunc("POST /v1/profiles", h.CreateProfile)
+	mux.HandleFunc("GET /v1/profiles", h.ListProfiles)
+	mux.HandleFunc("GET /v1/profiles/{profile_id}", h.GetProfile)
+	mux.HandleFunc("PUT /v1/profiles/{profile_id}", h.UpdateProfile)
+	mux.HandleFunc("DELETE /v1/profiles/{profile_id}", h.DeleteProfile)
+	mux.HandleFunc("GET /v1/audit/verify", h.VerifyAuditLog)
+	mux.HandleFunc("GET /healthz", h.Health)
+	mux.HandleFunc("GET /readyz", h.Ready)
+	mux.HandleFunc("GET /openapi.json", h.OpenAPI)
+	if config.Debug {
+		mux.HandleFunc("GET /v1/debug/checker", h.DebugChecker)
+	}
+
+	var handler http.Handler = withAuth(withLimits(withJSONErrors(mux), config.RequestTimeout, config.MaxBodyBytes), config.APIKeys)
+	if config.Compress {
+		handler = withCompression(handler)
+	}
+	return withLogging(withCORS(handler))
+}
+
+func (h *Handler) CreateTarget(w http.ResponseWriter, r *http.Request) {
+	var req models.CreateTargetRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	opts := storage.CanonicalizeOptions{WWW: h.config.WWW, StripParams: h.config.StripParams}
+	if req.WWW != nil {
+		mode, err := storage.ParseWWWMode(*req.WWW)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+			return
+		}
+		opts.WWW = mode
+	}
+
+	canonicalURL, urlErr := h.validateTargetURL(r, req.URL, opts)
+	if urlErr != nil {
+		writeError(w, urlErr.status, urlErr.code, urlErr.message)
+		return
+	}
+
+	settings := models.TargetSettings{DependsOn: req.DependsOn, TimeoutMs: req.TimeoutMs, ProfileID: req.ProfileID}
+	var err error
+	settings.Headers, err = validateHeaders(req.Headers)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+		return
+	}
+
+	if err := validateBasicAuth(req.Username, req.Password, settings.Headers); err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+		return
+	}
+	settings.Username = req.Username
+	settings.Password = req.Password
+
+	if err := validateBodyAssertions(req.ExpectedBody, req.BodyRegex); err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+		return
+	}
+	settings.ExpectedBody = req.ExpectedBody
+	settings.BodyRegex = req.BodyRegex
+
+	if err := validateMaintenanceWindows(req.MaintenanceWindows); err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+		return
+	}
+	settings.MaintenanceWindows = req.MaintenanceWindows
+
+	if req.IPVersion != nil {
+		if _, err := checker.ParseIPVersion(*req.IPVersion); err != nil || *req.IPVersion == "" {
+			writeError(w, http.StatusBadRequest, CodeInvalidRequest, "ip_version must be auto, v4 or v6")
+			return
+		}
+		settings.IPVersion = req.IPVersion
+	}
+
+	if req.CheckInterval != nil {
+		interval, err := time.ParseDuration(*req.CheckInterval)
+		if err != nil || interval < time.Second {
+			writeError(w, http.StatusBadRequest, CodeInvalidRequest, "check_interval must be a duration of at least 1s, e.g. \"30s\"")
+			return
+		}
+		normalized := interval.String()
+		settings.CheckInterval = &normalized
+	}
+
+	settings.SuccessStatus, err = validateCheckSettings(req.SuccessStatus, req.TimeoutMs)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+		return
+	}
+
+	if req.ProfileID != nil {
+		profile, err := h.store.GetProfile(r.Context(), *req.ProfileID)
+		if err != nil {
+			requestLogger(r).Error("failed to get profile", "error", err, "profile_id", *req.ProfileID)
+			writeError(w, http.StatusInternalServerError, CodeInternal, "internal error")
+			return
+		}
+		if profile == nil {
+			writeError(w, http.StatusBadRequest, CodeProfileNotFound, "profile_id not found")
+			return
+		}
+	}
+
+	if req.DependsOn != nil {
+		dependency, err := h.store.GetTarget(r.Context(), *req.DependsOn)
+		if err != nil {
+			requestLogger(r).Error("failed to get target", "error", err, "target_id", *req.DependsOn)
+			writeError(w, http.StatusInternalServerError, CodeInternal, "internal error")
+			return
+		}
+		if dependency == nil {
+			writeError(w, http.StatusBadRequest, CodeTargetNotFound, "depends_on target not found")
+			return
+		}
+	}
+
+	// Handle idempotency key
+	var idempotencyKey *string
+	if key := r.Header.Get("Idempotency-Key"); key != "" {
+		idempotencyKey = &key
+	}
+
+	// Process the create in the background when the client asks for it
+	if preferAsync(r) {
+		job := h.jobs.create()
+		// The create outlives the request, so it mustn't be cancelled with it
+		ctx := context.WithoutCancel(r.Context())
+		go func() {
+			target, isNew, err := h.store.CreateTargetWithSettings(ctx, req.URL, canonicalURL, idempotencyKey, settings)
+			if errors.Is(err, storage.ErrTargetLimit) {
+				h.jobs.complete(job.ID, "", err)
+				return
+			}
+			if err != nil {
+				requestLogger(r).Error("failed to create target", "error", err, "url", req.URL, "job_id", job.ID)
+				h.jobs.complete(job.ID, "", fmt.Errorf("internal error"))
+				return
+			}
+			if isNew {
+				h.wakeChecker()
+			}
+			h.jobs.complete(job.ID, target.ID, nil)
+		}()
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Location", "/v1/jobs/"+job.ID)
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(job)
+		return
+	}
+
+	target, isNew, err := h.store.CreateTargetWithSettings(r.Context(), req.URL, canonicalURL, idempotencyKey, settings)
+	if errors.Is(err, storage.ErrTargetLimit) {
+		writeError(w, http.StatusForbidden, CodeTargetLimit, err.Error())
+		return
+	}
+	if err != nil {
+		requestLogger(r).Error("failed to create target", "error", err, "url", req.URL)
+		writeError(w, http.StatusInternalServerError, CodeInternal, "internal error")
+		return
+	}
+
+	if !isNew && ifNotExists(r) {
+		writeError(w, http.StatusConflict, CodeTargetExists, "target already exists")
+		return
+	}
+
+	statusCode := http.StatusOK
+	if isNew {
+		statusCode = http.StatusCreated
+		h.wakeChecker()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(models.CreateTargetResponse{
+		ID:            target.ID,
+		URL:           target.URL,
+		CreatedAt:     target.CreatedAt,
+		DependsOn:     target.DependsOn,
+		SuccessStatus: target.SuccessStatus,
+		TimeoutMs:     target.TimeoutMs,
+		ProfileID:     target.ProfileID,
+		CheckInterval: target.CheckInterval,
+		Headers:       target.Headers,
+		Username:      target.Username,
+		Password:      target.Password,
+		ExpectedBody:  target.ExpectedBody,
+		BodyRegex:     target.BodyRegex,
+
+		MaintenanceWindows: target.MaintenanceWindows,
+		IPVersion:          target.IPVersion,
+	})
+}
+
+// targetURLError is why a URL can't be monitored, as an API error.
+type targetURLError struct {
+	status  int
+	code    ErrorCode
+	message string
+}
+
+// validateTargetURL canonicalizes rawURL and checks it may be monitored: an
+// HTTP(S) URL whose host isn't blocklisted or, with BlockPrivate, private.
+func (h *Handler) validateTargetURL(r *http.Request, rawURL string, opts storage.CanonicalizeOptions) (string, *targetURLError) {
+	if rawURL == "" {
+		return "", &targetURLError{http.StatusBadRequest, CodeInvalidURL, "url is required"}
+	}
+
+	// Validate and canonicalize URL
+	canonicalURL, err := storage.CanonicalizeURLWithOptions(rawURL, opts)
+	if err != nil {
+		return "", &targetURLError{http.StatusBadRequest, CodeInvalidURL, fmt.Sprintf("invalid URL: %v", err)}
+	}
+
+	// Parse URL to validate it's HTTP/HTTPS
+	parsed, err := url.Parse(canonicalURL)
+	if err != nil {
+		return "", &targetURLError{http.StatusBadRequest, CodeInvalidURL, "invalid URL"}
+	}
+
+	if parsed.Scheme != "http" && parsed.Scheme != "https" {
+		return "", &targetURLError{http.StatusBadRequest, CodeInvalidURL, "URL must use HTTP or HTTPS scheme"}
+	}
+
+	if err := validateHost(parsed); err != nil {
+		return "", &targetURLError{http.StatusBadRequest, CodeInvalidHost, err.Error()}
+	}
+
+	if urlErr := h.checkHostPolicy(r, parsed.Hostname()); urlErr != nil {
+		return "", urlErr
+	}
+
+	return canonicalURL, nil
+}
+
+// checkHostPolicy refuses a host the server may not connect to: one that is
+// blocklisted or, with BlockPrivate, resolves to a private address.
+func (h *Handler) checkHostPolicy(r *http.Request, host string) *targetURLError {
+	if h.config.Blocklist.Blocked(host) {
+		return &targetURLError{http.StatusUnprocessableEntity, CodeHostBlocked, fmt.Sprintf("host %s is blocklisted", host)}
+	}
+
+	if h.config.BlockPrivate {
+		// Lookup failures are let through; the checker's and notifier's
+		// dialers still refuse private addresses once the host resolves
+		ip, err := policy.PrivateAddress(r.Context(), net.DefaultResolver, host)
+		if err != nil {
+			requestLogger(r).Debug("failed to resolve host", "error", err, "host", host)
+		}
+		if ip != nil {
+			return &targetURLError{http.StatusBadRequest, CodePrivateAddress, fmt.Sprintf("host %s resolves to private address %s", host, ip)}
+		}
+	}
+	return nil
+}
+
+// maxHostLength is the longest DNS name, and maxLabelLength its longest label
+const (
+	maxHostLength  = 253
+	maxLabelLength = 63
+)
+
+// validateHost rejects hosts no check could reach: missing, overlong, an
+// impossible port, or a name that isn't an IP address or made of valid DNS
+// labels. Hosts are already lowercase punycode after canonicalization.
+func validateHost(u *url.URL) error {
+	host := u.Hostname()
+	if host == "" {
+		return errors.New("URL must have a host")
+	}
+	if port := u.Port(); port != "" {
+		if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+			return fmt.Errorf("invalid port %q", port)
+		}
+	}
+	if len(host) > maxHostLength {
+		return fmt.Errorf("host is longer than %d characters", maxHostLength)
+	}
+	if strings.HasPrefix(u.Host, "[") {
+		// url.Parse has already checked the IPv6 literal
+		return nil
+	}
+
+	// A fully qualified name may end in a dot
+	for _, label := range strings.Split(strings.TrimSuffix(host, "."), ".") {
+		if err := validateLabel(label); err != nil {
+			return fmt.Errorf("invalid host %q: %v", host, err)
+		}
+	}
+	return nil
+}
+
+// validateLabel checks one dot-separated part of a host name. Underscores
+// are allowed since some real hosts use them, though DNS names shouldn't.
+func validateLabel(label string) error {
+	if label == "" {
+		return errors.New("empty label")
+	}
+	if len(label) > maxLabelLength {
+		return fmt.Errorf("label longer than %d characters", maxLabelLength)
+	}
+	if label[0] == '-' || label[len(label)-1] == '-' {
+		return errors.New("label starts or ends with a hyphen")
+	}
+	for _, c := range label {
+		if !('a' <= c && c <= 'z' || '0' <= c && c <= '9' || c == '-' || c == '_') {
+			return fmt.Errorf("invalid character %q", c)
+		}
+	}
+	return nil
+}
+
+// maxBatchCreate caps the URLs accepted by one batch create
+const maxBatchCreate = 1000
+
+// BatchCreateTargets creates a target per URL with the server's default
+// settings. Invalid URLs, and new ones past MAX_TARGETS, are reported per
+// item and don't fail the batch.
+func (h *Handler) BatchCreateTargets(w http.ResponseWriter, r *http.Request) {
+	var req models.BatchCreateTargetsRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	if len(req.URLs) == 0 {
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest, "urls is required")
+		return
+	}
+	if len(req.URLs) > maxBatchCreate {
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest, fmt.Sprintf("at most %d urls can be created at once", maxBatchCreate))
+		return
+	}
+
+	opts := storage.CanonicalizeOptions{WWW: h.config.WWW, StripParams: h.config.StripParams}
+	resp := models.BatchCreateTargetsResponse{Items: make([]models.BatchCreateTargetResult, len(req.URLs))}
+	var valid []storage.TargetURL
+	var validIdx []int
+	for i, rawURL := range req.URLs {
+		resp.Items[i].URL = rawURL
+		canonicalURL, urlErr := h.validateTargetURL(r, rawURL, opts)
+		if urlErr != nil {
+			resp.Items[i].Status = models.BatchItemFailed
+			resp.Items[i].Error = urlErr.message
+			resp.Items[i].Code = string(urlErr.code)
+			resp.Failed++
+			continue
+		}
+		valid = append(valid, storage.TargetURL{URL: rawURL, CanonicalURL: canonicalURL})
+		validIdx = append(validIdx, i)
+	}
+
+	if len(valid) > 0 {
+		created, err := h.store.CreateTargets(r.Context(), valid)
+		if err != nil {
+			requestLogger(r).Error("failed to create targets", "error", err, "count", len(valid))
+			writeError(w, http.StatusInternalServerError, CodeInternal, "internal error")
+			return
+		}
+		for j, c := range created {
+			item := &resp.Items[validIdx[j]]
+			if c.Err != nil {
+				item.Status = models.BatchItemFailed
+				item.Error = c.Err.Error()
+				item.Code = string(CodeTargetLimit)
+				resp.Failed++
+				continue
+			}
+			item.ID = c.Target.ID
+			item.CreatedAt = &c.Target.CreatedAt
+			if c.Created {
+				item.Status = models.BatchItemCreated
+				resp.Created++
+			} else {
+				item.Status = models.BatchItemExisting
+				resp.Existing++
+			}
+		}
+		if resp.Created > 0 {
+			h.wakeChecker()
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// reservedHeaders are managed by the HTTP client and can't be set per target
+var reservedHeaders = map[string]bool{
+	"Host":              true,
+	"Content-Length":    true,
+	"Connection":        true,
+	"Transfer-Encoding": true,
+}
+
+// validateHeaders checks custom check headers and canonicalizes their names.
+func validateHeaders(headers models.Headers) (models.Headers, error) {
+	if len(headers) == 0 {
+		return nil, nil
+	}
+
+	canonical := make(models.Headers, len(headers))
+	for name, value := range headers {
+		if !validHeaderName(name) {
+			return nil, fmt.Errorf("invalid header name %q", name)
+		}
+		name = http.CanonicalHeaderKey(name)
+		if reservedHeaders[name] {
+			return nil, fmt.Errorf("header %s can't be set", name)
+		}
+		if strings.ContainsAny(value, "\r\n\x00") {
+			return nil, fmt.Errorf("invalid value for header %s", name)
+		}
+		canonical[name] = value
+	}
+	return canonical, nil
+}
+
+// validateBasicAuth checks basic auth credentials. A password needs a
+// username, and either conflicts with an Authorization header.
+func validateBasicAuth(username *string, password *models.Secret, headers models.Headers) error {
+	if username == nil {
+		if password != nil {
+			return fmt.Errorf("password requires a username")
+		}
+		return nil
+	}
+	if *username == "" || strings.ContainsAny(*username, ":\r\n\x00") {
+		return fmt.Errorf("username must be non-empty and can't contain ':'")
+	}
+	if _, ok := headers["Authorization"]; ok {
+		return fmt.Errorf("username can't be combined with an Authorization header")
+	}
+	return nil
+}
+
+// validateBodyAssertions rejects an empty expected_body and a body_regex
+// that doesn't compile.
+func validateBodyAssertions(expectedBody, bodyRegex *string) error {
+	if expectedBody != nil && *expectedBody == "" {
+		return fmt.Errorf("expected_body must not be empty")
+	}
+	if bodyRegex != nil {
+		if *bodyRegex == "" {
+			return fmt.Errorf("body_regex must not be empty")
+		}
+		if _, err := regexp.Compile(*bodyRegex); err != nil {
+			return fmt.Errorf("invalid body_regex: %v", err)
+		}
+	}
+	return nil
+}
+
+// validateMaintenanceWindows rejects windows with malformed times or days.
+func validateMaintenanceWindows(windows []models.MaintenanceWindow) error {
+	for i, window := range windows {
+		if err := window.Validate(); err != nil {
+			return fmt.Errorf("maintenance_windows[%d]: %v", i, err)
+		}
+	}
+	return nil
+}
+
+// validHeaderName reports whether name is an RFC 9110 token.
+func validHeaderName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, r := range name {
+		if r <= ' ' || r >= 0x7f || strings.ContainsRune("()<>@,;:\\\"/[]?={}", r) {
+			return false
+		}
+	}
+	return true
+}
+
+func (h *Handler) ListTargets(w http.ResponseWriter, r *http.Request) {
+	// Parse query parameters
+	var filter models.TargetFilter
+	if host := r.URL.Query().Get("host"); host != "" {
+		filter.Host = &host
+	}
+	if suffix := r.URL.Query().Get("host_suffix"); suffix != "" {
+		filter.HostSuffix = &suffix
+	}
+
+	if wantsNDJSON(r) {
+		h.streamTargets(w, r, filter)
+		return
+	}
+
+	limit := 10 // default
+	if l := r.URL.Query().Get("limit"); l != "" {
+		if parsed, err := strconv.Atoi(l); err == nil && parsed > 0 && parsed <= 100 {
+			limit = parsed
+		}
+	}
+
+	sort, ok := targetSort(w, r)
+	if !ok {
+		return
+	}
+
+	pageToken := r.URL.Query().Get("page_token")
+
+	targets, err := h.store.ListTargetsSorted(r.Context(), filter, limit, pageToken, sort)
+	if errors.Is(err, storage.ErrInvalidPageToken) {
+		writeError(w, http.StatusBadRequest, CodeInvalidPageToken, "invalid page_token")
+		return
+	}
+	if err != nil {
+		requestLogger(r).Error("failed to list targets", "error", err)
+		writeError(w, http.StatusInternalServerError, CodeInternal, "internal error")
+		return
+	}
+
+	if includeTotal(r) {
+		total, err := h.store.CountTargets(r.Context(), filter)
+		if err != nil {
+			requestLogger(r).Error("failed to count targets", "error", err)
+			writeError(w, http.StatusInternalServerError, CodeInternal, "internal error")
+			return
+		}
+		targets.TotalCount = &total
+	}
+
+	if includes(r, "last_check") {
+		for i := range targets.Items {
+			last, err := h.lastCheck(r.Context(), targets.Items[i].ID)
+			if err != nil {
+				requestLogger(r).Error("failed to get latest check result", "error", err, "target_id", targets.Items[i].ID)
+				writeError(w, http.StatusInternalServerError, CodeInternal, "internal error")
+				return
+			}
+			targets.Items[i].LastCheck = last
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(targets)
+}
+
+// lastCheck returns the target's latest result from the cache, falling back
+// to the database (and filling the cache) on a miss.
+func (h *Handler) lastCheck(ctx context.Context, targetID string) (*models.CheckResult, error) {
+	if result, ok := h.config.ResultCache.Get(targetID); ok {
+		return &result, nil
+	}
+
+	result, err := h.store.GetLatestCheckResult(ctx, targetID, true)
+	if err != nil || result == nil {
+		return nil, err
+	}
+	h.config.ResultCache.Set(targetID, *result)
+	return result, nil
+}
+
+// includes reports whether the comma-separated include parameter names field.
+func includes(r *http.Request, field string) bool {
+	for _, value := range strings.Split(r.URL.Query().Get("include"), ",") {
+		if strings.TrimSpace(value) == field {
+			return true
+		}
+	}
+	return false
+}
+
+// streamTargets writes every matching target as newline-delimited JSON,
+// bypassing pagination so exporters get everything in one request.
+func (h *Handler) streamTargets(w http.ResponseWriter, r *http.Request, filter models.TargetFilter) {
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+
+	flusher, _ := w.(http.Flusher)
+	enc := json.NewEncoder(w)
+	count := 0
+
+	err := h.store.StreamTargets(r.Context(), filter, func(target models.Target) error {
+		if err := enc.Encode(target); err != nil {
+			return err
+		}
+		count++
+		if flusher != nil && count%100 == 0 {
+			flusher.Flush()
+		}
+		return nil
+	})
+	if err != nil {
+		// Headers are already sent, so the client sees a truncated stream
+		requestLogger(r).Error("failed to stream targets", "error", err, "streamed", count)
+		return
+	}
+
+	if flusher != nil {
+		flusher.Flush()
+	}
+}
+
+func (h *Handler) UpdateTarget(w http.ResponseWriter, r *http.Request) {
+	targetID := r.PathValue("target_id")
+
+	var req models.UpdateTargetRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	if req.Paused == nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest, "no updatable fields given")
+		return
+	}
+
+	target, err := h.store.UpdateTarget(r.Context(), targetID, req)
+	if err != nil {
+		requestLogger(r).Error("failed to update target", "error", err, "target_id", targetID)
+		writeError(w, http.StatusInternalServerError, CodeInternal, "internal error")
+		return
+	}
+	if target == nil {
+		writeError(w, http.StatusNotFound, CodeTargetNotFound, "target not found")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(target)
+}
+
+func (h *Handler) CheckTarget(w http.ResponseWriter, r *http.Request) {
+	if h.config.Checker == nil {
+		writeError(w, http.StatusServiceUnavailable, CodeManualChecksDisabled, "manual checks are not enabled")
+		return
+	}
+
+	targetID := r.PathValue("target_id")
+	target, err := h.store.GetTarget(r.Context(), targetID)
+	if err != nil {
+		requestLogger(r).Error("failed to get target", "error", err, "target_id", targetID)
+		writeError(w, http.StatusInternalServerError, CodeInternal, "internal error")
+		return
+	}
+	if target == nil {
+		writeError(w, http.StatusNotFound, CodeTargetNotFound, "target not found")
+		return
+	}
+
+	result, err := h.config.Checker.CheckNow(r.Context(), *target, checkNowWait)
+	if errors.Is(err, checker.ErrHostBusy) {
+		writeError(w, http.StatusConflict, CodeHostBusy, err.Error())
+		return
+	}
+	if err != nil {
+		requestLogger(r).Error("failed to check target", "error", err, "target_id", targetID)
+		writeError(w, http.StatusInternalServerError, CodeInternal, "internal error")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// DryRunCheck checks a URL once, validated like a new target's, and returns
+// the result without creating a target or saving anything. The URL is
+// checked as given, as a target created from it would be.
+func (h *Handler) DryRunCheck(w http.ResponseWriter, r *http.Request) {
+	if h.config.Checker == nil {
+		writeError(w, http.StatusServiceUnavailable, CodeManualChecksDisabled, "manual checks are not enabled")
+		return
+	}
+
+	var req models.DryRunRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	opts := storage.CanonicalizeOptions{WWW: h.config.WWW, StripParams: h.config.StripParams}
+	if _, urlErr := h.validateTargetURL(r, req.URL, opts); urlErr != nil {
+		writeError(w, urlErr.status, urlErr.code, urlErr.message)
+		return
+	}
+
+	result, err := h.config.Checker.DryRun(r.Context(), req.URL, checkNowWait)
+	if errors.Is(err, checker.ErrHostBusy) {
+		writeError(w, http.StatusConflict, CodeHostBusy, err.Error())
+		return
+	}
+	if err != nil {
+		requestLogger(r).Error("failed to check url", "error", err, "url", req.URL)
+		writeError(w, http.StatusInternalServerError, CodeInternal, "internal error")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// PauseChecks stops the checker in this process from starting checks until
+// ResumeChecks, e.g. during an incident.
+func (h *Handler) PauseChecks(w http.ResponseWriter, r *http.Request) {
+	if h.config.Checker == nil {
+		writeError(w, http.StatusServiceUnavailable, CodeCheckerDisabled, "checker is not running in this process")
+		return
+	}
+
+	h.config.Checker.Pause()
+	requestLogger(r).Warn("checks paused by request")
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(models.PauseResponse{Paused: h.config.Checker.Paused()})
+}
+
+// ResumeChecks undoes PauseChecks.
+func (h *Handler) ResumeChecks(w http.ResponseWriter, r *http.Request) {
+	if h.config.Checker == nil {
+		writeError(w, http.StatusServiceUnavailable, CodeCheckerDisabled, "checker is not running in this process")
+		return
+	}
+
+	h.config.Checker.Resume()
+	requestLogger(r).Info("checks resumed by request")
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(models.PauseResponse{Paused: h.config.Checker.Paused()})
 }
 
-func NewRouter(store *storage.Storage) http.Handler {
-	h := &Handler{store: store}
+// DebugChecker reports the checker's scheduling state, for working out why
+// checks have stalled.
+func (h *Handler) DebugChecker(w http.ResponseWriter, r *http.Request) {
+	if h.config.Checker == nil {
+		writeError(w, http.StatusServiceUnavailable, CodeCheckerDisabled, "checker is not running in this process")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.config.Checker.Status())
+}
+
+func (h *Handler) GetCheckResults(w http.ResponseWriter, r *http.Request) {
+	targetID := r.PathValue("target_id")
+	if targetID == "" {
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest, "target_id is required")
+		return
+	}
+
+	// Parse query parameters
+	var since *time.Time
+	if s := r.URL.Query().Get("since"); s != "" {
+		if parsed, err := time.Parse(time.RFC3339, s); err == nil {
+			since = &parsed
+		} else {
+			writeError(w, http.StatusBadRequest, CodeInvalidParameter, "invalid since parameter, expected RFC3339 format")
+			return
+		}
+	}
+
+	filter := models.ResultFilter{Since: since, PageToken: r.URL.Query().Get("page_token")}
+	var ok bool
+	if filter.MinLatencyMs, ok = latencyParam(w, r, "min_latency_ms"); !ok {
+		return
+	}
+	if filter.MaxLatencyMs, ok = latencyParam(w, r, "max_latency_ms"); !ok {
+		return
+	}
+	if v := r.URL.Query().Get("failed"); v != "" {
+		failed, err := strconv.ParseBool(v)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, CodeInvalidParameter, "invalid failed parameter, expected true or false")
+			return
+		}
+		filter.Failed = &failed
+	}
 
-	mux := http.NewServeMux()
-	mux.HandleFunc("POST /v1/targets", h.CreateTarget)
-	mux.HandleFunc("GET /v1/targets", h.ListTargets)
-	mux.HandleFunc("GET /v1/targets/{target_id}/results", h.GetCheckResults)
-	mux.HandleFunc("GET /healthz", h.Health)
+	if filter.MinLatencyMs != nil && filter.MaxLatencyMs != nil && *filter.MinLatencyMs > *filter.MaxLatencyMs {
+		writeError(w, http.StatusBadRequest, CodeInvalidParameter, "min_latency_ms must not be greater than max_latency_ms")
+		return
+	}
+
+	switch r.URL.Query().Get("format") {
+	case "", "json":
+	case "csv":
+		h.streamResultsCSV(w, r, targetID, filter)
+		return
+	default:
+		writeError(w, http.StatusBadRequest, CodeInvalidParameter, "invalid format parameter, expected json or csv")
+		return
+	}
+
+	limit := 50 // default
+	if l := r.URL.Query().Get("limit"); l != "" {
+		if parsed, err := strconv.Atoi(l); err == nil && parsed > 0 && parsed <= 1000 {
+			limit = parsed
+		}
+	}
+
+	results, err := h.store.GetCheckResultsWithFilter(r.Context(), targetID, filter, limit)
+	if errors.Is(err, storage.ErrInvalidPageToken) {
+		writeError(w, http.StatusBadRequest, CodeInvalidPageToken, "invalid page_token")
+		return
+	}
+	if err != nil {
+		requestLogger(r).Error("failed to get check results", "error", err, "target_id", targetID)
+		writeError(w, http.StatusInternalServerError, CodeInternal, "internal error")
+		return
+	}
+
+	if h.config.TrendWindow > 0 {
+		results.Trend = storage.LatencyTrend(results.Items, h.config.TrendWindow)
+	}
 
-	return withLogging(withCORS(mux))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(results)
 }
 
-func (h *Handler) CreateTarget(w http.ResponseWriter, r *http.Request) {
-	var req models.CreateTargetRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		writeError(w, http.StatusBadRequest, "invalid JSON")
+// GetLatestCheckResult returns the target's most recent result, suppressed
+// or not. It reads the database rather than RESULT_CACHE, so the result
+// carries its id like those listed under /results.
+func (h *Handler) GetLatestCheckResult(w http.ResponseWriter, r *http.Request) {
+	targetID := r.PathValue("target_id")
+
+	target, err := h.store.GetTarget(r.Context(), targetID)
+	if err != nil {
+		requestLogger(r).Error("failed to get target", "error", err, "target_id", targetID)
+		writeError(w, http.StatusInternalServerError, CodeInternal, "internal error")
+		return
+	}
+	if target == nil {
+		writeError(w, http.StatusNotFound, CodeTargetNotFound, "target not found")
 		return
 	}
 
-	if req.URL == "" {
-		writeError(w, http.StatusBadRequest, "url is required")
+	result, err := h.store.GetLatestCheckResult(r.Context(), targetID, true)
+	if err != nil {
+		requestLogger(r).Error("failed to get latest check result", "error", err, "target_id", targetID)
+		writeError(w, http.StatusInternalServerError, CodeInternal, "internal error")
+		return
+	}
+	if result == nil {
+		writeError(w, http.StatusNotFound, CodeNoResults, "target has not been checked yet")
 		return
 	}
 
-	// Validate and canonicalize URL
-	canonicalURL, err := storage.CanonicalizeURL(req.URL)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// DeleteCheckResults clears a target's result history, optionally only the
+// results checked before the before parameter. The target itself is kept.
+func (h *Handler) DeleteCheckResults(w http.ResponseWriter, r *http.Request) {
+	targetID := r.PathValue("target_id")
+
+	var before *time.Time
+	if s := r.URL.Query().Get("before"); s != "" {
+		parsed, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, CodeInvalidParameter, "invalid before parameter, expected RFC3339 format")
+			return
+		}
+		before = &parsed
+	}
+
+	target, err := h.store.GetTarget(r.Context(), targetID)
 	if err != nil {
-		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid URL: %v", err))
+		requestLogger(r).Error("failed to get target", "error", err, "target_id", targetID)
+		writeError(w, http.StatusInternalServerError, CodeInternal, "internal error")
+		return
+	}
+	if target == nil {
+		writeError(w, http.StatusNotFound, CodeTargetNotFound, "target not found")
 		return
 	}
 
-	// Parse URL to validate it's HTTP/HTTPS
-	parsed, err := url.Parse(canonicalURL)
+	var deleted int64
+	if before != nil {
+		deleted, err = h.store.DeleteCheckResultsBefore(r.Context(), targetID, *before)
+	} else {
+		deleted, err = h.store.DeleteCheckResults(r.Context(), targetID)
+	}
 	if err != nil {
-		writeError(w, http.StatusBadRequest, "invalid URL")
+		requestLogger(r).Error("failed to delete check results", "error", err, "target_id", targetID)
+		writeError(w, http.StatusInternalServerError, CodeInternal, "internal error")
 		return
 	}
+	// The cached latest result may be gone now; let the next read refill it
+	h.config.ResultCache.Delete(targetID)
 
-	if parsed.Scheme != "http" && parsed.Scheme != "https" {
-		writeError(w, http.StatusBadRequest, "URL must use HTTP or HTTPS scheme")
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(models.DeleteResultsResponse{Deleted: deleted})
+}
+
+// resultsCSVHeader names the columns of a CSV results export
+var resultsCSVHeader = []string{"checked_at", "status_code", "latency_ms", "error"}
+
+// streamResultsCSV writes every result within the filter as CSV, newest
+// first, bypassing pagination like streamTargets. Missing status codes and
+// errors are empty cells.
+func (h *Handler) streamResultsCSV(w http.ResponseWriter, r *http.Request, targetID string, filter models.ResultFilter) {
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": targetID + "-results.csv"}))
+	w.WriteHeader(http.StatusOK)
+
+	flusher, _ := w.(http.Flusher)
+	cw := csv.NewWriter(w)
+	cw.Write(resultsCSVHeader)
+	count := 0
+
+	err := h.store.StreamCheckResults(r.Context(), targetID, filter, func(result models.CheckResult) error {
+		var statusCode, errorText string
+		if result.StatusCode != nil {
+			statusCode = strconv.Itoa(*result.StatusCode)
+		}
+		if result.Error != nil {
+			errorText = csvText(*result.Error)
+		}
+		if err := cw.Write([]string{
+			result.CheckedAt.UTC().Format(time.RFC3339Nano),
+			statusCode,
+			strconv.Itoa(result.LatencyMs),
+			errorText,
+		}); err != nil {
+			return err
+		}
+		count++
+		if count%100 == 0 {
+			cw.Flush()
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+		return cw.Error()
+	})
+	cw.Flush()
+	if err == nil {
+		err = cw.Error()
+	}
+	if err != nil {
+		// Headers are already sent, so the client sees a truncated export
+		requestLogger(r).Error("failed to stream check results", "error", err, "target_id", targetID, "streamed", count)
 		return
 	}
 
-	// Handle idempotency key
-	var idempotencyKey *string
-	if key := r.Header.Get("Idempotency-Key"); key != "" {
-		idempotencyKey = &key
+	if flusher != nil {
+		flusher.Flush()
+	}
+}
+
+// csvText keeps free text from being read as a formula when the export is
+// opened in a spreadsheet.
+func csvText(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
+
+func (h *Handler) GetCheckStats(w http.ResponseWriter, r *http.Request) {
+	targetID := r.PathValue("target_id")
+
+	window, ok := windowParam(w, r)
+	if !ok {
+		return
 	}
 
-	target, isNew, err := h.store.CreateTarget(req.URL, canonicalURL, idempotencyKey)
+	target, err := h.store.GetTarget(r.Context(), targetID)
 	if err != nil {
-		slog.Error("failed to create target", "error", err, "url", req.URL)
-		writeError(w, http.StatusInternalServerError, "internal error")
+		requestLogger(r).Error("failed to get target", "error", err, "target_id", targetID)
+		writeError(w, http.StatusInternalServerError, CodeInternal, "internal error")
+		return
+	}
+	if target == nil {
+		writeError(w, http.StatusNotFound, CodeTargetNotFound, "target not found")
 		return
 	}
 
-	statusCode := http.StatusOK
-	if isNew {
-		statusCode = http.StatusCreated
+	stats, err := h.store.GetCheckStats(r.Context(), targetID, time.Now().UTC().Add(-window))
+	if err != nil {
+		requestLogger(r).Error("failed to get check stats", "error", err, "target_id", targetID)
+		writeError(w, http.StatusInternalServerError, CodeInternal, "internal error")
+		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(statusCode)
-	json.NewEncoder(w).Encode(models.CreateTargetResponse{
-		ID:        target.ID,
-		URL:       target.URL,
-		CreatedAt: target.CreatedAt,
-	})
+	json.NewEncoder(w).Encode(stats)
 }
 
-func (h *Handler) ListTargets(w http.ResponseWriter, r *http.Request) {
-	// Parse query parameters
-	host := r.URL.Query().Get("host")
-	var hostPtr *string
-	if host != "" {
-		hostPtr = &host
+// GetHostStats summarizes the latency of checks by host, slowest first, to
+// find providers that are degrading across all their targets.
+func (h *Handler) GetHostStats(w http.ResponseWriter, r *http.Request) {
+	window, ok := windowParam(w, r)
+	if !ok {
+		return
 	}
 
-	limit := 10 // default
-	if l := r.URL.Query().Get("limit"); l != "" {
-		if parsed, err := strconv.Atoi(l); err == nil && parsed > 0 && parsed <= 100 {
-			limit = parsed
-		}
+	since := time.Now().UTC().Add(-window)
+	hosts, err := h.store.GetHostLatencyStats(r.Context(), since)
+	if err != nil {
+		requestLogger(r).Error("failed to get host stats", "error", err)
+		writeError(w, http.StatusInternalServerError, CodeInternal, "internal error")
+		return
 	}
 
-	pageToken := r.URL.Query().Get("page_token")
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(models.HostLatencyStatsList{Since: since, Items: hosts})
+}
+
+// windowParam parses the optional window of a stats request, 24 hours by
+// default, writing a 400 and returning false when it is invalid.
+func windowParam(w http.ResponseWriter, r *http.Request) (time.Duration, bool) {
+	v := r.URL.Query().Get("window")
+	if v == "" {
+		return 24 * time.Hour, true
+	}
+
+	window, err := time.ParseDuration(v)
+	if err != nil || window <= 0 {
+		writeError(w, http.StatusBadRequest, CodeInvalidParameter, "invalid window parameter, expected a positive duration such as \"24h\"")
+		return 0, false
+	}
+	return window, true
+}
+
+// wakeChecker has the checker running in this process, if any, pick up new
+// targets now instead of at its next tick.
+func (h *Handler) wakeChecker() {
+	if h.config.Checker != nil {
+		h.config.Checker.Wake()
+	}
+}
+
+// latencyParam parses an optional non-negative latency bound, writing a 400
+// and returning false when it is invalid.
+func latencyParam(w http.ResponseWriter, r *http.Request, name string) (*int, bool) {
+	v := r.URL.Query().Get(name)
+	if v == "" {
+		return nil, true
+	}
+
+	parsed, err := strconv.Atoi(v)
+	if err != nil || parsed < 0 {
+		writeError(w, http.StatusBadRequest, CodeInvalidParameter, "invalid "+name+" parameter, expected a non-negative integer")
+		return nil, false
+	}
+	return &parsed, true
+}
+
+func (h *Handler) GetJob(w http.ResponseWriter, r *http.Request) {
+	job, ok := h.jobs.get(r.PathValue("job_id"))
+	if !ok {
+		writeError(w, http.StatusNotFound, CodeJobNotFound, "job not found")
+		return
+	}
 
-	targets, err := h.store.ListTargets(hostPtr, limit, pageToken)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(job)
+}
+
+func (h *Handler) GetCheckFilter(w http.ResponseWriter, r *http.Request) {
+	filter, err := h.store.GetCheckFilter(r.Context())
 	if err != nil {
-		slog.Error("failed to list targets", "error", err)
-		writeError(w, http.StatusInternalServerError, "internal error")
+		requestLogger(r).Error("failed to get check filter", "error", err)
+		writeError(w, http.StatusInternalServerError, CodeInternal, "internal error")
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(targets)
+	json.NewEncoder(w).Encode(filter)
 }
 
-func (h *Handler) GetCheckResults(w http.ResponseWriter, r *http.Request) {
-	targetID := r.PathValue("target_id")
-	if targetID == "" {
-		writeError(w, http.StatusBadRequest, "target_id is required")
+func (h *Handler) UpdateCheckFilter(w http.ResponseWriter, r *http.Request) {
+	var req models.CheckFilter
+	if !decodeJSON(w, r, &req) {
 		return
 	}
 
-	// Parse query parameters
-	var since *time.Time
-	if s := r.URL.Query().Get("since"); s != "" {
-		if parsed, err := time.Parse(time.RFC3339, s); err == nil {
-			since = &parsed
-		} else {
-			writeError(w, http.StatusBadRequest, "invalid since parameter, expected RFC3339 format")
+	filter := models.CheckFilter{ExcludeHosts: []string{}}
+	for _, host := range req.ExcludeHosts {
+		host = strings.ToLower(strings.TrimSpace(host))
+		if host == "" || host == "*." || strings.ContainsAny(host, "/:") {
+			writeError(w, http.StatusBadRequest, CodeInvalidRequest, fmt.Sprintf("invalid host pattern %q", host))
 			return
 		}
+		filter.ExcludeHosts = append(filter.ExcludeHosts, host)
 	}
 
-	limit := 50 // default
-	if l := r.URL.Query().Get("limit"); l != "" {
-		if parsed, err := strconv.Atoi(l); err == nil && parsed > 0 && parsed <= 1000 {
-			limit = parsed
+	if err := h.store.SaveCheckFilter(r.Context(), filter); err != nil {
+		requestLogger(r).Error("failed to save check filter", "error", err)
+		writeError(w, http.StatusInternalServerError, CodeInternal, "internal error")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(filter)
+}
+
+func (h *Handler) CreateWebhook(w http.ResponseWriter, r *http.Request) {
+	var req models.CreateWebhookRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	if req.URL == "" {
+		writeError(w, http.StatusBadRequest, CodeInvalidURL, "url is required")
+		return
+	}
+
+	parsed, err := url.Parse(req.URL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		writeError(w, http.StatusBadRequest, CodeInvalidURL, "webhook URL must be an absolute HTTP or HTTPS URL")
+		return
+	}
+	// Webhooks are requests the server makes, so they get the same policy
+	// as targets
+	if urlErr := h.checkHostPolicy(r, parsed.Hostname()); urlErr != nil {
+		writeError(w, urlErr.status, urlErr.code, urlErr.message)
+		return
+	}
+
+	if req.TargetID != nil {
+		target, err := h.store.GetTarget(r.Context(), *req.TargetID)
+		if err != nil {
+			requestLogger(r).Error("failed to get target", "error", err, "target_id", *req.TargetID)
+			writeError(w, http.StatusInternalServerError, CodeInternal, "internal error")
+			return
 		}
+		if target == nil {
+			writeError(w, http.StatusNotFound, CodeTargetNotFound, "target not found")
+			return
+		}
+	}
+
+	if err := webhook.ValidateTemplate(req.Template); err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest, fmt.Sprintf("invalid template: %v", err))
+		return
 	}
 
-	results, err := h.store.GetCheckResults(targetID, since, limit)
+	wh, err := h.store.CreateWebhook(r.Context(), req.URL, req.TargetID, req.Template)
 	if err != nil {
-		slog.Error("failed to get check results", "error", err, "target_id", targetID)
-		writeError(w, http.StatusInternalServerError, "internal error")
+		requestLogger(r).Error("failed to create webhook", "error", err, "url", req.URL)
+		writeError(w, http.StatusInternalServerError, CodeInternal, "internal error")
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(results)
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(wh)
+}
+
+func (h *Handler) VerifyAuditLog(w http.ResponseWriter, r *http.Request) {
+	verification, err := h.store.VerifyAuditLog(r.Context())
+	if err != nil {
+		requestLogger(r).Error("failed to verify audit log", "error", err)
+		writeError(w, http.StatusInternalServerError, CodeInternal, "internal error")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(verification)
 }
 
 func (h *Handler) Health(w http.ResponseWriter, r *http.Request) {
@@ -157,19 +1278,189 @@ func (h *Handler) Health(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte("OK"))
 }
 
-func writeError(w http.ResponseWriter, statusCode int, message string) {
+// readyTimeout bounds the dependency checks of a readiness probe, so a hung
+// database fails the probe instead of stalling it
+const readyTimeout = 2 * time.Second
+
+// Ready is the readiness probe: unlike Health it checks that the database
+// answers, and reports 503 with the failed checks when it doesn't.
+func (h *Handler) Ready(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), readyTimeout)
+	defer cancel()
+
+	readiness := models.Readiness{Status: "ready", Checks: map[string]string{"database": "ok"}}
+	status := http.StatusOK
+	if err := h.store.Ping(ctx); err != nil {
+		// The cause is logged rather than shown to unauthenticated probes
+		requestLogger(r).Error("readiness check failed", "check", "database", "error", err)
+		readiness.Status = "unavailable"
+		readiness.Checks["database"] = "unreachable"
+		status = http.StatusServiceUnavailable
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(readiness)
+}
+
+// eventHeartbeat is how often an idle event stream gets a comment line, so
+// proxies don't close it and dead clients are noticed
+const eventHeartbeat = 15 * time.Second
+
+// StreamEvents sends each check result as it is saved, as server-sent
+// events, until the client goes away. ?target_id= limits the stream to one
+// target. A client too slow to keep up misses events and is told how many
+// with a "dropped" event.
+func (h *Handler) StreamEvents(w http.ResponseWriter, r *http.Request) {
+	if h.config.Events == nil {
+		writeError(w, http.StatusServiceUnavailable, CodeEventsDisabled, "event streaming is not enabled")
+		return
+	}
+	targetID := r.URL.Query().Get("target_id")
+
+	sub := h.config.Events.Subscribe()
+	defer sub.Close()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	rc := http.NewResponseController(w)
+	if err := rc.Flush(); err != nil {
+		return
+	}
+
+	heartbeat := time.NewTicker(eventHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+				return
+			}
+		case event, ok := <-sub.Events():
+			if !ok {
+				return
+			}
+			if dropped := sub.Dropped(); dropped > 0 {
+				if _, err := fmt.Fprintf(w, "event: dropped\ndata: {\"count\":%d}\n\n", dropped); err != nil {
+					return
+				}
+			}
+			if targetID != "" && event.TargetID != targetID {
+				continue
+			}
+			data, err := json.Marshal(event)
+			if err != nil {
+				requestLogger(r).Error("failed to encode event", "error", err)
+				continue
+			}
+			if _, err := fmt.Fprintf(w, "event: check\ndata: %s\n\n", data); err != nil {
+				return
+			}
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// wantsNDJSON reports whether the client asked for a newline-delimited JSON
+// stream, via ?format=ndjson or an Accept header.
+func wantsNDJSON(r *http.Request) bool {
+	if r.URL.Query().Get("format") == "ndjson" {
+		return true
+	}
+	accept := r.Header.Get("Accept")
+	return strings.Contains(accept, "application/x-ndjson") || strings.Contains(accept, "application/ndjson")
+}
+
+// preferAsync reports whether the request carries a "Prefer: respond-async"
+// preference (RFC 7240).
+func preferAsync(r *http.Request) bool {
+	for _, header := range r.Header.Values("Prefer") {
+		for _, pref := range strings.Split(header, ",") {
+			if strings.EqualFold(strings.TrimSpace(pref), "respond-async") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ifNotExists reports whether the client asked for a 409 instead of the
+// existing target when the canonical URL is already registered.
+func ifNotExists(r *http.Request) bool {
+	value, err := strconv.ParseBool(r.URL.Query().Get("if_not_exists"))
+	return err == nil && value
+}
+
+// targetSort parses the sort and order parameters of a target listing,
+// writing a 400 and returning false when either is invalid.
+func targetSort(w http.ResponseWriter, r *http.Request) (models.TargetSort, bool) {
+	sort := models.TargetSort{Field: models.SortCreatedAt}
+	switch field := r.URL.Query().Get("sort"); field {
+	case "", models.SortCreatedAt:
+	case models.SortURL:
+		sort.Field = field
+	default:
+		writeError(w, http.StatusBadRequest, CodeInvalidParameter, "invalid sort parameter, expected created_at or url")
+		return sort, false
+	}
+
+	switch r.URL.Query().Get("order") {
+	case "", "asc":
+	case "desc":
+		sort.Desc = true
+	default:
+		writeError(w, http.StatusBadRequest, CodeInvalidParameter, "invalid order parameter, expected asc or desc")
+		return sort, false
+	}
+	return sort, true
+}
+
+// includeTotal reports whether a listing should count every matching item,
+// which costs a query the pages themselves don't need.
+func includeTotal(r *http.Request) bool {
+	value, err := strconv.ParseBool(r.URL.Query().Get("include_total"))
+	return err == nil && value
+}
+
+// decodeJSON decodes the request body into v, answering 413 when it exceeds
+// the body limit and 400 when it isn't valid JSON. It reports whether v was
+// decoded.
+func decodeJSON(w http.ResponseWriter, r *http.Request, v any) bool {
+	err := json.NewDecoder(r.Body).Decode(v)
+	if err == nil {
+		return true
+	}
+
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		writeError(w, http.StatusRequestEntityTooLarge, CodeBodyTooLarge,
+			fmt.Sprintf("request body exceeds %d bytes", tooLarge.Limit))
+		return false
+	}
+	writeError(w, http.StatusBadRequest, CodeInvalidJSON, "invalid JSON")
+	return false
+}
+
+// writeError responds with {"error": message, "code": code}.
+func writeError(w http.ResponseWriter, statusCode int, code ErrorCode, message string) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(statusCode)
-	json.NewEncoder(w).Encode(map[string]string{"error": message})
+	json.NewEncoder(w).Encode(map[string]string{"error": message, "code": string(code)})
 }
 
 func withLogging(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
 
-		// Generate request ID
-		requestID := fmt.Sprintf("req_%d", time.Now().UnixNano())
-		r = r.WithContext(r.Context())
+		id := requestID(r)
+		r = r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id))
+		w.Header().Set(RequestIDHeader, id)
 
 		// Wrap response writer to capture status code
 		ww := &responseWriter{ResponseWriter: w, statusCode: http.StatusOK}
@@ -179,7 +1470,7 @@ func withLogging(next http.Handler) http.Handler {
 		duration := time.Since(start)
 
 		slog.Info("request completed",
-			"request_id", requestID,
+			"request_id", id,
 			"method", r.Method,
 			"path", r.URL.Path,
 			"status", ww.statusCode,
@@ -188,11 +1479,83 @@ func withLogging(next http.Handler) http.Handler {
 	})
 }
 
+// isStream reports whether the request is for a long-lived response the
+// request timeout doesn't apply to: the event stream, or an NDJSON or CSV
+// export, which writes every match in one response however long it takes.
+func isStream(r *http.Request) bool {
+	if r.Method != http.MethodGet {
+		return false
+	}
+	switch path := r.URL.Path; {
+	case path == "/v1/events":
+		return true
+	case path == "/v1/targets":
+		return wantsNDJSON(r)
+	case strings.HasPrefix(path, "/v1/targets/") && strings.HasSuffix(path, "/results"):
+		return r.URL.Query().Get("format") == "csv"
+	}
+	return false
+}
+
+// withLimits caps the request body at maxBodyBytes and gives the request
+// timeout to arrive and be handled: past it, reading the body fails and the
+// request context is done.
+func withLimits(next http.Handler, timeout time.Duration, maxBodyBytes int64) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if maxBodyBytes > 0 {
+			r.Body = http.MaxBytesReader(w, r.Body, maxBodyBytes)
+		}
+		if timeout > 0 && !isStream(r) {
+			// The context alone can't interrupt a slow client mid-upload
+			http.NewResponseController(w).SetReadDeadline(time.Now().Add(timeout))
+			ctx, cancel := context.WithTimeout(r.Context(), timeout)
+			defer cancel()
+			r = r.WithContext(ctx)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// withJSONErrors answers requests no route matches with the JSON error shape
+// instead of the mux's plain text. 405s keep the mux's Allow header.
+func withJSONErrors(mux *http.ServeMux) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handler, pattern := mux.Handler(r)
+		if pattern != "" {
+			mux.ServeHTTP(w, r)
+			return
+		}
+
+		// Let the mux decide between 404 and 405, then discard its body
+		capture := &statusCapture{header: make(http.Header), statusCode: http.StatusOK}
+		handler.ServeHTTP(capture, r)
+
+		if capture.statusCode == http.StatusMethodNotAllowed {
+			w.Header().Set("Allow", capture.header.Get("Allow"))
+			writeError(w, http.StatusMethodNotAllowed, CodeMethodNotAllowed, fmt.Sprintf("method %s not allowed", r.Method))
+			return
+		}
+		writeError(w, http.StatusNotFound, CodeNotFound, "not found")
+	})
+}
+
+// statusCapture records the status and headers a handler sets, dropping
+// its body.
+type statusCapture struct {
+	header     http.Header
+	statusCode int
+}
+
+func (c *statusCapture) Header() http.Header         { return c.header }
+func (c *statusCapture) WriteHeader(code int)        { c.statusCode = code }
+func (c *statusCapture) Write(b []byte) (int, error) { return len(b), nil }
+
 func withCORS(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Idempotency-Key")
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type, Idempotency-Key, Prefer, X-Request-ID")
+		w.Header().Set("Access-Control-Expose-Headers", "Location, X-Request-ID")
 
 		if r.Method == "OPTIONS" {
 			w.WriteHeader(http.StatusOK)
@@ -212,3 +1575,15 @@ func (rw *responseWriter) WriteHeader(code int) {
 	rw.statusCode = code
 	rw.ResponseWriter.WriteHeader(code)
 }
+
+// Unwrap gives http.ResponseController access to the underlying writer.
+func (rw *responseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
+// Flush lets streaming handlers flush through the logging wrapper.
+func (rw *responseWriter) Flush() {
+	if f, ok := rw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
@@ -0,0 +1,63 @@
+package checker
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+
+	"github.com/aarushishahhh/linkwatch/project/internal/models"
+	"github.com/aarushishahhh/linkwatch/project/internal/storage"
+)
+
+// resultBatch buffers the results of a check cycle and saves them size at a
+// time, so a large cycle costs a few transactions instead of one per target.
+type resultBatch struct {
+	ctx   context.Context // The cycle's, without its cancellation
+	store *storage.Storage
+	size  int
+
+	mu      sync.Mutex
+	pending []storage.TargetCheck
+}
+
+// newResultBatch returns a batch for the cycle running under ctx. Results
+// are saved even once ctx is cancelled, so checks that finished before a
+// forced shutdown aren't lost with the ones it cut short.
+func newResultBatch(ctx context.Context, store *storage.Storage, size int) *resultBatch {
+	return &resultBatch{ctx: context.WithoutCancel(ctx), store: store, size: size}
+}
+
+// add queues a result, saving the batch once it is full.
+func (b *resultBatch) add(targetID string, result models.CheckResult) {
+	b.mu.Lock()
+	b.pending = append(b.pending, storage.TargetCheck{TargetID: targetID, Result: result})
+	var full []storage.TargetCheck
+	if len(b.pending) >= b.size {
+		full, b.pending = b.pending, nil
+	}
+	b.mu.Unlock()
+
+	if full != nil {
+		b.save(full)
+	}
+}
+
+// flush saves whatever is still queued and returns how many results that
+// was.
+func (b *resultBatch) flush() int {
+	b.mu.Lock()
+	pending := b.pending
+	b.pending = nil
+	b.mu.Unlock()
+
+	if len(pending) > 0 {
+		b.save(pending)
+	}
+	return len(pending)
+}
+
+func (b *resultBatch) save(checks []storage.TargetCheck) {
+	if err := b.store.SaveCheckResults(b.ctx, checks); err != nil {
+		slog.Error("failed to save check results", "count", len(checks), "error", err)
+	}
+}
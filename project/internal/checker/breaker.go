@@ -0,0 +1,68 @@
+package checker
+
+import (
+	"log/slog"
+	"time"
+)
+
+// breaker is the circuit breaker state of one host. It opens after
+// BreakerThreshold consecutive failed checks; once BreakerCooldown has
+// passed a single probe is let through, and the first check to reach the
+// host closes it again.
+type breaker struct {
+	failures  int       // Consecutive checks that got no response
+	openUntil time.Time // No checks before this while open
+}
+
+// breakerAllow reports whether a check of host may go ahead. While the
+// breaker is open it returns false along with the failure count; after the
+// cooldown it lets one probe through and holds the rest for another
+// cooldown, so a probe that never reports back can't wedge it.
+func (c *Checker) breakerAllow(host string, now time.Time) (bool, int) {
+	if c.config.BreakerThreshold <= 0 {
+		return true, 0
+	}
+
+	c.hostMux.Lock()
+	defer c.hostMux.Unlock()
+
+	b, exists := c.breakers[host]
+	if !exists || b.failures < c.config.BreakerThreshold {
+		return true, 0
+	}
+	if now.Before(b.openUntil) {
+		return false, b.failures
+	}
+	b.openUntil = now.Add(c.config.BreakerCooldown)
+	return true, 0
+}
+
+// breakerRecord feeds a check's outcome into host's breaker: any response
+// closes it, no response counts towards opening it.
+func (c *Checker) breakerRecord(host string, responded bool, now time.Time) {
+	if c.config.BreakerThreshold <= 0 {
+		return
+	}
+
+	c.hostMux.Lock()
+	defer c.hostMux.Unlock()
+
+	if responded {
+		delete(c.breakers, host)
+		return
+	}
+
+	b, exists := c.breakers[host]
+	if !exists {
+		b = &breaker{}
+		c.breakers[host] = b
+	}
+	b.failures++
+	if b.failures >= c.config.BreakerThreshold {
+		if b.failures == c.config.BreakerThreshold {
+			slog.Warn("circuit breaker opened", "host", host, "failures", b.failures,
+				"cooldown", c.config.BreakerCooldown)
+		}
+		b.openUntil = now.Add(c.config.BreakerCooldown)
+	}
+}
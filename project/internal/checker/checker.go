@@ -1,143 +1,1010 @@
 package checker
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
+	"hash/fnv"
+	"io"
 	"log/slog"
 	"net"
 	"net/http"
 	"net/url"
+	"regexp"
+	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/aarushishahhh/linkwatch/project/internal/events"
 	"github.com/aarushishahhh/linkwatch/project/internal/models"
+	"github.com/aarushishahhh/linkwatch/project/internal/policy"
 	"github.com/aarushishahhh/linkwatch/project/internal/storage"
+	"github.com/aarushishahhh/linkwatch/project/internal/webhook"
 )
 
 type Config struct {
 	Interval       time.Duration
 	MaxConcurrency int
-	HTTPTimeout    time.Duration
+	HTTPTimeout    time.Duration // Bounds each attempt, reading the body included
+	MaxRetries     int           // Retries after the first attempt on 5xx, RetryStatusCodes or network errors
+	BackoffBase    time.Duration // Delay before the first retry, doubling after each one
+	DoHURL         string        // Optional DNS-over-HTTPS endpoint; system resolver when empty
+	DNSCacheTTL    time.Duration // How long resolved addresses are reused; every dial resolves when zero
+	UserAgent      string        // Sent with every check; defaults to DefaultUserAgent
+	MaxRedirects   int           // Redirects followed per check; a redirect fails the check when zero and is its response when negative
+	TLSExpiryWarn  time.Duration // Flag results whose certificate expires within this; disabled when zero
+	Blocklist      *policy.Blocklist
+	BlockPrivate   bool                 // Refuse to connect to private, loopback and link-local addresses
+	InstanceID     string               // Stamped on every saved result
+	ResultCache    *storage.ResultCache // Optional; updated with every saved result
+	Events         *events.Hub          // Optional; every saved result is published to it
+	CheckBudget    int                  // Maximum checks per interval; unlimited when zero
+	SpreadChecks   bool                 // Check each target at its own phase of the interval instead of all at once
+
+	// ResultBatchSize saves the results of in-process cycles this many at a
+	// time instead of one by one; saved immediately when below 2. Queue
+	// workers always save immediately, before completing the claim.
+	ResultBatchSize int
+
+	// ProxyURL routes every check through an HTTP(S) or SOCKS5 proxy;
+	// checks connect directly when nil
+	ProxyURL *url.URL
+
+	// PerHostConcurrency is how many checks may hit one host at once;
+	// defaults to 1
+	PerHostConcurrency int
+
+	// IPVersion restricts checks to one address family unless a target
+	// sets its own; one of the models.IPVersion constants, auto when empty
+	IPVersion string
+
+	// Connection pool of the check client; DefaultMaxIdleConns,
+	// DefaultMaxIdleConnsPerHost and DefaultIdleConnTimeout when zero
+	MaxIdleConns        int
+	MaxIdleConnsPerHost int
+	IdleConnTimeout     time.Duration
+
+	// After BreakerThreshold consecutive checks of a host get no response,
+	// its checks are short-circuited for BreakerCooldown before one probe is
+	// let through; disabled when the threshold is zero
+	BreakerThreshold int
+	BreakerCooldown  time.Duration
+
+	// RetryStatusCodes are retried like 5xx responses, e.g. 408, 425 and
+	// 429. Only 5xx is retried when empty. A retried response's Retry-After
+	// lengthens the backoff, up to maxRetryAfter.
+	RetryStatusCodes policy.StatusRanges
+
+	// ResultRetention prunes results older than this, keeping each target's
+	// latest one; disabled when zero
+	ResultRetention time.Duration
+
+	// CheckTimeout bounds a whole check, every attempt and backoff included;
+	// a target's timeout_ms overrides it. Only attempts are bounded when zero.
+	CheckTimeout time.Duration
+
+	// IdempotencyTTL deletes idempotency keys older than this; disabled
+	// when zero
+	IdempotencyTTL time.Duration
+
+	// Queue mode splits scheduling from execution across instances. With
+	// neither set, the checker schedules and checks in-process.
+	SchedulerMode     bool          // Enqueue due targets each interval
+	WorkerMode        bool          // Claim and check queued targets
+	QueuePollInterval time.Duration // How often an idle worker polls the queue
+	QueueLease        time.Duration // How long a claim lasts before another worker may take over
+
+	// Optional httptrace dump of sampled checks; disabled when TraceFile is empty
+	TraceFile       string
+	TraceSampleRate float64
+	TraceMaxBytes   int64
 }
 
 type Checker struct {
 	store    *storage.Storage
 	config   Config
 	client   *http.Client
+	notifier *webhook.Notifier
+
+	// Clients dialing only IPv4 or IPv6, keyed by models.IPVersion. They
+	// have pools of their own, so a connection made for one family is never
+	// reused by a check restricted to the other.
+	ipClients map[string]*http.Client
+
+	tracer   *traceWriter
+	workerID string
 	hostSems map[string]chan struct{} // Per-host semaphores
-	hostMux  sync.RWMutex             // Protects hostSems map
+	breakers map[string]*breaker      // Per-host circuit breakers
+	hostMux  sync.RWMutex             // Protects hostSems and breakers
+
+	budgetSpent atomic.Bool // The last cycle ran out of CheckBudget with targets still due
+	// effectiveInterval is how often each target is checked given the
+	// budget, as a time.Duration; set along with budgetSpent
+	effectiveInterval atomic.Int64
+	paused            atomic.Bool // Set by Pause: cycles check nothing until Resume
+
+	// Cycle bookkeeping reported by Status
+	cycleMux         sync.Mutex
+	cycleStartedAt   *time.Time // Set while a cycle runs
+	lastCycleAt      *time.Time
+	lastCycleTargets int
+
+	// Shutdown: stopping is closed by Stop so no new work starts, running
+	// tracks the loops started by Start, and abort cancels their context
+	// once the drain deadline passes
+	stopping chan struct{}
+	stopOnce sync.Once
+	running  sync.WaitGroup
+	abort    context.CancelFunc
+
+	wake chan struct{} // Signalled by Wake; holds at most one pending wake-up
 }
 
+// DefaultUserAgent identifies checks when Config.UserAgent is empty
+const DefaultUserAgent = "Linkwatch/1.0"
+
+// Connection pool defaults for the check client, used when the matching
+// Config fields are zero
+const (
+	DefaultMaxIdleConns        = 100
+	DefaultMaxIdleConnsPerHost = 10
+	DefaultIdleConnTimeout     = 30 * time.Second
+)
+
 func New(store *storage.Storage, config Config) *Checker {
-	return &Checker{
-		store:    store,
-		config:   config,
-		hostSems: make(map[string]chan struct{}),
-		client: &http.Client{
-			Timeout: config.HTTPTimeout,
-			Transport: &http.Transport{
-				MaxIdleConns:        100,
-				MaxIdleConnsPerHost: 10,
-				IdleConnTimeout:     30 * time.Second,
-			},
+	if config.MaxIdleConns <= 0 {
+		config.MaxIdleConns = DefaultMaxIdleConns
+	}
+	if config.MaxIdleConnsPerHost <= 0 {
+		config.MaxIdleConnsPerHost = DefaultMaxIdleConnsPerHost
+	}
+	if config.IdleConnTimeout <= 0 {
+		config.IdleConnTimeout = DefaultIdleConnTimeout
+	}
+	transport := &http.Transport{
+		MaxIdleConns:        config.MaxIdleConns,
+		MaxIdleConnsPerHost: config.MaxIdleConnsPerHost,
+		IdleConnTimeout:     config.IdleConnTimeout,
+	}
+
+	if config.ProxyURL != nil {
+		transport.Proxy = http.ProxyURL(config.ProxyURL)
+	}
+
+	dialer := &net.Dialer{Timeout: config.HTTPTimeout}
+	// Through a proxy the only address dialed is the proxy's, which is
+	// usually private itself, so the check can't apply there
+	if config.BlockPrivate && config.ProxyURL == nil {
+		// Checked on the dialed address, so DNS rebinding can't get around it
+		dialer.Control = policy.DialControl
+		transport.DialContext = dialer.DialContext
+	}
+
+	var resolver ipResolver
+	if config.DoHURL != "" {
+		resolver = newDoHResolver(config.DoHURL, config.HTTPTimeout)
+	}
+	if config.DNSCacheTTL > 0 {
+		if resolver == nil {
+			resolver = systemResolver{}
+		}
+		resolver = newDNSCache(resolver, config.DNSCacheTTL)
+	}
+	if resolver != nil {
+		transport.DialContext = dialContextWithResolver(resolver, dialer)
+	}
+
+	if config.BackoffBase <= 0 {
+		config.BackoffBase = 200 * time.Millisecond
+	}
+	if config.PerHostConcurrency <= 0 {
+		config.PerHostConcurrency = 1
+	}
+	if config.UserAgent == "" {
+		config.UserAgent = DefaultUserAgent
+	}
+
+	var tracer *traceWriter
+	if config.TraceFile != "" {
+		tracer = newTraceWriter(config.TraceFile, config.TraceMaxBytes, config.TraceSampleRate)
+	}
+
+	// Claims are keyed by instance, so every worker needs a distinct id
+	workerID := config.InstanceID
+	if workerID == "" {
+		workerID = fmt.Sprintf("worker-%d", time.Now().UnixNano())
+	}
+
+	// Attempts are bounded by their context rather than Timeout, so a timed
+	// out attempt can be told apart from the whole check running out
+	newClient := func(transport *http.Transport) *http.Client {
+		return &http.Client{
+			Transport: transport,
 			CheckRedirect: func(req *http.Request, via []*http.Request) error {
-				if len(via) >= 5 {
-					return fmt.Errorf("stopped after 5 redirects")
+				// Not following, the redirect itself is the check's response
+				if config.MaxRedirects < 0 {
+					return http.ErrUseLastResponse
+				}
+				if len(via) > config.MaxRedirects {
+					return fmt.Errorf("stopped after %d redirects", config.MaxRedirects)
+				}
+				if config.Blocklist.Blocked(req.URL.Hostname()) {
+					return fmt.Errorf("blocked by policy: redirect to blocklisted host %s", req.URL.Hostname())
 				}
 				return nil
 			},
-		},
+		}
+	}
+
+	dial := transport.DialContext
+	if dial == nil {
+		dial = dialer.DialContext
+	}
+	ipClients := make(map[string]*http.Client)
+	for version, network := range map[string]string{models.IPVersionV4: "tcp4", models.IPVersionV6: "tcp6"} {
+		restricted := transport.Clone()
+		restricted.DialContext = dialNetwork(dial, network)
+		ipClients[version] = newClient(restricted)
+	}
+
+	return &Checker{
+		store:     store,
+		config:    config,
+		workerID:  workerID,
+		notifier:  webhook.NewNotifier(store, config.HTTPTimeout, config.BlockPrivate),
+		tracer:    tracer,
+		hostSems:  make(map[string]chan struct{}),
+		breakers:  make(map[string]*breaker),
+		stopping:  make(chan struct{}),
+		wake:      make(chan struct{}, 1),
+		client:    newClient(transport),
+		ipClients: ipClients,
 	}
 }
 
+// Start runs the checker's loops in the background until ctx is cancelled
+// or Stop is called.
 func (c *Checker) Start(ctx context.Context) {
-	go c.run(ctx)
+	ctx, c.abort = context.WithCancel(ctx)
+
+	// Pruning and key cleanup are database-wide, so only the instance that
+	// schedules runs them
+	if c.config.SchedulerMode || !c.config.WorkerMode {
+		if c.config.ResultRetention > 0 {
+			c.loop(ctx, c.pruneInterval, nil, c.pruneResults)
+		}
+		if c.config.IdempotencyTTL > 0 {
+			c.loop(ctx, c.keyCleanupInterval, nil, c.cleanupIdempotencyKeys)
+		}
+	}
+
+	scheduleTick := func() time.Duration { return c.scheduleTick(ctx) }
+	if !c.config.SchedulerMode && !c.config.WorkerMode {
+		c.loop(ctx, scheduleTick, c.wake, c.checkAllTargets)
+		return
+	}
+
+	if c.config.SchedulerMode {
+		c.loop(ctx, scheduleTick, c.wake, c.enqueueDueTargets)
+	}
+	if c.config.WorkerMode {
+		c.loop(ctx, func() time.Duration { return c.config.QueuePollInterval }, nil, c.drainQueue)
+	}
+}
+
+// Stop stops starting new checks and waits for the running ones to finish
+// and save their results, then for queued webhooks to be delivered. If ctx is
+// done first, the remaining checks and deliveries are cancelled, their
+// results discarded, and ctx's error returned. Either way results buffered
+// by ResultBatchSize are saved, and the trace file closed, before it returns.
+func (c *Checker) Stop(ctx context.Context) error {
+	c.stopOnce.Do(func() { close(c.stopping) })
+
+	drained := make(chan struct{})
+	go func() {
+		c.running.Wait()
+		close(drained)
+	}()
+
+	var err error
+	select {
+	case <-drained:
+		err = c.notifier.Close(ctx)
+	case <-ctx.Done():
+		if c.abort != nil {
+			c.abort()
+		}
+		<-drained
+		c.notifier.Close(ctx)
+		err = ctx.Err()
+	}
+
+	// No check is left to write a trace
+	if c.tracer != nil {
+		if err := c.tracer.Close(); err != nil {
+			slog.Error("failed to close trace file", "error", err)
+		}
+	}
+	return err
+}
+
+func (c *Checker) loop(ctx context.Context, next func() time.Duration, wake <-chan struct{}, fn func(context.Context)) {
+	c.running.Add(1)
+	go func() {
+		defer c.running.Done()
+		c.every(ctx, next, wake, fn)
+	}()
 }
 
-func (c *Checker) run(ctx context.Context) {
-	ticker := time.NewTicker(c.config.Interval)
-	defer ticker.Stop()
+// every runs fn immediately and then again after each delay returned by
+// next, or sooner when woken through wake (nil for loops that aren't), until
+// ctx is cancelled or the checker is stopped.
+func (c *Checker) every(ctx context.Context, next func() time.Duration, wake <-chan struct{}, fn func(context.Context)) {
+	fn(ctx)
 
-	// Run initial check immediately
-	c.checkAllTargets(ctx)
+	for c.sleep(ctx, next(), wake) {
+		fn(ctx)
+	}
+}
 
+// sleep waits d, or until woken through wake unless the last cycle spent the
+// check budget, which always waits out its tick. It returns false when ctx
+// is cancelled or the checker is stopped first.
+func (c *Checker) sleep(ctx context.Context, d time.Duration, wake <-chan struct{}) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
 	for {
 		select {
 		case <-ctx.Done():
-			return
-		case <-ticker.C:
-			c.checkAllTargets(ctx)
+			return false
+		case <-c.stopping:
+			return false
+		case <-timer.C:
+			return true
+		case <-wake:
+			if !c.budgetSpent.Load() {
+				return true
+			}
 		}
 	}
 }
 
+// Wake has the scheduling loop run a cycle now rather than at its next tick,
+// so targets created while it sleeps, e.g. while there were none, are
+// checked right away. Wake-ups made while one is pending are merged into it.
+func (c *Checker) Wake() {
+	select {
+	case c.wake <- struct{}{}:
+	default:
+	}
+}
+
+// pruneInterval is how often old results are pruned: hourly, or every
+// retention period if that is shorter.
+func (c *Checker) pruneInterval() time.Duration {
+	return min(c.config.ResultRetention, time.Hour)
+}
+
+func (c *Checker) pruneResults(ctx context.Context) {
+	removed, err := c.store.PruneCheckResults(ctx, time.Now().Add(-c.config.ResultRetention))
+	if err != nil {
+		slog.Error("failed to prune check results", "error", err)
+		return
+	}
+	if removed > 0 {
+		slog.Info("pruned check results", "removed", removed, "retention", c.config.ResultRetention)
+	}
+}
+
+// keyCleanupInterval is how often expired idempotency keys are deleted:
+// hourly, or every TTL if that is shorter.
+func (c *Checker) keyCleanupInterval() time.Duration {
+	return min(c.config.IdempotencyTTL, time.Hour)
+}
+
+func (c *Checker) cleanupIdempotencyKeys(ctx context.Context) {
+	removed, err := c.store.CleanupOldIdempotencyKeys(ctx, time.Now().Add(-c.config.IdempotencyTTL))
+	if err != nil {
+		slog.Error("failed to clean up idempotency keys", "error", err)
+		return
+	}
+	if removed > 0 {
+		slog.Info("cleaned up idempotency keys", "removed", removed, "ttl", c.config.IdempotencyTTL)
+	}
+}
+
+// scheduleTick is how long the scheduling loop sleeps between cycles: until
+// the earliest target is next due, but no longer than the interval. After a
+// cycle that ran out of budget it sleeps the full tick, so the budget holds.
+func (c *Checker) scheduleTick(ctx context.Context) time.Duration {
+	tick := c.config.Interval
+	if c.config.SpreadChecks {
+		// Wake often enough for new targets to go out close to their phase
+		tick = max(tick/spreadSlots, minScheduleTick)
+	}
+	if c.budgetSpent.Load() {
+		return tick
+	}
+
+	next, err := c.store.NextCheckAt(ctx)
+	if err != nil {
+		slog.Error("failed to get next check time", "error", err)
+		return tick
+	}
+	if next != nil {
+		tick = min(tick, max(time.Until(*next), minScheduleTick))
+	}
+	return tick
+}
+
+// With SpreadChecks the scheduler wakes spreadSlots times per interval, so
+// due targets go out in that many small batches instead of one burst. It
+// never wakes more often than minScheduleTick.
+const (
+	spreadSlots     = 20
+	minScheduleTick = 100 * time.Millisecond
+)
+
+// dueBatchSize is how many due targets a cycle loads from the database at a
+// time, so a cycle's memory doesn't grow with the number of targets.
+const dueBatchSize = 500
+
+// phase is the target's fixed offset within its interval. It is derived from
+// the ID, so it stays put across restarts and is the same on every instance.
+func phase(targetID string, interval time.Duration) time.Duration {
+	h := fnv.New64a()
+	h.Write([]byte(targetID))
+	return time.Duration(h.Sum64() % uint64(interval))
+}
+
+// slotStart is the most recent time at or before now that falls on the
+// target's phase.
+func (c *Checker) slotStart(target models.Target, now time.Time) time.Time {
+	interval := c.targetInterval(target)
+	offset := phase(target.ID, interval)
+	into := (now.UnixNano() - int64(offset)) % int64(interval)
+	return now.Add(-time.Duration(into))
+}
+
+// targetInterval is the target's own check interval, or the global one.
+func (c *Checker) targetInterval(target models.Target) time.Duration {
+	if target.CheckInterval != nil {
+		if interval, err := time.ParseDuration(*target.CheckInterval); err == nil && interval > 0 {
+			return interval
+		}
+	}
+	return c.config.Interval
+}
+
+// nextDue is when a target handed out at now is next due: one interval
+// later, or with SpreadChecks at its next phase.
+func (c *Checker) nextDue(target models.Target, now time.Time) time.Time {
+	if c.config.SpreadChecks {
+		return c.slotStart(target, now).Add(c.targetInterval(target))
+	}
+	return now.Add(c.targetInterval(target))
+}
+
+// forDueTargets hands the targets due at the start of the cycle to fn in
+// batches of up to dueBatchSize, most overdue first, after the check filter
+// and budget are applied. Every loaded target is rescheduled before fn runs,
+// filtered ones included, so each batch moves on to new targets and excluded
+// ones don't hold up the rest. Targets beyond the budget stay due, and so go
+// first next cycle.
+func (c *Checker) forDueTargets(ctx context.Context, fn func([]models.Target)) error {
+	filter, err := c.store.GetCheckFilter(ctx)
+	if err != nil {
+		return fmt.Errorf("get check filter: %w", err)
+	}
+
+	now := time.Now()
+	budget := c.config.CheckBudget
+	c.budgetSpent.Store(false)
+	for ctx.Err() == nil && !c.stopped() {
+		limit := dueBatchSize
+		if c.config.CheckBudget > 0 {
+			if budget == 0 {
+				return c.checkBudgetSpent(ctx, now)
+			}
+			limit = min(limit, budget)
+		}
+
+		targets, err := c.store.GetTargetsDue(ctx, now, limit)
+		if err != nil {
+			return fmt.Errorf("get due targets: %w", err)
+		}
+		if len(targets) == 0 {
+			return nil
+		}
+
+		next := make(map[string]time.Time, len(targets))
+		for _, target := range targets {
+			next[target.ID] = c.nextDue(target, now)
+		}
+		if err := c.store.SetNextCheckAt(ctx, next); err != nil {
+			return fmt.Errorf("reschedule targets: %w", err)
+		}
+
+		included := skipMaintenance(filterTargets(targets, filter), now)
+		budget -= len(included)
+		if len(included) > 0 {
+			fn(included)
+		}
+		if len(targets) < limit {
+			return nil
+		}
+	}
+	return nil
+}
+
+// checkBudgetSpent is called once a cycle has used its whole budget, and
+// notes whether targets were left waiting and so how often each target is
+// actually checked: every ceil(targets / budget) intervals.
+func (c *Checker) checkBudgetSpent(ctx context.Context, now time.Time) error {
+	waiting, err := c.store.GetTargetsDue(ctx, now, 1)
+	if err != nil {
+		return fmt.Errorf("get due targets: %w", err)
+	}
+	if len(waiting) == 0 {
+		return nil
+	}
+
+	count, err := c.store.CountUnpausedTargets(ctx)
+	if err != nil {
+		return fmt.Errorf("count targets: %w", err)
+	}
+	budget := c.config.CheckBudget
+	cycles := max((count+budget-1)/budget, 1)
+	effective := time.Duration(cycles) * c.config.Interval
+
+	c.effectiveInterval.Store(int64(effective))
+	c.budgetSpent.Store(true)
+	slog.Info("check budget exceeded",
+		"target_count", count,
+		"budget", budget,
+		"effective_interval", effective)
+	return nil
+}
+
+// Pause stops this process's checker from starting checks until Resume;
+// checks already running finish. Cycles still run, and only log that they
+// are paused. Targets a cycle had loaded but not yet dispatched wait for
+// their next due time.
+func (c *Checker) Pause() {
+	c.paused.Store(true)
+}
+
+// Resume undoes Pause and wakes the scheduling loop, so targets that fell
+// due meanwhile are checked right away.
+func (c *Checker) Resume() {
+	if c.paused.Swap(false) {
+		c.Wake()
+	}
+}
+
+// Paused reports whether checks are paused.
+func (c *Checker) Paused() bool {
+	return c.paused.Load()
+}
+
 func (c *Checker) checkAllTargets(ctx context.Context) {
-	targets, err := c.store.GetAllTargets()
+	if c.paused.Load() {
+		slog.Info("checks paused, skipping cycle")
+		return
+	}
+	checked := 0
+	c.beginCycle()
+	defer func() { c.endCycle(checked) }()
+	err := c.forDueTargets(ctx, func(targets []models.Target) {
+		slog.Info("starting check batch", "target_count", len(targets))
+		var batch *resultBatch
+		if c.config.ResultBatchSize > 1 {
+			batch = newResultBatch(ctx, c.store, c.config.ResultBatchSize)
+			// Flushed before the next batch is loaded, and when shutting
+			// down before the loop returns, so Stop doesn't return with
+			// finished checks still unsaved
+			defer func() {
+				if n := batch.flush(); n > 0 && (ctx.Err() != nil || c.stopped()) {
+					slog.Info("flushed buffered results on shutdown", "count", n)
+				}
+			}()
+		}
+		c.dispatch(ctx, targets, func(t models.Target) {
+			// Errors are logged where they happen
+			c.check(ctx, t, 0, batch)
+		})
+		checked += len(targets)
+	})
 	if err != nil {
 		slog.Error("failed to get targets for checking", "error", err)
+	} else if checked == 0 {
+		slog.Debug("no targets due, idle until the next tick or a new target")
+	}
+	if checked > 0 {
+		slog.Info("check cycle completed", "target_count", checked)
+	}
+}
+
+// enqueueDueTargets is the scheduler half of queue mode: due targets are put
+// on the shared queue for workers instead of being checked here.
+func (c *Checker) enqueueDueTargets(ctx context.Context) {
+	if c.paused.Load() {
+		slog.Info("checks paused, skipping cycle")
 		return
 	}
+	total, queued := 0, 0
+	c.beginCycle()
+	defer func() { c.endCycle(total) }()
+	err := c.forDueTargets(ctx, func(targets []models.Target) {
+		ids := make([]string, len(targets))
+		for i, target := range targets {
+			ids[i] = target.ID
+		}
 
-	if len(targets) == 0 {
+		n, err := c.store.EnqueueChecks(ctx, ids)
+		if err != nil {
+			slog.Error("failed to enqueue checks", "error", err)
+			return
+		}
+		total += len(targets)
+		queued += n
+	})
+	if err != nil {
+		slog.Error("failed to get targets for scheduling", "error", err)
 		return
 	}
+	slog.Info("enqueued checks", "target_count", total, "queued", queued)
+}
+
+// beginCycle records that a cycle has started.
+func (c *Checker) beginCycle() {
+	now := time.Now()
+	c.cycleMux.Lock()
+	c.cycleStartedAt = &now
+	c.cycleMux.Unlock()
+}
+
+// endCycle records a finished cycle and how many targets it handed out.
+func (c *Checker) endCycle(targets int) {
+	now := time.Now()
+	c.cycleMux.Lock()
+	c.cycleStartedAt = nil
+	c.lastCycleAt = &now
+	c.lastCycleTargets = targets
+	c.cycleMux.Unlock()
+}
+
+// Status reports what the checker is doing. Cycle fields stay empty on
+// instances that only work the queue.
+func (c *Checker) Status() models.CheckerStatus {
+	c.cycleMux.Lock()
+	status := models.CheckerStatus{
+		CycleRunning:         c.cycleStartedAt != nil,
+		CycleStartedAt:       c.cycleStartedAt,
+		LastCycleCompletedAt: c.lastCycleAt,
+		LastCycleTargets:     c.lastCycleTargets,
+	}
+	c.cycleMux.Unlock()
+
+	status.BudgetExhausted = c.budgetSpent.Load()
+	if status.BudgetExhausted {
+		effective := time.Duration(c.effectiveInterval.Load()).String()
+		status.EffectiveInterval = &effective
+	}
+	status.Paused = c.paused.Load()
+	status.Stopping = c.stopped()
+
+	c.hostMux.RLock()
+	status.HostSemaphores = len(c.hostSems)
+	for _, sem := range c.hostSems {
+		if len(sem) > 0 {
+			status.ActiveHosts++
+		}
+	}
+	c.hostMux.RUnlock()
+	return status
+}
 
-	slog.Info("starting check cycle", "target_count", len(targets))
+// drainQueue is the worker half of queue mode: it claims batches from the
+// queue and checks them until the queue is empty.
+func (c *Checker) drainQueue(ctx context.Context) {
+	// Polled often, so only the scheduling cycles log that checks are paused
+	for ctx.Err() == nil && !c.stopped() && !c.paused.Load() {
+		targets, err := c.store.ClaimChecks(ctx, c.workerID, c.config.MaxConcurrency, c.config.QueueLease)
+		if err != nil {
+			slog.Error("failed to claim checks", "worker_id", c.workerID, "error", err)
+			return
+		}
+		if len(targets) == 0 {
+			return
+		}
 
+		c.dispatch(ctx, targets, func(t models.Target) {
+			// Paused after it was queued
+			if !t.Paused {
+				c.checkTarget(ctx, t)
+			}
+			if err := c.store.CompleteCheck(ctx, t.ID, c.workerID); err != nil {
+				slog.Error("failed to complete queued check", "target_id", t.ID, "worker_id", c.workerID, "error", err)
+			}
+		})
+	}
+}
+
+// dispatch runs fn for each target, at most MaxConcurrency at a time.
+func (c *Checker) dispatch(ctx context.Context, targets []models.Target, fn func(models.Target)) {
 	// Use a semaphore to limit overall concurrency
 	sem := make(chan struct{}, c.config.MaxConcurrency)
 	var wg sync.WaitGroup
+	defer wg.Wait()
 
 	for _, target := range targets {
+		if c.stopped() || c.paused.Load() {
+			return
+		}
 		select {
 		case <-ctx.Done():
 			return
+		case <-c.stopping:
+			return
 		case sem <- struct{}{}:
 			wg.Add(1)
 			go func(t models.Target) {
 				defer wg.Done()
 				defer func() { <-sem }()
-				c.checkTarget(ctx, t)
+				fn(t)
 			}(target)
 		}
 	}
+}
+
+// stopped reports whether Stop has been called.
+func (c *Checker) stopped() bool {
+	select {
+	case <-c.stopping:
+		return true
+	default:
+		return false
+	}
+}
+
+// filterTargets drops targets whose host matches an exclusion in the filter.
+func filterTargets(targets []models.Target, filter *models.CheckFilter) []models.Target {
+	if filter == nil || len(filter.ExcludeHosts) == 0 {
+		return targets
+	}
+
+	var included []models.Target
+	for _, target := range targets {
+		parsed, err := url.Parse(target.URL)
+		if err == nil && policy.MatchHost(parsed.Hostname(), filter.ExcludeHosts) {
+			continue
+		}
+		included = append(included, target)
+	}
+	return included
+}
+
+// ParseIPVersion validates an address family preference, returning
+// models.IPVersionAuto for an empty one.
+func ParseIPVersion(s string) (string, error) {
+	switch s {
+	case "":
+		return models.IPVersionAuto, nil
+	case models.IPVersionAuto, models.IPVersionV4, models.IPVersionV6:
+		return s, nil
+	}
+	return "", fmt.Errorf("invalid ip version %q, expected auto, v4 or v6", s)
+}
+
+// clientFor returns the client dialing the address family target is
+// restricted to, by its own ip_version or else the checker's.
+func (c *Checker) clientFor(target models.Target) *http.Client {
+	version := c.config.IPVersion
+	if target.IPVersion != nil {
+		version = *target.IPVersion
+	}
+	if client, ok := c.ipClients[version]; ok {
+		return client
+	}
+	return c.client
+}
+
+// skipMaintenance drops targets inside one of their maintenance windows at
+// now. They were rescheduled like the rest, so they're due again once the
+// window is over.
+func skipMaintenance(targets []models.Target, now time.Time) []models.Target {
+	var included []models.Target
+	for _, target := range targets {
+		if target.InMaintenance(now) {
+			slog.Debug("target in maintenance, check skipped", "target_id", target.ID)
+			continue
+		}
+		included = append(included, target)
+	}
+	return included
+}
+
+// ErrHostBusy is returned by CheckNow and DryRun when another check of the same host
+// holds the per-host slot for longer than the caller is willing to wait.
+var ErrHostBusy = errors.New("a check for this host is already in flight")
+
+// DryRun checks rawURL once as a target with default settings would be
+// checked, without saving, caching, publishing or alerting on the result.
+// The host's circuit breaker is neither consulted nor updated. Like
+// CheckNow it takes the host's slot, waiting at most wait for it.
+func (c *Checker) DryRun(ctx context.Context, rawURL string, wait time.Duration) (*models.CheckResult, error) {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+
+	release, err := c.acquireHost(ctx, parsed.Host, wait)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
 
-	wg.Wait()
-	slog.Info("check cycle completed")
+	start := time.Now()
+	var result models.CheckResult
+	if c.config.Blocklist.Blocked(parsed.Hostname()) {
+		errorMsg := fmt.Sprintf("blocked by policy: host %s is blocklisted", parsed.Hostname())
+		result.Error = &errorMsg
+	} else {
+		result = c.runCheck(ctx, models.Target{URL: rawURL})
+	}
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	c.stamp(&result, start)
+	return &result, nil
 }
 
 func (c *Checker) checkTarget(ctx context.Context, target models.Target) {
+	// Errors are logged where they happen
+	c.check(ctx, target, 0, nil)
+}
+
+// CheckNow runs and records an immediate check of target outside the
+// schedule. It still takes the target's per-host slot, waiting at most wait
+// for it, so manual checks can't stampede a host alongside a cycle.
+func (c *Checker) CheckNow(ctx context.Context, target models.Target, wait time.Duration) (*models.CheckResult, error) {
+	return c.check(ctx, target, wait, nil)
+}
+
+// check runs and records one check. It waits up to wait for the per-host
+// slot (returning ErrHostBusy after that), or until ctx is done if wait is
+// zero. With a batch the result is queued there instead of saved right away.
+func (c *Checker) check(ctx context.Context, target models.Target, wait time.Duration, batch *resultBatch) (*models.CheckResult, error) {
+	// Settings come from the profile at check time, so profile edits apply
+	// from the next check on
+	target, err := c.store.ResolveTarget(ctx, target)
+	if err != nil {
+		slog.Error("failed to resolve target profile", "target_id", target.ID, "error", err)
+		return nil, err
+	}
+
 	parsed, err := url.Parse(target.URL)
 	if err != nil {
 		slog.Error("failed to parse target URL", "target_id", target.ID, "url", target.URL, "error", err)
-		return
+		return nil, err
 	}
 
+	// The target's host even when checks go through a proxy, so a proxy
+	// doesn't serialize checks of unrelated hosts
 	host := parsed.Host
 
-	// Get or create per-host semaphore
-	hostSem := c.getHostSemaphore(host)
+	release, err := c.acquireHost(ctx, host, wait)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
 
-	// Acquire per-host lock
-	select {
-	case <-ctx.Done():
-		return
-	case hostSem <- struct{}{}:
-		defer func() { <-hostSem }()
+	// Fetch the previous result so we can detect up/down transitions
+	previous, err := c.store.GetLatestCheckResult(ctx, target.ID, false)
+	if err != nil {
+		slog.Error("failed to get previous check result", "target_id", target.ID, "error", err)
 	}
 
 	start := time.Now()
-	result := c.performCheck(ctx, target.URL)
-	result.CheckedAt = start
-	result.LatencyMs = int(time.Since(start).Milliseconds())
+	var result models.CheckResult
+	probed := false
+	if c.config.Blocklist.Blocked(parsed.Hostname()) {
+		// Never contact blocklisted hosts; record the policy violation instead
+		errorMsg := fmt.Sprintf("blocked by policy: host %s is blocklisted", parsed.Hostname())
+		result.Error = &errorMsg
+	} else if allowed, failures := c.breakerAllow(host, start); !allowed {
+		errorMsg := fmt.Sprintf("circuit open: host %s failed %d consecutive checks", parsed.Hostname(), failures)
+		result.Error = &errorMsg
+	} else {
+		probed = true
+		result = c.runCheck(ctx, target)
+	}
+
+	// Cancelled from outside (a forced shutdown or a gone client) rather
+	// than by the target's own timeout: the result says nothing about the
+	// target, so don't record it
+	if ctx.Err() != nil {
+		slog.Warn("check cancelled, result discarded", "target_id", target.ID, "url", target.URL)
+		return nil, ctx.Err()
+	}
+	if probed {
+		c.breakerRecord(host, result.StatusCode != nil, time.Now())
+	}
+	c.stamp(&result, start)
+
+	if result.TLSExpiring {
+		slog.Warn("TLS certificate expiring soon", "target_id", target.ID, "url", target.URL,
+			"expires_at", result.TLSExpiresAt)
+	}
 
-	if err := c.store.SaveCheckResult(target.ID, result); err != nil {
+	// Only a fresh 2xx or an unchanged 304 moves the validators, so errors
+	// don't reset them
+	if result.Error == nil && result.StatusCode != nil &&
+		(result.NotModified || (*result.StatusCode >= 200 && *result.StatusCode < 300)) {
+		if err := c.store.SetValidators(ctx, target.ID, result.ETag, result.LastModified); err != nil {
+			slog.Error("failed to save validators", "target_id", target.ID, "error", err)
+		}
+	}
+
+	c.applyDependency(ctx, target, &result)
+
+	if batch != nil {
+		batch.add(target.ID, result)
+	} else if err := c.store.SaveCheckResult(ctx, target.ID, result); err != nil {
 		slog.Error("failed to save check result", "target_id", target.ID, "error", err)
-		return
+		return nil, err
+	}
+	c.config.ResultCache.Set(target.ID, result)
+	c.config.Events.Publish(models.CheckEvent{TargetID: target.ID, Result: result})
+
+	// Suppressed failures are attributed to the dependency and don't alert,
+	// nor does anything during maintenance (checks on demand or claimed
+	// from the queue just before a window started)
+	if previous != nil && result.SuppressedBy == nil && !target.InMaintenance(start) {
+		if transition := webhook.NewTransition(target, *previous, result); transition != nil {
+			// Delivered in the background, so a slow receiver doesn't hold
+			// this check's slots
+			c.notifier.Enqueue(*transition)
+		}
 	}
 
 	slog.Debug("check completed", "target_id", target.ID, "url", target.URL,
 		"status", result.StatusCode, "latency_ms", result.LatencyMs, "error", result.Error)
+	return &result, nil
+}
+
+// applyDependency marks a failed result as suppressed when the target's
+// dependency is currently down, based on the dependency's latest result.
+func (c *Checker) applyDependency(ctx context.Context, target models.Target, result *models.CheckResult) {
+	if target.DependsOn == nil || webhook.TargetState(target, *result) == webhook.StateUp {
+		return
+	}
+
+	dependency, err := c.store.GetTarget(ctx, *target.DependsOn)
+	if err != nil {
+		slog.Error("failed to get dependency target", "target_id", target.ID, "depends_on", *target.DependsOn, "error", err)
+		return
+	}
+	if dependency == nil {
+		return
+	}
+	if *dependency, err = c.store.ResolveTarget(ctx, *dependency); err != nil {
+		slog.Error("failed to resolve dependency profile", "target_id", target.ID, "depends_on", *target.DependsOn, "error", err)
+		return
+	}
+
+	latest, err := c.store.GetLatestCheckResult(ctx, dependency.ID, true)
+	if err != nil {
+		slog.Error("failed to get dependency check result", "target_id", target.ID, "depends_on", *target.DependsOn, "error", err)
+		return
+	}
+
+	if latest != nil && webhook.TargetState(*dependency, *latest) == webhook.StateDown {
+		result.SuppressedBy = target.DependsOn
+	}
 }
 
 func (c *Checker) getHostSemaphore(host string) chan struct{} {
@@ -156,44 +1023,108 @@ func (c *Checker) getHostSemaphore(host string) chan struct{} {
 		return sem
 	}
 
-	// Create new semaphore with capacity 1 (one check per host at a time)
-	sem := make(chan struct{}, 1)
+	sem := make(chan struct{}, c.config.PerHostConcurrency)
 	c.hostSems[host] = sem
 	return sem
 }
 
-func (c *Checker) performCheck(ctx context.Context, targetURL string) models.CheckResult {
+func (c *Checker) performCheck(ctx context.Context, target models.Target) models.CheckResult {
+	targetURL := target.URL
 	var result models.CheckResult
 	var lastErr error
 
-	// Retry logic: initial attempt + up to 2 retries on 5xx or network errors
-	maxAttempts := 3
-	backoff := 200 * time.Millisecond
+	// Retry logic: initial attempt + up to MaxRetries retries on 5xx,
+	// RetryStatusCodes or network errors
+	maxAttempts := c.config.MaxRetries + 1
+	backoff := c.config.BackoffBase
+	var retryAfter time.Duration // Asked for by the last response
+
+	traced := c.tracer != nil && c.tracer.sample()
+
+	var password string
+	if target.Password != nil {
+		var err error
+		if password, err = c.store.OpenSecret(*target.Password); err != nil {
+			errorMsg := "credentials: " + err.Error()
+			result.Error = &errorMsg
+			return result
+		}
+	}
 
 	for attempt := 0; attempt < maxAttempts; attempt++ {
 		if attempt > 0 {
-			// Apply exponential backoff
+			// Apply exponential backoff, or wait as long as the server asked
 			select {
 			case <-ctx.Done():
 				errorMsg := "context cancelled"
 				result.Error = &errorMsg
 				return result
-			case <-time.After(backoff):
+			case <-time.After(max(backoff, retryAfter)):
 				backoff *= 2
+				retryAfter = 0
 			}
 		}
 
-		req, err := http.NewRequestWithContext(ctx, "GET", targetURL, nil)
+		attemptCtx, cancel := c.attemptContext(ctx)
+		timer := &phaseTimer{}
+		reqCtx := timer.withClientTrace(attemptCtx)
+		var record *traceRecord
+		if traced {
+			record = newTraceRecord(targetURL, attempt+1)
+			reqCtx = record.withClientTrace(reqCtx)
+		}
+
+		req, err := http.NewRequestWithContext(reqCtx, "GET", targetURL, nil)
 		if err != nil {
+			cancel()
 			lastErr = err
 			continue
 		}
 
-		req.Header.Set("User-Agent", "Linkwatch/1.0")
+		req.Header.Set("User-Agent", c.config.UserAgent)
+		// Body assertions need the body, so those targets always fetch it
+		conditional := target.ExpectedBody == nil && target.BodyRegex == nil &&
+			(target.ETag != nil || target.LastModified != nil)
+		if conditional {
+			if target.ETag != nil {
+				req.Header.Set("If-None-Match", *target.ETag)
+			}
+			if target.LastModified != nil {
+				req.Header.Set("If-Modified-Since", *target.LastModified)
+			}
+		}
+		for name, value := range target.Headers {
+			req.Header.Set(name, value)
+		}
+		if target.Username != nil {
+			req.SetBasicAuth(*target.Username, password)
+		}
 
-		resp, err := c.client.Do(req)
+		resp, err := c.clientFor(target).Do(req)
+		timer.apply(&result)
+		if record != nil {
+			c.writeTrace(record, resp, err)
+		}
+		// On a redirect policy error resp is the last hop reached, so the
+		// final URL shows where the chain was cut off
+		if resp != nil && resp.Request != nil {
+			finalURL := resp.Request.URL.String()
+			result.FinalURL = &finalURL
+			redirects := redirectCount(resp)
+			result.RedirectCount = &redirects
+		} else {
+			result.FinalURL = nil
+			result.RedirectCount = nil
+		}
+		result.ContentType, result.ContentLength, result.BodyBytes = nil, nil, nil
 		if err != nil {
+			cancel()
 			lastErr = err
+			// The attempt ran out of time but the check may not have; retry
+			if ctx.Err() == nil && attemptCtx.Err() == context.DeadlineExceeded {
+				lastErr = fmt.Errorf("timeout: no response within %s", c.config.HTTPTimeout)
+				continue
+			}
 			// Retry on network errors
 			if isNetworkError(err) {
 				continue
@@ -202,14 +1133,39 @@ func (c *Checker) performCheck(ctx context.Context, targetURL string) models.Che
 		}
 
 		result.StatusCode = &resp.StatusCode
+		result.ContentType, result.ContentLength = contentHeaders(resp)
+		body := &countingReader{r: resp.Body}
+		bodyErr := assertBody(target, body)
+		drainBody(body)
 		resp.Body.Close()
+		cancel()
+		result.BodyBytes = &body.n
+		c.recordTLSExpiry(&result, resp)
 
-		// Success or 4xx - don't retry
-		if resp.StatusCode < 500 {
+		// A retryable status other than 5xx that is out of retries, or a
+		// server asking for longer than we wait, is kept as the response
+		retryable := resp.StatusCode >= 500 || c.config.RetryStatusCodes.Contains(resp.StatusCode)
+		if retryable && attempt < maxAttempts-1 {
+			var ok bool
+			if retryAfter, ok = parseRetryAfter(resp, time.Now()); ok && retryAfter > maxRetryAfter {
+				retryable = false
+				lastErr = fmt.Errorf("server error: %d, retry after %s exceeds %s", resp.StatusCode, retryAfter, maxRetryAfter)
+			}
+		}
+		if resp.StatusCode < 500 && (!retryable || attempt == maxAttempts-1) {
+			result.NotModified = conditional && resp.StatusCode == http.StatusNotModified
+			result.ETag, result.LastModified = validators(target, resp, result.NotModified)
+			if bodyErr != nil {
+				errorMsg := bodyErr.Error()
+				result.Error = &errorMsg
+			}
 			return result
 		}
 
-		// 5xx - retry if we have attempts left
+		// 5xx, or a retryable status with attempts left
+		if !retryable {
+			break
+		}
 		lastErr = fmt.Errorf("server error: %d", resp.StatusCode)
 		if attempt == maxAttempts-1 {
 			break
@@ -225,6 +1181,216 @@ func (c *Checker) performCheck(ctx context.Context, targetURL string) models.Che
 	return result
 }
 
+// maxRetryAfter is the longest Retry-After a check waits out before
+// retrying; a response asking for longer ends the check instead.
+const maxRetryAfter = 30 * time.Second
+
+// parseRetryAfter returns how long resp's Retry-After header, in seconds or
+// as an HTTP date, asks to wait.
+func parseRetryAfter(resp *http.Response, now time.Time) (time.Duration, bool) {
+	value := resp.Header.Get("Retry-After")
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		return max(at.Sub(now), 0), true
+	}
+	return 0, false
+}
+
+// acquireHost takes host's per-host slot, returning the function that gives
+// it back. It waits up to wait (returning ErrHostBusy after that), or until
+// ctx is done if wait is zero.
+func (c *Checker) acquireHost(ctx context.Context, host string, wait time.Duration) (func(), error) {
+	hostSem := c.getHostSemaphore(host)
+
+	var busy <-chan time.Time
+	if wait > 0 {
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+		busy = timer.C
+	}
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-busy:
+		return nil, ErrHostBusy
+	case hostSem <- struct{}{}:
+		return func() { <-hostSem }, nil
+	}
+}
+
+// runCheck probes target within its time budget: its timeout_ms, or the
+// configured CheckTimeout.
+func (c *Checker) runCheck(ctx context.Context, target models.Target) models.CheckResult {
+	budget := c.config.CheckTimeout
+	if target.TimeoutMs != nil {
+		budget = time.Duration(*target.TimeoutMs) * time.Millisecond
+	}
+	if budget <= 0 {
+		return c.performCheck(ctx, target)
+	}
+
+	checkCtx, cancel := context.WithTimeout(ctx, budget)
+	defer cancel()
+	result := c.performCheck(checkCtx, target)
+	if result.Error != nil && ctx.Err() == nil && checkCtx.Err() == context.DeadlineExceeded {
+		errorMsg := fmt.Sprintf("timeout: check exceeded its %s budget", budget)
+		result.Error = &errorMsg
+	}
+	return result
+}
+
+// stamp records when a check started, how long it took since, and which
+// instance ran it.
+func (c *Checker) stamp(result *models.CheckResult, start time.Time) {
+	elapsed := time.Since(start)
+	latencyUs := elapsed.Microseconds()
+	result.CheckedAt = start
+	result.LatencyMs = int(elapsed.Milliseconds())
+	result.LatencyUs = &latencyUs
+	result.InstanceID = c.config.InstanceID
+}
+
+// attemptContext bounds a single attempt by HTTPTimeout, within whatever is
+// left of the check's own deadline.
+func (c *Checker) attemptContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if c.config.HTTPTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, c.config.HTTPTimeout)
+}
+
+// maxAssertBodyBytes caps how much of a response body is read to check
+// expected_body and body_regex; content past it is not searched.
+const maxAssertBodyBytes = 1 << 20
+
+// assertBody checks the target's body assertions against body, returning a
+// descriptive error when one doesn't hold. Targets without assertions don't
+// read the body at all.
+func assertBody(target models.Target, body io.Reader) error {
+	if target.ExpectedBody == nil && target.BodyRegex == nil {
+		return nil
+	}
+
+	data, err := io.ReadAll(io.LimitReader(body, maxAssertBodyBytes))
+	if err != nil {
+		return fmt.Errorf("read body: %w", err)
+	}
+
+	if target.ExpectedBody != nil && !bytes.Contains(data, []byte(*target.ExpectedBody)) {
+		return fmt.Errorf("body assertion failed: body does not contain %q", *target.ExpectedBody)
+	}
+	if target.BodyRegex != nil {
+		re, err := regexp.Compile(*target.BodyRegex)
+		if err != nil {
+			return fmt.Errorf("invalid body_regex: %w", err)
+		}
+		if !re.Match(data) {
+			return fmt.Errorf("body assertion failed: body does not match %q", *target.BodyRegex)
+		}
+	}
+	return nil
+}
+
+// maxDrainBodyBytes caps how much of a response body is read and discarded
+// after the body assertions. Bodies are decoded by then, so the cap also
+// bounds what a compressed body expands to.
+const maxDrainBodyBytes = 4 << 20
+
+// drainBody reads what is left of body, up to maxDrainBodyBytes, so the
+// connection can be reused for the next check and the latency covers the
+// whole response. The read is bound by the request's context and timeout
+// like the rest of the check. A body that isn't read to the end within the
+// cap just costs the connection, so errors are ignored.
+func drainBody(body io.Reader) {
+	io.Copy(io.Discard, io.LimitReader(body, maxDrainBodyBytes))
+}
+
+// contentHeaders returns resp's Content-Type and Content-Length, each nil
+// when absent. Transparently decompressed responses have no known length.
+func contentHeaders(resp *http.Response) (*string, *int64) {
+	var contentType *string
+	if value := resp.Header.Get("Content-Type"); value != "" {
+		contentType = &value
+	}
+	var contentLength *int64
+	if resp.ContentLength >= 0 {
+		length := resp.ContentLength
+		contentLength = &length
+	}
+	return contentType, contentLength
+}
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// redirectCount returns how many redirects led to resp, by walking back
+// through the responses that caused each request.
+func redirectCount(resp *http.Response) int {
+	count := 0
+	for req := resp.Request; req != nil && req.Response != nil; req = req.Response.Request {
+		count++
+	}
+	return count
+}
+
+// validators returns the ETag and Last-Modified to send on the next check:
+// those of the response, or for a 304 that omits them, the ones just sent.
+func validators(target models.Target, resp *http.Response, notModified bool) (etag, lastModified *string) {
+	if value := resp.Header.Get("ETag"); value != "" {
+		etag = &value
+	} else if notModified {
+		etag = target.ETag
+	}
+	if value := resp.Header.Get("Last-Modified"); value != "" {
+		lastModified = &value
+	} else if notModified {
+		lastModified = target.LastModified
+	}
+	return etag, lastModified
+}
+
+// recordTLSExpiry stores when the response's leaf certificate expires and
+// flags it when that is within TLSExpiryWarn.
+func (c *Checker) recordTLSExpiry(result *models.CheckResult, resp *http.Response) {
+	if resp.TLS == nil || len(resp.TLS.PeerCertificates) == 0 {
+		result.TLSExpiresAt = nil
+		result.TLSExpiring = false
+		return
+	}
+
+	notAfter := resp.TLS.PeerCertificates[0].NotAfter.UTC()
+	result.TLSExpiresAt = &notAfter
+	result.TLSExpiring = c.config.TLSExpiryWarn > 0 && time.Until(notAfter) < c.config.TLSExpiryWarn
+}
+
+func (c *Checker) writeTrace(record *traceRecord, resp *http.Response, err error) {
+	if resp != nil {
+		statusCode := resp.StatusCode
+		record.StatusCode = &statusCode
+	}
+	if err != nil {
+		record.Error = err.Error()
+	}
+	if err := c.tracer.write(record); err != nil {
+		slog.Error("failed to write check trace", "url", record.URL, "error", err)
+	}
+}
+
 func isNetworkError(err error) bool {
 	if _, ok := err.(*net.OpError); ok {
 		return true
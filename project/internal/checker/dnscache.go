@@ -0,0 +1,113 @@
+package checker
+
+import (
+	"context"
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ipResolver looks up the addresses to dial for a host on network, "tcp",
+// "tcp4" or "tcp6".
+type ipResolver interface {
+	LookupIP(ctx context.Context, network, host string) ([]net.IP, error)
+}
+
+// systemResolver resolves through the system resolver, IPv4 addresses first
+// like dohResolver.
+type systemResolver struct{}
+
+func (systemResolver) LookupIP(ctx context.Context, network, host string) ([]net.IP, error) {
+	family := "ip"
+	switch network {
+	case "tcp4":
+		family = "ip4"
+	case "tcp6":
+		family = "ip6"
+	}
+	ips, err := net.DefaultResolver.LookupIP(ctx, family, host)
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(ips, func(i, j int) bool {
+		return ips[i].To4() != nil && ips[j].To4() == nil
+	})
+	return ips, nil
+}
+
+// dnsCache remembers what resolver returned for each host and network for
+// ttl, so checks of many targets on one host resolve it once per ttl rather
+// than once per check. Failed lookups aren't cached.
+type dnsCache struct {
+	resolver ipResolver
+	ttl      time.Duration
+
+	mu        sync.Mutex
+	entries   map[dnsKey]dnsEntry
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+type dnsKey struct {
+	network, host string
+}
+
+type dnsEntry struct {
+	ips     []net.IP
+	expires time.Time
+}
+
+func newDNSCache(resolver ipResolver, ttl time.Duration) *dnsCache {
+	return &dnsCache{
+		resolver: resolver,
+		ttl:      ttl,
+		entries:  make(map[dnsKey]dnsEntry),
+		now:      time.Now,
+	}
+}
+
+// LookupIP returns host's cached addresses, resolving them again once they
+// are older than the ttl. An error drops whatever was cached for host.
+func (c *dnsCache) LookupIP(ctx context.Context, network, host string) ([]net.IP, error) {
+	key := dnsKey{network, host}
+	c.mu.Lock()
+	entry, ok := c.entries[key]
+	c.mu.Unlock()
+	if ok && c.now().Before(entry.expires) {
+		return entry.ips, nil
+	}
+
+	ips, err := c.resolver.LookupIP(ctx, network, host)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.now()
+	if now.Sub(c.lastSweep) >= c.ttl {
+		c.sweep(now)
+	}
+	if err != nil {
+		delete(c.entries, key)
+		return nil, err
+	}
+	c.entries[key] = dnsEntry{ips: ips, expires: now.Add(c.ttl)}
+	return ips, nil
+}
+
+// forget drops host's cached addresses for network, so the next lookup
+// resolves it.
+func (c *dnsCache) forget(network, host string) {
+	c.mu.Lock()
+	delete(c.entries, dnsKey{network, host})
+	c.mu.Unlock()
+}
+
+// sweep drops expired entries, so hosts no longer checked don't pile up.
+func (c *dnsCache) sweep(now time.Time) {
+	for key, entry := range c.entries {
+		if !now.Before(entry.expires) {
+			delete(c.entries, key)
+		}
+	}
+	c.lastSweep = now
+}
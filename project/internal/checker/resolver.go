@@ -0,0 +1,204 @@
+package checker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/aarushishahhh/linkwatch/project/internal/models"
+)
+
+// DNS record types used in DoH JSON queries
+const (
+	dnsTypeA    = 1
+	dnsTypeAAAA = 28
+)
+
+// dohResolver resolves hostnames through a DNS-over-HTTPS endpoint using the
+// JSON API (application/dns-json) supported by Cloudflare, Google and others.
+type dohResolver struct {
+	endpoint string
+	client   *http.Client
+}
+
+type dohResponse struct {
+	Status int `json:"Status"`
+	Answer []struct {
+		Type int    `json:"type"`
+		Data string `json:"data"`
+	} `json:"Answer"`
+}
+
+func newDoHResolver(endpoint string, timeout time.Duration) *dohResolver {
+	return &dohResolver{
+		endpoint: endpoint,
+		// Plain client so resolver queries never go through the checker's dialer
+		client: &http.Client{Timeout: timeout},
+	}
+}
+
+// LookupIP returns the addresses for host to dial on network: only AAAA
+// records for "tcp6" and only A records for "tcp4". Otherwise it returns the
+// IPv4 addresses, falling back to IPv6 when the host has no A records.
+func (r *dohResolver) LookupIP(ctx context.Context, network, host string) ([]net.IP, error) {
+	if network != "tcp6" {
+		ips, err := r.query(ctx, host, dnsTypeA)
+		if err != nil {
+			return nil, err
+		}
+		if len(ips) > 0 || network == "tcp4" {
+			return nonEmpty(ips, host)
+		}
+	}
+
+	ips, err := r.query(ctx, host, dnsTypeAAAA)
+	if err != nil {
+		return nil, err
+	}
+	return nonEmpty(ips, host)
+}
+
+// nonEmpty returns ips, or a not found error for host when there are none.
+func nonEmpty(ips []net.IP, host string) ([]net.IP, error) {
+	if len(ips) == 0 {
+		return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+	return ips, nil
+}
+
+func (r *dohResolver) query(ctx context.Context, host string, recordType int) ([]net.IP, error) {
+	u, err := url.Parse(r.endpoint)
+	if err != nil {
+		return nil, err
+	}
+	q := u.Query()
+	q.Set("name", host)
+	q.Set("type", fmt.Sprintf("%d", recordType))
+	u.RawQuery = q.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/dns-json")
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, &net.DNSError{Err: err.Error(), Name: host, IsTemporary: true}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, &net.DNSError{Err: fmt.Sprintf("DoH server returned %d", resp.StatusCode), Name: host, IsTemporary: true}
+	}
+
+	var body dohResponse
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, &net.DNSError{Err: "invalid DoH response", Name: host}
+	}
+
+	// Status is the DNS RCODE; 3 is NXDOMAIN
+	if body.Status == 3 {
+		return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+	if body.Status != 0 {
+		return nil, &net.DNSError{Err: fmt.Sprintf("DNS error code %d", body.Status), Name: host}
+	}
+
+	var ips []net.IP
+	for _, answer := range body.Answer {
+		// Skip CNAMEs and other records in the chain
+		if answer.Type != recordType {
+			continue
+		}
+		if ip := net.ParseIP(answer.Data); ip != nil {
+			ips = append(ips, ip)
+		}
+	}
+	return ips, nil
+}
+
+// dialContextWithResolver returns a DialContext that resolves hostnames with
+// the given resolver and tries each address in turn.
+func dialContextWithResolver(resolver ipResolver, dialer *net.Dialer) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+
+		if ip := net.ParseIP(host); ip != nil {
+			return dialer.DialContext(ctx, network, addr)
+		}
+
+		ips, err := resolver.LookupIP(ctx, network, host)
+		if err != nil {
+			return nil, err
+		}
+
+		var lastErr error
+		for _, ip := range ips {
+			if !ipMatches(network, ip) {
+				continue
+			}
+			conn, err := dialer.DialContext(ctx, network, net.JoinHostPort(ip.String(), port))
+			if err == nil {
+				return conn, nil
+			}
+			lastErr = err
+		}
+		if lastErr == nil {
+			return nil, &net.AddrError{Err: "no " + familyName(network) + " address", Addr: host}
+		}
+		// The host may have moved, so cached addresses that all failed
+		// aren't tried again
+		if cache, ok := resolver.(*dnsCache); ok {
+			cache.forget(network, host)
+		}
+		return nil, lastErr
+	}
+}
+
+// dialNetwork returns a DialContext that dials network, "tcp4" or "tcp6",
+// where the transport asks for plain "tcp".
+func dialNetwork(dial func(ctx context.Context, network, addr string) (net.Conn, error), network string) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, _, addr string) (net.Conn, error) {
+		return dial(ctx, network, addr)
+	}
+}
+
+// ipMatches reports whether ip can be dialed on network.
+func ipMatches(network string, ip net.IP) bool {
+	switch network {
+	case "tcp4":
+		return ip.To4() != nil
+	case "tcp6":
+		return ip.To4() == nil
+	}
+	return true
+}
+
+func familyName(network string) string {
+	if network == "tcp6" {
+		return "IPv6"
+	}
+	return "IPv4"
+}
+
+// ipVersionOf returns the models.IPVersion of a connection's address, nil
+// when it isn't an IP address.
+func ipVersionOf(addr net.Addr) *string {
+	tcp, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return nil
+	}
+	version := models.IPVersionV6
+	if tcp.IP.To4() != nil {
+		version = models.IPVersionV4
+	}
+	return &version
+}
@@ -0,0 +1,100 @@
+package checker
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http/httptrace"
+	"sync"
+	"time"
+
+	"github.com/aarushishahhh/linkwatch/project/internal/models"
+)
+
+// phaseTimer records when each phase of a request starts and ends so a slow
+// check can be attributed to DNS, connecting, TLS or the server, and which
+// address the request went to. Redirects issue several requests; each
+// GetConn starts over, so the timings describe the final hop.
+type phaseTimer struct {
+	mu    sync.Mutex
+	times phaseTimes
+}
+
+type phaseTimes struct {
+	getConn      time.Time
+	dnsStart     time.Time
+	dnsDone      time.Time
+	connectStart time.Time
+	connectDone  time.Time
+	tlsStart     time.Time
+	tlsDone      time.Time
+	firstByte    time.Time
+
+	remoteAddr net.Addr
+}
+
+func (p *phaseTimer) mark(at *time.Time) {
+	p.mu.Lock()
+	*at = time.Now()
+	p.mu.Unlock()
+}
+
+// withClientTrace attaches the timer to ctx. Hooks already present in ctx,
+// such as a traceRecord's, keep firing alongside it.
+func (p *phaseTimer) withClientTrace(ctx context.Context) context.Context {
+	return httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		GetConn: func(string) {
+			p.mu.Lock()
+			p.times = phaseTimes{getConn: time.Now()}
+			p.mu.Unlock()
+		},
+		DNSStart: func(httptrace.DNSStartInfo) { p.mark(&p.times.dnsStart) },
+		DNSDone:  func(httptrace.DNSDoneInfo) { p.mark(&p.times.dnsDone) },
+		ConnectStart: func(network, addr string) {
+			// Dual-stack dialing may start several connections; time from the first
+			p.mu.Lock()
+			if p.times.connectStart.IsZero() {
+				p.times.connectStart = time.Now()
+			}
+			p.mu.Unlock()
+		},
+		ConnectDone: func(network, addr string, err error) {
+			if err == nil {
+				p.mark(&p.times.connectDone)
+			}
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			p.mu.Lock()
+			p.times.remoteAddr = info.Conn.RemoteAddr()
+			p.mu.Unlock()
+		},
+		TLSHandshakeStart:    func() { p.mark(&p.times.tlsStart) },
+		TLSHandshakeDone:     func(tls.ConnectionState, error) { p.mark(&p.times.tlsDone) },
+		GotFirstResponseByte: func() { p.mark(&p.times.firstByte) },
+	})
+}
+
+// apply copies the phase durations and the connection's address family into
+// result. Phases that didn't happen, such as DNS for an IP literal or
+// everything but TTFB on a reused connection, are left nil.
+func (p *phaseTimer) apply(result *models.CheckResult) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	t := p.times
+	result.DNSMs = phaseMs(t.dnsStart, t.dnsDone)
+	result.ConnectMs = phaseMs(t.connectStart, t.connectDone)
+	result.TLSMs = phaseMs(t.tlsStart, t.tlsDone)
+	result.TTFBMs = phaseMs(t.getConn, t.firstByte)
+	result.IPVersion = nil
+	if t.remoteAddr != nil {
+		result.IPVersion = ipVersionOf(t.remoteAddr)
+	}
+}
+
+func phaseMs(start, end time.Time) *int {
+	if start.IsZero() || end.IsZero() {
+		return nil
+	}
+	ms := int(end.Sub(start).Milliseconds())
+	return &ms
+}
@@ -0,0 +1,168 @@
+package checker
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http/httptrace"
+	"os"
+	"sync"
+	"time"
+)
+
+type traceEvent struct {
+	Name   string  `json:"name"`
+	AtMs   float64 `json:"at_ms"` // Offset from the start of the attempt
+	Detail string  `json:"detail,omitempty"`
+}
+
+// traceRecord is one line in the trace file, covering a single request attempt.
+type traceRecord struct {
+	URL        string       `json:"url"`
+	Attempt    int          `json:"attempt"`
+	StartedAt  time.Time    `json:"started_at"`
+	StatusCode *int         `json:"status_code,omitempty"`
+	Error      string       `json:"error,omitempty"`
+	Events     []traceEvent `json:"events"`
+
+	mu    sync.Mutex
+	start time.Time
+}
+
+func newTraceRecord(url string, attempt int) *traceRecord {
+	now := time.Now()
+	return &traceRecord{URL: url, Attempt: attempt, StartedAt: now.UTC(), start: now}
+}
+
+func (r *traceRecord) add(name, detail string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Events = append(r.Events, traceEvent{
+		Name:   name,
+		AtMs:   float64(time.Since(r.start).Microseconds()) / 1000,
+		Detail: detail,
+	})
+}
+
+// withClientTrace attaches an httptrace.ClientTrace that records every
+// lifecycle callback into the record.
+func (r *traceRecord) withClientTrace(ctx context.Context) context.Context {
+	errDetail := func(err error) string {
+		if err != nil {
+			return err.Error()
+		}
+		return ""
+	}
+
+	return httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		GetConn: func(hostPort string) { r.add("get_conn", hostPort) },
+		GotConn: func(info httptrace.GotConnInfo) {
+			r.add("got_conn", fmt.Sprintf("reused=%t", info.Reused))
+		},
+		DNSStart: func(info httptrace.DNSStartInfo) { r.add("dns_start", info.Host) },
+		DNSDone: func(info httptrace.DNSDoneInfo) {
+			r.add("dns_done", errDetail(info.Err))
+		},
+		ConnectStart: func(network, addr string) { r.add("connect_start", addr) },
+		ConnectDone: func(network, addr string, err error) {
+			r.add("connect_done", errDetail(err))
+		},
+		TLSHandshakeStart: func() { r.add("tls_handshake_start", "") },
+		TLSHandshakeDone: func(state tls.ConnectionState, err error) {
+			r.add("tls_handshake_done", errDetail(err))
+		},
+		WroteHeaders: func() { r.add("wrote_headers", "") },
+		WroteRequest: func(info httptrace.WroteRequestInfo) {
+			r.add("wrote_request", errDetail(info.Err))
+		},
+		GotFirstResponseByte: func() { r.add("got_first_response_byte", "") },
+	})
+}
+
+// traceWriter appends trace records as newline-delimited JSON, rotating the
+// file to "<path>.1" once it would grow past maxBytes.
+type traceWriter struct {
+	mu         sync.Mutex
+	path       string
+	maxBytes   int64
+	sampleRate float64
+	file       *os.File
+	size       int64
+}
+
+func newTraceWriter(path string, maxBytes int64, sampleRate float64) *traceWriter {
+	return &traceWriter{path: path, maxBytes: maxBytes, sampleRate: sampleRate}
+}
+
+// sample decides whether a check should be traced.
+func (w *traceWriter) sample() bool {
+	return w.sampleRate >= 1 || rand.Float64() < w.sampleRate
+}
+
+func (w *traceWriter) write(record *traceRecord) error {
+	record.mu.Lock()
+	line, err := json.Marshal(record)
+	record.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.file == nil {
+		if err := w.open(); err != nil {
+			return err
+		}
+	}
+
+	if w.maxBytes > 0 && w.size > 0 && w.size+int64(len(line)) > w.maxBytes {
+		if err := w.rotate(); err != nil {
+			return err
+		}
+	}
+
+	n, err := w.file.Write(line)
+	w.size += int64(n)
+	return err
+}
+
+func (w *traceWriter) open() error {
+	file, err := os.OpenFile(w.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	w.file = file
+	w.size = info.Size()
+	return nil
+}
+
+func (w *traceWriter) rotate() error {
+	if err := w.file.Close(); err != nil {
+		return err
+	}
+	w.file = nil
+	if err := os.Rename(w.path, w.path+".1"); err != nil {
+		return err
+	}
+	return w.open()
+}
+
+func (w *traceWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.file == nil {
+		return nil
+	}
+	err := w.file.Close()
+	w.file = nil
+	return err
+}
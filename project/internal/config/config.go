@@ -11,8 +11,74 @@ type Config struct {
 	DatabaseURL    string
 	CheckInterval  time.Duration
 	MaxConcurrency int
+	CheckBudget    int
+	SpreadChecks   bool
 	HTTPTimeout    time.Duration
+	MaxRetries     int
+	BackoffBase    time.Duration
+	UserAgent      string
+	MaxRedirects   int
+	TLSExpiryWarn  time.Duration
 	ShutdownGrace  time.Duration
+	AuditLog       bool
+	DoHURL         string
+	DNSCacheTTL    time.Duration
+	IPVersion      string
+	WWW            string
+	StripParams    string
+	Blocklist      string
+	BlocklistFile  string
+	BlockPrivate   bool
+	InstanceID     string
+	TrendWindow    int
+	ResultCache    bool
+
+	ResultRetention time.Duration
+	IdempotencyTTL  time.Duration
+
+	MaxTargets int
+
+	CheckTimeout time.Duration
+
+	RetryStatusCodes string
+
+	ProxyURL string
+
+	PerHostConcurrency int
+	ResultBatchSize    int
+
+	MaxIdleConns        int
+	MaxIdleConnsPerHost int
+	IdleConnTimeout     time.Duration
+
+	BreakerThreshold int
+	BreakerCooldown  time.Duration
+
+	RequestTimeout time.Duration
+	MaxBodyBytes   int64
+
+	APIKeys string
+
+	CredentialsKey string
+
+	CreateRateLimit float64
+	CreateRateBurst int
+
+	Compress bool
+
+	Debug bool
+
+	SchedulerMode     bool
+	WorkerMode        bool
+	QueuePollInterval time.Duration
+	QueueLease        time.Duration
+
+	TraceFile       string
+	TraceSampleRate float64
+	TraceMaxBytes   int64
+
+	LogLevel  string
+	LogFormat string
 }
 
 func Load() *Config {
@@ -21,9 +87,83 @@ func Load() *Config {
 		DatabaseURL:    getEnv("DATABASE_URL", ""),
 		CheckInterval:  getDuration("CHECK_INTERVAL", 15*time.Second),
 		MaxConcurrency: getInt("MAX_CONCURRENCY", 8),
+		CheckBudget:    getInt("CHECK_BUDGET", 0),
+		SpreadChecks:   getBool("SPREAD_CHECKS", false),
 		HTTPTimeout:    getDuration("HTTP_TIMEOUT", 5*time.Second),
+		MaxRetries:     getInt("MAX_RETRIES", 2),
+		BackoffBase:    getDuration("BACKOFF_BASE", 200*time.Millisecond),
+		UserAgent:      getEnv("USER_AGENT", "Linkwatch/1.0"),
+		MaxRedirects:   getInt("MAX_REDIRECTS", 5),
+		TLSExpiryWarn:  getDuration("TLS_EXPIRY_WARNING", 14*24*time.Hour),
 		ShutdownGrace:  getDuration("SHUTDOWN_GRACE", 10*time.Second),
+		AuditLog:       getBool("AUDIT_LOG", false),
+		DoHURL:         getEnv("DOH_URL", ""),
+		DNSCacheTTL:    getDuration("DNS_CACHE_TTL", 30*time.Second),
+		IPVersion:      getEnv("IP_VERSION", "auto"),
+		WWW:            getEnv("WWW_NORMALIZATION", "keep"),
+		StripParams:    getEnv("STRIP_QUERY_PARAMS", "default"),
+		Blocklist:      getEnv("BLOCKLIST", ""),
+		BlocklistFile:  getEnv("BLOCKLIST_FILE", ""),
+		BlockPrivate:   getBool("BLOCK_PRIVATE_IPS", false),
+		InstanceID:     getEnv("INSTANCE_ID", hostname()),
+		TrendWindow:    getInt("TREND_WINDOW", 10),
+		ResultCache:    getBool("RESULT_CACHE", false),
+
+		ResultRetention: getDuration("RESULT_RETENTION", 0),
+		IdempotencyTTL:  getDuration("IDEMPOTENCY_TTL", 24*time.Hour),
+
+		MaxTargets: getInt("MAX_TARGETS", 0),
+
+		CheckTimeout: getDuration("CHECK_TIMEOUT", 0),
+
+		RetryStatusCodes: getEnv("RETRY_STATUS_CODES", ""),
+
+		ProxyURL: getEnv("PROXY_URL", ""),
+
+		PerHostConcurrency: getInt("PER_HOST_CONCURRENCY", 1),
+		ResultBatchSize:    getInt("RESULT_BATCH_SIZE", 0),
+
+		MaxIdleConns:        getInt("MAX_IDLE_CONNS", 100),
+		MaxIdleConnsPerHost: getInt("MAX_IDLE_CONNS_PER_HOST", 10),
+		IdleConnTimeout:     getDuration("IDLE_CONN_TIMEOUT", 30*time.Second),
+
+		BreakerThreshold: getInt("CIRCUIT_BREAKER_THRESHOLD", 5),
+		BreakerCooldown:  getDuration("CIRCUIT_BREAKER_COOLDOWN", time.Minute),
+
+		RequestTimeout: getDuration("REQUEST_TIMEOUT", 30*time.Second),
+		MaxBodyBytes:   int64(getInt("MAX_BODY_BYTES", 1024*1024)),
+
+		APIKeys: getEnv("API_KEYS", ""),
+
+		CredentialsKey: getEnv("CREDENTIALS_KEY", ""),
+
+		CreateRateLimit: getFloat("CREATE_RATE_LIMIT", 0),
+		CreateRateBurst: getInt("CREATE_RATE_BURST", 10),
+
+		Compress: getBool("GZIP_RESPONSES", true),
+
+		Debug: getBool("DEBUG_ENDPOINTS", false),
+
+		SchedulerMode:     getBool("SCHEDULER_MODE", false),
+		WorkerMode:        getBool("WORKER_MODE", false),
+		QueuePollInterval: getDuration("QUEUE_POLL_INTERVAL", time.Second),
+		QueueLease:        getDuration("QUEUE_LEASE", time.Minute),
+
+		TraceFile:       getEnv("TRACE_FILE", ""),
+		TraceSampleRate: getFloat("TRACE_SAMPLE_RATE", 0.01),
+		TraceMaxBytes:   int64(getInt("TRACE_MAX_BYTES", 10*1024*1024)),
+
+		LogLevel:  getEnv("LOG_LEVEL", "info"),
+		LogFormat: getEnv("LOG_FORMAT", "json"),
+	}
+}
+
+func hostname() string {
+	name, err := os.Hostname()
+	if err != nil {
+		return ""
 	}
+	return name
 }
 
 func getEnv(key, defaultValue string) string {
@@ -42,6 +182,24 @@ func getInt(key string, defaultValue int) int {
 	return defaultValue
 }
 
+func getFloat(key string, defaultValue float64) float64 {
+	if str := os.Getenv(key); str != "" {
+		if value, err := strconv.ParseFloat(str, 64); err == nil {
+			return value
+		}
+	}
+	return defaultValue
+}
+
+func getBool(key string, defaultValue bool) bool {
+	if str := os.Getenv(key); str != "" {
+		if value, err := strconv.ParseBool(str); err == nil {
+			return value
+		}
+	}
+	return defaultValue
+}
+
 func getDuration(key string, defaultValue time.Duration) time.Duration {
 	if str := os.Getenv(key); str != "" {
 		if value, err := time.ParseDuration(str); err == nil {
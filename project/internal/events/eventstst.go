@@ -0,0 +1,78 @@
+package events
+
+import (
+	"testing"
+
+	"github.com/aarushishahhh/linkwatch/project/internal/models"
+)
+
+func TestHubPublish(t *testing.T) {
+	hub := NewHub()
+	a := hub.Subscribe()
+	b := hub.Subscribe()
+	defer b.Close()
+
+	hub.Publish(models.CheckEvent{TargetID: "t_1"})
+	for _, sub := range []*Subscription{a, b} {
+		select {
+		case event := <-sub.Events():
+			if event.TargetID != "t_1" {
+				t.Errorf("expected t_1, got %q", event.TargetID)
+			}
+		default:
+			t.Fatal("expected every subscriber to get the event")
+		}
+	}
+
+	// A closed subscription gets nothing more, and closing twice is fine
+	a.Close()
+	a.Close()
+	hub.Publish(models.CheckEvent{TargetID: "t_2"})
+	if _, ok := <-a.Events(); ok {
+		t.Error("expected a closed subscription's channel to be closed")
+	}
+	if event := <-b.Events(); event.TargetID != "t_2" {
+		t.Errorf("expected t_2, got %q", event.TargetID)
+	}
+}
+
+func TestHubSlowSubscriber(t *testing.T) {
+	hub := NewHub()
+	sub := hub.Subscribe()
+	defer sub.Close()
+
+	// Publishing to a subscriber that never reads must not block
+	for i := 0; i < subscriberBuffer+10; i++ {
+		hub.Publish(models.CheckEvent{TargetID: "t_1"})
+	}
+
+	if dropped := sub.Dropped(); dropped != 10 {
+		t.Errorf("expected 10 dropped events, got %d", dropped)
+	}
+	if dropped := sub.Dropped(); dropped != 0 {
+		t.Errorf("expected the dropped count to reset, got %d", dropped)
+	}
+	if len(sub.Events()) != subscriberBuffer {
+		t.Errorf("expected a full buffer of %d, got %d", subscriberBuffer, len(sub.Events()))
+	}
+}
+
+func TestHubClose(t *testing.T) {
+	hub := NewHub()
+	sub := hub.Subscribe()
+
+	hub.Close()
+	if _, ok := <-sub.Events(); ok {
+		t.Error("expected closing the hub to end subscriptions")
+	}
+	sub.Close()
+
+	late := hub.Subscribe()
+	if _, ok := <-late.Events(); ok {
+		t.Error("expected subscribing to a closed hub to end immediately")
+	}
+
+	// A nil hub publishes nothing
+	var nilHub *Hub
+	nilHub.Publish(models.CheckEvent{})
+}
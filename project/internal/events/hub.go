@@ -0,0 +1,98 @@
+package events
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/aarushishahhh/linkwatch/project/internal/models"
+)
+
+// subscriberBuffer is how many events a subscriber may fall behind by before
+// it starts missing them
+const subscriberBuffer = 256
+
+// Hub fans saved check results out to subscribers such as event stream
+// clients. Publishing never blocks: a subscriber whose buffer is full misses
+// the event, so one stuck client can't hold up the checker. A nil Hub
+// publishes nothing.
+type Hub struct {
+	mu     sync.Mutex
+	subs   map[*Subscription]struct{}
+	closed bool
+}
+
+func NewHub() *Hub {
+	return &Hub{subs: make(map[*Subscription]struct{})}
+}
+
+// Subscription receives the events published after it was made.
+type Subscription struct {
+	hub     *Hub
+	events  chan models.CheckEvent
+	dropped atomic.Int64
+}
+
+// Subscribe registers a new subscriber. Its channel is closed by Close, or
+// straight away once the hub is closed.
+func (h *Hub) Subscribe() *Subscription {
+	s := &Subscription{hub: h, events: make(chan models.CheckEvent, subscriberBuffer)}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		close(s.events)
+		return s
+	}
+	h.subs[s] = struct{}{}
+	return s
+}
+
+// Publish hands event to every subscriber with room for it.
+func (h *Hub) Publish(event models.CheckEvent) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for s := range h.subs {
+		select {
+		case s.events <- event:
+		default:
+			s.dropped.Add(1)
+		}
+	}
+}
+
+// Close ends every subscription, e.g. so long-lived streams let a server
+// shut down. Later subscriptions end immediately.
+func (h *Hub) Close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for s := range h.subs {
+		delete(h.subs, s)
+		close(s.events)
+	}
+	h.closed = true
+}
+
+// Events returns the subscriber's channel.
+func (s *Subscription) Events() <-chan models.CheckEvent {
+	return s.events
+}
+
+// Dropped returns how many events were missed because the buffer was full
+// since the last call.
+func (s *Subscription) Dropped() int64 {
+	return s.dropped.Swap(0)
+}
+
+// Close unsubscribes and closes the channel. It is safe to call more than
+// once and after the hub is closed.
+func (s *Subscription) Close() {
+	s.hub.mu.Lock()
+	defer s.hub.mu.Unlock()
+	if _, ok := s.hub.subs[s]; ok {
+		delete(s.hub.subs, s)
+		close(s.events)
+	}
+}
@@ -3,16 +3,20 @@ package main
 import (
 	"context"
 	"database/sql"
+	"fmt"
 	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	_ "time"
 
 	"github.com/aarushishahhh/linkwatch/project/internal/api"
 	"github.com/aarushishahhh/linkwatch/project/internal/checker"
 	"github.com/aarushishahhh/linkwatch/project/internal/config"
+	"github.com/aarushishahhh/linkwatch/project/internal/events"
+	"github.com/aarushishahhh/linkwatch/project/internal/policy"
 	"github.com/aarushishahhh/linkwatch/project/internal/storage"
 
 	_ "github.com/lib/pq"
@@ -22,7 +26,11 @@ import (
 func main() {
 	cfg := config.Load()
 
-	logger := slog.New(slog.NewJSONHandler(os.Stdout, nil))
+	logger, err := newLogger(cfg.LogLevel, cfg.LogFormat)
+	if err != nil {
+		slog.Error("invalid configuration", "error", err)
+		os.Exit(1)
+	}
 	slog.SetDefault(logger)
 
 	// Initialize database
@@ -38,20 +46,150 @@ func main() {
 		slog.Error("failed to run migrations", "error", err)
 		os.Exit(1)
 	}
+	store.SetAuditLog(cfg.AuditLog)
+	store.SetIdempotencyTTL(cfg.IdempotencyTTL)
+	store.SetMaxTargets(cfg.MaxTargets)
+
+	credentialsKey, err := storage.ParseCredentialsKey(cfg.CredentialsKey)
+	if err == nil {
+		err = store.SetCredentialsKey(credentialsKey)
+	}
+	if err != nil {
+		slog.Error("invalid configuration", "error", err)
+		os.Exit(1)
+	}
+
+	blocklist, err := policy.LoadBlocklist(cfg.Blocklist, cfg.BlocklistFile)
+	if err != nil {
+		slog.Error("failed to load blocklist", "error", err)
+		os.Exit(1)
+	}
+
+	var resultCache *storage.ResultCache
+	if cfg.ResultCache {
+		resultCache = storage.NewResultCache()
+	}
+
+	// Publishing costs nothing until a client subscribes to /v1/events
+	eventHub := events.NewHub()
+
+	if cfg.MaxRetries < 0 {
+		slog.Error("invalid configuration", "error", "MAX_RETRIES must not be negative")
+		os.Exit(1)
+	}
+
+	proxyURL, err := checker.ParseProxyURL(cfg.ProxyURL)
+	if err != nil {
+		slog.Error("invalid configuration", "error", err)
+		os.Exit(1)
+	}
+	if proxyURL != nil && cfg.BlockPrivate {
+		// The checker only dials the proxy, so it can't refuse private
+		// addresses; hosts are still vetted when targets are registered
+		slog.Warn("BLOCK_PRIVATE_IPS can't guard connections through PROXY_URL: redirects to private addresses and DNS rebinding aren't blocked",
+			"proxy", proxyURL.Redacted())
+	}
+
+	ipVersion, err := checker.ParseIPVersion(cfg.IPVersion)
+	if err != nil {
+		slog.Error("invalid configuration", "error", fmt.Errorf("IP_VERSION: %w", err))
+		os.Exit(1)
+	}
+
+	var retryStatusCodes policy.StatusRanges
+	if cfg.RetryStatusCodes != "" {
+		if retryStatusCodes, err = policy.ParseStatusRanges(cfg.RetryStatusCodes); err != nil {
+			slog.Error("invalid configuration", "error", fmt.Errorf("RETRY_STATUS_CODES: %w", err))
+			os.Exit(1)
+		}
+	}
 
 	// Initialize checker
 	chk := checker.New(store, checker.Config{
 		Interval:       cfg.CheckInterval,
 		MaxConcurrency: cfg.MaxConcurrency,
+		CheckBudget:    cfg.CheckBudget,
+		SpreadChecks:   cfg.SpreadChecks,
 		HTTPTimeout:    cfg.HTTPTimeout,
+		MaxRetries:     cfg.MaxRetries,
+		BackoffBase:    cfg.BackoffBase,
+		UserAgent:      cfg.UserAgent,
+		MaxRedirects:   cfg.MaxRedirects,
+		TLSExpiryWarn:  cfg.TLSExpiryWarn,
+		DoHURL:         cfg.DoHURL,
+		DNSCacheTTL:    cfg.DNSCacheTTL,
+		IPVersion:      ipVersion,
+		Blocklist:      blocklist,
+		BlockPrivate:   cfg.BlockPrivate,
+		InstanceID:     cfg.InstanceID,
+		ResultCache:    resultCache,
+		Events:         eventHub,
+
+		ResultRetention: cfg.ResultRetention,
+		IdempotencyTTL:  cfg.IdempotencyTTL,
+
+		CheckTimeout: cfg.CheckTimeout,
+
+		RetryStatusCodes: retryStatusCodes,
+
+		ProxyURL: proxyURL,
+
+		PerHostConcurrency: cfg.PerHostConcurrency,
+		ResultBatchSize:    cfg.ResultBatchSize,
+
+		MaxIdleConns:        cfg.MaxIdleConns,
+		MaxIdleConnsPerHost: cfg.MaxIdleConnsPerHost,
+		IdleConnTimeout:     cfg.IdleConnTimeout,
+
+		BreakerThreshold: cfg.BreakerThreshold,
+		BreakerCooldown:  cfg.BreakerCooldown,
+
+		SchedulerMode:     cfg.SchedulerMode,
+		WorkerMode:        cfg.WorkerMode,
+		QueuePollInterval: cfg.QueuePollInterval,
+		QueueLease:        cfg.QueueLease,
+
+		TraceFile:       cfg.TraceFile,
+		TraceSampleRate: cfg.TraceSampleRate,
+		TraceMaxBytes:   cfg.TraceMaxBytes,
 	})
 
+	wwwMode, err := storage.ParseWWWMode(cfg.WWW)
+	if err != nil {
+		slog.Error("invalid configuration", "error", err)
+		os.Exit(1)
+	}
+
 	// Initialize API server
 	server := &http.Server{
-		Addr:    ":" + cfg.Port,
-		Handler: api.NewRouter(store),
+		Addr: ":" + cfg.Port,
+		Handler: api.NewRouterWithConfig(store, api.Config{
+			WWW:          wwwMode,
+			StripParams:  storage.ParseStripParams(cfg.StripParams),
+			Blocklist:    blocklist,
+			BlockPrivate: cfg.BlockPrivate,
+			TrendWindow:  cfg.TrendWindow,
+			ResultCache:  resultCache,
+			Events:       eventHub,
+			Checker:      chk,
+
+			RequestTimeout: cfg.RequestTimeout,
+			MaxBodyBytes:   cfg.MaxBodyBytes,
+
+			APIKeys: api.ParseAPIKeys(cfg.APIKeys),
+
+			CreateRateLimit: cfg.CreateRateLimit,
+			CreateRateBurst: cfg.CreateRateBurst,
+
+			Compress: cfg.Compress,
+
+			Debug: cfg.Debug,
+		}),
 	}
 
+	// Event streams never finish on their own, so end them when shutting down
+	server.RegisterOnShutdown(eventHub.Close)
+
 	// Start background checker
 	ctx, cancel := context.WithCancel(context.Background())
 	chk.Start(ctx)
@@ -70,19 +208,45 @@ func main() {
 	<-sigChan
 
 	slog.Info("shutting down gracefully")
-	cancel() // Stop checker
-
-	// Shutdown HTTP server
 	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), cfg.ShutdownGrace)
 	defer shutdownCancel()
 
+	// Let in-flight checks finish and save while the server drains requests.
+	// Stop returns only after buffered results are flushed, so they are
+	// saved before the database is closed
+	checkerStopped := make(chan error, 1)
+	go func() { checkerStopped <- chk.Stop(shutdownCtx) }()
+
 	if err := server.Shutdown(shutdownCtx); err != nil {
 		slog.Error("server shutdown failed", "error", err)
 	}
+	if err := <-checkerStopped; err != nil {
+		slog.Error("checker did not drain in time, in-flight checks cancelled", "error", err)
+	}
+	cancel()
 
 	slog.Info("shutdown complete")
 }
 
+// newLogger returns a logger writing to stdout at level (debug, info, warn
+// or error) in format (json or text).
+func newLogger(level, format string) (*slog.Logger, error) {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		return nil, fmt.Errorf("invalid LOG_LEVEL %q, expected debug, info, warn or error", level)
+	}
+
+	opts := &slog.HandlerOptions{Level: lvl}
+	switch strings.ToLower(format) {
+	case "json":
+		return slog.New(slog.NewJSONHandler(os.Stdout, opts)), nil
+	case "text":
+		return slog.New(slog.NewTextHandler(os.Stdout, opts)), nil
+	default:
+		return nil, fmt.Errorf("invalid LOG_FORMAT %q, expected json or text", format)
+	}
+}
+
 func initDB(databaseURL string) (*sql.DB, error) {
 	if databaseURL == "" {
 		// Supporting SQLite
@@ -90,16 +254,37 @@ func initDB(databaseURL string) (*sql.DB, error) {
 	}
 
 	var driver, dsn string
-	if databaseURL[:9] == "sqlite3://" {
+	if strings.HasPrefix(databaseURL, "sqlite3://") {
 		driver = "sqlite3"
-		dsn = databaseURL[9:]
-	} else if databaseURL[:11] == "postgres://" || databaseURL[:13] == "postgresql://" {
+		dsn = sqliteDSN(strings.TrimPrefix(databaseURL, "sqlite3://"))
+	} else if strings.HasPrefix(databaseURL, "postgres://") || strings.HasPrefix(databaseURL, "postgresql://") {
 		driver = "postgres"
 		dsn = databaseURL
 	} else {
 		driver = "sqlite3"
-		dsn = databaseURL
+		dsn = sqliteDSN(databaseURL)
 	}
 
 	return sql.Open(driver, dsn)
 }
+
+// sqliteDSN enables WAL mode and a busy timeout unless the DSN already sets
+// them, so concurrent result writes wait for the lock instead of failing.
+func sqliteDSN(dsn string) string {
+	params := []string{}
+	if !strings.Contains(dsn, "_journal_mode=") && !strings.Contains(dsn, "_journal=") {
+		params = append(params, "_journal_mode=WAL")
+	}
+	if !strings.Contains(dsn, "_busy_timeout=") && !strings.Contains(dsn, "_timeout=") {
+		params = append(params, "_busy_timeout=5000")
+	}
+	if len(params) == 0 {
+		return dsn
+	}
+
+	separator := "?"
+	if strings.Contains(dsn, "?") {
+		separator = "&"
+	}
+	return dsn + separator + strings.Join(params, "&")
+}
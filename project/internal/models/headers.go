@@ -0,0 +1,53 @@
+package models
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// Headers are extra request headers sent with a target's checks.
+//
+// Values of headers that look like credentials are redacted whenever Headers
+// is marshalled to JSON, so they never leak through API responses or logs.
+// Storage must encode the plain map to keep the real values.
+type Headers map[string]string
+
+// Redacted replaces the value of a sensitive header in JSON output.
+const Redacted = "[REDACTED]"
+
+// sensitiveHeaderParts mark a header name as carrying a credential
+var sensitiveHeaderParts = []string{"auth", "cookie", "token", "secret", "key", "password", "session"}
+
+// IsSensitiveHeader reports whether the header name suggests a credential.
+func IsSensitiveHeader(name string) bool {
+	name = strings.ToLower(name)
+	for _, part := range sensitiveHeaderParts {
+		if strings.Contains(name, part) {
+			return true
+		}
+	}
+	return false
+}
+
+func (h Headers) MarshalJSON() ([]byte, error) {
+	if h == nil {
+		return []byte("null"), nil
+	}
+	return json.Marshal(map[string]string(h.Redact()))
+}
+
+// Redact returns a copy of h with the values of sensitive headers replaced
+// by Redacted, for output that doesn't go through MarshalJSON.
+func (h Headers) Redact() Headers {
+	if h == nil {
+		return nil
+	}
+	redacted := make(Headers, len(h))
+	for name, value := range h {
+		if IsSensitiveHeader(name) {
+			value = Redacted
+		}
+		redacted[name] = value
+	}
+	return redacted
+}
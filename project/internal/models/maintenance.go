@@ -0,0 +1,99 @@
+package models
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// MaintenanceWindow is a recurring period, in UTC, during which a target
+// isn't checked and doesn't alert. Start and End are "HH:MM"; a window
+// whose End is before its Start runs past midnight. Days limits the window
+// to the weekdays it starts on ("mon" to "sun"); every day when empty.
+type MaintenanceWindow struct {
+	Start string   `json:"start"`
+	End   string   `json:"end"`
+	Days  []string `json:"days,omitempty"`
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// Validate reports whether the window's times and days are well formed.
+func (w MaintenanceWindow) Validate() error {
+	start, err := minuteOfDay(w.Start)
+	if err != nil {
+		return fmt.Errorf("start: %w", err)
+	}
+	end, err := minuteOfDay(w.End)
+	if err != nil {
+		return fmt.Errorf("end: %w", err)
+	}
+	if start == end {
+		return fmt.Errorf("start and end must differ")
+	}
+	for _, day := range w.Days {
+		if _, ok := weekdays[strings.ToLower(day)]; !ok {
+			return fmt.Errorf("invalid day %q, expected one of mon, tue, wed, thu, fri, sat or sun", day)
+		}
+	}
+	return nil
+}
+
+// Contains reports whether t falls inside the window. Invalid windows
+// contain nothing.
+func (w MaintenanceWindow) Contains(t time.Time) bool {
+	start, err := minuteOfDay(w.Start)
+	if err != nil {
+		return false
+	}
+	end, err := minuteOfDay(w.End)
+	if err != nil {
+		return false
+	}
+
+	t = t.UTC()
+	minute := t.Hour()*60 + t.Minute()
+	if start < end {
+		return minute >= start && minute < end && w.onDay(t.Weekday())
+	}
+	// Past midnight: the early part belongs to the previous day's window
+	if minute >= start {
+		return w.onDay(t.Weekday())
+	}
+	return minute < end && w.onDay((t.Weekday()+6)%7)
+}
+
+func (w MaintenanceWindow) onDay(day time.Weekday) bool {
+	if len(w.Days) == 0 {
+		return true
+	}
+	for _, d := range w.Days {
+		if weekday, ok := weekdays[strings.ToLower(d)]; ok && weekday == day {
+			return true
+		}
+	}
+	return false
+}
+
+// InMaintenance reports whether t falls inside any of the target's
+// maintenance windows.
+func (t Target) InMaintenance(at time.Time) bool {
+	for _, window := range t.MaintenanceWindows {
+		if window.Contains(at) {
+			return true
+		}
+	}
+	return false
+}
+
+// minuteOfDay parses an "HH:MM" time of day into minutes since midnight.
+func minuteOfDay(s string) (int, error) {
+	parsed, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q, expected HH:MM", s)
+	}
+	return parsed.Hour()*60 + parsed.Minute(), nil
+}
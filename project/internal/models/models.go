@@ -6,30 +6,442 @@ type Target struct {
 	ID        string    `json:"id"`
 	URL       string    `json:"url"`
 	CreatedAt time.Time `json:"created_at"`
+	DependsOn *string   `json:"depends_on,omitempty"`
+
+	// SuccessStatus lists the status codes that count as healthy, e.g.
+	// "200-299,418". Nil means the default 2xx/3xx rule.
+	SuccessStatus *string `json:"success_status,omitempty"`
+
+	// TimeoutMs bounds the whole check, retries included. Nil means
+	// HTTP_TIMEOUT per attempt only.
+	TimeoutMs *int `json:"timeout_ms,omitempty"`
+
+	// ProfileID names a profile whose settings fill in any left unset here
+	ProfileID *string `json:"profile_id,omitempty"`
+
+	// Paused targets stay registered but are skipped by the checker
+	Paused bool `json:"paused"`
+
+	// CheckInterval overrides the global check interval, as a Go duration
+	// string such as "30s". Nil means the global interval.
+	CheckInterval *string `json:"check_interval,omitempty"`
+
+	// LastCheckedAt, LastStatusCode and LastError summarize the latest check
+	// result; all null for targets never checked
+	LastCheckedAt  *time.Time `json:"last_checked_at"`
+	LastStatusCode *int       `json:"last_status_code"`
+	LastError      *string    `json:"last_error"`
+
+	// ConsecutiveFailures counts the target's latest checks that weren't up
+	// (an error, or a status not accepted by the target's success_status,
+	// 2xx/3xx by default); zero after a check that was
+	ConsecutiveFailures int `json:"consecutive_failures"`
+
+	// Headers are sent with every check, e.g. an Authorization header
+	Headers Headers `json:"headers,omitempty"`
+
+	// Username and Password are sent as HTTP basic auth with every check.
+	// Password is in its stored form, which Storage.OpenSecret opens.
+	Username *string `json:"username,omitempty"`
+	Password *Secret `json:"password,omitempty"`
+
+	// ExpectedBody and BodyRegex assert on the response body; a check whose
+	// body doesn't contain the substring or match the regex fails even on 200
+	ExpectedBody *string `json:"expected_body,omitempty"`
+	BodyRegex    *string `json:"body_regex,omitempty"`
+
+	// MaintenanceWindows are recurring periods during which the target
+	// isn't checked and its transitions don't alert
+	MaintenanceWindows []MaintenanceWindow `json:"maintenance_windows,omitempty"`
+
+	// IPVersion restricts checks to one address family, one of the
+	// IPVersion constants; nil uses the checker's default
+	IPVersion *string `json:"ip_version,omitempty"`
+
+	// ETag and LastModified are the validators from the last successful
+	// check, sent back as If-None-Match and If-Modified-Since
+	ETag         *string `json:"-"`
+	LastModified *string `json:"-"`
+
+	// LastCheck is the latest result, only filled with ?include=last_check
+	LastCheck *CheckResult `json:"last_check,omitempty"`
+}
+
+// TargetSettings holds the optional per-target settings accepted at creation.
+type TargetSettings struct {
+	DependsOn     *string
+	SuccessStatus *string
+	TimeoutMs     *int
+	ProfileID     *string
+	CheckInterval *string
+	Headers       Headers
+	Username      *string
+	Password      *Secret
+	ExpectedBody  *string
+	BodyRegex     *string
+
+	MaintenanceWindows []MaintenanceWindow
+	IPVersion          *string
+}
+
+// UpdateTargetRequest is a partial update; nil fields are left unchanged.
+type UpdateTargetRequest struct {
+	Paused *bool `json:"paused"`
+}
+
+// Profile is a named bundle of check settings shared by many targets.
+// Targets inherit each setting they don't set themselves.
+type Profile struct {
+	ID            string    `json:"id"`
+	Name          string    `json:"name"`
+	SuccessStatus *string   `json:"success_status,omitempty"`
+	TimeoutMs     *int      `json:"timeout_ms,omitempty"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+type ProfileRequest struct {
+	Name          string  `json:"name"`
+	SuccessStatus *string `json:"success_status"`
+	TimeoutMs     *int    `json:"timeout_ms"`
 }
 
+type ProfileList struct {
+	Items []Profile `json:"items"`
+}
+
+// TargetFilter narrows a target listing by host. Hosts compare as they
+// appear in canonical URLs, without the port. Nil fields are not applied.
+type TargetFilter struct {
+	Host       *string // Exactly this host
+	HostSuffix *string // This host or any of its subdomains
+}
+
+// TargetSort orders a target listing.
+type TargetSort struct {
+	Field string // One of the Sort constants
+	Desc  bool
+}
+
+// Fields targets can be sorted by
+const (
+	SortCreatedAt = "created_at"
+	SortURL       = "url"
+)
+
 type TargetList struct {
 	Items         []Target `json:"items"`
 	NextPageToken string   `json:"next_page_token,omitempty"`
+	HasMore       bool     `json:"has_more"`
+	TotalCount    *int     `json:"total_count,omitempty"` // Only with ?include_total=true
 }
 
 type CheckResult struct {
+	// ID identifies a saved result. It is 0, and omitted, on results that
+	// weren't read back from the database: dry runs, streamed events and
+	// cached summaries.
+	ID int64 `json:"id,omitempty"`
+
 	CheckedAt  time.Time `json:"checked_at"`
 	StatusCode *int      `json:"status_code"`
 	LatencyMs  int       `json:"latency_ms"`
 	Error      *string   `json:"error"`
+
+	// LatencyUs is the same measurement in microseconds, so sub-millisecond
+	// checks aren't all recorded as 0. Nil for results saved before it existed.
+	LatencyUs *int64 `json:"latency_us,omitempty"`
+
+	// FinalURL is where the check landed after following redirects; equal to
+	// the target URL when there was no redirect, nil if no response arrived
+	FinalURL *string `json:"final_url,omitempty"`
+
+	// RedirectCount is how many redirects were followed to reach FinalURL;
+	// 0 for a direct response, nil if no response arrived
+	RedirectCount *int `json:"redirect_count,omitempty"`
+
+	// ContentType and ContentLength are the final response's headers, nil
+	// when it didn't send them. BodyBytes is how much of the body was read
+	// (after decompression, up to the checker's read cap), so pages without
+	// a Content-Length still show their size. All nil if no response arrived.
+	ContentType   *string `json:"content_type,omitempty"`
+	ContentLength *int64  `json:"content_length,omitempty"`
+	BodyBytes     *int64  `json:"body_bytes,omitempty"`
+
+	// TLSExpiresAt is the NotAfter of the leaf certificate presented by the
+	// final response; nil for plain HTTP or when no response arrived.
+	// TLSExpiring flags it as within the checker's expiry warning threshold.
+	TLSExpiresAt *time.Time `json:"tls_expires_at,omitempty"`
+	TLSExpiring  bool       `json:"tls_expiring,omitempty"`
+
+	// IPVersion is the address family of the connection the final request
+	// went over, "v4" or "v6"; the proxy's when checks are proxied. Nil if
+	// no connection was made.
+	IPVersion *string `json:"ip_version,omitempty"`
+
+	// Phase timings of the final request, in milliseconds: DNS lookup, TCP
+	// connect, TLS handshake, and time to first byte from asking for a
+	// connection. A phase that didn't happen (e.g. on a reused connection) is
+	// nil. LatencyMs remains the total including retries.
+	DNSMs     *int `json:"dns_ms,omitempty"`
+	ConnectMs *int `json:"connect_ms,omitempty"`
+	TLSMs     *int `json:"tls_ms,omitempty"`
+	TTFBMs    *int `json:"ttfb_ms,omitempty"`
+
+	// NotModified marks a 304 answer to a conditional request: the page is
+	// unchanged since the last check, which counts as up
+	NotModified bool `json:"not_modified,omitempty"`
+
+	// ETag and LastModified are the validators to keep for the target's next
+	// check; they aren't stored with the result
+	ETag         *string `json:"-"`
+	LastModified *string `json:"-"`
+
+	// SuppressedBy is set when the check failed while the target's dependency
+	// was down; the failure is attributed to that dependency.
+	SuppressedBy *string `json:"suppressed_by,omitempty"`
+
+	// InstanceID identifies the checker instance that produced the result
+	InstanceID string `json:"instance_id,omitempty"`
 }
 
 type CheckResultList struct {
-	Items []CheckResult `json:"items"`
+	Items         []CheckResult `json:"items"`
+	Trend         string        `json:"trend,omitempty"`
+	NextPageToken string        `json:"next_page_token,omitempty"`
 }
 
-type CreateTargetRequest struct {
+// CheckEvent is a saved check result as pushed to event stream clients.
+type CheckEvent struct {
+	TargetID string      `json:"target_id"`
+	Result   CheckResult `json:"result"`
+}
+
+// CheckerStatus is a snapshot of the checker's scheduling state, for
+// diagnosing stalled checks. A cycle is one pass of the scheduler over the
+// due targets, which it checks itself or, in queue mode, enqueues.
+type CheckerStatus struct {
+	CycleRunning         bool       `json:"cycle_running"`
+	CycleStartedAt       *time.Time `json:"cycle_started_at"`
+	LastCycleCompletedAt *time.Time `json:"last_cycle_completed_at"`
+	LastCycleTargets     int        `json:"last_cycle_targets"`
+	BudgetExhausted      bool       `json:"budget_exhausted"`
+	EffectiveInterval    *string    `json:"effective_interval"` // Each target's cadence under the budget; null unless exhausted
+	Paused               bool       `json:"paused"`
+	HostSemaphores       int        `json:"host_semaphores"` // Hosts checked since startup
+	ActiveHosts          int        `json:"active_hosts"`    // Hosts with a check holding their semaphore
+	Stopping             bool       `json:"stopping"`
+}
+
+// PauseResponse reports whether checks are paused after a pause or resume.
+type PauseResponse struct {
+	Paused bool `json:"paused"`
+}
+
+// DeleteResultsResponse reports how many check results a delete removed.
+type DeleteResultsResponse struct {
+	Deleted int64 `json:"deleted"`
+}
+
+// CheckStats summarizes a target's checks over a window. The uptime,
+// latency and stability fields are nil when there were no checks in the
+// window.
+type CheckStats struct {
+	TargetID      string    `json:"target_id"`
+	Since         time.Time `json:"since"`
+	TotalChecks   int       `json:"total_checks"`
+	UptimePercent *float64  `json:"uptime_percent"`
+	AvgLatencyMs  *float64  `json:"avg_latency_ms"`
+	P50LatencyMs  *int      `json:"p50_latency_ms"`
+	P95LatencyMs  *int      `json:"p95_latency_ms"`
+
+	// FlapCount is how many times consecutive checks changed between up and
+	// down. StabilityPercent is the share of consecutive pairs that didn't,
+	// so a target that is steadily down scores 100 and one flapping every
+	// check scores 0.
+	FlapCount        int      `json:"flap_count"`
+	StabilityPercent *float64 `json:"stability_percent"`
+
+	// ConsecutiveFailures is the target's current failure streak, which
+	// may extend back past the window
+	ConsecutiveFailures int `json:"consecutive_failures"`
+}
+
+// HostLatencyStats summarizes the checks of every target on a host over a
+// window, to tell a degrading provider from a single slow target.
+type HostLatencyStats struct {
+	Host         string  `json:"host"`
+	TargetCount  int     `json:"target_count"` // Targets with checks in the window
+	TotalChecks  int     `json:"total_checks"`
+	FailedChecks int     `json:"failed_checks"`
+	AvgLatencyMs float64 `json:"avg_latency_ms"`
+	P50LatencyMs int     `json:"p50_latency_ms"`
+	P95LatencyMs int     `json:"p95_latency_ms"`
+	P99LatencyMs int     `json:"p99_latency_ms"`
+}
+
+// HostLatencyStatsList is the latency summary of every host checked since
+// Since, slowest first.
+type HostLatencyStatsList struct {
+	Since time.Time          `json:"since"`
+	Items []HostLatencyStats `json:"items"`
+}
+
+// Address families a check may be restricted to
+const (
+	IPVersionAuto = "auto" // Whatever the host resolves to, IPv4 first
+	IPVersionV4   = "v4"
+	IPVersionV6   = "v6"
+)
+
+// Latency trend classifications
+const (
+	TrendImproving = "improving"
+	TrendStable    = "stable"
+	TrendDegrading = "degrading"
+	TrendUnknown   = "unknown"
+)
+
+// DryRunRequest is a URL to check once without creating a target.
+type DryRunRequest struct {
 	URL string `json:"url"`
 }
 
+type CreateTargetRequest struct {
+	URL           string  `json:"url"`
+	DependsOn     *string `json:"depends_on"`
+	WWW           *string `json:"www"` // Overrides the server's www normalization: keep, strip or add
+	SuccessStatus *string `json:"success_status"`
+	TimeoutMs     *int    `json:"timeout_ms"`
+	ProfileID     *string `json:"profile_id"`
+	CheckInterval *string `json:"check_interval"`
+	Headers       Headers `json:"headers"`
+	Username      *string `json:"username"`
+	Password      *Secret `json:"password"`
+	ExpectedBody  *string `json:"expected_body"`
+	BodyRegex     *string `json:"body_regex"`
+
+	MaintenanceWindows []MaintenanceWindow `json:"maintenance_windows"`
+	IPVersion          *string             `json:"ip_version"`
+}
+
 type CreateTargetResponse struct {
+	ID            string    `json:"id"`
+	URL           string    `json:"url"`
+	CreatedAt     time.Time `json:"created_at"`
+	DependsOn     *string   `json:"depends_on,omitempty"`
+	SuccessStatus *string   `json:"success_status,omitempty"`
+	TimeoutMs     *int      `json:"timeout_ms,omitempty"`
+	ProfileID     *string   `json:"profile_id,omitempty"`
+	CheckInterval *string   `json:"check_interval,omitempty"`
+	Headers       Headers   `json:"headers,omitempty"`
+	Username      *string   `json:"username,omitempty"`
+	Password      *Secret   `json:"password,omitempty"`
+	ExpectedBody  *string   `json:"expected_body,omitempty"`
+	BodyRegex     *string   `json:"body_regex,omitempty"`
+
+	MaintenanceWindows []MaintenanceWindow `json:"maintenance_windows,omitempty"`
+	IPVersion          *string             `json:"ip_version,omitempty"`
+}
+
+type BatchCreateTargetsRequest struct {
+	URLs []string `json:"urls"`
+}
+
+// Outcomes of one URL in a batch create
+const (
+	BatchItemCreated  = "created"
+	BatchItemExisting = "existing"
+	BatchItemFailed   = "error"
+)
+
+// BatchCreateTargetsResponse reports every URL of a batch create in request
+// order. Failed URLs don't stop the others, so callers should check Failed
+// (or each item's status) rather than the HTTP status alone.
+type BatchCreateTargetsResponse struct {
+	Items    []BatchCreateTargetResult `json:"items"`
+	Created  int                       `json:"created"`
+	Existing int                       `json:"existing"`
+	Failed   int                       `json:"failed"`
+}
+
+type BatchCreateTargetResult struct {
+	URL       string     `json:"url"`
+	Status    string     `json:"status"`
+	ID        string     `json:"id,omitempty"`
+	CreatedAt *time.Time `json:"created_at,omitempty"`
+	Error     string     `json:"error,omitempty"`
+	Code      string     `json:"code,omitempty"`
+}
+
+// Readiness reports whether the service can serve requests, with the state
+// of each dependency checked.
+type Readiness struct {
+	Status string            `json:"status"` // "ready" or "unavailable"
+	Checks map[string]string `json:"checks"` // "ok", or what is wrong
+}
+
+type Webhook struct {
 	ID        string    `json:"id"`
 	URL       string    `json:"url"`
+	TargetID  *string   `json:"target_id"`
+	Template  string    `json:"template,omitempty"`
 	CreatedAt time.Time `json:"created_at"`
 }
+
+type CreateWebhookRequest struct {
+	URL      string  `json:"url"`
+	TargetID *string `json:"target_id"`
+	Template string  `json:"template"`
+}
+
+type AuditEntry struct {
+	Seq        int64   `json:"seq"`
+	TargetID   string  `json:"target_id"`
+	CheckedAt  string  `json:"checked_at"`
+	StatusCode *int    `json:"status_code"`
+	LatencyMs  int     `json:"latency_ms"`
+	Error      *string `json:"error"`
+	PrevHash   string  `json:"prev_hash"`
+	Hash       string  `json:"hash"`
+}
+
+type AuditVerification struct {
+	Valid    bool   `json:"valid"`
+	Entries  int    `json:"entries"`
+	BrokenAt *int64 `json:"broken_at,omitempty"`
+}
+
+const (
+	JobStatusPending   = "pending"
+	JobStatusSucceeded = "succeeded"
+	JobStatusFailed    = "failed"
+)
+
+type Job struct {
+	ID          string     `json:"id"`
+	Status      string     `json:"status"`
+	TargetID    *string    `json:"target_id"`
+	Error       *string    `json:"error"`
+	CreatedAt   time.Time  `json:"created_at"`
+	CompletedAt *time.Time `json:"completed_at"`
+}
+
+// ResultFilter narrows the check results returned for a target. Nil fields
+// are not applied.
+type ResultFilter struct {
+	Since        *time.Time
+	MinLatencyMs *int
+	MaxLatencyMs *int
+
+	// Failed keeps only failed results when true and only successful ones
+	// when false
+	Failed *bool
+
+	// PageToken resumes after the last result of a previous page
+	PageToken string
+}
+
+// CheckFilter narrows which targets the checker visits each cycle.
+type CheckFilter struct {
+	ExcludeHosts []string `json:"exclude_hosts"`
+}
@@ -0,0 +1,22 @@
+package models
+
+import (
+	"encoding/json"
+	"log/slog"
+)
+
+// Secret is a credential such as a basic auth password. It prints, logs and
+// marshals to JSON as Redacted, so it can't leak through API responses or
+// logs by accident; convert it to a string for the value.
+type Secret string
+
+func (s Secret) String() string   { return Redacted }
+func (s Secret) GoString() string { return Redacted }
+
+func (s Secret) LogValue() slog.Value {
+	return slog.StringValue(Redacted)
+}
+
+func (s Secret) MarshalJSON() ([]byte, error) {
+	return json.Marshal(Redacted)
+}
@@ -0,0 +1,70 @@
+package policy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"syscall"
+)
+
+// ErrPrivateAddress is returned when a connection or target would reach a
+// private, loopback, link-local or metadata address.
+var ErrPrivateAddress = errors.New("blocked by policy: private address")
+
+// metadataIPs are cloud metadata endpoints outside the private and
+// link-local ranges (169.254.169.254 is covered by link-local).
+var metadataIPs = []net.IP{
+	net.ParseIP("100.100.100.200"), // Alibaba Cloud
+}
+
+// IsPrivateIP reports whether ip is loopback, private (RFC 1918 / RFC 4193),
+// link-local, unspecified or a known metadata endpoint.
+func IsPrivateIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsUnspecified() {
+		return true
+	}
+	for _, metadata := range metadataIPs {
+		if ip.Equal(metadata) {
+			return true
+		}
+	}
+	return false
+}
+
+// PrivateAddress resolves host and returns the first private address it maps
+// to, or nil if it maps to none. IP literals are checked without a lookup.
+func PrivateAddress(ctx context.Context, resolver *net.Resolver, host string) (net.IP, error) {
+	if ip := net.ParseIP(host); ip != nil {
+		if IsPrivateIP(ip) {
+			return ip, nil
+		}
+		return nil, nil
+	}
+
+	addrs, err := resolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	for _, addr := range addrs {
+		if IsPrivateIP(addr.IP) {
+			return addr.IP, nil
+		}
+	}
+	return nil, nil
+}
+
+// DialControl is a net.Dialer Control function that refuses connections to
+// private addresses. It runs on the address actually being dialed, after DNS
+// resolution, so a host can't pass validation and then rebind to one.
+func DialControl(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip != nil && IsPrivateIP(ip) {
+		return fmt.Errorf("%w %s", ErrPrivateAddress, ip)
+	}
+	return nil
+}
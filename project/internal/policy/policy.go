@@ -0,0 +1,86 @@
+package policy
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// MatchHost reports whether host matches any pattern. A pattern is either an
+// exact host or "*.example.com", which matches any subdomain of example.com.
+// "*.zip" therefore matches every host under the .zip TLD.
+func MatchHost(host string, patterns []string) bool {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	for _, pattern := range patterns {
+		pattern = strings.ToLower(pattern)
+		if suffix, ok := strings.CutPrefix(pattern, "*."); ok {
+			if strings.HasSuffix(host, "."+suffix) {
+				return true
+			}
+			continue
+		}
+		if host == pattern {
+			return true
+		}
+	}
+	return false
+}
+
+// Blocklist rejects hosts, domains and TLDs that must never be monitored.
+type Blocklist struct {
+	patterns []string
+}
+
+// NewBlocklist builds a blocklist from host patterns (see MatchHost).
+func NewBlocklist(patterns []string) (*Blocklist, error) {
+	b := &Blocklist{}
+	for _, pattern := range patterns {
+		pattern = strings.ToLower(strings.TrimSpace(pattern))
+		if pattern == "" {
+			continue
+		}
+		if pattern == "*." || strings.ContainsAny(pattern, "/: ") || strings.Count(pattern, "*") > 1 ||
+			(strings.Contains(pattern, "*") && !strings.HasPrefix(pattern, "*.")) {
+			return nil, fmt.Errorf("invalid blocklist entry %q", pattern)
+		}
+		b.patterns = append(b.patterns, pattern)
+	}
+	return b, nil
+}
+
+// LoadBlocklist combines comma-separated entries with an optional file holding
+// one entry per line. Blank lines and lines starting with # are ignored.
+func LoadBlocklist(entries string, path string) (*Blocklist, error) {
+	patterns := strings.Split(entries, ",")
+
+	if path != "" {
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			patterns = append(patterns, line)
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	return NewBlocklist(patterns)
+}
+
+// Blocked reports whether host is blocklisted. A nil blocklist blocks nothing.
+func (b *Blocklist) Blocked(host string) bool {
+	if b == nil {
+		return false
+	}
+	return MatchHost(host, b.patterns)
+}
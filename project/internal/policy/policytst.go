@@ -0,0 +1,157 @@
+package policy
+
+import (
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMatchHost(t *testing.T) {
+	tests := []struct {
+		host     string
+		patterns []string
+		expected bool
+	}{
+		{"example.com", nil, false},
+		{"example.com", []string{"example.com"}, true},
+		{"EXAMPLE.com", []string{"example.com"}, true},
+		{"api.example.com", []string{"example.com"}, false},
+		{"api.staging.example.com", []string{"*.staging.example.com"}, true},
+		{"example.com.", []string{"example.com"}, true},
+		{"staging.example.com", []string{"*.staging.example.com"}, false},
+		{"notstaging.example.com", []string{"*.staging.example.com"}, false},
+	}
+
+	for _, tt := range tests {
+		if got := MatchHost(tt.host, tt.patterns); got != tt.expected {
+			t.Errorf("MatchHost(%q, %v) = %v, expected %v", tt.host, tt.patterns, got, tt.expected)
+		}
+	}
+}
+
+func TestBlocklist(t *testing.T) {
+	blocklist, err := NewBlocklist([]string{"evil.example.com", "*.blocked.org", "*.zip"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		host    string
+		blocked bool
+	}{
+		{"evil.example.com", true},      // blocklisted domain
+		{"EVIL.example.com", true},      // case-insensitive
+		{"api.blocked.org", true},       // wildcard domain
+		{"downloads.archive.zip", true}, // TLD wildcard
+		{"example.com", false},          // permitted host
+		{"good.example.com", false},     // sibling of a blocked host
+		{"zip.example.com", false},      // TLD name elsewhere in the host
+	}
+
+	for _, tt := range tests {
+		if got := blocklist.Blocked(tt.host); got != tt.blocked {
+			t.Errorf("Blocked(%q) = %v, expected %v", tt.host, got, tt.blocked)
+		}
+	}
+
+	var nilBlocklist *Blocklist
+	if nilBlocklist.Blocked("evil.example.com") {
+		t.Error("expected nil blocklist to block nothing")
+	}
+}
+
+func TestNewBlocklistInvalid(t *testing.T) {
+	for _, entry := range []string{"*.", "https://example.com", "ex*ample.com", "*.*.com"} {
+		if _, err := NewBlocklist([]string{entry}); err == nil {
+			t.Errorf("expected error for entry %q", entry)
+		}
+	}
+}
+
+func TestLoadBlocklist(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "blocklist.txt")
+	content := "# internal hosts\n*.internal.example.com\n\nadmin.example.com\n"
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatalf("failed to write blocklist file: %v", err)
+	}
+
+	blocklist, err := LoadBlocklist("*.zip, evil.example.com", path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for _, host := range []string{"a.zip", "evil.example.com", "db.internal.example.com", "admin.example.com"} {
+		if !blocklist.Blocked(host) {
+			t.Errorf("expected %q to be blocked", host)
+		}
+	}
+
+	if blocklist.Blocked("example.com") {
+		t.Error("expected example.com to be allowed")
+	}
+}
+
+func TestParseStatusRanges(t *testing.T) {
+	ranges, err := ParseStatusRanges(" 200-299, 418 ")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if ranges.String() != "200-299,418" {
+		t.Errorf("unexpected normalized form %q", ranges.String())
+	}
+
+	for code, expected := range map[int]bool{200: true, 204: true, 299: true, 418: true, 300: false, 404: false, 500: false} {
+		if ranges.Contains(code) != expected {
+			t.Errorf("Contains(%d) = %t, expected %t", code, !expected, expected)
+		}
+	}
+
+	for _, spec := range []string{"", "ok", "99", "600", "299-200", "200-", ","} {
+		if _, err := ParseStatusRanges(spec); err == nil {
+			t.Errorf("expected error for %q", spec)
+		}
+	}
+}
+
+func TestIsPrivateIP(t *testing.T) {
+	tests := []struct {
+		ip       string
+		expected bool
+	}{
+		{"10.1.2.3", true},
+		{"172.16.0.1", true},
+		{"192.168.1.1", true},
+		{"127.0.0.1", true},
+		{"169.254.169.254", true},
+		{"100.100.100.200", true},
+		{"0.0.0.0", true},
+		{"::1", true},
+		{"fe80::1", true},
+		{"fd00:ec2::254", true},
+		{"::ffff:127.0.0.1", true},
+		{"93.184.216.34", false},
+		{"172.32.0.1", false},
+		{"2606:4700::1111", false},
+	}
+
+	for _, tt := range tests {
+		if got := IsPrivateIP(net.ParseIP(tt.ip)); got != tt.expected {
+			t.Errorf("IsPrivateIP(%s) = %v, expected %v", tt.ip, got, tt.expected)
+		}
+	}
+}
+
+func TestDialControl(t *testing.T) {
+	if err := DialControl("tcp", "127.0.0.1:80", nil); !errors.Is(err, ErrPrivateAddress) {
+		t.Errorf("expected ErrPrivateAddress for loopback, got %v", err)
+	}
+	if err := DialControl("tcp", "[fe80::1]:443", nil); !errors.Is(err, ErrPrivateAddress) {
+		t.Errorf("expected ErrPrivateAddress for link-local, got %v", err)
+	}
+	if err := DialControl("tcp", "93.184.216.34:443", nil); err != nil {
+		t.Errorf("expected public address to be allowed, got %v", err)
+	}
+}
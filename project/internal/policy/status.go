@@ -0,0 +1,80 @@
+package policy
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// StatusRange is an inclusive range of HTTP status codes.
+type StatusRange struct {
+	Min int
+	Max int
+}
+
+// StatusRanges is the set of status codes a target treats as healthy.
+type StatusRanges []StatusRange
+
+// ParseStatusRanges parses a comma-separated list of codes and inclusive
+// ranges such as "200-299,418".
+func ParseStatusRanges(spec string) (StatusRanges, error) {
+	var ranges StatusRanges
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		low, high, isRange := strings.Cut(part, "-")
+		min, err := parseStatusCode(low)
+		if err != nil {
+			return nil, err
+		}
+		max := min
+		if isRange {
+			if max, err = parseStatusCode(high); err != nil {
+				return nil, err
+			}
+			if max < min {
+				return nil, fmt.Errorf("invalid status range %q", part)
+			}
+		}
+		ranges = append(ranges, StatusRange{Min: min, Max: max})
+	}
+
+	if len(ranges) == 0 {
+		return nil, fmt.Errorf("no status codes given")
+	}
+	return ranges, nil
+}
+
+func parseStatusCode(s string) (int, error) {
+	code, err := strconv.Atoi(strings.TrimSpace(s))
+	if err != nil || code < 100 || code > 599 {
+		return 0, fmt.Errorf("invalid status code %q", s)
+	}
+	return code, nil
+}
+
+// Contains reports whether code falls in any of the ranges.
+func (r StatusRanges) Contains(code int) bool {
+	for _, sr := range r {
+		if code >= sr.Min && code <= sr.Max {
+			return true
+		}
+	}
+	return false
+}
+
+// String formats the ranges in the form accepted by ParseStatusRanges.
+func (r StatusRanges) String() string {
+	parts := make([]string, len(r))
+	for i, sr := range r {
+		if sr.Min == sr.Max {
+			parts[i] = strconv.Itoa(sr.Min)
+		} else {
+			parts[i] = fmt.Sprintf("%d-%d", sr.Min, sr.Max)
+		}
+	}
+	return strings.Join(parts, ",")
+}
@@ -0,0 +1,54 @@
+package storage
+
+import (
+	"sync"
+
+	"github.com/aarushishahhh/linkwatch/project/internal/models"
+)
+
+// ResultCache holds the latest check result per target so hot read paths
+// can skip the database. It only sees results written by this process; a nil
+// cache caches nothing.
+type ResultCache struct {
+	mu      sync.RWMutex
+	results map[string]models.CheckResult
+}
+
+func NewResultCache() *ResultCache {
+	return &ResultCache{results: make(map[string]models.CheckResult)}
+}
+
+func (c *ResultCache) Get(targetID string) (models.CheckResult, bool) {
+	if c == nil {
+		return models.CheckResult{}, false
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	result, ok := c.results[targetID]
+	return result, ok
+}
+
+// Set records result as the target's latest, ignoring results older than
+// the one already cached so out-of-order writers can't roll it back.
+func (c *ResultCache) Set(targetID string, result models.CheckResult) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if cached, ok := c.results[targetID]; ok && cached.CheckedAt.After(result.CheckedAt) {
+		return
+	}
+	c.results[targetID] = result
+}
+
+// Delete drops a target's entry, e.g. when the target or its results are
+// removed.
+func (c *ResultCache) Delete(targetID string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.results, targetID)
+}
@@ -0,0 +1,323 @@
+package storage
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/net/idna"
+)
+
+// WWWMode controls how a leading "www." label is treated during canonicalization.
+type WWWMode string
+
+const (
+	WWWKeep  WWWMode = "keep"  // Leave hosts untouched (default)
+	WWWStrip WWWMode = "strip" // www.example.com -> example.com
+	WWWAdd   WWWMode = "add"   // example.com -> www.example.com
+)
+
+// ParseWWWMode parses a WWWMode, treating an empty string as WWWKeep.
+func ParseWWWMode(s string) (WWWMode, error) {
+	switch mode := WWWMode(strings.ToLower(s)); mode {
+	case "", WWWKeep:
+		return WWWKeep, nil
+	case WWWStrip, WWWAdd:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("invalid www mode %q, expected keep, strip or add", s)
+	}
+}
+
+// CanonicalizeOptions enables optional canonicalization rules on top of the
+// defaults applied by CanonicalizeURL.
+type CanonicalizeOptions struct {
+	WWW WWWMode
+
+	// StripParams lists query parameters to remove, e.g. tracking parameters.
+	// A trailing "*" matches by prefix ("utm_*"). Matching ignores case.
+	StripParams []string
+}
+
+// DefaultStripParams are the UTM and ad click-ID parameters stripped by
+// default. They identify the campaign a link came from, never the resource.
+var DefaultStripParams = []string{
+	"utm_*",
+	"fbclid",
+	"gclid",
+	"dclid",
+	"gbraid",
+	"wbraid",
+	"msclkid",
+	"yclid",
+	"twclid",
+	"igshid",
+	"mc_cid",
+	"mc_eid",
+	"_ga",
+}
+
+// ParseStripParams parses a comma-separated parameter list. "default" expands
+// to DefaultStripParams (so "default,ref" extends it) and "none" or an empty
+// string strips nothing.
+func ParseStripParams(s string) []string {
+	var params []string
+	for _, param := range strings.Split(s, ",") {
+		switch param = strings.TrimSpace(param); param {
+		case "", "none":
+		case "default":
+			params = append(params, DefaultStripParams...)
+		default:
+			params = append(params, param)
+		}
+	}
+	return params
+}
+
+// CanonicalizeURL converts a URL to its canonical form
+func CanonicalizeURL(rawURL string) (string, error) {
+	return CanonicalizeURLWithOptions(rawURL, CanonicalizeOptions{})
+}
+
+// CanonicalizeURLWithOptions converts a URL to its canonical form, applying
+// the optional rules in opts.
+func CanonicalizeURLWithOptions(rawURL string, opts CanonicalizeOptions) (string, error) {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return "", err
+	}
+
+	// Ensure scheme is present
+	if parsed.Scheme == "" {
+		return "", fmt.Errorf("missing scheme")
+	}
+
+	// Internationalized hosts become punycode, so both spellings of a host
+	// canonicalize the same
+	if parsed.Host, err = asciiHost(parsed); err != nil {
+		return "", err
+	}
+
+	// Lowercase scheme and host
+	parsed.Scheme = strings.ToLower(parsed.Scheme)
+	parsed.Host = strings.ToLower(parsed.Host)
+
+	// Remove default ports
+	switch parsed.Scheme {
+	case "http":
+		if strings.HasSuffix(parsed.Host, ":80") {
+			parsed.Host = strings.TrimSuffix(parsed.Host, ":80")
+		}
+	case "https":
+		if strings.HasSuffix(parsed.Host, ":443") {
+			parsed.Host = strings.TrimSuffix(parsed.Host, ":443")
+		}
+	}
+
+	parsed.Host = normalizeWWW(parsed.Host, opts.WWW)
+
+	// Remove fragment
+	parsed.Fragment = ""
+
+	// Normalize path - remove trailing slash unless it's root. Done on the
+	// escaped form so an encoded slash (%2F) is neither trimmed nor decoded.
+	path := normalizeEscapes(parsed.EscapedPath())
+	if path != "/" && strings.HasSuffix(path, "/") {
+		path = strings.TrimSuffix(path, "/")
+	}
+	if parsed.Path, err = url.PathUnescape(path); err != nil {
+		return "", err
+	}
+	parsed.RawPath = path
+
+	parsed.RawQuery = canonicalQuery(parsed.RawQuery, opts.StripParams)
+
+	return parsed.String(), nil
+}
+
+// idnaProfile maps hosts the way browsers look them up, but without the
+// STD3 rules so ASCII names that were accepted before, like those with
+// underscores, still are.
+var idnaProfile = idna.New(idna.MapForLookup(), idna.BidiRule(), idna.StrictDomainName(false))
+
+// asciiHost returns the host of u, port included, with an internationalized
+// name converted to its punycode form. ASCII hosts are returned unchanged.
+func asciiHost(u *url.URL) (string, error) {
+	if isASCII(u.Host) {
+		return u.Host, nil
+	}
+
+	name, err := idnaProfile.ToASCII(u.Hostname())
+	if err != nil {
+		return "", fmt.Errorf("invalid host %q: %w", u.Hostname(), err)
+	}
+	if port := u.Port(); port != "" {
+		return name + ":" + port, nil
+	}
+	return name, nil
+}
+
+// hostOf returns the hostname of a canonical URL as stored for host
+// filtering: no port, no IPv6 brackets and no trailing dot. It is empty
+// when the URL doesn't parse.
+func hostOf(canonicalURL string) string {
+	u, err := url.Parse(canonicalURL)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSuffix(u.Hostname(), ".")
+}
+
+// filterHost normalizes a host given to filter on the way canonicalization
+// does the host of a URL, so it compares equal to hostOf: lowercased,
+// internationalized names in punycode, no trailing dot. Names that can't be
+// converted are only lowercased.
+func filterHost(host string) string {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	if isASCII(host) {
+		return host
+	}
+	if name, err := idnaProfile.ToASCII(host); err == nil {
+		return name
+	}
+	return host
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
+
+// normalizeEscapes normalizes percent-encoding per RFC 3986: escaped
+// unreserved characters are decoded and the hex digits of the remaining
+// escapes uppercased. Reserved characters stay escaped, since decoding them
+// could change what the URL means (%2F isn't a path separator). Malformed
+// escapes are left alone.
+func normalizeEscapes(s string) string {
+	if !strings.Contains(s, "%") {
+		return s
+	}
+
+	var b strings.Builder
+	b.Grow(len(s))
+	for i := 0; i < len(s); i++ {
+		if s[i] != '%' || i+2 >= len(s) || !isHex(s[i+1]) || !isHex(s[i+2]) {
+			b.WriteByte(s[i])
+			continue
+		}
+		c := unhex(s[i+1])<<4 | unhex(s[i+2])
+		if isUnreserved(c) {
+			b.WriteByte(c)
+		} else {
+			b.WriteByte('%')
+			b.WriteString(strings.ToUpper(s[i+1 : i+3]))
+		}
+		i += 2
+	}
+	return b.String()
+}
+
+func isUnreserved(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' ||
+		c == '-' || c == '.' || c == '_' || c == '~'
+}
+
+func isHex(c byte) bool {
+	return '0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'
+}
+
+func unhex(c byte) byte {
+	switch {
+	case '0' <= c && c <= '9':
+		return c - '0'
+	case 'a' <= c && c <= 'f':
+		return c - 'a' + 10
+	default:
+		return c - 'A' + 10
+	}
+}
+
+// canonicalQuery drops the strip parameters and orders the rest by key, and
+// repeated keys by value, so reordered but otherwise identical queries
+// canonicalize the same. Queries that don't parse cleanly are kept verbatim
+// rather than losing parameters.
+func canonicalQuery(rawQuery string, strip []string) string {
+	if rawQuery == "" {
+		return rawQuery
+	}
+
+	values, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return normalizeEscapes(rawQuery)
+	}
+	for key, v := range values {
+		if matchParam(key, strip) {
+			delete(values, key)
+			continue
+		}
+		sort.Strings(v)
+	}
+	return values.Encode() // Encode sorts by key
+}
+
+// matchParam reports whether key matches any pattern in params.
+func matchParam(key string, params []string) bool {
+	key = strings.ToLower(key)
+	for _, param := range params {
+		param = strings.ToLower(param)
+		if prefix, ok := strings.CutSuffix(param, "*"); ok {
+			if strings.HasPrefix(key, prefix) {
+				return true
+			}
+			continue
+		}
+		if key == param {
+			return true
+		}
+	}
+	return false
+}
+
+// normalizeWWW strips or adds a leading "www." label. Stripping leaves at
+// least a two-label host ("www.com" is kept), and adding only applies to
+// two-label hosts so other subdomains are never touched. IPs are left alone.
+func normalizeWWW(host string, mode WWWMode) string {
+	if mode != WWWStrip && mode != WWWAdd {
+		return host
+	}
+
+	hostname, port := host, ""
+	if h, p, err := net.SplitHostPort(host); err == nil {
+		hostname, port = h, p
+	}
+
+	if hostname == "" || net.ParseIP(hostname) != nil {
+		return host
+	}
+
+	switch mode {
+	case WWWStrip:
+		rest, ok := strings.CutPrefix(hostname, "www.")
+		if !ok || !strings.Contains(rest, ".") {
+			return host
+		}
+		hostname = rest
+	case WWWAdd:
+		if strings.Count(hostname, ".") != 1 || strings.HasPrefix(hostname, "www.") {
+			return host
+		}
+		hostname = "www." + hostname
+	}
+
+	if port != "" {
+		return net.JoinHostPort(hostname, port)
+	}
+	return hostname
+}
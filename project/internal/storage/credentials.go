@@ -0,0 +1,89 @@
+package storage
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/aarushishahhh/linkwatch/project/internal/models"
+)
+
+// Stored secrets are prefixed with how they are stored, so secrets saved
+// before a key was set still open after it is
+const (
+	secretPlain  = "plain:"
+	secretSealed = "aes256gcm:"
+)
+
+// ParseCredentialsKey decodes a base64-encoded 32-byte key for
+// SetCredentialsKey. An empty string is no key.
+func ParseCredentialsKey(s string) ([]byte, error) {
+	if s == "" {
+		return nil, nil
+	}
+	key, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("credentials key must be base64: %w", err)
+	}
+	if len(key) != 32 {
+		return nil, fmt.Errorf("credentials key must be 32 bytes, got %d", len(key))
+	}
+	return key, nil
+}
+
+// SetCredentialsKey encrypts target passwords saved from now on with
+// AES-256-GCM under key. Without a key they are stored in the clear.
+func (s *Storage) SetCredentialsKey(key []byte) error {
+	if key == nil {
+		s.credentials = nil
+		return nil
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return err
+	}
+	s.credentials, err = cipher.NewGCM(block)
+	return err
+}
+
+// sealSecret returns secret in its stored form.
+func (s *Storage) sealSecret(secret models.Secret) (models.Secret, error) {
+	if s.credentials == nil {
+		return models.Secret(secretPlain + string(secret)), nil
+	}
+	nonce := make([]byte, s.credentials.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := s.credentials.Seal(nonce, nonce, []byte(secret), nil)
+	return models.Secret(secretSealed + base64.StdEncoding.EncodeToString(sealed)), nil
+}
+
+// OpenSecret returns the value of a secret as read from storage, such as a
+// target's Password. Sealed secrets need the key they were sealed with.
+func (s *Storage) OpenSecret(stored models.Secret) (string, error) {
+	if plain, ok := strings.CutPrefix(string(stored), secretPlain); ok {
+		return plain, nil
+	}
+	encoded, ok := strings.CutPrefix(string(stored), secretSealed)
+	if !ok {
+		return "", errors.New("unknown secret encoding")
+	}
+	if s.credentials == nil {
+		return "", errors.New("secret is encrypted but no credentials key is set")
+	}
+	sealed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(sealed) < s.credentials.NonceSize() {
+		return "", errors.New("malformed encrypted secret")
+	}
+	nonce, ciphertext := sealed[:s.credentials.NonceSize()], sealed[s.credentials.NonceSize():]
+	plain, err := s.credentials.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return "", errors.New("secret can't be decrypted with the credentials key")
+	}
+	return string(plain), nil
+}
@@ -1,87 +1,337 @@
 package storage
 
 import (
+	"context"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
 	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
-	"net/url"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/aarushishahhh/linkwatch/project/internal/models"
+	"github.com/aarushishahhh/linkwatch/project/internal/policy"
 )
 
 type Storage struct {
-	db *sql.DB
-}
-
-func New(db *sql.DB) *Storage {
-	return &Storage{db: db}
-}
-
-func (s *Storage) Migrate() error {
-	schema := `
-	CREATE TABLE IF NOT EXISTS targets (
-		id TEXT PRIMARY KEY,
-		url TEXT NOT NULL UNIQUE,
-		canonical_url TEXT NOT NULL UNIQUE,
-		created_at TIMESTAMP NOT NULL
-	);
-
-	CREATE TABLE IF NOT EXISTS check_results (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		target_id TEXT NOT NULL REFERENCES targets(id),
-		checked_at TIMESTAMP NOT NULL,
-		status_code INTEGER,
-		latency_ms INTEGER NOT NULL,
-		error TEXT,
-		FOREIGN KEY (target_id) REFERENCES targets(id)
-	);
-
-	CREATE TABLE IF NOT EXISTS idempotency_keys (
-		key TEXT PRIMARY KEY,
-		target_id TEXT NOT NULL,
-		created_at TIMESTAMP NOT NULL,
-		FOREIGN KEY (target_id) REFERENCES targets(id)
-	);
-
-	CREATE INDEX IF NOT EXISTS idx_check_results_target_checked 
-		ON check_results(target_id, checked_at DESC);
-	CREATE INDEX IF NOT EXISTS idx_targets_created_id 
-		ON targets(created_at, id);
-	CREATE INDEX IF NOT EXISTS idx_idempotency_created 
-		ON idempotency_keys(created_at);
-	`
-
-	_, err := s.db.Exec(schema)
-	return err
+	db             *db
+	auditLog       bool
+	idempotencyTTL time.Duration // Keys older than this are treated as absent; never when zero
+	auditMux       sync.Mutex    // Serializes audit log appends so the hash chain can't fork
+	credentials    cipher.AEAD   // Seals target passwords; stored in the clear when nil
+	maxTargets     int           // Creates beyond this many targets fail; unlimited when zero
 }
 
-func (s *Storage) CreateTarget(originalURL, canonicalURL string, idempotencyKey *string) (*models.Target, bool, error) {
-	targetID := generateID("t_")
-	now := time.Now().UTC()
+// New wraps conn, which may be a SQLite or Postgres (lib/pq) database.
+func New(conn *sql.DB) *Storage {
+	return &Storage{db: &db{DB: conn, dialect: detectDialect(conn)}}
+}
+
+// Ping checks that the database is reachable.
+func (s *Storage) Ping(ctx context.Context) error {
+	return s.db.PingContext(ctx)
+}
+
+const targetColumns = "id, url, created_at, depends_on, success_status, timeout_ms, profile_id, paused, " +
+	"check_interval, last_checked_at, headers, expected_body, body_regex, etag, last_modified, username, password, " +
+	"maintenance_windows, consecutive_failures, ip_version"
+
+// summaryColumns and targetsWithLastCheck read targets together with a
+// summary of their latest check result, for the listings clients read. The
+// summary comes from check_results itself, so deleting results clears it.
+var summaryColumns = "targets." + strings.ReplaceAll(targetColumns, ", ", ", targets.") +
+	", last_result.status_code, last_result.checked_at, last_result.error"
+
+const targetsWithLastCheck = `targets LEFT JOIN check_results last_result ON last_result.id = (
+	SELECT id FROM check_results WHERE target_id = targets.id ORDER BY checked_at DESC, id DESC LIMIT 1)`
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanTarget scans a row of targetColumns, followed by any extra columns
+// into extra.
+func scanTarget(row rowScanner, extra ...interface{}) (*models.Target, error) {
+	var target models.Target
+	var dependsOn, successStatus, profileID, checkInterval, headers, expectedBody, bodyRegex, etag, lastModified sql.NullString
+	var username, password, maintenanceWindows, ipVersion sql.NullString
+	var timeoutMs sql.NullInt64
+	var lastCheckedAt sql.NullTime
+	dest := []interface{}{&target.ID, &target.URL, &target.CreatedAt, &dependsOn, &successStatus, &timeoutMs,
+		&profileID, &target.Paused, &checkInterval, &lastCheckedAt, &headers, &expectedBody, &bodyRegex,
+		&etag, &lastModified, &username, &password, &maintenanceWindows, &target.ConsecutiveFailures,
+		&ipVersion}
+	if err := row.Scan(append(dest, extra...)...); err != nil {
+		return nil, err
+	}
+	if etag.Valid {
+		target.ETag = &etag.String
+	}
+	if lastModified.Valid {
+		target.LastModified = &lastModified.String
+	}
+	if expectedBody.Valid {
+		target.ExpectedBody = &expectedBody.String
+	}
+	if bodyRegex.Valid {
+		target.BodyRegex = &bodyRegex.String
+	}
+	if username.Valid {
+		target.Username = &username.String
+	}
+	if password.Valid {
+		stored := models.Secret(password.String)
+		target.Password = &stored
+	}
+	if headers.Valid {
+		if err := json.Unmarshal([]byte(headers.String), &target.Headers); err != nil {
+			return nil, fmt.Errorf("decode headers of target %s: %w", target.ID, err)
+		}
+	}
+	if ipVersion.Valid {
+		target.IPVersion = &ipVersion.String
+	}
+	if maintenanceWindows.Valid {
+		if err := json.Unmarshal([]byte(maintenanceWindows.String), &target.MaintenanceWindows); err != nil {
+			return nil, fmt.Errorf("decode maintenance windows of target %s: %w", target.ID, err)
+		}
+	}
+	if dependsOn.Valid {
+		target.DependsOn = &dependsOn.String
+	}
+	if successStatus.Valid {
+		target.SuccessStatus = &successStatus.String
+	}
+	if timeoutMs.Valid {
+		ms := int(timeoutMs.Int64)
+		target.TimeoutMs = &ms
+	}
+	if profileID.Valid {
+		target.ProfileID = &profileID.String
+	}
+	if checkInterval.Valid {
+		target.CheckInterval = &checkInterval.String
+	}
+	if lastCheckedAt.Valid {
+		target.LastCheckedAt = &lastCheckedAt.Time
+	}
+	return &target, nil
+}
+
+// scanTargetSummary scans a row of summaryColumns. Targets never checked
+// are left with a nil summary.
+func scanTargetSummary(row rowScanner) (*models.Target, error) {
+	var statusCode sql.NullInt64
+	var checkedAt sql.NullTime
+	var lastError sql.NullString
+	target, err := scanTarget(row, &statusCode, &checkedAt, &lastError)
+	if err != nil {
+		return nil, err
+	}
+
+	target.LastCheckedAt = nil
+	if checkedAt.Valid {
+		target.LastCheckedAt = &checkedAt.Time
+	}
+	if statusCode.Valid {
+		code := int(statusCode.Int64)
+		target.LastStatusCode = &code
+	}
+	if lastError.Valid {
+		target.LastError = &lastError.String
+	}
+	return target, nil
+}
+
+const resultColumns = "id, checked_at, status_code, latency_ms, latency_us, error, suppressed_by, instance_id, final_url, " +
+	"tls_expires_at, tls_expiring, dns_ms, connect_ms, tls_ms, ttfb_ms, not_modified, redirect_count, " +
+	"content_type, content_length, body_bytes, ip_version"
+
+func scanCheckResult(row rowScanner) (*models.CheckResult, error) {
+	var result models.CheckResult
+	var errorStr, suppressedBy, finalURL, contentType, ipVersion sql.NullString
+	var latencyUs, contentLength, bodyBytes sql.NullInt64
+	var tlsExpiresAt sql.NullTime
+	if err := row.Scan(&result.ID, &result.CheckedAt, &result.StatusCode, &result.LatencyMs, &latencyUs, &errorStr, &suppressedBy,
+		&result.InstanceID, &finalURL, &tlsExpiresAt, &result.TLSExpiring,
+		&result.DNSMs, &result.ConnectMs, &result.TLSMs, &result.TTFBMs, &result.NotModified,
+		&result.RedirectCount, &contentType, &contentLength, &bodyBytes, &ipVersion); err != nil {
+		return nil, err
+	}
+	if contentType.Valid {
+		result.ContentType = &contentType.String
+	}
+	if ipVersion.Valid {
+		result.IPVersion = &ipVersion.String
+	}
+	if contentLength.Valid {
+		result.ContentLength = &contentLength.Int64
+	}
+	if bodyBytes.Valid {
+		result.BodyBytes = &bodyBytes.Int64
+	}
+	if tlsExpiresAt.Valid {
+		result.TLSExpiresAt = &tlsExpiresAt.Time
+	}
+	if finalURL.Valid {
+		result.FinalURL = &finalURL.String
+	}
+	if latencyUs.Valid {
+		result.LatencyUs = &latencyUs.Int64
+	}
+	if errorStr.Valid {
+		result.Error = &errorStr.String
+	}
+	if suppressedBy.Valid {
+		result.SuppressedBy = &suppressedBy.String
+	}
+	return &result, nil
+}
+
+func (s *Storage) CreateTarget(ctx context.Context, originalURL, canonicalURL string, idempotencyKey *string) (*models.Target, bool, error) {
+	return s.CreateTargetWithSettings(ctx, originalURL, canonicalURL, idempotencyKey, models.TargetSettings{})
+}
+
+// CreateTargetWithSettings is CreateTarget with optional per-target settings.
+// Settings only apply to newly created targets; an existing target is
+// returned unchanged.
+func (s *Storage) CreateTargetWithSettings(ctx context.Context, originalURL, canonicalURL string, idempotencyKey *string, settings models.TargetSettings) (*models.Target, bool, error) {
+	// createTarget stores the password as given
+	if settings.Password != nil {
+		sealed, err := s.sealSecret(*settings.Password)
+		if err != nil {
+			return nil, false, fmt.Errorf("seal password: %w", err)
+		}
+		settings.Password = &sealed
+	}
+
+	var target *models.Target
+	var isNew bool
+	err := s.createTx(ctx, func(tx *tx) error {
+		// An expired key is forgotten even if cleanup hasn't removed it yet,
+		// so reusing it creates a target afresh
+		if idempotencyKey != nil && s.idempotencyTTL > 0 {
+			_, err := tx.ExecContext(ctx, "DELETE FROM idempotency_keys WHERE key = ? AND created_at < ?",
+				*idempotencyKey, time.Now().UTC().Add(-s.idempotencyTTL))
+			if err != nil {
+				return err
+			}
+		}
 
-	tx, err := s.db.Begin()
+		var err error
+		target, isNew, err = s.createTarget(ctx, tx, originalURL, canonicalURL, idempotencyKey, settings)
+		return err
+	})
 	if err != nil {
 		return nil, false, err
 	}
-	defer tx.Rollback()
+	return target, isNew, nil
+}
+
+// createTx runs fn in a transaction that creates targets. Concurrent creates
+// of the same URL can both miss the existing target and race to insert it;
+// the loser's unique violation means the target now exists, so fn is run
+// once more in a fresh transaction to find it. Busy SQLite writes are
+// retried as usual.
+func (s *Storage) createTx(ctx context.Context, fn func(tx *tx) error) error {
+	run := func() error {
+		return retryBusy(ctx, func() error {
+			tx, err := s.db.BeginTx(ctx)
+			if err != nil {
+				return err
+			}
+			defer tx.Rollback()
+
+			if err := fn(tx); err != nil {
+				return err
+			}
+			return tx.Commit()
+		})
+	}
+
+	err := run()
+	if isUniqueViolation(err) {
+		err = run()
+	}
+	return err
+}
+
+// TargetURL is a URL to create a target for, with its canonical form.
+type TargetURL struct {
+	URL          string
+	CanonicalURL string
+}
+
+// CreatedTarget is the outcome of creating one target in a batch.
+type CreatedTarget struct {
+	Target  *models.Target
+	Created bool  // False when a target with the same canonical URL existed
+	Err     error // ErrTargetLimit when the URL was left out for the cap; Target is nil
+}
+
+// CreateTargets creates a target per URL in a single transaction, returning
+// the outcomes in order. URLs sharing a canonical form resolve to the same
+// target. Once the cap set with SetMaxTargets is reached, new URLs are left
+// out with Err set while the rest of the batch is still created.
+func (s *Storage) CreateTargets(ctx context.Context, urls []TargetURL) ([]CreatedTarget, error) {
+	var created []CreatedTarget
+	err := s.createTx(ctx, func(tx *tx) error {
+		created = make([]CreatedTarget, 0, len(urls))
+		for _, u := range urls {
+			target, isNew, err := s.createTarget(ctx, tx, u.URL, u.CanonicalURL, nil, models.TargetSettings{})
+			if errors.Is(err, ErrTargetLimit) {
+				created = append(created, CreatedTarget{Err: err})
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			created = append(created, CreatedTarget{Target: target, Created: isNew})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return created, nil
+}
+
+// ErrTargetLimit is returned when creating a target would take the number
+// of targets past the limit set with SetMaxTargets.
+var ErrTargetLimit = errors.New("target limit reached")
+
+// SetMaxTargets caps how many targets may exist; creates of new targets
+// beyond it fail with ErrTargetLimit, while those returning an existing
+// target still succeed. Zero removes the cap.
+func (s *Storage) SetMaxTargets(n int) {
+	s.maxTargets = n
+}
+
+// createTarget creates a target within tx, or returns the existing target
+// for the canonical URL or idempotency key.
+func (s *Storage) createTarget(ctx context.Context, tx *tx, originalURL, canonicalURL string, idempotencyKey *string, settings models.TargetSettings) (*models.Target, bool, error) {
+	targetID := GenerateID("t_")
+	now := time.Now().UTC()
 
 	// Check for existing target by canonical URL
-	var existing models.Target
-	err = tx.QueryRow("SELECT id, url, created_at FROM targets WHERE canonical_url = ?", canonicalURL).
-		Scan(&existing.ID, &existing.URL, &existing.CreatedAt)
+	existing, err := scanTarget(tx.QueryRowContext(ctx, "SELECT "+targetColumns+" FROM targets WHERE canonical_url = ?", canonicalURL))
 
 	if err == nil {
 		// Target exists, handle idempotency key if provided
 		if idempotencyKey != nil {
-			_, err = tx.Exec("INSERT OR IGNORE INTO idempotency_keys (key, target_id, created_at) VALUES (?, ?, ?)",
+			_, err = tx.ExecContext(ctx, "INSERT INTO idempotency_keys (key, target_id, created_at) VALUES (?, ?, ?) ON CONFLICT (key) DO NOTHING",
 				*idempotencyKey, existing.ID, now)
 			if err != nil {
 				return nil, false, err
 			}
 		}
-		tx.Commit()
-		return &existing, false, nil
+		return existing, false, nil
 	}
 
 	if err != sql.ErrNoRows {
@@ -91,18 +341,16 @@ func (s *Storage) CreateTarget(originalURL, canonicalURL string, idempotencyKey
 	// Check idempotency key if provided
 	if idempotencyKey != nil {
 		var existingTargetID string
-		err = tx.QueryRow("SELECT target_id FROM idempotency_keys WHERE key = ?", *idempotencyKey).
+		err = tx.QueryRowContext(ctx, "SELECT target_id FROM idempotency_keys WHERE key = ?", *idempotencyKey).
 			Scan(&existingTargetID)
 
 		if err == nil {
 			// Key exists, return existing target
-			err = tx.QueryRow("SELECT id, url, created_at FROM targets WHERE id = ?", existingTargetID).
-				Scan(&existing.ID, &existing.URL, &existing.CreatedAt)
+			existing, err = scanTarget(tx.QueryRowContext(ctx, "SELECT "+targetColumns+" FROM targets WHERE id = ?", existingTargetID))
 			if err != nil {
 				return nil, false, err
 			}
-			tx.Commit()
-			return &existing, false, nil
+			return existing, false, nil
 		}
 
 		if err != sql.ErrNoRows {
@@ -110,65 +358,174 @@ func (s *Storage) CreateTarget(originalURL, canonicalURL string, idempotencyKey
 		}
 	}
 
+	// Counted in the transaction, so a batch counts its own creates. On
+	// Postgres concurrent creates can each see room for one more, so the
+	// cap may be overshot by a few.
+	if s.maxTargets > 0 {
+		count, err := countTargets(ctx, tx, models.TargetFilter{})
+		if err != nil {
+			return nil, false, err
+		}
+		if count >= s.maxTargets {
+			return nil, false, fmt.Errorf("%w: at most %d targets can be registered", ErrTargetLimit, s.maxTargets)
+		}
+	}
+
+	// Headers are encoded as a plain map; Headers' own JSON form is redacted
+	var headers *string
+	if len(settings.Headers) > 0 {
+		data, err := json.Marshal(map[string]string(settings.Headers))
+		if err != nil {
+			return nil, false, err
+		}
+		encoded := string(data)
+		headers = &encoded
+	}
+
+	var maintenanceWindows *string
+	if len(settings.MaintenanceWindows) > 0 {
+		data, err := json.Marshal(settings.MaintenanceWindows)
+		if err != nil {
+			return nil, false, err
+		}
+		encoded := string(data)
+		maintenanceWindows = &encoded
+	}
+
 	// Create new target
-	_, err = tx.Exec("INSERT INTO targets (id, url, canonical_url, created_at) VALUES (?, ?, ?, ?)",
-		targetID, originalURL, canonicalURL, now)
+	_, err = tx.ExecContext(ctx,
+		`INSERT INTO targets (id, url, canonical_url, host, created_at, depends_on, success_status, timeout_ms, profile_id,
+			check_interval, headers, expected_body, body_regex, username, password, maintenance_windows, ip_version)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		targetID, originalURL, canonicalURL, hostOf(canonicalURL), now, settings.DependsOn, settings.SuccessStatus,
+		settings.TimeoutMs, settings.ProfileID, settings.CheckInterval, headers, settings.ExpectedBody, settings.BodyRegex,
+		settings.Username, (*string)(settings.Password), maintenanceWindows, settings.IPVersion)
 	if err != nil {
 		return nil, false, err
 	}
 
 	// Store idempotency key if provided
 	if idempotencyKey != nil {
-		_, err = tx.Exec("INSERT INTO idempotency_keys (key, target_id, created_at) VALUES (?, ?, ?)",
+		_, err = tx.ExecContext(ctx, "INSERT INTO idempotency_keys (key, target_id, created_at) VALUES (?, ?, ?)",
 			*idempotencyKey, targetID, now)
 		if err != nil {
 			return nil, false, err
 		}
 	}
 
-	if err = tx.Commit(); err != nil {
-		return nil, false, err
-	}
-
 	return &models.Target{
-		ID:        targetID,
-		URL:       originalURL,
-		CreatedAt: now,
+		ID:            targetID,
+		URL:           originalURL,
+		CreatedAt:     now,
+		DependsOn:     settings.DependsOn,
+		SuccessStatus: settings.SuccessStatus,
+		TimeoutMs:     settings.TimeoutMs,
+		ProfileID:     settings.ProfileID,
+		CheckInterval: settings.CheckInterval,
+		Headers:       settings.Headers,
+		Username:      settings.Username,
+		Password:      settings.Password,
+		ExpectedBody:  settings.ExpectedBody,
+		BodyRegex:     settings.BodyRegex,
+
+		MaintenanceWindows: settings.MaintenanceWindows,
+		IPVersion:          settings.IPVersion,
 	}, true, nil
 }
 
-func (s *Storage) ListTargets(host *string, limit int, pageToken string) (*models.TargetList, error) {
-	var query string
+// targetFilter returns the WHERE clauses and arguments applying filter to
+// a query on targets.
+func targetFilter(filter models.TargetFilter) ([]string, []interface{}) {
+	var where []string
 	var args []interface{}
+	if filter.Host != nil {
+		where = append(where, "targets.host = ?")
+		args = append(args, filterHost(*filter.Host))
+	}
+	if filter.HostSuffix != nil {
+		suffix := filterHost(*filter.HostSuffix)
+		where = append(where, `(targets.host = ? OR targets.host LIKE ? ESCAPE '\')`)
+		args = append(args, suffix, "%."+escapeLike(suffix))
+	}
+	return where, args
+}
 
-	baseQuery := "SELECT id, url, created_at FROM targets"
+// escapeLike escapes LIKE's wildcards in s, for use with ESCAPE '\'.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
 
-	if host != nil {
-		baseQuery += " WHERE canonical_url LIKE ?"
-		args = append(args, "%://"+strings.ToLower(*host)+"/%")
+// CountTargets returns how many targets match filter.
+func (s *Storage) CountTargets(ctx context.Context, filter models.TargetFilter) (int, error) {
+	return countTargets(ctx, s.db, filter)
+}
+
+// CountUnpausedTargets returns how many targets the scheduler checks.
+func (s *Storage) CountUnpausedTargets(ctx context.Context) (int, error) {
+	var count int
+	err := s.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM targets WHERE paused = ?", false).Scan(&count)
+	return count, err
+}
+
+// rowQuerier is a db or a tx.
+type rowQuerier interface {
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+func countTargets(ctx context.Context, q rowQuerier, filter models.TargetFilter) (int, error) {
+	query := "SELECT COUNT(*) FROM targets"
+	where, args := targetFilter(filter)
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
 	}
 
+	var count int
+	err := q.QueryRowContext(ctx, query, args...).Scan(&count)
+	return count, err
+}
+
+func (s *Storage) ListTargets(ctx context.Context, host *string, limit int, pageToken string) (*models.TargetList, error) {
+	return s.ListTargetsSorted(ctx, models.TargetFilter{Host: host}, limit, pageToken, models.TargetSort{Field: models.SortCreatedAt})
+}
+
+// ListTargetsSorted is ListTargets in the given order. Page tokens only
+// resume a listing in the order they were issued for.
+func (s *Storage) ListTargetsSorted(ctx context.Context, filter models.TargetFilter, limit int, pageToken string, sort models.TargetSort) (*models.TargetList, error) {
+	column := "targets.created_at"
+	if sort.Field == models.SortURL {
+		column = "targets.url"
+	}
+	direction, after := "ASC", ">"
+	if sort.Desc {
+		direction, after = "DESC", "<"
+	}
+
+	query := "SELECT " + summaryColumns + " FROM " + targetsWithLastCheck
+	where, args := targetFilter(filter)
+
 	if pageToken != "" {
-		// Decode cursor (simplified - in production, use proper encoding)
-		parts := strings.Split(pageToken, "_")
-		if len(parts) == 2 {
-			timestamp := parts[0]
-			id := parts[1]
-
-			if host != nil {
-				baseQuery += " AND (created_at > ? OR (created_at = ? AND id > ?))"
-				args = append(args, timestamp, timestamp, id)
-			} else {
-				baseQuery += " WHERE (created_at > ? OR (created_at = ? AND id > ?))"
-				args = append(args, timestamp, timestamp, id)
-			}
+		cursor, err := decodePageToken(pageToken, sort)
+		if err != nil {
+			return nil, err
 		}
+
+		// id breaks ties, so rows sharing a sort value are neither skipped
+		// nor repeated across pages
+		var value interface{} = cursor.CreatedAt
+		if sort.Field == models.SortURL {
+			value = cursor.URL
+		}
+		where = append(where, fmt.Sprintf("(%s %s ? OR (%s = ? AND targets.id %s ?))", column, after, column, after))
+		args = append(args, value, value, cursor.ID)
 	}
 
-	query = baseQuery + " ORDER BY created_at, id LIMIT ?"
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	query += fmt.Sprintf(" ORDER BY %s %s, targets.id %s LIMIT ?", column, direction, direction)
 	args = append(args, limit+1) // Fetch one extra to determine if there's a next page
 
-	rows, err := s.db.Query(query, args...)
+	rows, err := s.db.QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -176,28 +533,124 @@ func (s *Storage) ListTargets(host *string, limit int, pageToken string) (*model
 
 	var targets []models.Target
 	for rows.Next() {
-		var target models.Target
-		if err := rows.Scan(&target.ID, &target.URL, &target.CreatedAt); err != nil {
+		target, err := scanTargetSummary(rows)
+		if err != nil {
 			return nil, err
 		}
-		targets = append(targets, target)
+		targets = append(targets, *target)
 	}
 
 	result := &models.TargetList{Items: targets}
 
 	// Set next page token if there are more results
 	if len(targets) > limit {
+		result.HasMore = true
 		result.Items = targets[:limit]
-		last := targets[limit-1]
-		result.NextPageToken = fmt.Sprintf("%s_%s",
-			last.CreatedAt.Format(time.RFC3339Nano), last.ID)
+		result.NextPageToken = encodePageToken(targets[limit-1], sort)
 	}
 
 	return result, nil
 }
 
-func (s *Storage) GetAllTargets() ([]models.Target, error) {
-	rows, err := s.db.Query("SELECT id, url, created_at FROM targets ORDER BY created_at")
+// maxPageTokenLength bounds page tokens well above anything ListTargets
+// issues (tokens of URL-sorted listings carry a URL), so junk is rejected
+// before any parsing work.
+const maxPageTokenLength = 8192
+
+// ErrInvalidPageToken is returned for page tokens that are oversized or were
+// not issued by the listing they are passed to.
+var ErrInvalidPageToken = errors.New("invalid page token")
+
+// pageCursor is the position a page token resumes from. Tokens are opaque to
+// clients: base64url-encoded JSON of this struct.
+type pageCursor struct {
+	CreatedAt time.Time `json:"created_at"`
+	ID        string    `json:"id"`
+
+	// Only set for orders other than the default created_at ascending, so
+	// tokens issued before sorting existed stay valid
+	Sort string `json:"sort,omitempty"`
+	Desc bool   `json:"desc,omitempty"`
+	URL  string `json:"url,omitempty"`
+}
+
+func encodePageToken(last models.Target, sort models.TargetSort) string {
+	cursor := pageCursor{CreatedAt: last.CreatedAt, ID: last.ID, Desc: sort.Desc}
+	if sort.Field != models.SortCreatedAt {
+		cursor.Sort = sort.Field
+		cursor.URL = last.URL
+	}
+	return encodeCursor(cursor)
+}
+
+func decodePageToken(token string, sort models.TargetSort) (pageCursor, error) {
+	var cursor pageCursor
+	if err := decodeCursor(token, &cursor); err != nil || cursor.CreatedAt.IsZero() || cursor.ID == "" {
+		return pageCursor{}, ErrInvalidPageToken
+	}
+	if cursor.Sort == "" {
+		cursor.Sort = models.SortCreatedAt
+	}
+	if cursor.Sort != sort.Field || cursor.Desc != sort.Desc {
+		return pageCursor{}, ErrInvalidPageToken
+	}
+	return cursor, nil
+}
+
+// encodeCursor turns a cursor struct into an opaque page token.
+func encodeCursor(cursor interface{}) string {
+	data, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decodeCursor reverses encodeCursor into cursor, returning
+// ErrInvalidPageToken for anything it didn't produce.
+func decodeCursor(token string, cursor interface{}) error {
+	if len(token) > maxPageTokenLength {
+		return ErrInvalidPageToken
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return ErrInvalidPageToken
+	}
+	if err := json.Unmarshal(data, cursor); err != nil {
+		return ErrInvalidPageToken
+	}
+	return nil
+}
+
+// StreamTargets calls fn for every target matching filter, in (created_at,
+// id) order, reading from a single cursor without buffering. Iteration stops
+// at the first error returned by fn.
+func (s *Storage) StreamTargets(ctx context.Context, filter models.TargetFilter, fn func(models.Target) error) error {
+	query := "SELECT " + summaryColumns + " FROM " + targetsWithLastCheck
+	where, args := targetFilter(filter)
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+
+	rows, err := s.db.QueryContext(ctx, query+" ORDER BY targets.created_at, targets.id", args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		target, err := scanTargetSummary(rows)
+		if err != nil {
+			return err
+		}
+		if err := fn(*target); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+func (s *Storage) GetAllTargets(ctx context.Context) ([]models.Target, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT "+targetColumns+" FROM targets ORDER BY created_at")
 	if err != nil {
 		return nil, err
 	}
@@ -205,29 +658,141 @@ func (s *Storage) GetAllTargets() ([]models.Target, error) {
 
 	var targets []models.Target
 	for rows.Next() {
-		var target models.Target
-		if err := rows.Scan(&target.ID, &target.URL, &target.CreatedAt); err != nil {
+		target, err := scanTarget(rows)
+		if err != nil {
 			return nil, err
 		}
-		targets = append(targets, target)
+		targets = append(targets, *target)
 	}
 
 	return targets, nil
 }
 
-func (s *Storage) GetCheckResults(targetID string, since *time.Time, limit int) (*models.CheckResultList, error) {
-	query := "SELECT checked_at, status_code, latency_ms, error FROM check_results WHERE target_id = ?"
-	args := []interface{}{targetID}
+func (s *Storage) GetTarget(ctx context.Context, targetID string) (*models.Target, error) {
+	target, err := scanTargetSummary(s.db.QueryRowContext(ctx,
+		"SELECT "+summaryColumns+" FROM "+targetsWithLastCheck+" WHERE targets.id = ?", targetID))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return target, err
+}
 
-	if since != nil {
-		query += " AND checked_at >= ?"
-		args = append(args, *since)
+// GetTargetsDue returns up to limit unpaused targets whose next check is due
+// at now, most overdue first. Targets never scheduled are due from creation.
+// When a target is next due is up to the scheduler, which records it with
+// SetNextCheckAt as it hands targets out.
+func (s *Storage) GetTargetsDue(ctx context.Context, now time.Time, limit int) ([]models.Target, error) {
+	rows, err := s.db.QueryContext(ctx,
+		"SELECT "+targetColumns+" FROM targets WHERE paused = ? AND (next_check_at IS NULL OR next_check_at <= ?) "+
+			"ORDER BY COALESCE(next_check_at, created_at), id LIMIT ?",
+		false, now.UTC(), limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var targets []models.Target
+	for rows.Next() {
+		target, err := scanTarget(rows)
+		if err != nil {
+			return nil, err
+		}
+		targets = append(targets, *target)
+	}
+	return targets, rows.Err()
+}
+
+// SetNextCheckAt records when each target is next due, by target ID.
+func (s *Storage) SetNextCheckAt(ctx context.Context, next map[string]time.Time) error {
+	if len(next) == 0 {
+		return nil
 	}
+	return retryBusy(ctx, func() error {
+		tx, err := s.db.BeginTx(ctx)
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
 
-	query += " ORDER BY checked_at DESC LIMIT ?"
-	args = append(args, limit)
+		for id, at := range next {
+			if _, err := tx.ExecContext(ctx, "UPDATE targets SET next_check_at = ? WHERE id = ?", at.UTC(), id); err != nil {
+				return err
+			}
+		}
+		return tx.Commit()
+	})
+}
 
-	rows, err := s.db.Query(query, args...)
+// NextCheckAt returns when the earliest unpaused target is due, or nil when
+// there are none.
+func (s *Storage) NextCheckAt(ctx context.Context) (*time.Time, error) {
+	// The columns are selected as such rather than through MIN(COALESCE(...)),
+	// which SQLite would return as text
+	var next sql.NullTime
+	var createdAt time.Time
+	err := s.db.QueryRowContext(ctx,
+		"SELECT next_check_at, created_at FROM targets WHERE paused = ? ORDER BY COALESCE(next_check_at, created_at) LIMIT 1",
+		false,
+	).Scan(&next, &createdAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !next.Valid {
+		return &createdAt, nil
+	}
+	return &next.Time, nil
+}
+
+// UpdateTarget applies a partial update and returns the updated target, or
+// nil if it doesn't exist.
+func (s *Storage) UpdateTarget(ctx context.Context, targetID string, update models.UpdateTargetRequest) (*models.Target, error) {
+	if update.Paused != nil {
+		res, err := s.db.ExecContext(ctx, "UPDATE targets SET paused = ? WHERE id = ?", *update.Paused, targetID)
+		if err != nil {
+			return nil, err
+		}
+		if n, err := res.RowsAffected(); err != nil || n == 0 {
+			return nil, err
+		}
+	}
+	return s.GetTarget(ctx, targetID)
+}
+
+// SetValidators stores the ETag and Last-Modified to send as conditional
+// request headers on the target's next check; nil clears them.
+func (s *Storage) SetValidators(ctx context.Context, targetID string, etag, lastModified *string) error {
+	return retryBusy(ctx, func() error {
+		_, err := s.db.ExecContext(ctx, "UPDATE targets SET etag = ?, last_modified = ? WHERE id = ?", etag, lastModified, targetID)
+		return err
+	})
+}
+
+func (s *Storage) GetCheckResults(ctx context.Context, targetID string, since *time.Time, limit int) (*models.CheckResultList, error) {
+	return s.GetCheckResultsWithFilter(ctx, targetID, models.ResultFilter{Since: since}, limit)
+}
+
+// GetCheckResultsWithFilter is GetCheckResults with additional bounds on the
+// returned results.
+func (s *Storage) GetCheckResultsWithFilter(ctx context.Context, targetID string, filter models.ResultFilter, limit int) (*models.CheckResultList, error) {
+	query, args := resultQuery(resultColumns, targetID, filter)
+
+	if filter.PageToken != "" {
+		var cursor resultCursor
+		if err := decodeCursor(filter.PageToken, &cursor); err != nil || cursor.CheckedAt.IsZero() || cursor.ID == 0 {
+			return nil, ErrInvalidPageToken
+		}
+		query += " AND (checked_at < ? OR (checked_at = ? AND id < ?))"
+		args = append(args, cursor.CheckedAt, cursor.CheckedAt, cursor.ID)
+	}
+
+	query += " ORDER BY checked_at DESC, id DESC LIMIT ?"
+	args = append(args, limit+1) // Fetch one extra to determine if there's a next page
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -235,76 +800,473 @@ func (s *Storage) GetCheckResults(targetID string, since *time.Time, limit int)
 
 	var results []models.CheckResult
 	for rows.Next() {
-		var result models.CheckResult
-		var errorStr sql.NullString
-
-		if err := rows.Scan(&result.CheckedAt, &result.StatusCode, &result.LatencyMs, &errorStr); err != nil {
+		result, err := scanCheckResult(rows)
+		if err != nil {
 			return nil, err
 		}
+		results = append(results, *result)
+	}
 
-		if errorStr.Valid {
-			result.Error = &errorStr.String
+	list := &models.CheckResultList{Items: results}
+	if len(results) > limit {
+		list.Items = results[:limit]
+		// id breaks ties between results checked at the same instant
+		last := results[limit-1]
+		list.NextPageToken = encodeCursor(resultCursor{CheckedAt: last.CheckedAt, ID: last.ID})
+	}
+
+	return list, nil
+}
+
+// resultQuery selects columns from the target's results within the filter's
+// bounds. The page token is left to the caller.
+func resultQuery(columns, targetID string, filter models.ResultFilter) (string, []interface{}) {
+	query := "SELECT " + columns + " FROM check_results WHERE target_id = ?"
+	args := []interface{}{targetID}
+
+	if filter.Since != nil {
+		query += " AND checked_at >= ?"
+		args = append(args, *filter.Since)
+	}
+
+	// Latency has no index of its own: results are read newest first from
+	// idx_check_results_target_checked and the bounds checked on the way,
+	// which a since bound keeps short
+	if filter.MinLatencyMs != nil {
+		query += " AND latency_ms >= ?"
+		args = append(args, *filter.MinLatencyMs)
+	}
+
+	if filter.MaxLatencyMs != nil {
+		query += " AND latency_ms <= ?"
+		args = append(args, *filter.MaxLatencyMs)
+	}
+
+	if filter.Failed != nil {
+		// Written to match the predicate of idx_check_results_failed, so
+		// failed results are read from that partial index
+		if *filter.Failed {
+			query += " AND error IS NOT NULL"
+		} else {
+			query += " AND error IS NULL"
 		}
+	}
+
+	return query, args
+}
+
+// StreamCheckResults calls fn for every result of the target within the
+// filter, newest first, reading from a single cursor without buffering. The
+// filter's page token is ignored. Iteration stops at the first error
+// returned by fn.
+func (s *Storage) StreamCheckResults(ctx context.Context, targetID string, filter models.ResultFilter, fn func(models.CheckResult) error) error {
+	query, args := resultQuery(resultColumns, targetID, filter)
 
-		results = append(results, result)
+	rows, err := s.db.QueryContext(ctx, query+" ORDER BY checked_at DESC, id DESC", args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		result, err := scanCheckResult(rows)
+		if err != nil {
+			return err
+		}
+		if err := fn(*result); err != nil {
+			return err
+		}
 	}
 
-	return &models.CheckResultList{Items: results}, nil
+	return rows.Err()
+}
+
+// resultCursor is the position a check results page token resumes from.
+type resultCursor struct {
+	CheckedAt time.Time `json:"checked_at"`
+	ID        int64     `json:"id"`
 }
 
-func (s *Storage) SaveCheckResult(targetID string, result models.CheckResult) error {
-	_, err := s.db.Exec(
-		"INSERT INTO check_results (target_id, checked_at, status_code, latency_ms, error) VALUES (?, ?, ?, ?, ?)",
-		targetID, result.CheckedAt, result.StatusCode, result.LatencyMs, result.Error,
+// GetLatestCheckResult returns the most recent result for a target, or nil if
+// it has never been checked. Results suppressed by a down dependency are
+// skipped unless includeSuppressed is set.
+func (s *Storage) GetLatestCheckResult(ctx context.Context, targetID string, includeSuppressed bool) (*models.CheckResult, error) {
+	query := "SELECT " + resultColumns + " FROM check_results WHERE target_id = ?"
+	if !includeSuppressed {
+		query += " AND suppressed_by IS NULL"
+	}
+	query += " ORDER BY checked_at DESC, id DESC LIMIT 1"
+
+	result, err := scanCheckResult(s.db.QueryRowContext(ctx, query, targetID))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return result, err
+}
+
+// SetAuditLog enables or disables appending every saved check result to the
+// hash-chained audit log.
+func (s *Storage) SetAuditLog(enabled bool) {
+	s.auditLog = enabled
+}
+
+// SaveCheckResult stores a result, retrying while SQLite reports the
+// database as locked by a concurrent writer.
+func (s *Storage) SaveCheckResult(ctx context.Context, targetID string, result models.CheckResult) error {
+	return s.SaveCheckResults(ctx, []TargetCheck{{TargetID: targetID, Result: result}})
+}
+
+// TargetCheck is a check result together with the target it belongs to.
+type TargetCheck struct {
+	TargetID string
+	Result   models.CheckResult
+}
+
+// SaveCheckResults stores many results in one transaction, saving a round
+// trip per result when a large cycle finishes. Like SaveCheckResult it
+// retries while the database is locked; either all results are saved or
+// none are.
+func (s *Storage) SaveCheckResults(ctx context.Context, checks []TargetCheck) error {
+	if len(checks) == 0 {
+		return nil
+	}
+	return retryBusy(ctx, func() error { return s.saveCheckResults(ctx, checks) })
+}
+
+func (s *Storage) saveCheckResults(ctx context.Context, checks []TargetCheck) error {
+	if s.auditLog {
+		s.auditMux.Lock()
+		defer s.auditMux.Unlock()
+	}
+
+	// One transaction, so a busy retry never duplicates a result
+	tx, err := s.db.BeginTx(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Looked up once per target, however many of its results the batch has
+	success := make(map[string]policy.StatusRanges)
+	for _, check := range checks {
+		ranges, ok := success[check.TargetID]
+		if !ok {
+			var successStatus sql.NullString
+			err := tx.QueryRowContext(ctx, successStatusQuery, check.TargetID).Scan(&successStatus)
+			if err != nil && err != sql.ErrNoRows {
+				return err
+			}
+			ranges = successRanges(successStatus)
+			success[check.TargetID] = ranges
+		}
+		if err := insertCheckResult(ctx, tx, check.TargetID, check.Result, ranges); err != nil {
+			return err
+		}
+	}
+
+	if !s.auditLog {
+		return tx.Commit()
+	}
+
+	// Link the new entries to the most recent one
+	var seq int64
+	var prevHash string
+	err = tx.QueryRowContext(ctx, "SELECT seq, hash FROM audit_log ORDER BY seq DESC LIMIT 1").Scan(&seq, &prevHash)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+
+	for _, check := range checks {
+		seq++
+		entry := models.AuditEntry{
+			Seq:        seq,
+			TargetID:   check.TargetID,
+			CheckedAt:  check.Result.CheckedAt.UTC().Format(time.RFC3339Nano),
+			StatusCode: check.Result.StatusCode,
+			LatencyMs:  check.Result.LatencyMs,
+			Error:      check.Result.Error,
+			PrevHash:   prevHash,
+		}
+		entry.Hash = auditHash(entry)
+
+		_, err = tx.ExecContext(ctx,
+			"INSERT INTO audit_log (seq, target_id, checked_at, status_code, latency_ms, error, prev_hash, hash) VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+			entry.Seq, entry.TargetID, entry.CheckedAt, entry.StatusCode, entry.LatencyMs, entry.Error, entry.PrevHash, entry.Hash,
+		)
+		if err != nil {
+			return err
+		}
+		prevHash = entry.Hash
+	}
+
+	return tx.Commit()
+}
+
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// insertCheckResult saves a result, counting it towards the target's failure
+// streak unless it is up by the target's success ranges.
+func insertCheckResult(ctx context.Context, db execer, targetID string, result models.CheckResult, success policy.StatusRanges) error {
+	_, err := db.ExecContext(ctx,
+		`INSERT INTO check_results (target_id, checked_at, status_code, latency_ms, latency_us, error, suppressed_by,
+			instance_id, final_url, tls_expires_at, tls_expiring, dns_ms, connect_ms, tls_ms, ttfb_ms,
+			not_modified, redirect_count, content_type, content_length, body_bytes, ip_version)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		targetID, result.CheckedAt, result.StatusCode, result.LatencyMs, result.LatencyUs, result.Error, result.SuppressedBy,
+		result.InstanceID, result.FinalURL, result.TLSExpiresAt, result.TLSExpiring,
+		result.DNSMs, result.ConnectMs, result.TLSMs, result.TTFBMs, result.NotModified, result.RedirectCount,
+		result.ContentType, result.ContentLength, result.BodyBytes, result.IPVersion,
+	)
+	if err != nil {
+		return err
+	}
+
+	// Kept on the target so listings don't scan check_results; older results
+	// arriving late must not move them backwards
+	_, err = db.ExecContext(ctx,
+		`UPDATE targets SET last_checked_at = ?,
+			consecutive_failures = CASE WHEN ? THEN 0 ELSE consecutive_failures + 1 END
+		WHERE id = ? AND (last_checked_at IS NULL OR last_checked_at < ?)`,
+		result.CheckedAt.UTC(), resultUp(result, success), targetID, result.CheckedAt.UTC(),
 	)
 	return err
 }
 
-func (s *Storage) CleanupOldIdempotencyKeys(olderThan time.Time) error {
-	_, err := s.db.Exec("DELETE FROM idempotency_keys WHERE created_at < ?", olderThan)
-	return err
+// resultUp is upConditionFor(success) for a result not yet saved.
+func resultUp(result models.CheckResult, success policy.StatusRanges) bool {
+	if result.Error != nil || result.StatusCode == nil {
+		return false
+	}
+	if len(success) == 0 {
+		return *result.StatusCode >= 200 && *result.StatusCode <= 399
+	}
+	return result.NotModified || success.Contains(*result.StatusCode)
 }
 
-func generateID(prefix string) string {
-	// Simple ID generation - in production, use UUIDs or similar
-	return fmt.Sprintf("%s%d", prefix, time.Now().UnixNano())
+// PruneCheckResults deletes results checked before olderThan and returns how
+// many were removed. Each target's most recent result is always kept, so
+// targets that are rarely checked still report a last state.
+func (s *Storage) PruneCheckResults(ctx context.Context, olderThan time.Time) (int64, error) {
+	var removed int64
+	err := retryBusy(ctx, func() error {
+		res, err := s.db.ExecContext(ctx,
+			`DELETE FROM check_results WHERE checked_at < ? AND EXISTS (
+				SELECT 1 FROM check_results newer
+				WHERE newer.target_id = check_results.target_id
+				AND (newer.checked_at > check_results.checked_at
+					OR (newer.checked_at = check_results.checked_at AND newer.id > check_results.id)))`,
+			olderThan,
+		)
+		if err != nil {
+			return err
+		}
+		removed, err = res.RowsAffected()
+		return err
+	})
+	return removed, err
 }
 
-// CanonicalizeURL converts a URL to its canonical form
-func CanonicalizeURL(rawURL string) (string, error) {
-	parsed, err := url.Parse(rawURL)
+// DeleteCheckResults removes all of a target's results and returns how many
+// were removed. The audit log is append-only and keeps its entries.
+func (s *Storage) DeleteCheckResults(ctx context.Context, targetID string) (int64, error) {
+	return s.deleteCheckResults(ctx, "DELETE FROM check_results WHERE target_id = ?", targetID)
+}
+
+// DeleteCheckResultsBefore removes a target's results checked before the
+// given time and returns how many were removed.
+func (s *Storage) DeleteCheckResultsBefore(ctx context.Context, targetID string, before time.Time) (int64, error) {
+	return s.deleteCheckResults(ctx, "DELETE FROM check_results WHERE target_id = ? AND checked_at < ?", targetID, before)
+}
+
+func (s *Storage) deleteCheckResults(ctx context.Context, query string, args ...interface{}) (int64, error) {
+	var removed int64
+	err := retryBusy(ctx, func() error {
+		res, err := s.db.ExecContext(ctx, query, args...)
+		if err != nil {
+			return err
+		}
+		removed, err = res.RowsAffected()
+		return err
+	})
+	return removed, err
+}
+
+// VerifyAuditLog walks the audit log in sequence order and recomputes each
+// hash, reporting the first entry whose hash or link doesn't match.
+func (s *Storage) VerifyAuditLog(ctx context.Context) (*models.AuditVerification, error) {
+	rows, err := s.db.QueryContext(ctx,
+		"SELECT seq, target_id, checked_at, status_code, latency_ms, error, prev_hash, hash FROM audit_log ORDER BY seq",
+	)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
+	defer rows.Close()
+
+	verification := &models.AuditVerification{Valid: true}
+	var prevHash string
+	var prevSeq int64
 
-	// Ensure scheme is present
-	if parsed.Scheme == "" {
-		return "", fmt.Errorf("missing scheme")
+	for rows.Next() {
+		var entry models.AuditEntry
+		var errorStr sql.NullString
+		if err := rows.Scan(&entry.Seq, &entry.TargetID, &entry.CheckedAt, &entry.StatusCode,
+			&entry.LatencyMs, &errorStr, &entry.PrevHash, &entry.Hash); err != nil {
+			return nil, err
+		}
+		if errorStr.Valid {
+			entry.Error = &errorStr.String
+		}
+
+		verification.Entries++
+
+		if entry.Seq != prevSeq+1 || entry.PrevHash != prevHash || entry.Hash != auditHash(entry) {
+			verification.Valid = false
+			verification.BrokenAt = &entry.Seq
+			return verification, nil
+		}
+
+		prevSeq = entry.Seq
+		prevHash = entry.Hash
 	}
 
-	// Lowercase scheme and host
-	parsed.Scheme = strings.ToLower(parsed.Scheme)
-	parsed.Host = strings.ToLower(parsed.Host)
+	return verification, rows.Err()
+}
 
-	// Remove default ports
-	switch parsed.Scheme {
-	case "http":
-		if strings.HasSuffix(parsed.Host, ":80") {
-			parsed.Host = strings.TrimSuffix(parsed.Host, ":80")
+func auditHash(entry models.AuditEntry) string {
+	statusCode := ""
+	if entry.StatusCode != nil {
+		statusCode = fmt.Sprintf("%d", *entry.StatusCode)
+	}
+	errorStr := ""
+	if entry.Error != nil {
+		errorStr = *entry.Error
+	}
+
+	h := sha256.New()
+	fmt.Fprintf(h, "%d\x00%s\x00%s\x00%s\x00%d\x00%s\x00%s",
+		entry.Seq, entry.TargetID, entry.CheckedAt, statusCode, entry.LatencyMs, errorStr, entry.PrevHash)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func (s *Storage) CreateWebhook(ctx context.Context, url string, targetID *string, template string) (*models.Webhook, error) {
+	webhook := &models.Webhook{
+		ID:        GenerateID("wh_"),
+		URL:       url,
+		TargetID:  targetID,
+		Template:  template,
+		CreatedAt: time.Now().UTC(),
+	}
+
+	_, err := s.db.ExecContext(ctx, "INSERT INTO webhooks (id, url, target_id, template, created_at) VALUES (?, ?, ?, ?, ?)",
+		webhook.ID, webhook.URL, webhook.TargetID, webhook.Template, webhook.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return webhook, nil
+}
+
+// GetWebhooksForTarget returns the webhooks registered for the given target
+// along with any global webhooks (those without a target).
+func (s *Storage) GetWebhooksForTarget(ctx context.Context, targetID string) ([]models.Webhook, error) {
+	rows, err := s.db.QueryContext(ctx,
+		"SELECT id, url, target_id, template, created_at FROM webhooks WHERE target_id IS NULL OR target_id = ? ORDER BY created_at, id",
+		targetID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var webhooks []models.Webhook
+	for rows.Next() {
+		var webhook models.Webhook
+		var hookTargetID sql.NullString
+		if err := rows.Scan(&webhook.ID, &webhook.URL, &hookTargetID, &webhook.Template, &webhook.CreatedAt); err != nil {
+			return nil, err
 		}
-	case "https":
-		if strings.HasSuffix(parsed.Host, ":443") {
-			parsed.Host = strings.TrimSuffix(parsed.Host, ":443")
+		if hookTargetID.Valid {
+			webhook.TargetID = &hookTargetID.String
 		}
+		webhooks = append(webhooks, webhook)
+	}
+
+	return webhooks, rows.Err()
+}
+
+// GetCheckFilter returns the saved check filter, or an empty filter that
+// includes every target when none has been saved.
+func (s *Storage) GetCheckFilter(ctx context.Context) (*models.CheckFilter, error) {
+	filter := &models.CheckFilter{ExcludeHosts: []string{}}
+
+	var excludeHosts string
+	err := s.db.QueryRowContext(ctx, "SELECT exclude_hosts FROM check_filter WHERE id = 1").Scan(&excludeHosts)
+	if err == sql.ErrNoRows {
+		return filter, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal([]byte(excludeHosts), &filter.ExcludeHosts); err != nil {
+		return nil, err
+	}
+	return filter, nil
+}
+
+func (s *Storage) SaveCheckFilter(ctx context.Context, filter models.CheckFilter) error {
+	excludeHosts, err := json.Marshal(filter.ExcludeHosts)
+	if err != nil {
+		return err
 	}
 
-	// Remove fragment
-	parsed.Fragment = ""
+	_, err = s.db.ExecContext(ctx,
+		`INSERT INTO check_filter (id, exclude_hosts, updated_at) VALUES (1, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET exclude_hosts = excluded.exclude_hosts, updated_at = excluded.updated_at`,
+		string(excludeHosts), time.Now().UTC(),
+	)
+	return err
+}
+
+// SetIdempotencyTTL sets how long idempotency keys are honoured; zero keeps
+// them forever.
+func (s *Storage) SetIdempotencyTTL(ttl time.Duration) {
+	s.idempotencyTTL = ttl
+}
+
+// CleanupOldIdempotencyKeys deletes keys created before olderThan and
+// returns how many were removed.
+func (s *Storage) CleanupOldIdempotencyKeys(ctx context.Context, olderThan time.Time) (int64, error) {
+	var removed int64
+	err := retryBusy(ctx, func() error {
+		res, err := s.db.ExecContext(ctx, "DELETE FROM idempotency_keys WHERE created_at < ?", olderThan.UTC())
+		if err != nil {
+			return err
+		}
+		removed, err = res.RowsAffected()
+		return err
+	})
+	return removed, err
+}
+
+// GenerateID returns prefix followed by a UUIDv7: 48 bits of millisecond
+// timestamp then 74 random bits, so IDs sort by creation time but can't
+// collide between concurrent creates.
+func GenerateID(prefix string) string {
+	var uuid [16]byte
+	if _, err := rand.Read(uuid[6:]); err != nil {
+		panic(fmt.Sprintf("read random bytes: %v", err))
+	}
 
-	// Normalize path - remove trailing slash unless it's root
-	if parsed.Path != "/" && strings.HasSuffix(parsed.Path, "/") {
-		parsed.Path = strings.TrimSuffix(parsed.Path, "/")
+	ms := uint64(time.Now().UnixMilli())
+	for i := 5; i >= 0; i-- {
+		uuid[i] = byte(ms)
+		ms >>= 8
 	}
+	uuid[6] = uuid[6]&0x0f | 0x70 // Version 7
+	uuid[8] = uuid[8]&0x3f | 0x80 // RFC 4122 variant
 
-	return parsed.String(), nil
+	return fmt.Sprintf("%s%x-%x-%x-%x-%x", prefix, uuid[0:4], uuid[4:6], uuid[6:8], uuid[8:10], uuid[10:16])
 }
@@ -0,0 +1,146 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// dialect is the SQL flavour of the underlying driver. Queries are written
+// for SQLite with ? placeholders and rewritten where Postgres differs.
+type dialect int
+
+const (
+	dialectSQLite dialect = iota
+	dialectPostgres
+)
+
+func detectDialect(conn *sql.DB) dialect {
+	if _, ok := conn.Driver().(*pq.Driver); ok {
+		return dialectPostgres
+	}
+	return dialectSQLite
+}
+
+// rebind rewrites ? placeholders as $1, $2, ... for Postgres. Question marks
+// inside quoted literals are left alone.
+func (d dialect) rebind(query string) string {
+	if d != dialectPostgres || !strings.Contains(query, "?") {
+		return query
+	}
+
+	var b strings.Builder
+	b.Grow(len(query) + 8)
+	n := 0
+	inQuote := false
+	for _, r := range query {
+		switch {
+		case r == '\'':
+			inQuote = !inQuote
+		case r == '?' && !inQuote:
+			n++
+			b.WriteByte('$')
+			b.WriteString(strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// schema adapts SQLite column definitions in DDL to the dialect.
+func (d dialect) schema(ddl string) string {
+	if d != dialectPostgres {
+		return ddl
+	}
+	return strings.ReplaceAll(ddl, "INTEGER PRIMARY KEY AUTOINCREMENT", "BIGSERIAL PRIMARY KEY")
+}
+
+// isUniqueViolation reports whether err is a write rejected by a UNIQUE
+// constraint, on either database.
+func isUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return pqErr.Code == "23505"
+	}
+	return err != nil && strings.Contains(err.Error(), "UNIQUE constraint failed")
+}
+
+// db is a *sql.DB whose queries are rebound for its dialect, so storage code
+// can use ? placeholders regardless of the driver.
+type db struct {
+	*sql.DB
+	dialect dialect
+}
+
+func (d *db) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return d.DB.Exec(d.dialect.rebind(query), args...)
+}
+
+func (d *db) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	return d.DB.Query(d.dialect.rebind(query), args...)
+}
+
+func (d *db) QueryRow(query string, args ...interface{}) *sql.Row {
+	return d.DB.QueryRow(d.dialect.rebind(query), args...)
+}
+
+func (d *db) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	return d.DB.ExecContext(ctx, d.dialect.rebind(query), args...)
+}
+
+func (d *db) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	return d.DB.QueryContext(ctx, d.dialect.rebind(query), args...)
+}
+
+func (d *db) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	return d.DB.QueryRowContext(ctx, d.dialect.rebind(query), args...)
+}
+
+func (d *db) Begin() (*tx, error) {
+	return d.BeginTx(context.Background())
+}
+
+// BeginTx starts a transaction that is rolled back if ctx is done before it
+// commits.
+func (d *db) BeginTx(ctx context.Context) (*tx, error) {
+	t, err := d.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &tx{Tx: t, dialect: d.dialect}, nil
+}
+
+// tx is the transaction counterpart of db.
+type tx struct {
+	*sql.Tx
+	dialect dialect
+}
+
+func (t *tx) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return t.Tx.Exec(t.dialect.rebind(query), args...)
+}
+
+func (t *tx) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	return t.Tx.Query(t.dialect.rebind(query), args...)
+}
+
+func (t *tx) QueryRow(query string, args ...interface{}) *sql.Row {
+	return t.Tx.QueryRow(t.dialect.rebind(query), args...)
+}
+
+func (t *tx) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	return t.Tx.ExecContext(ctx, t.dialect.rebind(query), args...)
+}
+
+func (t *tx) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	return t.Tx.QueryContext(ctx, t.dialect.rebind(query), args...)
+}
+
+func (t *tx) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	return t.Tx.QueryRowContext(ctx, t.dialect.rebind(query), args...)
+}
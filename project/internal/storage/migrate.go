@@ -0,0 +1,378 @@
+package storage
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// migration is one step in the history of the schema. Steps run in version
+// order, each in its own transaction, and are recorded in schema_migrations
+// so every database runs each of them once.
+type migration struct {
+	version int
+	name    string
+	apply   func(t *tx) error
+}
+
+// migrations is the history of the schema. Changes are made by appending a
+// step with the next version; steps that may already have run are never
+// edited or reordered. The first three predate versioning and are
+// idempotent, so databases created before it adopt them unchanged.
+var migrations = []migration{
+	{1, "initial schema", execSchema(initialSchema)},
+	{2, "columns added before versioning", addColumns(preVersioningColumns...)},
+	{3, "index targets by next check", execSchema("CREATE INDEX IF NOT EXISTS idx_targets_next_check ON targets(next_check_at)")},
+	{4, "response content type and size", addColumns(
+		column{"check_results", "content_type", "TEXT"},
+		column{"check_results", "content_length", "BIGINT"},
+		column{"check_results", "body_bytes", "BIGINT"},
+	)},
+	{5, "index targets by host", addTargetHosts},
+	{6, "basic auth credentials", addColumns(
+		column{"targets", "username", "TEXT"},
+		column{"targets", "password", "TEXT"},
+	)},
+	{7, "index failed check results", execSchema(`
+		CREATE INDEX IF NOT EXISTS idx_check_results_failed
+			ON check_results(target_id, checked_at DESC) WHERE error IS NOT NULL`)},
+	{8, "maintenance windows", addColumns(column{"targets", "maintenance_windows", "TEXT"})},
+	{9, "consecutive failures", addConsecutiveFailures},
+	{10, "ip version", addColumns(
+		column{"targets", "ip_version", "TEXT"},
+		column{"check_results", "ip_version", "TEXT"},
+	)},
+	{11, "drop unique original url", dropOriginalURLUnique},
+}
+
+const initialSchema = `
+	CREATE TABLE IF NOT EXISTS targets (
+		id TEXT PRIMARY KEY,
+		url TEXT NOT NULL UNIQUE,
+		canonical_url TEXT NOT NULL UNIQUE,
+		created_at TIMESTAMP NOT NULL
+	);
+
+	CREATE TABLE IF NOT EXISTS check_results (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		target_id TEXT NOT NULL REFERENCES targets(id),
+		checked_at TIMESTAMP NOT NULL,
+		status_code INTEGER,
+		latency_ms INTEGER NOT NULL,
+		error TEXT,
+		FOREIGN KEY (target_id) REFERENCES targets(id)
+	);
+
+	CREATE TABLE IF NOT EXISTS idempotency_keys (
+		key TEXT PRIMARY KEY,
+		target_id TEXT NOT NULL,
+		created_at TIMESTAMP NOT NULL,
+		FOREIGN KEY (target_id) REFERENCES targets(id)
+	);
+
+	CREATE TABLE IF NOT EXISTS webhooks (
+		id TEXT PRIMARY KEY,
+		url TEXT NOT NULL,
+		target_id TEXT,
+		template TEXT NOT NULL DEFAULT '',
+		created_at TIMESTAMP NOT NULL,
+		FOREIGN KEY (target_id) REFERENCES targets(id)
+	);
+
+	CREATE TABLE IF NOT EXISTS audit_log (
+		seq INTEGER PRIMARY KEY,
+		target_id TEXT NOT NULL,
+		checked_at TEXT NOT NULL,
+		status_code INTEGER,
+		latency_ms INTEGER NOT NULL,
+		error TEXT,
+		prev_hash TEXT NOT NULL,
+		hash TEXT NOT NULL
+	);
+
+	CREATE TABLE IF NOT EXISTS check_filter (
+		id INTEGER PRIMARY KEY CHECK (id = 1),
+		exclude_hosts TEXT NOT NULL,
+		updated_at TIMESTAMP NOT NULL
+	);
+
+	CREATE TABLE IF NOT EXISTS profiles (
+		id TEXT PRIMARY KEY,
+		name TEXT NOT NULL,
+		success_status TEXT,
+		timeout_ms INTEGER,
+		created_at TIMESTAMP NOT NULL,
+		updated_at TIMESTAMP NOT NULL
+	);
+
+	CREATE TABLE IF NOT EXISTS check_queue (
+		target_id TEXT PRIMARY KEY,
+		enqueued_at TIMESTAMP NOT NULL,
+		claimed_by TEXT,
+		lease_expires_at TIMESTAMP,
+		FOREIGN KEY (target_id) REFERENCES targets(id)
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_check_results_target_checked
+		ON check_results(target_id, checked_at DESC);
+	CREATE INDEX IF NOT EXISTS idx_targets_created_id
+		ON targets(created_at, id);
+	CREATE INDEX IF NOT EXISTS idx_idempotency_created
+		ON idempotency_keys(created_at);
+`
+
+// column is a column added to an existing table.
+type column struct {
+	table      string
+	name       string
+	definition string
+}
+
+// preVersioningColumns are the columns added after the initial schema and
+// before migrations were versioned.
+var preVersioningColumns = []column{
+	{"targets", "depends_on", "TEXT REFERENCES targets(id)"},
+	{"check_results", "suppressed_by", "TEXT"},
+	{"check_results", "instance_id", "TEXT NOT NULL DEFAULT ''"},
+	{"check_results", "latency_us", "INTEGER"},
+	{"check_results", "final_url", "TEXT"},
+	{"check_results", "tls_expires_at", "TIMESTAMP"},
+	{"check_results", "tls_expiring", "BOOLEAN NOT NULL DEFAULT FALSE"},
+	{"check_results", "dns_ms", "INTEGER"},
+	{"check_results", "connect_ms", "INTEGER"},
+	{"check_results", "tls_ms", "INTEGER"},
+	{"check_results", "ttfb_ms", "INTEGER"},
+	{"check_results", "not_modified", "BOOLEAN NOT NULL DEFAULT FALSE"},
+	{"check_results", "redirect_count", "INTEGER"},
+	{"targets", "success_status", "TEXT"},
+	{"targets", "timeout_ms", "INTEGER"},
+	{"targets", "profile_id", "TEXT REFERENCES profiles(id)"},
+	{"targets", "paused", "BOOLEAN NOT NULL DEFAULT FALSE"},
+	{"targets", "check_interval", "TEXT"},
+	{"targets", "last_checked_at", "TIMESTAMP"},
+	{"targets", "headers", "TEXT"},
+	{"targets", "expected_body", "TEXT"},
+	{"targets", "body_regex", "TEXT"},
+	{"targets", "etag", "TEXT"},
+	{"targets", "last_modified", "TEXT"},
+	{"targets", "next_check_at", "TIMESTAMP"},
+}
+
+// Migrate brings the schema up to date by running the migrations the
+// database hasn't recorded yet. It is safe on fresh databases, on ones
+// created before migrations were versioned, and when several instances
+// start at once. Versions recorded by a newer release are left alone.
+func (s *Storage) Migrate() error {
+	_, err := s.db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		name TEXT NOT NULL,
+		applied_at TIMESTAMP NOT NULL
+	)`)
+	if err != nil {
+		return err
+	}
+
+	applied, err := s.appliedMigrations()
+	if err != nil {
+		return err
+	}
+
+	for _, m := range migrations {
+		if applied[m.version] {
+			continue
+		}
+		if err := s.runMigration(m); err != nil {
+			// Another instance may have run it first, failing our insert
+			if applied, checkErr := s.appliedMigrations(); checkErr == nil && applied[m.version] {
+				continue
+			}
+			return fmt.Errorf("migration %d (%s): %w", m.version, m.name, err)
+		}
+	}
+	return nil
+}
+
+func (s *Storage) appliedMigrations() (map[int]bool, error) {
+	rows, err := s.db.Query("SELECT version FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := make(map[int]bool)
+	for rows.Next() {
+		var version int
+		if err := rows.Scan(&version); err != nil {
+			return nil, err
+		}
+		applied[version] = true
+	}
+	return applied, rows.Err()
+}
+
+// runMigration applies m and records it in one transaction, so a failed
+// step leaves neither changes nor a record behind.
+func (s *Storage) runMigration(m migration) error {
+	t, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer t.Rollback()
+
+	if err := m.apply(t); err != nil {
+		return err
+	}
+	_, err = t.Exec("INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)",
+		m.version, m.name, time.Now().UTC())
+	if err != nil {
+		return err
+	}
+	return t.Commit()
+}
+
+// execSchema returns a step running ddl, adapted to the dialect.
+func execSchema(ddl string) func(t *tx) error {
+	return func(t *tx) error {
+		_, err := t.Exec(t.dialect.schema(ddl))
+		return err
+	}
+}
+
+// addTargetHosts adds targets.host, the hostname of the canonical URL, so
+// listings filter on it exactly instead of matching URLs with LIKE, and
+// fills it in for existing targets.
+func addTargetHosts(t *tx) error {
+	if err := addColumns(column{"targets", "host", "TEXT"})(t); err != nil {
+		return err
+	}
+
+	rows, err := t.Query("SELECT id, canonical_url FROM targets WHERE host IS NULL")
+	if err != nil {
+		return err
+	}
+	hosts := make(map[string]string)
+	for rows.Next() {
+		var id, canonicalURL string
+		if err := rows.Scan(&id, &canonicalURL); err != nil {
+			rows.Close()
+			return err
+		}
+		hosts[id] = hostOf(canonicalURL)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for id, host := range hosts {
+		if _, err := t.Exec("UPDATE targets SET host = ? WHERE id = ?", host, id); err != nil {
+			return err
+		}
+	}
+	_, err = t.Exec("CREATE INDEX IF NOT EXISTS idx_targets_host ON targets(host)")
+	return err
+}
+
+// addConsecutiveFailures adds the failure streak of each target, counted
+// from its results since the latest one that was up.
+func addConsecutiveFailures(t *tx) error {
+	if err := addColumns(column{"targets", "consecutive_failures", "INTEGER NOT NULL DEFAULT 0"})(t); err != nil {
+		return err
+	}
+	// Unqualified columns resolve to the innermost table, so upCondition
+	// applies to r and to newer in turn
+	_, err := t.Exec(`UPDATE targets SET consecutive_failures = (
+		SELECT COUNT(*) FROM check_results r
+		WHERE r.target_id = targets.id AND NOT (CASE WHEN ` + upCondition + ` THEN TRUE ELSE FALSE END)
+		AND NOT EXISTS (
+			SELECT 1 FROM check_results newer
+			WHERE newer.target_id = r.target_id AND newer.checked_at > r.checked_at AND ` + upCondition + `))`)
+	return err
+}
+
+// originalURLUnique matches the UNIQUE constraint on targets.url in the
+// SQLite table definition, without matching the one on canonical_url.
+var originalURLUnique = regexp.MustCompile(`\burl TEXT NOT NULL UNIQUE`)
+
+// dropOriginalURLUnique drops the UNIQUE constraint on targets.url. Targets
+// are deduplicated by canonical URL, so the original URL needn't be unique,
+// and the constraint rejected a URL submitted again after canonicalization
+// changed. SQLite can't drop a constraint, so there the table is rebuilt
+// without it and its indexes recreated.
+func dropOriginalURLUnique(t *tx) error {
+	if t.dialect == dialectPostgres {
+		_, err := t.Exec("ALTER TABLE targets DROP CONSTRAINT IF EXISTS targets_url_key")
+		return err
+	}
+
+	var ddl string
+	if err := t.QueryRow("SELECT sql FROM sqlite_master WHERE type = 'table' AND name = 'targets'").Scan(&ddl); err != nil {
+		return err
+	}
+	if !originalURLUnique.MatchString(ddl) {
+		return nil
+	}
+
+	rows, err := t.Query("SELECT sql FROM sqlite_master WHERE type = 'index' AND tbl_name = 'targets' AND sql IS NOT NULL")
+	if err != nil {
+		return err
+	}
+	var indexes []string
+	for rows.Next() {
+		var index string
+		if err := rows.Scan(&index); err != nil {
+			rows.Close()
+			return err
+		}
+		indexes = append(indexes, index)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	ddl = originalURLUnique.ReplaceAllString(ddl, "url TEXT NOT NULL")
+	ddl = strings.Replace(ddl, "CREATE TABLE targets", "CREATE TABLE targets_new", 1)
+	steps := append([]string{
+		ddl,
+		"INSERT INTO targets_new SELECT * FROM targets",
+		"DROP TABLE targets",
+		"ALTER TABLE targets_new RENAME TO targets",
+	}, indexes...)
+	for _, step := range steps {
+		if _, err := t.Exec(step); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addColumns returns a step adding columns, skipping any that already
+// exist.
+func addColumns(columns ...column) func(t *tx) error {
+	return func(t *tx) error {
+		for _, c := range columns {
+			if err := addColumn(t, c); err != nil {
+				return fmt.Errorf("add column %s.%s: %w", c.table, c.name, err)
+			}
+		}
+		return nil
+	}
+}
+
+// addColumn adds a column, treating an already existing column as success.
+// Postgres aborts a transaction on any error, so there the check is left to
+// IF NOT EXISTS instead.
+func addColumn(t *tx, c column) error {
+	if t.dialect == dialectPostgres {
+		_, err := t.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN IF NOT EXISTS %s %s", c.table, c.name, c.definition))
+		return err
+	}
+
+	_, err := t.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", c.table, c.name, c.definition))
+	if err != nil && strings.Contains(err.Error(), "duplicate column") {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,139 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/aarushishahhh/linkwatch/project/internal/models"
+)
+
+const profileColumns = "id, name, success_status, timeout_ms, created_at, updated_at"
+
+func scanProfile(row rowScanner) (*models.Profile, error) {
+	var profile models.Profile
+	var successStatus sql.NullString
+	var timeoutMs sql.NullInt64
+	if err := row.Scan(&profile.ID, &profile.Name, &successStatus, &timeoutMs, &profile.CreatedAt,
+		&profile.UpdatedAt); err != nil {
+		return nil, err
+	}
+	if successStatus.Valid {
+		profile.SuccessStatus = &successStatus.String
+	}
+	if timeoutMs.Valid {
+		ms := int(timeoutMs.Int64)
+		profile.TimeoutMs = &ms
+	}
+	return &profile, nil
+}
+
+func (s *Storage) CreateProfile(ctx context.Context, req models.ProfileRequest) (*models.Profile, error) {
+	now := time.Now().UTC()
+	profile := &models.Profile{
+		ID:            GenerateID("p_"),
+		Name:          req.Name,
+		SuccessStatus: req.SuccessStatus,
+		TimeoutMs:     req.TimeoutMs,
+		CreatedAt:     now,
+		UpdatedAt:     now,
+	}
+
+	_, err := s.db.ExecContext(ctx,
+		"INSERT INTO profiles (id, name, success_status, timeout_ms, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?)",
+		profile.ID, profile.Name, profile.SuccessStatus, profile.TimeoutMs, profile.CreatedAt, profile.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return profile, nil
+}
+
+// GetProfile returns the profile, or nil if it doesn't exist.
+func (s *Storage) GetProfile(ctx context.Context, id string) (*models.Profile, error) {
+	profile, err := scanProfile(s.db.QueryRowContext(ctx, "SELECT "+profileColumns+" FROM profiles WHERE id = ?", id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return profile, err
+}
+
+func (s *Storage) ListProfiles(ctx context.Context) (*models.ProfileList, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT "+profileColumns+" FROM profiles ORDER BY created_at, id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := &models.ProfileList{Items: []models.Profile{}}
+	for rows.Next() {
+		profile, err := scanProfile(rows)
+		if err != nil {
+			return nil, err
+		}
+		list.Items = append(list.Items, *profile)
+	}
+	return list, rows.Err()
+}
+
+// UpdateProfile replaces the profile's settings; every target using it picks
+// them up on its next check. Returns nil if the profile doesn't exist.
+func (s *Storage) UpdateProfile(ctx context.Context, id string, req models.ProfileRequest) (*models.Profile, error) {
+	res, err := s.db.ExecContext(ctx,
+		"UPDATE profiles SET name = ?, success_status = ?, timeout_ms = ?, updated_at = ? WHERE id = ?",
+		req.Name, req.SuccessStatus, req.TimeoutMs, time.Now().UTC(), id,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return nil, err
+	}
+	return s.GetProfile(ctx, id)
+}
+
+// DeleteProfile removes a profile and detaches its targets, which fall back
+// to their own settings. Reports whether the profile existed.
+func (s *Storage) DeleteProfile(ctx context.Context, id string) (bool, error) {
+	tx, err := s.db.BeginTx(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, "UPDATE targets SET profile_id = NULL WHERE profile_id = ?", id); err != nil {
+		return false, err
+	}
+
+	res, err := tx.ExecContext(ctx, "DELETE FROM profiles WHERE id = ?", id)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return n > 0, tx.Commit()
+}
+
+// ResolveTarget returns the target with any settings it leaves unset filled
+// in from its profile. Targets without a profile are returned unchanged.
+func (s *Storage) ResolveTarget(ctx context.Context, target models.Target) (models.Target, error) {
+	if target.ProfileID == nil {
+		return target, nil
+	}
+
+	profile, err := s.GetProfile(ctx, *target.ProfileID)
+	if err != nil || profile == nil {
+		return target, err
+	}
+
+	if target.SuccessStatus == nil {
+		target.SuccessStatus = profile.SuccessStatus
+	}
+	if target.TimeoutMs == nil {
+		target.TimeoutMs = profile.TimeoutMs
+	}
+	return target, nil
+}
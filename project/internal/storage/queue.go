@@ -0,0 +1,106 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	"github.com/aarushishahhh/linkwatch/project/internal/models"
+)
+
+// EnqueueChecks adds targets to the check queue. A target that is already
+// queued (claimed or not) is left alone, so a slow cycle never piles up
+// duplicate work. Returns the number of targets newly queued.
+func (s *Storage) EnqueueChecks(ctx context.Context, targetIDs []string) (int, error) {
+	tx, err := s.db.BeginTx(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC()
+	queued := 0
+	for _, id := range targetIDs {
+		res, err := tx.ExecContext(ctx,
+			"INSERT INTO check_queue (target_id, enqueued_at) VALUES (?, ?) ON CONFLICT (target_id) DO NOTHING",
+			id, now,
+		)
+		if err != nil {
+			return 0, err
+		}
+		if n, err := res.RowsAffected(); err == nil {
+			queued += int(n)
+		}
+	}
+
+	return queued, tx.Commit()
+}
+
+// ClaimChecks leases up to limit queued targets to workerID, oldest first.
+// Entries whose lease has expired (a worker died mid-check) are claimable
+// again. Each claim is a conditional update, so concurrent workers sharing
+// the database never claim the same entry.
+func (s *Storage) ClaimChecks(ctx context.Context, workerID string, limit int, lease time.Duration) ([]models.Target, error) {
+	now := time.Now().UTC()
+
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT target_id FROM check_queue
+		WHERE claimed_by IS NULL OR lease_expires_at < ?
+		ORDER BY enqueued_at, target_id LIMIT ?`,
+		now, limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	var candidates []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		candidates = append(candidates, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var claimed []models.Target
+	for _, id := range candidates {
+		res, err := s.db.ExecContext(ctx,
+			`UPDATE check_queue SET claimed_by = ?, lease_expires_at = ?
+			WHERE target_id = ? AND (claimed_by IS NULL OR lease_expires_at < ?)`,
+			workerID, now.Add(lease), id, now,
+		)
+		if err != nil {
+			return nil, err
+		}
+		if n, err := res.RowsAffected(); err != nil || n == 0 {
+			continue // Another worker got there first
+		}
+
+		target, err := s.GetTarget(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		if target == nil {
+			// Target no longer exists; drop the entry
+			if err := s.CompleteCheck(ctx, id, workerID); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		claimed = append(claimed, *target)
+	}
+
+	return claimed, nil
+}
+
+// CompleteCheck removes a claimed entry from the queue. It only succeeds for
+// the worker holding the lease, so a worker whose lease expired and was
+// reclaimed can't remove the new claim.
+func (s *Storage) CompleteCheck(ctx context.Context, targetID, workerID string) error {
+	_, err := s.db.ExecContext(ctx, "DELETE FROM check_queue WHERE target_id = ? AND claimed_by = ?", targetID, workerID)
+	return err
+}
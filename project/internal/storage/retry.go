@@ -0,0 +1,48 @@
+package storage
+
+import (
+	"context"
+	"math/rand"
+	"strings"
+	"time"
+)
+
+// Bounds for retrying writes that hit a locked SQLite database
+const (
+	busyMaxAttempts = 10
+	busyBaseBackoff = 5 * time.Millisecond
+	busyMaxBackoff  = 200 * time.Millisecond
+)
+
+// isBusy reports whether err is SQLite refusing a write because another
+// connection holds the lock (SQLITE_BUSY / SQLITE_LOCKED).
+func isBusy(err error) bool {
+	if err == nil {
+		return false
+	}
+	msg := err.Error()
+	return strings.Contains(msg, "database is locked") || strings.Contains(msg, "database table is locked")
+}
+
+// retryBusy runs fn, retrying with jittered exponential backoff while it
+// fails with a busy error. The jitter keeps competing writers from retrying
+// in lockstep. Other errors, and the last busy error, are returned as is;
+// so is the busy error once ctx is done, rather than waiting out a backoff.
+func retryBusy(ctx context.Context, fn func() error) error {
+	backoff := busyBaseBackoff
+	var err error
+	for attempt := 0; attempt < busyMaxAttempts; attempt++ {
+		if err = fn(); !isBusy(err) {
+			return err
+		}
+		timer := time.NewTimer(backoff/2 + time.Duration(rand.Int63n(int64(backoff))))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+		backoff = min(backoff*2, busyMaxBackoff)
+	}
+	return err
+}
@@ -0,0 +1,241 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aarushishahhh/linkwatch/project/internal/models"
+	"github.com/aarushishahhh/linkwatch/project/internal/policy"
+)
+
+// upCondition is true for check results that count as up
+const upCondition = "error IS NULL AND status_code BETWEEN 200 AND 399"
+
+// upConditionFor is upCondition for a target whose success_status lists the
+// codes that count as up instead, matching resultUp and webhook.StateFor.
+func upConditionFor(success policy.StatusRanges) string {
+	if len(success) == 0 {
+		return upCondition
+	}
+
+	listed := []string{"not_modified"}
+	for _, r := range success {
+		listed = append(listed, fmt.Sprintf("status_code BETWEEN %d AND %d", r.Min, r.Max))
+	}
+	return "error IS NULL AND status_code IS NOT NULL AND (" + strings.Join(listed, " OR ") + ")"
+}
+
+// successStatusQuery selects the success_status a target's results are
+// judged by: its own, or else its profile's.
+const successStatusQuery = `SELECT COALESCE(t.success_status, p.success_status)
+	FROM targets t LEFT JOIN profiles p ON p.id = t.profile_id WHERE t.id = ?`
+
+// successRanges parses a success_status, returning nil for the default
+// 2xx/3xx rule. It was validated when set, so a parse error only means no
+// override.
+func successRanges(spec sql.NullString) policy.StatusRanges {
+	if !spec.Valid {
+		return nil
+	}
+	ranges, _ := policy.ParseStatusRanges(spec.String)
+	return ranges
+}
+
+// GetCheckStats aggregates a target's checks since the given time. A check
+// counts as up when it got a response without error whose status the
+// target's success_status lists, 2xx/3xx by default. Percentiles use the
+// nearest-rank method. With no checks in the window only TotalChecks and
+// FlapCount are set.
+func (s *Storage) GetCheckStats(ctx context.Context, targetID string, since time.Time) (*models.CheckStats, error) {
+	stats := &models.CheckStats{TargetID: targetID, Since: since}
+
+	err := s.db.QueryRowContext(ctx, "SELECT consecutive_failures FROM targets WHERE id = ?", targetID).Scan(&stats.ConsecutiveFailures)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+	var successStatus sql.NullString
+	err = s.db.QueryRowContext(ctx, successStatusQuery, targetID).Scan(&successStatus)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+	up := upConditionFor(successRanges(successStatus))
+
+	var upCount sql.NullInt64
+	var avgLatency sql.NullFloat64
+	err = s.db.QueryRowContext(ctx,
+		`SELECT COUNT(*),
+			SUM(CASE WHEN `+up+` THEN 1 ELSE 0 END),
+			AVG(latency_ms)
+		FROM check_results WHERE target_id = ? AND checked_at >= ?`,
+		targetID, since,
+	).Scan(&stats.TotalChecks, &upCount, &avgLatency)
+	if err != nil {
+		return nil, err
+	}
+
+	if stats.TotalChecks == 0 {
+		return stats, nil
+	}
+
+	uptime := 100 * float64(upCount.Int64) / float64(stats.TotalChecks)
+	stats.UptimePercent = &uptime
+	stats.AvgLatencyMs = &avgLatency.Float64
+
+	if stats.P50LatencyMs, err = s.latencyPercentile(ctx, targetID, since, stats.TotalChecks, 0.50); err != nil {
+		return nil, err
+	}
+	if stats.P95LatencyMs, err = s.latencyPercentile(ctx, targetID, since, stats.TotalChecks, 0.95); err != nil {
+		return nil, err
+	}
+
+	if stats.FlapCount, err = s.flapCount(ctx, targetID, since, up); err != nil {
+		return nil, err
+	}
+	stability := 100.0
+	if stats.TotalChecks > 1 {
+		stability = 100 * (1 - float64(stats.FlapCount)/float64(stats.TotalChecks-1))
+	}
+	stats.StabilityPercent = &stability
+	return stats, nil
+}
+
+// GetHostLatencyStats aggregates the checks made since the given time by
+// the host of their target, slowest p95 first. Percentiles use the
+// nearest-rank method, as in GetCheckStats; hosts without checks in the
+// window are left out.
+//
+// Counts and averages are grouped in SQL. Postgres computes the percentiles
+// in the same query with percentile_disc; SQLite has no such aggregate, so
+// each one is a bounded per-host lookup run in the same transaction, which
+// keeps them consistent with the counts while results are pruned.
+func (s *Storage) GetHostLatencyStats(ctx context.Context, since time.Time) ([]models.HostLatencyStats, error) {
+	t, err := s.db.BeginTx(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer t.Rollback()
+
+	postgres := s.db.dialect == dialectPostgres
+	percentiles := ""
+	if postgres {
+		percentiles = `,
+		percentile_disc(0.50) WITHIN GROUP (ORDER BY r.latency_ms),
+		percentile_disc(0.95) WITHIN GROUP (ORDER BY r.latency_ms),
+		percentile_disc(0.99) WITHIN GROUP (ORDER BY r.latency_ms)`
+	}
+	rows, err := t.QueryContext(ctx,
+		`SELECT t.host, COUNT(DISTINCT r.target_id), COUNT(*),
+		SUM(CASE WHEN r.error IS NOT NULL THEN 1 ELSE 0 END), AVG(r.latency_ms)`+percentiles+`
+		FROM check_results r JOIN targets t ON t.id = r.target_id
+		WHERE r.checked_at >= ? AND t.host IS NOT NULL
+		GROUP BY t.host`,
+		since,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	hosts := []models.HostLatencyStats{}
+	for rows.Next() {
+		var h models.HostLatencyStats
+		dest := []interface{}{&h.Host, &h.TargetCount, &h.TotalChecks, &h.FailedChecks, &h.AvgLatencyMs}
+		if postgres {
+			dest = append(dest, &h.P50LatencyMs, &h.P95LatencyMs, &h.P99LatencyMs)
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+		hosts = append(hosts, h)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	if !postgres {
+		for i := range hosts {
+			h := &hosts[i]
+			for _, pct := range []struct {
+				p    float64
+				dest *int
+			}{{0.50, &h.P50LatencyMs}, {0.95, &h.P95LatencyMs}, {0.99, &h.P99LatencyMs}} {
+				if err := hostLatencyPercentile(ctx, t, h.Host, since, h.TotalChecks, pct.p, pct.dest); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+
+	sort.Slice(hosts, func(i, j int) bool {
+		if hosts[i].P95LatencyMs != hosts[j].P95LatencyMs {
+			return hosts[i].P95LatencyMs > hosts[j].P95LatencyMs
+		}
+		return hosts[i].Host < hosts[j].Host
+	})
+	return hosts, nil
+}
+
+// hostLatencyPercentile stores the nearest-rank percentile p of the count
+// latencies checked for host in the window into dest.
+func hostLatencyPercentile(ctx context.Context, t *tx, host string, since time.Time, count int, p float64, dest *int) error {
+	rank := int(math.Ceil(p * float64(count)))
+	err := t.QueryRowContext(ctx,
+		`SELECT r.latency_ms FROM check_results r JOIN targets t ON t.id = r.target_id
+		WHERE t.host = ? AND r.checked_at >= ?
+		ORDER BY r.latency_ms LIMIT 1 OFFSET ?`,
+		host, since, max(rank-1, 0),
+	).Scan(dest)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	return err
+}
+
+// flapCount returns how many times the checks in the window went from up to
+// down or back, in the order they were made, with up telling them apart.
+func (s *Storage) flapCount(ctx context.Context, targetID string, since time.Time, up string) (int, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT CASE WHEN `+up+` THEN 1 ELSE 0 END FROM check_results
+		WHERE target_id = ? AND checked_at >= ? ORDER BY checked_at, id`,
+		targetID, since,
+	)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	flaps, prev := 0, -1
+	for rows.Next() {
+		var up int
+		if err := rows.Scan(&up); err != nil {
+			return 0, err
+		}
+		if prev != -1 && up != prev {
+			flaps++
+		}
+		prev = up
+	}
+	return flaps, rows.Err()
+}
+
+// latencyPercentile returns the nearest-rank percentile p of the count
+// latencies in the window.
+func (s *Storage) latencyPercentile(ctx context.Context, targetID string, since time.Time, count int, p float64) (*int, error) {
+	rank := int(math.Ceil(p * float64(count)))
+	var latency int
+	err := s.db.QueryRowContext(ctx,
+		`SELECT latency_ms FROM check_results WHERE target_id = ? AND checked_at >= ?
+		ORDER BY latency_ms LIMIT 1 OFFSET ?`,
+		targetID, since, max(rank-1, 0),
+	).Scan(&latency)
+	if err != nil {
+		return nil, err
+	}
+	return &latency, nil
+}
@@ -1,30 +1,77 @@
 package storage
 
 import (
+	"context"
 	"database/sql"
+	"errors"
+	"fmt"
+	"maps"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/aarushishahhh/linkwatch/project/internal/models"
 
+	"github.com/lib/pq"
 	_ "github.com/mattn/go-sqlite3"
 )
 
-func setupTestDB(t *testing.T) *Storage {
-	db, err := sql.Open("sqlite3", ":memory:")
+// setupTestDB returns a fresh in-memory SQLite store, or an emptied Postgres
+// store when DATABASE_URL points at Postgres, so the suite can run against
+// both drivers.
+func setupTestDB(t testing.TB) *Storage {
+	driver, dsn := "sqlite3", ":memory:"
+	if url := os.Getenv("DATABASE_URL"); strings.HasPrefix(url, "postgres://") || strings.HasPrefix(url, "postgresql://") {
+		driver, dsn = "postgres", url
+	}
+
+	db, err := sql.Open(driver, dsn)
 	if err != nil {
 		t.Fatalf("failed to open test database: %v", err)
 	}
+	t.Cleanup(func() { db.Close() })
 
 	store := New(db)
 	if err := store.Migrate(); err != nil {
 		t.Fatalf("failed to migrate test database: %v", err)
 	}
 
+	if driver == "postgres" {
+		_, err := db.Exec(`TRUNCATE targets, check_results, idempotency_keys, webhooks, audit_log, check_filter,
+			profiles, check_queue CASCADE`)
+		if err != nil {
+			t.Fatalf("failed to reset test database: %v", err)
+		}
+	}
+
 	return store
 }
 
+func TestRebind(t *testing.T) {
+	tests := []struct {
+		dialect  dialect
+		query    string
+		expected string
+	}{
+		{dialectSQLite, "SELECT * FROM t WHERE a = ? AND b = ?", "SELECT * FROM t WHERE a = ? AND b = ?"},
+		{dialectPostgres, "SELECT * FROM t WHERE a = ? AND b = ?", "SELECT * FROM t WHERE a = $1 AND b = $2"},
+		{dialectPostgres, "SELECT * FROM t WHERE a = '?' AND b = ?", "SELECT * FROM t WHERE a = '?' AND b = $1"},
+		{dialectPostgres, "SELECT 1", "SELECT 1"},
+	}
+
+	for _, tt := range tests {
+		if got := tt.dialect.rebind(tt.query); got != tt.expected {
+			t.Errorf("rebind(%q) = %q, expected %q", tt.query, got, tt.expected)
+		}
+	}
+}
+
 func TestCanonicalizeURL(t *testing.T) {
 	tests := []struct {
 		input    string
@@ -39,6 +86,23 @@ func TestCanonicalizeURL(t *testing.T) {
 		{"https://example.com", "https://example.com", false},
 		{"example.com", "", true}, // missing scheme
 		{"ftp://example.com", "ftp://example.com", false},
+		{"https://example.com/path?b=2&a=1", "https://example.com/path?a=1&b=2", false},
+		{"https://example.com/path?a=1&b=2", "https://example.com/path?a=1&b=2", false},
+		{"https://example.com/path?tag=z&tag=a&id=7", "https://example.com/path?id=7&tag=a&tag=z", false},
+		{"https://example.com/path/?b=2&a=1#top", "https://example.com/path?a=1&b=2", false},
+
+		// Percent-encoding: unreserved characters decoded, other escapes
+		// uppercased, reserved delimiters left encoded
+		{"https://example.com/%7Euser/%61bc", "https://example.com/~user/abc", false},
+		{"https://example.com/a%2fb", "https://example.com/a%2Fb", false},
+		{"https://example.com/a%2Fb", "https://example.com/a%2Fb", false},
+		{"https://example.com/a/b", "https://example.com/a/b", false},
+		{"https://example.com/a%2F", "https://example.com/a%2F", false},
+		{"https://example.com/caf%c3%a9", "https://example.com/caf%C3%A9", false},
+		{"https://example.com/caf%C3%A9/", "https://example.com/caf%C3%A9", false},
+		{"https://example.com/a%3fb%23c", "https://example.com/a%3Fb%23c", false},
+		{"https://example.com/a%20b", "https://example.com/a%20b", false},
+		{"https://example.com/p?q=%7e%2f", "https://example.com/p?q=~%2F", false},
 	}
 
 	for _, tt := range tests {
@@ -63,11 +127,140 @@ func TestCanonicalizeURL(t *testing.T) {
 	}
 }
 
+func TestCanonicalizeURLIDN(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"http://münchen.de/", "http://xn--mnchen-3ya.de/"},
+		{"http://xn--mnchen-3ya.de/", "http://xn--mnchen-3ya.de/"},
+		{"http://XN--MNCHEN-3YA.DE/", "http://xn--mnchen-3ya.de/"},
+		{"http://MÜNCHEN.de/", "http://xn--mnchen-3ya.de/"},
+		{"http://München.DE:80/path/", "http://xn--mnchen-3ya.de/path"},
+		{"https://bücher.example:8443/", "https://xn--bcher-kva.example:8443/"},
+		{"https://例え.jp", "https://xn--r8jz45g.jp"},
+		{"https://straße.de", "https://xn--strae-oqa.de"},
+		{"https://m%C3%BCnchen.de/", "https://xn--mnchen-3ya.de/"},
+
+		// ASCII hosts are only lowercased, as before
+		{"https://My_Host.internal/", "https://my_host.internal/"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			result, err := CanonicalizeURL(tt.input)
+			if err != nil {
+				t.Fatalf("unexpected error for input %q: %v", tt.input, err)
+			}
+			if result != tt.expected {
+				t.Errorf("for input %q, expected %q, got %q", tt.input, tt.expected, result)
+			}
+		})
+	}
+
+	if _, err := CanonicalizeURL("https://a\u200db.example/"); err == nil {
+		t.Error("expected an error for a host with a stray zero width joiner")
+	}
+}
+
+func TestCanonicalizeURLWWW(t *testing.T) {
+	tests := []struct {
+		input    string
+		mode     WWWMode
+		expected string
+	}{
+		// Default leaves hosts untouched
+		{"https://www.example.com/path", WWWKeep, "https://www.example.com/path"},
+		{"https://example.com/path", WWWKeep, "https://example.com/path"},
+
+		// Strip collapses www and non-www
+		{"https://www.example.com/path", WWWStrip, "https://example.com/path"},
+		{"https://WWW.Example.com/path", WWWStrip, "https://example.com/path"},
+		{"https://example.com/path", WWWStrip, "https://example.com/path"},
+		{"http://www.example.com:8080/", WWWStrip, "http://example.com:8080/"},
+		{"https://api.example.com/path", WWWStrip, "https://api.example.com/path"},
+		{"https://www2.example.com/path", WWWStrip, "https://www2.example.com/path"},
+		{"https://www.com", WWWStrip, "https://www.com"},
+
+		// Add collapses the other way
+		{"https://example.com/path", WWWAdd, "https://www.example.com/path"},
+		{"https://www.example.com/path", WWWAdd, "https://www.example.com/path"},
+		{"https://api.example.com/path", WWWAdd, "https://api.example.com/path"},
+		{"http://127.0.0.1:8080/", WWWAdd, "http://127.0.0.1:8080/"},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.mode)+" "+tt.input, func(t *testing.T) {
+			result, err := CanonicalizeURLWithOptions(tt.input, CanonicalizeOptions{WWW: tt.mode})
+			if err != nil {
+				t.Fatalf("unexpected error for input %q: %v", tt.input, err)
+			}
+
+			if result != tt.expected {
+				t.Errorf("for input %q, expected %q, got %q", tt.input, tt.expected, result)
+			}
+		})
+	}
+
+	t.Run("www and non-www collapse under strip", func(t *testing.T) {
+		opts := CanonicalizeOptions{WWW: WWWStrip}
+		a, _ := CanonicalizeURLWithOptions("https://www.example.com/", opts)
+		b, _ := CanonicalizeURLWithOptions("https://example.com/", opts)
+		if a != b {
+			t.Errorf("expected %q and %q to collapse", a, b)
+		}
+	})
+}
+
+func TestCanonicalizeURLStripParams(t *testing.T) {
+	opts := CanonicalizeOptions{StripParams: DefaultStripParams}
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"https://example.com/page?utm_source=news&utm_medium=email&id=4", "https://example.com/page?id=4"},
+		{"https://example.com/page?fbclid=abc", "https://example.com/page"},
+		{"https://example.com/page?GCLID=abc&b=2&a=1", "https://example.com/page?a=1&b=2"},
+		{"https://example.com/page?utm=keep", "https://example.com/page?utm=keep"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			result, err := CanonicalizeURLWithOptions(tt.input, opts)
+			if err != nil {
+				t.Fatalf("unexpected error for input %q: %v", tt.input, err)
+			}
+
+			if result != tt.expected {
+				t.Errorf("for input %q, expected %q, got %q", tt.input, tt.expected, result)
+			}
+		})
+	}
+
+	t.Run("not stripped without options", func(t *testing.T) {
+		result, _ := CanonicalizeURL("https://example.com/page?utm_source=news")
+		if result != "https://example.com/page?utm_source=news" {
+			t.Errorf("expected tracking parameters kept, got %q", result)
+		}
+	})
+
+	t.Run("parse list", func(t *testing.T) {
+		if params := ParseStripParams("none"); len(params) != 0 {
+			t.Errorf("expected no parameters for none, got %v", params)
+		}
+		params := ParseStripParams("default, ref")
+		if len(params) != len(DefaultStripParams)+1 || params[len(params)-1] != "ref" {
+			t.Errorf("expected defaults plus ref, got %v", params)
+		}
+	})
+}
+
 func TestCreateTarget(t *testing.T) {
 	store := setupTestDB(t)
 
 	t.Run("create new target", func(t *testing.T) {
-		target, isNew, err := store.CreateTarget("https://example.com", "https://example.com", nil)
+		target, isNew, err := store.CreateTarget(context.Background(), "https://example.com", "https://example.com", nil)
 		if err != nil {
 			t.Fatalf("unexpected error: %v", err)
 		}
@@ -87,7 +280,7 @@ func TestCreateTarget(t *testing.T) {
 
 	t.Run("duplicate canonical URL returns existing", func(t *testing.T) {
 		// First create
-		target1, isNew1, err := store.CreateTarget("https://example.com/", "https://example.com", nil)
+		target1, isNew1, err := store.CreateTarget(context.Background(), "https://example.com/", "https://example.com", nil)
 		if err != nil {
 			t.Fatalf("unexpected error: %v", err)
 		}
@@ -96,7 +289,7 @@ func TestCreateTarget(t *testing.T) {
 		}
 
 		// Second create with same canonical URL
-		target2, isNew2, err := store.CreateTarget("https://EXAMPLE.COM", "https://example.com", nil)
+		target2, isNew2, err := store.CreateTarget(context.Background(), "https://EXAMPLE.COM", "https://example.com", nil)
 		if err != nil {
 			t.Fatalf("unexpected error: %v", err)
 		}
@@ -111,13 +304,43 @@ func TestCreateTarget(t *testing.T) {
 	})
 }
 
+func TestCreateTargetOriginalURLNotUnique(t *testing.T) {
+	store := setupTestDB(t)
+
+	// Distinct originals canonicalizing equal dedupe to one target
+	first, _, err := store.CreateTarget(context.Background(), "https://www.example.com/a?utm_source=x", "https://example.com/a", nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	second, isNew, err := store.CreateTarget(context.Background(), "https://Example.com/a", "https://example.com/a", nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if isNew || second.ID != first.ID {
+		t.Errorf("expected the existing target %s, got %s (new %v)", first.ID, second.ID, isNew)
+	}
+
+	// The same original under other canonicalization options is a new
+	// target rather than a unique violation on url
+	third, isNew, err := store.CreateTarget(context.Background(), "https://www.example.com/a?utm_source=x", "https://www.example.com/a", nil)
+	if err != nil {
+		t.Fatalf("expected the same original URL to be accepted again, got %v", err)
+	}
+	if !isNew || third.ID == first.ID {
+		t.Errorf("expected a new target, got %s (new %v)", third.ID, isNew)
+	}
+	if third.URL != first.URL {
+		t.Errorf("expected original URL %q, got %q", first.URL, third.URL)
+	}
+}
+
 func TestCreateTargetIdempotency(t *testing.T) {
 	store := setupTestDB(t)
 
 	idempotencyKey := "test-key-123"
 
 	t.Run("first request with idempotency key", func(t *testing.T) {
-		target, isNew, err := store.CreateTarget("https://example.com", "https://example.com", &idempotencyKey)
+		target, isNew, err := store.CreateTarget(context.Background(), "https://example.com", "https://example.com", &idempotencyKey)
 		if err != nil {
 			t.Fatalf("unexpected error: %v", err)
 		}
@@ -132,7 +355,7 @@ func TestCreateTargetIdempotency(t *testing.T) {
 	})
 
 	t.Run("duplicate request with same idempotency key", func(t *testing.T) {
-		target, isNew, err := store.CreateTarget("https://example.com", "https://example.com", &idempotencyKey)
+		target, isNew, err := store.CreateTarget(context.Background(), "https://example.com", "https://example.com", &idempotencyKey)
 		if err != nil {
 			t.Fatalf("unexpected error: %v", err)
 		}
@@ -147,7 +370,7 @@ func TestCreateTargetIdempotency(t *testing.T) {
 	})
 
 	t.Run("different URL with same idempotency key returns original", func(t *testing.T) {
-		target, isNew, err := store.CreateTarget("https://different.com", "https://different.com", &idempotencyKey)
+		target, isNew, err := store.CreateTarget(context.Background(), "https://different.com", "https://different.com", &idempotencyKey)
 		if err != nil {
 			t.Fatalf("unexpected error: %v", err)
 		}
@@ -162,6 +385,113 @@ func TestCreateTargetIdempotency(t *testing.T) {
 	})
 }
 
+func TestIdempotencyKeyExpiry(t *testing.T) {
+	store := setupTestDB(t)
+	store.SetIdempotencyTTL(time.Hour)
+
+	key := "expiring-key"
+	original, _, err := store.CreateTarget(context.Background(), "https://example.com", "https://example.com", &key)
+	if err != nil {
+		t.Fatalf("failed to create target: %v", err)
+	}
+
+	// A live key still returns the original target
+	target, isNew, err := store.CreateTarget(context.Background(), "https://other.com", "https://other.com", &key)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if isNew || target.ID != original.ID {
+		t.Errorf("expected the live key to return %s, got %s (new: %v)", original.ID, target.ID, isNew)
+	}
+
+	// An expired key is ignored before cleanup gets to it
+	if _, err := store.db.Exec("UPDATE idempotency_keys SET created_at = ? WHERE key = ?",
+		time.Now().UTC().Add(-2*time.Hour), key); err != nil {
+		t.Fatalf("failed to age key: %v", err)
+	}
+	target, isNew, err = store.CreateTarget(context.Background(), "https://other.com", "https://other.com", &key)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !isNew || target.ID == original.ID {
+		t.Errorf("expected the expired key to create a new target, got %s (new: %v)", target.ID, isNew)
+	}
+
+	// The reused key now belongs to the new target
+	again, isNew, err := store.CreateTarget(context.Background(), "https://third.com", "https://third.com", &key)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if isNew || again.ID != target.ID {
+		t.Errorf("expected the reused key to return %s, got %s", target.ID, again.ID)
+	}
+
+	// Cleanup purges old keys, after which the key behaves as new
+	if _, err := store.db.Exec("UPDATE idempotency_keys SET created_at = ? WHERE key = ?",
+		time.Now().UTC().Add(-2*time.Hour), key); err != nil {
+		t.Fatalf("failed to age key: %v", err)
+	}
+	removed, err := store.CleanupOldIdempotencyKeys(context.Background(), time.Now().Add(-time.Hour))
+	if err != nil {
+		t.Fatalf("failed to clean up keys: %v", err)
+	}
+	if removed != 1 {
+		t.Errorf("expected 1 key removed, got %d", removed)
+	}
+	var remaining int
+	if err := store.db.QueryRow("SELECT COUNT(*) FROM idempotency_keys").Scan(&remaining); err != nil {
+		t.Fatalf("failed to count keys: %v", err)
+	}
+	if remaining != 0 {
+		t.Errorf("expected no keys left, got %d", remaining)
+	}
+
+	store.SetIdempotencyTTL(0)
+	fresh, isNew, err := store.CreateTarget(context.Background(), "https://fourth.com", "https://fourth.com", &key)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !isNew || fresh.ID == target.ID {
+		t.Errorf("expected the purged key to create a new target, got %s (new: %v)", fresh.ID, isNew)
+	}
+}
+
+func TestGenerateID(t *testing.T) {
+	pattern := regexp.MustCompile(`^t_[0-9a-f]{8}-[0-9a-f]{4}-7[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+
+	var mu sync.Mutex
+	seen := make(map[string]bool)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				id := GenerateID("t_")
+				mu.Lock()
+				seen[id] = true
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if len(seen) != 8000 {
+		t.Errorf("expected 8000 unique IDs, got %d", len(seen))
+	}
+	for id := range seen {
+		if !pattern.MatchString(id) {
+			t.Fatalf("expected a t_-prefixed UUIDv7, got %q", id)
+		}
+	}
+
+	first := GenerateID("t_")
+	time.Sleep(2 * time.Millisecond)
+	if second := GenerateID("t_"); second <= first {
+		t.Errorf("expected IDs to sort by creation time, got %q then %q", first, second)
+	}
+}
+
 func TestListTargets(t *testing.T) {
 	store := setupTestDB(t)
 
@@ -175,7 +505,7 @@ func TestListTargets(t *testing.T) {
 	var createdTargets []models.Target
 	for _, url := range urls {
 		canonical, _ := CanonicalizeURL(url)
-		target, _, err := store.CreateTarget(url, canonical, nil)
+		target, _, err := store.CreateTarget(context.Background(), url, canonical, nil)
 		if err != nil {
 			t.Fatalf("failed to create target: %v", err)
 		}
@@ -184,7 +514,7 @@ func TestListTargets(t *testing.T) {
 	}
 
 	t.Run("list all targets", func(t *testing.T) {
-		result, err := store.ListTargets(nil, 10, "")
+		result, err := store.ListTargets(context.Background(), nil, 10, "")
 		if err != nil {
 			t.Fatalf("unexpected error: %v", err)
 		}
@@ -203,7 +533,7 @@ func TestListTargets(t *testing.T) {
 
 	t.Run("filter by host", func(t *testing.T) {
 		host := "example.com"
-		result, err := store.ListTargets(&host, 10, "")
+		result, err := store.ListTargets(context.Background(), &host, 10, "")
 		if err != nil {
 			t.Fatalf("unexpected error: %v", err)
 		}
@@ -219,7 +549,7 @@ func TestListTargets(t *testing.T) {
 
 	t.Run("pagination", func(t *testing.T) {
 		// First page with limit 2
-		result1, err := store.ListTargets(nil, 2, "")
+		result1, err := store.ListTargets(context.Background(), nil, 2, "")
 		if err != nil {
 			t.Fatalf("unexpected error: %v", err)
 		}
@@ -233,7 +563,7 @@ func TestListTargets(t *testing.T) {
 		}
 
 		// Second page
-		result2, err := store.ListTargets(nil, 2, result1.NextPageToken)
+		result2, err := store.ListTargets(context.Background(), nil, 2, result1.NextPageToken)
 		if err != nil {
 			t.Fatalf("unexpected error: %v", err)
 		}
@@ -257,89 +587,1475 @@ func TestListTargets(t *testing.T) {
 	})
 }
 
-func TestSaveAndGetCheckResults(t *testing.T) {
+func TestListTargetsSorted(t *testing.T) {
 	store := setupTestDB(t)
 
-	// Create a target first
-	target, _, err := store.CreateTarget("https://example.com", "https://example.com", nil)
-	if err != nil {
-		t.Fatalf("failed to create target: %v", err)
+	// Created in this order, which differs from URL order
+	for _, u := range []string{"https://b.example", "https://c.example", "https://a.example"} {
+		if _, _, err := store.CreateTarget(context.Background(), u, u, nil); err != nil {
+			t.Fatalf("failed to create target: %v", err)
+		}
+		time.Sleep(time.Millisecond)
 	}
 
-	now := time.Now().UTC()
-	results := []models.CheckResult{
-		{
-			CheckedAt:  now,
-			StatusCode: intPtr(200),
-			LatencyMs:  150,
-			Error:      nil,
-		},
-		{
-			CheckedAt:  now.Add(-time.Minute),
-			StatusCode: nil,
-			LatencyMs:  0,
-			Error:      stringPtr("connection timeout"),
-		},
-		{
-			CheckedAt:  now.Add(-2 * time.Minute),
-			StatusCode: intPtr(404),
-			LatencyMs:  75,
-			Error:      nil,
-		},
+	tests := []struct {
+		sort     models.TargetSort
+		expected string
+	}{
+		{models.TargetSort{Field: models.SortCreatedAt}, "b,c,a"},
+		{models.TargetSort{Field: models.SortCreatedAt, Desc: true}, "a,c,b"},
+		{models.TargetSort{Field: models.SortURL}, "a,b,c"},
+		{models.TargetSort{Field: models.SortURL, Desc: true}, "c,b,a"},
 	}
-
-	// Save results
-	for _, result := range results {
-		if err := store.SaveCheckResult(target.ID, result); err != nil {
-			t.Fatalf("failed to save check result: %v", err)
+	for _, tt := range tests {
+		// One target per page, so every step goes through a page token
+		var got []string
+		token := ""
+		for page := 0; page < 5; page++ {
+			list, err := store.ListTargetsSorted(context.Background(), models.TargetFilter{}, 1, token, tt.sort)
+			if err != nil {
+				t.Fatalf("sort %+v: unexpected error: %v", tt.sort, err)
+			}
+			for _, target := range list.Items {
+				got = append(got, strings.TrimSuffix(strings.TrimPrefix(target.URL, "https://"), ".example"))
+			}
+			if token = list.NextPageToken; token == "" {
+				break
+			}
+		}
+		if strings.Join(got, ",") != tt.expected {
+			t.Errorf("sort %+v: expected %s, got %s", tt.sort, tt.expected, strings.Join(got, ","))
 		}
 	}
+}
 
-	t.Run("get all results", func(t *testing.T) {
-		retrieved, err := store.GetCheckResults(target.ID, nil, 10)
+func TestTargetHostFilter(t *testing.T) {
+	store := setupTestDB(t)
+
+	for _, u := range []string{
+		"https://example.com",
+		"https://api.example.com/v1",
+		"http://example.com:8080/admin",
+		"https://deep.api.example.com/",
+		"https://notexample.com/",
+		"https://example.org/",
+		"https://exampleXcom.org/",
+		"https://xn--bcher-kva.example/",
+	} {
+		canonical, err := CanonicalizeURL(u)
 		if err != nil {
-			t.Fatalf("unexpected error: %v", err)
+			t.Fatalf("failed to canonicalize %s: %v", u, err)
 		}
-
-		if len(retrieved.Items) != 3 {
-			t.Errorf("expected 3 results, got %d", len(retrieved.Items))
+		if _, _, err := store.CreateTarget(context.Background(), u, canonical, nil); err != nil {
+			t.Fatalf("failed to create target: %v", err)
 		}
+	}
 
-		// Should be ordered most recent first
-		for i := 0; i < len(retrieved.Items)-1; i++ {
-			if retrieved.Items[i].CheckedAt.Before(retrieved.Items[i+1].CheckedAt) {
-				t.Error("results not properly ordered by checked_at DESC")
+	strPtr := func(s string) *string { return &s }
+	tests := []struct {
+		name     string
+		filter   models.TargetFilter
+		expected int
+	}{
+		{"exact host without a path", models.TargetFilter{Host: strPtr("example.com")}, 2},
+		{"exact host ignores case and trailing dot", models.TargetFilter{Host: strPtr("EXAMPLE.com.")}, 2},
+		{"exact subdomain", models.TargetFilter{Host: strPtr("api.example.com")}, 1},
+		{"suffix matches host and subdomains", models.TargetFilter{HostSuffix: strPtr("example.com")}, 4},
+		{"suffix is label aligned", models.TargetFilter{HostSuffix: strPtr("ample.com")}, 0},
+		{"suffix wildcards are literal", models.TargetFilter{HostSuffix: strPtr("_xample.com")}, 0},
+		{"suffix of a subdomain", models.TargetFilter{HostSuffix: strPtr("api.example.com")}, 2},
+		{"internationalized suffix", models.TargetFilter{HostSuffix: strPtr("bücher.example")}, 1},
+		{"host and suffix combine", models.TargetFilter{Host: strPtr("api.example.com"), HostSuffix: strPtr("example.com")}, 1},
+		{"no filter", models.TargetFilter{}, 8},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			list, err := store.ListTargetsSorted(context.Background(), tt.filter, 100, "", models.TargetSort{Field: models.SortCreatedAt})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(list.Items) != tt.expected {
+				var urls []string
+				for _, target := range list.Items {
+					urls = append(urls, target.URL)
+				}
+				t.Errorf("expected %d targets, got %d: %v", tt.expected, len(list.Items), urls)
 			}
-		}
-	})
 
-	t.Run("get results since timestamp", func(t *testing.T) {
-		since := now.Add(-90 * time.Second)
-		retrieved, err := store.GetCheckResults(target.ID, &since, 10)
-		if err != nil {
-			t.Fatalf("unexpected error: %v", err)
-		}
+			count, err := store.CountTargets(context.Background(), tt.filter)
+			if err != nil || count != tt.expected {
+				t.Errorf("expected a count of %d, got %d (%v)", tt.expected, count, err)
+			}
 
-		if len(retrieved.Items) != 2 {
-			t.Errorf("expected 2 results since %v, got %d", since, len(retrieved.Items))
+			streamed := 0
+			if err := store.StreamTargets(context.Background(), tt.filter, func(models.Target) error { streamed++; return nil }); err != nil || streamed != tt.expected {
+				t.Errorf("expected %d streamed targets, got %d (%v)", tt.expected, streamed, err)
+			}
+		})
+	}
+
+	// Targets from before the host column get it filled in by the migration
+	if _, err := store.db.Exec("UPDATE targets SET host = NULL"); err != nil {
+		t.Fatalf("failed to clear hosts: %v", err)
+	}
+	tx, err := store.db.Begin()
+	if err != nil {
+		t.Fatalf("failed to begin: %v", err)
+	}
+	if err := addTargetHosts(tx); err != nil {
+		t.Fatalf("failed to backfill hosts: %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("failed to commit: %v", err)
+	}
+	if count, err := store.CountTargets(context.Background(), models.TargetFilter{HostSuffix: strPtr("example.com")}); err != nil || count != 4 {
+		t.Errorf("expected 4 targets after the backfill, got %d (%v)", count, err)
+	}
+}
+
+func TestHostFilterRootURL(t *testing.T) {
+	store := setupTestDB(t)
+
+	// Root-only canonical URLs have nothing after the host, which a pattern
+	// on the full URL like "%://example.com/%" never matched
+	for _, canonical := range []string{"https://example.com", "http://example.com:8080", "https://example.com?page=1"} {
+		if _, _, err := store.CreateTarget(context.Background(), canonical, canonical, nil); err != nil {
+			t.Fatalf("failed to create target: %v", err)
 		}
-	})
+	}
 
-	t.Run("limit results", func(t *testing.T) {
-		retrieved, err := store.GetCheckResults(target.ID, nil, 1)
+	var host string
+	if err := store.db.QueryRow("SELECT host FROM targets WHERE canonical_url = ?", "https://example.com").Scan(&host); err != nil {
+		t.Fatalf("failed to read host: %v", err)
+	}
+	if host != "example.com" {
+		t.Errorf("expected host example.com, got %q", host)
+	}
+
+	exact := "example.com"
+	list, err := store.ListTargets(context.Background(), &exact, 100, "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(list.Items) != 3 {
+		t.Errorf("expected 3 targets, got %d", len(list.Items))
+	}
+}
+
+func TestTargetCredentials(t *testing.T) {
+	store := setupTestDB(t)
+
+	create := func(url string) *models.Target {
+		username := "monitor"
+		password := models.Secret("s3cret")
+		target, _, err := store.CreateTargetWithSettings(context.Background(), url, url, nil,
+			models.TargetSettings{Username: &username, Password: &password})
 		if err != nil {
-			t.Fatalf("unexpected error: %v", err)
+			t.Fatalf("failed to create target: %v", err)
 		}
-
-		if len(retrieved.Items) != 1 {
-			t.Errorf("expected 1 result with limit, got %d", len(retrieved.Items))
+		return target
+	}
+	stored := func(id string) string {
+		var password string
+		if err := store.db.QueryRow("SELECT password FROM targets WHERE id = ?", id).Scan(&password); err != nil {
+			t.Fatalf("failed to read password: %v", err)
 		}
+		return password
+	}
 
-		// Should be the most recent
-		if retrieved.Items[0].StatusCode == nil || *retrieved.Items[0].StatusCode != 200 {
-			t.Error("expected most recent result (200 status)")
+	plain := create("https://plain.example.com/")
+
+	key := make([]byte, 32)
+	key[0] = 1
+	if err := store.SetCredentialsKey(key); err != nil {
+		t.Fatalf("failed to set key: %v", err)
+	}
+	sealed := create("https://sealed.example.com/")
+	if raw := stored(sealed.ID); !strings.HasPrefix(raw, "aes256gcm:") || strings.Contains(raw, "s3cret") {
+		t.Errorf("expected an encrypted password, got %q", raw)
+	}
+
+	// Both open once read back, including the one saved before the key
+	for _, target := range []*models.Target{plain, sealed} {
+		read, err := store.GetTarget(context.Background(), target.ID)
+		if err != nil || read == nil || read.Password == nil {
+			t.Fatalf("failed to get target: %v", err)
 		}
+		if read.Username == nil || *read.Username != "monitor" {
+			t.Errorf("expected username monitor, got %v", read.Username)
+		}
+		if password, err := store.OpenSecret(*read.Password); err != nil || password != "s3cret" {
+			t.Errorf("expected s3cret, got %q (%v)", password, err)
+		}
+		if s := fmt.Sprintf("%v %+v", *read.Password, read); strings.Contains(s, "s3cret") {
+			t.Errorf("expected the password to print redacted, got %s", s)
+		}
+	}
+
+	// A different key can't open it
+	if err := store.SetCredentialsKey(make([]byte, 32)); err != nil {
+		t.Fatalf("failed to set key: %v", err)
+	}
+	if _, err := store.OpenSecret(*sealed.Password); err == nil {
+		t.Error("expected an error opening with the wrong key")
+	}
+
+	for _, bad := range []string{"not base64!", "c2hvcnQ="} {
+		if _, err := ParseCredentialsKey(bad); err == nil {
+			t.Errorf("expected an error for key %q", bad)
+		}
+	}
+}
+
+func TestMaxTargets(t *testing.T) {
+	store := setupTestDB(t)
+	store.SetMaxTargets(2)
+
+	key := "key-1"
+	for _, u := range []string{"https://a.example.com/", "https://b.example.com/"} {
+		if _, _, err := store.CreateTarget(context.Background(), u, u, &key); err != nil {
+			t.Fatalf("failed to create target: %v", err)
+		}
+		key = "key-2"
+	}
+
+	if _, _, err := store.CreateTarget(context.Background(), "https://c.example.com/", "https://c.example.com/", nil); !errors.Is(err, ErrTargetLimit) {
+		t.Errorf("expected %v, got %v", ErrTargetLimit, err)
+	}
+
+	// Creates that return an existing target aren't counted
+	if _, isNew, err := store.CreateTarget(context.Background(), "https://a.example.com/", "https://a.example.com/", nil); err != nil || isNew {
+		t.Errorf("expected the existing target, got new %v (%v)", isNew, err)
+	}
+	key = "key-1"
+	if _, isNew, err := store.CreateTarget(context.Background(), "https://d.example.com/", "https://d.example.com/", &key); err != nil || isNew {
+		t.Errorf("expected the target for the idempotency key, got new %v (%v)", isNew, err)
+	}
+
+	// A batch keeps going past the cap, leaving out only the new URLs
+	created, err := store.CreateTargets(context.Background(), []TargetURL{
+		{URL: "https://c.example.com/", CanonicalURL: "https://c.example.com/"},
+		{URL: "https://a.example.com/", CanonicalURL: "https://a.example.com/"},
+	})
+	if err != nil {
+		t.Fatalf("failed to create batch: %v", err)
+	}
+	if !errors.Is(created[0].Err, ErrTargetLimit) || created[0].Target != nil {
+		t.Errorf("expected %v for the new URL, got %+v", ErrTargetLimit, created[0])
+	}
+	if created[1].Err != nil || created[1].Target == nil || created[1].Created {
+		t.Errorf("expected the existing target, got %+v", created[1])
+	}
+	if count, _ := store.CountTargets(context.Background(), models.TargetFilter{}); count != 2 {
+		t.Errorf("expected 2 targets, got %d", count)
+	}
+
+	store.SetMaxTargets(0)
+	if _, _, err := store.CreateTarget(context.Background(), "https://c.example.com/", "https://c.example.com/", nil); err != nil {
+		t.Errorf("expected no limit, got %v", err)
+	}
+}
+
+func TestPageToken(t *testing.T) {
+	createdAt := time.Date(2025, 8, 17, 12, 0, 0, 123456789, time.UTC)
+	byCreated := models.TargetSort{Field: models.SortCreatedAt}
+	token := encodePageToken(models.Target{ID: "t_with_underscores", CreatedAt: createdAt}, byCreated)
+
+	if strings.Contains(token, "t_with_underscores") {
+		t.Errorf("expected an opaque token, got %q", token)
+	}
+
+	cursor, err := decodePageToken(token, byCreated)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !cursor.CreatedAt.Equal(createdAt) || cursor.ID != "t_with_underscores" {
+		t.Errorf("expected (%v, %q), got (%v, %q)", createdAt, "t_with_underscores", cursor.CreatedAt, cursor.ID)
+	}
+
+	for _, bad := range []string{"2025-08-17T12:00:00Z_t_1", "%%%", strings.Repeat("a", 10000)} {
+		if _, err := decodePageToken(bad, byCreated); !errors.Is(err, ErrInvalidPageToken) {
+			t.Errorf("expected ErrInvalidPageToken for %q, got %v", bad, err)
+		}
+	}
+
+	// Tokens only resume the order they were issued for
+	for _, other := range []models.TargetSort{{Field: models.SortCreatedAt, Desc: true}, {Field: models.SortURL}} {
+		if _, err := decodePageToken(token, other); !errors.Is(err, ErrInvalidPageToken) {
+			t.Errorf("expected ErrInvalidPageToken for sort %+v, got %v", other, err)
+		}
+	}
+}
+
+func TestSaveAndGetCheckResults(t *testing.T) {
+	store := setupTestDB(t)
+
+	// Create a target first
+	target, _, err := store.CreateTarget(context.Background(), "https://example.com", "https://example.com", nil)
+	if err != nil {
+		t.Fatalf("failed to create target: %v", err)
+	}
+
+	now := time.Now().UTC()
+	results := []models.CheckResult{
+		{
+			CheckedAt:  now,
+			StatusCode: intPtr(200),
+			LatencyMs:  150,
+			Error:      nil,
+		},
+		{
+			CheckedAt:  now.Add(-time.Minute),
+			StatusCode: nil,
+			LatencyMs:  0,
+			Error:      stringPtr("connection timeout"),
+		},
+		{
+			CheckedAt:  now.Add(-2 * time.Minute),
+			StatusCode: intPtr(404),
+			LatencyMs:  75,
+			Error:      nil,
+		},
+	}
+
+	// Save results
+	for _, result := range results {
+		if err := store.SaveCheckResult(context.Background(), target.ID, result); err != nil {
+			t.Fatalf("failed to save check result: %v", err)
+		}
+	}
+
+	t.Run("get all results", func(t *testing.T) {
+		retrieved, err := store.GetCheckResults(context.Background(), target.ID, nil, 10)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if len(retrieved.Items) != 3 {
+			t.Errorf("expected 3 results, got %d", len(retrieved.Items))
+		}
+
+		// Should be ordered most recent first
+		for i := 0; i < len(retrieved.Items)-1; i++ {
+			if retrieved.Items[i].CheckedAt.Before(retrieved.Items[i+1].CheckedAt) {
+				t.Error("results not properly ordered by checked_at DESC")
+			}
+		}
+	})
+
+	t.Run("get results since timestamp", func(t *testing.T) {
+		since := now.Add(-90 * time.Second)
+		retrieved, err := store.GetCheckResults(context.Background(), target.ID, &since, 10)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if len(retrieved.Items) != 2 {
+			t.Errorf("expected 2 results since %v, got %d", since, len(retrieved.Items))
+		}
+	})
+
+	t.Run("limit results", func(t *testing.T) {
+		retrieved, err := store.GetCheckResults(context.Background(), target.ID, nil, 1)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if len(retrieved.Items) != 1 {
+			t.Errorf("expected 1 result with limit, got %d", len(retrieved.Items))
+		}
+
+		// Should be the most recent
+		if retrieved.Items[0].StatusCode == nil || *retrieved.Items[0].StatusCode != 200 {
+			t.Error("expected most recent result (200 status)")
+		}
+	})
+}
+
+func TestCheckResultsPagination(t *testing.T) {
+	store := setupTestDB(t)
+
+	target, _, _ := store.CreateTarget(context.Background(), "https://example.com", "https://example.com", nil)
+
+	// Five results, three of them sharing a timestamp
+	now := time.Now().UTC()
+	for i, offset := range []time.Duration{0, time.Minute, time.Minute, time.Minute, 2 * time.Minute} {
+		store.SaveCheckResult(context.Background(), target.ID, models.CheckResult{CheckedAt: now.Add(-offset), LatencyMs: i})
+	}
+
+	var seen []int
+	ids := make(map[int64]int)
+	token := ""
+	for page := 0; page < 5; page++ {
+		results, err := store.GetCheckResultsWithFilter(context.Background(), target.ID, models.ResultFilter{PageToken: token}, 2)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		for _, result := range results.Items {
+			seen = append(seen, result.LatencyMs)
+			ids[result.ID] = result.LatencyMs
+		}
+		if token = results.NextPageToken; token == "" {
+			break
+		}
+	}
+
+	// Ties come back newest insert first
+	expected := []int{0, 3, 2, 1, 4}
+	if fmt.Sprint(seen) != fmt.Sprint(expected) {
+		t.Errorf("expected results %v across pages, got %v", expected, seen)
+	}
+
+	// Each result has its own id, in insert order
+	var byID []int
+	for _, id := range slices.Sorted(maps.Keys(ids)) {
+		byID = append(byID, ids[id])
+	}
+	if fmt.Sprint(byID) != "[0 1 2 3 4]" {
+		t.Errorf("expected distinct ids in insert order, got %v", ids)
+	}
+
+	if _, err := store.GetCheckResultsWithFilter(context.Background(), target.ID, models.ResultFilter{PageToken: "bogus"}, 2); !errors.Is(err, ErrInvalidPageToken) {
+		t.Errorf("expected ErrInvalidPageToken, got %v", err)
+	}
+}
+
+func TestCheckResultsFailedFilter(t *testing.T) {
+	store := setupTestDB(t)
+
+	target, _, _ := store.CreateTarget(context.Background(), "https://example.com", "https://example.com", nil)
+
+	now := time.Now().UTC()
+	for i := 0; i < 6; i++ {
+		result := models.CheckResult{CheckedAt: now.Add(-time.Duration(i) * time.Minute), StatusCode: intPtr(200), LatencyMs: i}
+		if i%3 == 1 {
+			result.StatusCode = nil
+			result.Error = stringPtr("connection refused")
+		}
+		store.SaveCheckResult(context.Background(), target.ID, result)
+	}
+
+	latencies := func(failed bool) []int {
+		t.Helper()
+		results, err := store.GetCheckResultsWithFilter(context.Background(), target.ID, models.ResultFilter{Failed: &failed}, 10)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		var latencies []int
+		for _, result := range results.Items {
+			latencies = append(latencies, result.LatencyMs)
+		}
+		return latencies
+	}
+	if got := latencies(true); fmt.Sprint(got) != "[1 4]" {
+		t.Errorf("expected failed results [1 4], got %v", got)
+	}
+	if got := latencies(false); fmt.Sprint(got) != "[0 2 3 5]" {
+		t.Errorf("expected successful results [0 2 3 5], got %v", got)
+	}
+
+	if store.db.dialect != dialectSQLite {
+		return
+	}
+	failed := true
+	query, args := resultQuery(resultColumns, target.ID, models.ResultFilter{Failed: &failed})
+	rows, err := store.db.Query("EXPLAIN QUERY PLAN "+query+" ORDER BY checked_at DESC, id DESC", args...)
+	if err != nil {
+		t.Fatalf("failed to explain query: %v", err)
+	}
+	defer rows.Close()
+	var plan []string
+	for rows.Next() {
+		var id, parent, unused int
+		var detail string
+		if err := rows.Scan(&id, &parent, &unused, &detail); err != nil {
+			t.Fatalf("failed to read query plan: %v", err)
+		}
+		plan = append(plan, detail)
+	}
+	if !strings.Contains(strings.Join(plan, "\n"), "idx_check_results_failed") {
+		t.Errorf("expected the failed filter to use idx_check_results_failed, got plan %q", plan)
+	}
+}
+
+// BenchmarkFailedResults reads the latest failures of one target among
+// 300,000 results that are mostly successes, with and without the partial
+// index on failed results.
+func BenchmarkFailedResults(b *testing.B) {
+	store := setupTestDB(b)
+
+	var targetIDs []string
+	for i := 0; i < 10; i++ {
+		url := fmt.Sprintf("https://%d.example.com", i)
+		target, _, err := store.CreateTarget(context.Background(), url, url, nil)
+		if err != nil {
+			b.Fatalf("failed to create target: %v", err)
+		}
+		targetIDs = append(targetIDs, target.ID)
+	}
+
+	t, err := store.db.Begin()
+	if err != nil {
+		b.Fatalf("failed to begin: %v", err)
+	}
+	start := time.Now().UTC().Add(-300000 * time.Minute)
+	for i := 0; i < 300000; i++ {
+		var errMsg *string
+		if i%500 == 0 {
+			errMsg = stringPtr("connection refused")
+		}
+		_, err := t.Exec("INSERT INTO check_results (target_id, checked_at, latency_ms, error) VALUES (?, ?, ?, ?)",
+			targetIDs[i%len(targetIDs)], start.Add(time.Duration(i)*time.Minute), 10, errMsg)
+		if err != nil {
+			t.Rollback()
+			b.Fatalf("failed to insert result: %v", err)
+		}
+	}
+	if err := t.Commit(); err != nil {
+		b.Fatalf("failed to commit: %v", err)
+	}
+	if _, err := store.db.Exec("ANALYZE"); err != nil {
+		b.Fatalf("failed to analyze: %v", err)
+	}
+
+	failed := true
+	run := func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			results, err := store.GetCheckResultsWithFilter(context.Background(), targetIDs[0], models.ResultFilter{Failed: &failed}, 50)
+			if err != nil {
+				b.Fatalf("unexpected error: %v", err)
+			}
+			if len(results.Items) != 50 {
+				b.Fatalf("expected 50 results, got %d", len(results.Items))
+			}
+		}
+	}
+
+	b.Run("indexed", run)
+	if _, err := store.db.Exec("DROP INDEX idx_check_results_failed"); err != nil {
+		b.Fatalf("failed to drop index: %v", err)
+	}
+	b.Run("unindexed", run)
+}
+
+func TestPruneCheckResults(t *testing.T) {
+	store := setupTestDB(t)
+
+	busy, _, _ := store.CreateTarget(context.Background(), "https://busy.example.com", "https://busy.example.com", nil)
+	stale, _, _ := store.CreateTarget(context.Background(), "https://stale.example.com", "https://stale.example.com", nil)
+
+	now := time.Now().UTC()
+	for _, age := range []time.Duration{0, time.Hour, 48 * time.Hour, 72 * time.Hour} {
+		store.SaveCheckResult(context.Background(), busy.ID, models.CheckResult{CheckedAt: now.Add(-age), StatusCode: intPtr(200)})
+	}
+	// Only old results; the latest must survive
+	for _, age := range []time.Duration{48 * time.Hour, 72 * time.Hour} {
+		store.SaveCheckResult(context.Background(), stale.ID, models.CheckResult{CheckedAt: now.Add(-age), StatusCode: intPtr(200)})
+	}
+
+	removed, err := store.PruneCheckResults(context.Background(), now.Add(-24*time.Hour))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if removed != 3 {
+		t.Errorf("expected 3 results removed, got %d", removed)
+	}
+
+	busyResults, _ := store.GetCheckResults(context.Background(), busy.ID, nil, 10)
+	if len(busyResults.Items) != 2 {
+		t.Errorf("expected 2 recent results kept, got %d", len(busyResults.Items))
+	}
+
+	staleResults, _ := store.GetCheckResults(context.Background(), stale.ID, nil, 10)
+	if len(staleResults.Items) != 1 {
+		t.Fatalf("expected the latest stale result kept, got %d", len(staleResults.Items))
+	}
+	if !staleResults.Items[0].CheckedAt.Equal(now.Add(-48 * time.Hour)) {
+		t.Errorf("expected the newest stale result kept, got %v", staleResults.Items[0].CheckedAt)
+	}
+}
+
+func TestGetCheckStats(t *testing.T) {
+	store := setupTestDB(t)
+
+	target, _, _ := store.CreateTarget(context.Background(), "https://example.com", "https://example.com", nil)
+	now := time.Now().UTC()
+
+	t.Run("no checks", func(t *testing.T) {
+		stats, err := store.GetCheckStats(context.Background(), target.ID, now.Add(-time.Hour))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if stats.TotalChecks != 0 || stats.UptimePercent != nil || stats.P50LatencyMs != nil {
+			t.Errorf("expected empty stats, got %+v", stats)
+		}
+	})
+
+	// 10 checks in the window with latencies 10..100; two are down
+	for i := 1; i <= 10; i++ {
+		result := models.CheckResult{CheckedAt: now.Add(-time.Duration(i) * time.Minute), StatusCode: intPtr(200), LatencyMs: i * 10}
+		switch i {
+		case 3:
+			result.StatusCode = intPtr(503)
+		case 7:
+			result.StatusCode = nil
+			result.Error = stringPtr("connection refused")
+		}
+		store.SaveCheckResult(context.Background(), target.ID, result)
+	}
+	// Outside the window
+	store.SaveCheckResult(context.Background(), target.ID, models.CheckResult{CheckedAt: now.Add(-2 * time.Hour), StatusCode: intPtr(500), LatencyMs: 5000})
+
+	stats, err := store.GetCheckStats(context.Background(), target.ID, now.Add(-time.Hour))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if stats.TotalChecks != 10 {
+		t.Errorf("expected 10 checks, got %d", stats.TotalChecks)
+	}
+	if stats.UptimePercent == nil || *stats.UptimePercent != 80 {
+		t.Errorf("expected 80%% uptime, got %v", stats.UptimePercent)
+	}
+	if stats.AvgLatencyMs == nil || *stats.AvgLatencyMs != 55 {
+		t.Errorf("expected average latency 55, got %v", stats.AvgLatencyMs)
+	}
+	if stats.P50LatencyMs == nil || *stats.P50LatencyMs != 50 {
+		t.Errorf("expected p50 latency 50, got %v", stats.P50LatencyMs)
+	}
+	if stats.P95LatencyMs == nil || *stats.P95LatencyMs != 100 {
+		t.Errorf("expected p95 latency 100, got %v", stats.P95LatencyMs)
+	}
+
+	// Oldest first the checks go up, down, up, down, up: four flaps over
+	// nine consecutive pairs
+	if stats.FlapCount != 4 {
+		t.Errorf("expected 4 flaps, got %d", stats.FlapCount)
+	}
+	if stats.StabilityPercent == nil || math.Abs(*stats.StabilityPercent-100*5.0/9) > 1e-9 {
+		t.Errorf("expected stability %.2f, got %v", 100*5.0/9, stats.StabilityPercent)
+	}
+
+	t.Run("steadily down is stable", func(t *testing.T) {
+		down, _, _ := store.CreateTarget(context.Background(), "https://down.example.com", "https://down.example.com", nil)
+		for i := 1; i <= 5; i++ {
+			store.SaveCheckResult(context.Background(), down.ID, models.CheckResult{CheckedAt: now.Add(-time.Duration(i) * time.Minute), StatusCode: intPtr(503)})
+		}
+
+		stats, err := store.GetCheckStats(context.Background(), down.ID, now.Add(-time.Hour))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if stats.FlapCount != 0 || stats.StabilityPercent == nil || *stats.StabilityPercent != 100 {
+			t.Errorf("expected no flaps and full stability, got %d and %v", stats.FlapCount, stats.StabilityPercent)
+		}
+	})
+}
+
+func TestConsecutiveFailures(t *testing.T) {
+	store := setupTestDB(t)
+
+	target, _, _ := store.CreateTarget(context.Background(), "https://example.com", "https://example.com", nil)
+	streak := func() int {
+		t.Helper()
+		got, err := store.GetTarget(context.Background(), target.ID)
+		if err != nil || got == nil {
+			t.Fatalf("failed to get target: %v", err)
+		}
+		return got.ConsecutiveFailures
+	}
+
+	now := time.Now().UTC()
+	down := models.CheckResult{StatusCode: intPtr(503)}
+	failed := models.CheckResult{Error: stringPtr("connection refused")}
+	up := models.CheckResult{StatusCode: intPtr(200)}
+	for i, tt := range []struct {
+		result   models.CheckResult
+		expected int
+	}{{down, 1}, {failed, 2}, {up, 0}, {down, 1}, {down, 2}} {
+		tt.result.CheckedAt = now.Add(time.Duration(i-10) * time.Minute)
+		store.SaveCheckResult(context.Background(), target.ID, tt.result)
+		if got := streak(); got != tt.expected {
+			t.Errorf("after check %d expected %d consecutive failures, got %d", i, tt.expected, got)
+		}
+	}
+
+	// A result older than the latest arrives late and doesn't count
+	late := models.CheckResult{CheckedAt: now.Add(-time.Hour), Error: stringPtr("timeout")}
+	store.SaveCheckResult(context.Background(), target.ID, late)
+	if got := streak(); got != 2 {
+		t.Errorf("expected a late result to be ignored, got %d", got)
+	}
+
+	stats, err := store.GetCheckStats(context.Background(), target.ID, now.Add(-time.Minute))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if stats.ConsecutiveFailures != 2 {
+		t.Errorf("expected stats to carry the streak past the window, got %d", stats.ConsecutiveFailures)
+	}
+
+	t.Run("backfilled by the migration", func(t *testing.T) {
+		if _, err := store.db.Exec("UPDATE targets SET consecutive_failures = 0"); err != nil {
+			t.Fatalf("failed to reset streak: %v", err)
+		}
+		tx, err := store.db.Begin()
+		if err != nil {
+			t.Fatalf("failed to begin: %v", err)
+		}
+		if err := addConsecutiveFailures(tx); err != nil {
+			tx.Rollback()
+			t.Fatalf("failed to backfill: %v", err)
+		}
+		if err := tx.Commit(); err != nil {
+			t.Fatalf("failed to commit: %v", err)
+		}
+		if got := streak(); got != 2 {
+			t.Errorf("expected a backfilled streak of 2, got %d", got)
+		}
+	})
+}
+
+func TestSuccessStatusStats(t *testing.T) {
+	store := setupTestDB(t)
+
+	// Auth-protected: 401 is healthy, a redirect to the login page isn't
+	successStatus := "200-299,401"
+	target, _, err := store.CreateTargetWithSettings(context.Background(), "https://example.com", "https://example.com", nil,
+		models.TargetSettings{SuccessStatus: &successStatus})
+	if err != nil {
+		t.Fatalf("failed to create target: %v", err)
+	}
+	profile, err := store.CreateProfile(context.Background(), models.ProfileRequest{Name: "auth", SuccessStatus: &successStatus})
+	if err != nil {
+		t.Fatalf("failed to create profile: %v", err)
+	}
+	inherited, _, err := store.CreateTargetWithSettings(context.Background(), "https://example.org", "https://example.org", nil,
+		models.TargetSettings{ProfileID: &profile.ID})
+	if err != nil {
+		t.Fatalf("failed to create target: %v", err)
+	}
+	plain, _, _ := store.CreateTarget(context.Background(), "https://example.net", "https://example.net", nil)
+
+	now := time.Now().UTC()
+	var checks []TargetCheck
+	for _, id := range []string{target.ID, inherited.ID, plain.ID} {
+		for i, result := range []models.CheckResult{
+			{StatusCode: intPtr(200)},
+			{StatusCode: intPtr(401)},
+			{StatusCode: intPtr(304), NotModified: true},
+			{StatusCode: intPtr(302)},
+		} {
+			result.CheckedAt = now.Add(time.Duration(i-10) * time.Minute)
+			checks = append(checks, TargetCheck{TargetID: id, Result: result})
+		}
+	}
+	if err := store.SaveCheckResults(context.Background(), checks); err != nil {
+		t.Fatalf("failed to save results: %v", err)
+	}
+
+	for _, tt := range []struct {
+		name     string
+		id       string
+		uptime   float64
+		flaps    int
+		failures int
+	}{
+		{"own success_status", target.ID, 75, 1, 1},
+		{"profile success_status", inherited.ID, 75, 1, 1},
+		{"default 2xx/3xx", plain.ID, 75, 2, 0},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			stats, err := store.GetCheckStats(context.Background(), tt.id, now.Add(-time.Hour))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if stats.UptimePercent == nil || *stats.UptimePercent != tt.uptime {
+				t.Errorf("expected uptime %v, got %v", tt.uptime, stats.UptimePercent)
+			}
+			if stats.FlapCount != tt.flaps {
+				t.Errorf("expected %d flaps, got %d", tt.flaps, stats.FlapCount)
+			}
+			if stats.ConsecutiveFailures != tt.failures {
+				t.Errorf("expected %d consecutive failures, got %d", tt.failures, stats.ConsecutiveFailures)
+			}
+		})
+	}
+}
+
+func TestHostLatencyStats(t *testing.T) {
+	store := setupTestDB(t)
+
+	fast, _, _ := store.CreateTarget(context.Background(), "https://fast.example/a", "https://fast.example/a", nil)
+	slowA, _, _ := store.CreateTarget(context.Background(), "https://slow.example/a", "https://slow.example/a", nil)
+	slowB, _, _ := store.CreateTarget(context.Background(), "https://slow.example/b", "https://slow.example/b", nil)
+
+	now := time.Now().UTC()
+	var checks []TargetCheck
+	add := func(id string, at time.Time, latencies ...int) {
+		for _, latency := range latencies {
+			checks = append(checks, TargetCheck{TargetID: id, Result: models.CheckResult{CheckedAt: at, StatusCode: intPtr(200), LatencyMs: latency}})
+		}
+	}
+	add(fast.ID, now.Add(-time.Minute), 10, 20, 30, 40)
+	// Both targets on slow.example count towards it; a timeout is a failure
+	// but its latency still counts
+	add(slowA.ID, now.Add(-time.Minute), 100, 200, 300, 400, 500)
+	add(slowB.ID, now.Add(-time.Minute), 600, 700, 800, 900)
+	checks = append(checks, TargetCheck{TargetID: slowB.ID, Result: models.CheckResult{CheckedAt: now.Add(-time.Minute), Error: stringPtr("timeout"), LatencyMs: 5000}})
+	// Outside the window
+	add(fast.ID, now.Add(-2*time.Hour), 9000)
+	if err := store.SaveCheckResults(context.Background(), checks); err != nil {
+		t.Fatalf("failed to save results: %v", err)
+	}
+
+	hosts, err := store.GetHostLatencyStats(context.Background(), now.Add(-time.Hour))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(hosts) != 2 {
+		t.Fatalf("expected 2 hosts, got %+v", hosts)
+	}
+
+	slow := hosts[0]
+	if slow.Host != "slow.example" || slow.TargetCount != 2 || slow.TotalChecks != 10 || slow.FailedChecks != 1 {
+		t.Errorf("expected slow.example first with 2 targets, 10 checks and 1 failure, got %+v", slow)
+	}
+	if slow.P50LatencyMs != 500 || slow.P95LatencyMs != 5000 || slow.P99LatencyMs != 5000 {
+		t.Errorf("expected p50 500, p95 5000 and p99 5000 for slow.example, got %+v", slow)
+	}
+	if slow.AvgLatencyMs != 950 {
+		t.Errorf("expected an average of 950ms for slow.example, got %v", slow.AvgLatencyMs)
+	}
+
+	quick := hosts[1]
+	if quick.Host != "fast.example" || quick.TotalChecks != 4 || quick.P50LatencyMs != 20 || quick.P99LatencyMs != 40 {
+		t.Errorf("expected fast.example with 4 checks, p50 20 and p99 40, got %+v", quick)
+	}
+
+	hosts, err = store.GetHostLatencyStats(context.Background(), now)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if hosts == nil || len(hosts) != 0 {
+		t.Errorf("expected an empty list with no checks in the window, got %#v", hosts)
+	}
+}
+
+func TestAuditLog(t *testing.T) {
+	store := setupTestDB(t)
+	store.SetAuditLog(true)
+
+	target, _, err := store.CreateTarget(context.Background(), "https://example.com", "https://example.com", nil)
+	if err != nil {
+		t.Fatalf("failed to create target: %v", err)
+	}
+
+	now := time.Now().UTC()
+	results := []models.CheckResult{
+		{CheckedAt: now.Add(-2 * time.Minute), StatusCode: intPtr(200), LatencyMs: 120},
+		{CheckedAt: now.Add(-time.Minute), LatencyMs: 5000, Error: stringPtr("connection timeout")},
+		{CheckedAt: now, StatusCode: intPtr(503), LatencyMs: 80},
+	}
+
+	for _, result := range results {
+		if err := store.SaveCheckResult(context.Background(), target.ID, result); err != nil {
+			t.Fatalf("failed to save check result: %v", err)
+		}
+	}
+
+	t.Run("chain verifies", func(t *testing.T) {
+		verification, err := store.VerifyAuditLog(context.Background())
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if !verification.Valid {
+			t.Errorf("expected valid chain, broken at %v", *verification.BrokenAt)
+		}
+
+		if verification.Entries != 3 {
+			t.Errorf("expected 3 audit entries, got %d", verification.Entries)
+		}
+	})
+
+	t.Run("tampered row is detected", func(t *testing.T) {
+		if _, err := store.db.Exec("UPDATE audit_log SET latency_ms = 10 WHERE seq = 2"); err != nil {
+			t.Fatalf("failed to tamper with audit log: %v", err)
+		}
+
+		verification, err := store.VerifyAuditLog(context.Background())
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if verification.Valid {
+			t.Fatal("expected tampered chain to be invalid")
+		}
+
+		if verification.BrokenAt == nil || *verification.BrokenAt != 2 {
+			t.Errorf("expected chain broken at seq 2, got %v", verification.BrokenAt)
+		}
+	})
+}
+
+func TestGetTargetsDue(t *testing.T) {
+	store := setupTestDB(t)
+
+	if next, err := store.NextCheckAt(context.Background()); err != nil || next != nil {
+		t.Fatalf("expected no next check without targets, got %v, %v", next, err)
+	}
+
+	var ids []string
+	for _, u := range []string{"https://a.example", "https://b.example", "https://c.example", "https://d.example"} {
+		target, _, err := store.CreateTarget(context.Background(), u, u, nil)
+		if err != nil {
+			t.Fatalf("failed to create target: %v", err)
+		}
+		ids = append(ids, target.ID)
+	}
+	paused := true
+	store.UpdateTarget(context.Background(), ids[3], models.UpdateTargetRequest{Paused: &paused})
+
+	now := time.Now().UTC()
+	err := store.SetNextCheckAt(context.Background(), map[string]time.Time{
+		ids[0]: now.Add(time.Minute),    // Not due yet
+		ids[1]: now.Add(-2 * time.Hour), // Overdue the longest
+	})
+	if err != nil {
+		t.Fatalf("failed to set next check: %v", err)
+	}
+
+	due, err := store.GetTargetsDue(context.Background(), now, 10)
+	if err != nil {
+		t.Fatalf("failed to get due targets: %v", err)
+	}
+	// c was never scheduled, so it is due since it was created (just now)
+	if len(due) != 2 || due[0].ID != ids[1] || due[1].ID != ids[2] {
+		t.Fatalf("expected b then c to be due, got %+v", due)
+	}
+
+	if due, _ := store.GetTargetsDue(context.Background(), now, 1); len(due) != 1 || due[0].ID != ids[1] {
+		t.Errorf("expected the limit to keep the most overdue target, got %+v", due)
+	}
+	if due, _ := store.GetTargetsDue(context.Background(), now.Add(time.Hour), 10); len(due) != 3 {
+		t.Errorf("expected all unpaused targets to be due in an hour, got %d", len(due))
+	}
+
+	next, err := store.NextCheckAt(context.Background())
+	if err != nil || next == nil || !next.Equal(now.Add(-2*time.Hour)) {
+		t.Errorf("expected the next check at b's due time, got %v, %v", next, err)
+	}
+}
+
+func TestSaveCheckResults(t *testing.T) {
+	store := setupTestDB(t)
+	store.SetAuditLog(true)
+
+	first, _, _ := store.CreateTarget(context.Background(), "https://example.com", "https://example.com", nil)
+	second, _, _ := store.CreateTarget(context.Background(), "https://example.org", "https://example.org", nil)
+
+	// A single save first, so the batch has to continue an existing chain
+	now := time.Now().UTC()
+	if err := store.SaveCheckResult(context.Background(), first.ID, models.CheckResult{CheckedAt: now.Add(-time.Minute), StatusCode: intPtr(200)}); err != nil {
+		t.Fatalf("failed to save check result: %v", err)
+	}
+
+	err := store.SaveCheckResults(context.Background(), []TargetCheck{
+		{TargetID: first.ID, Result: models.CheckResult{CheckedAt: now, StatusCode: intPtr(200), LatencyMs: 40}},
+		{TargetID: second.ID, Result: models.CheckResult{CheckedAt: now, LatencyMs: 5000, Error: stringPtr("timeout")}},
+	})
+	if err != nil {
+		t.Fatalf("failed to save check results: %v", err)
+	}
+
+	for _, target := range []*models.Target{first, second} {
+		latest, err := store.GetLatestCheckResult(context.Background(), target.ID, true)
+		if err != nil || latest == nil {
+			t.Fatalf("failed to get latest result for %s: %v", target.ID, err)
+		}
+		if !latest.CheckedAt.Equal(now) {
+			t.Errorf("expected the batched result for %s, got one checked at %v", target.ID, latest.CheckedAt)
+		}
+		updated, _ := store.GetTarget(context.Background(), target.ID)
+		if updated.LastCheckedAt == nil || !updated.LastCheckedAt.Equal(now) {
+			t.Errorf("expected last_checked_at %v for %s, got %v", now, target.ID, updated.LastCheckedAt)
+		}
+	}
+
+	verification, err := store.VerifyAuditLog(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !verification.Valid || verification.Entries != 3 {
+		t.Errorf("expected a valid chain of 3 entries, got valid=%t entries=%d", verification.Valid, verification.Entries)
+	}
+
+	if err := store.SaveCheckResults(context.Background(), nil); err != nil {
+		t.Errorf("expected an empty batch to be a no-op, got %v", err)
+	}
+}
+
+func TestLatencyTrend(t *testing.T) {
+	// Latencies are listed oldest first and reversed below, since results
+	// come back most recent first
+	tests := []struct {
+		name      string
+		latencies []int
+		expected  string
+	}{
+		{"rising", []int{100, 110, 105, 100, 200, 220, 210, 230}, models.TrendDegrading},
+		{"flat", []int{100, 105, 98, 102, 101, 99, 104, 100}, models.TrendStable},
+		{"falling", []int{300, 280, 310, 290, 120, 110, 130, 100}, models.TrendImproving},
+		{"too few checks", []int{100, 300}, models.TrendUnknown},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var results []models.CheckResult
+			for i := len(tt.latencies) - 1; i >= 0; i-- {
+				results = append(results, models.CheckResult{StatusCode: intPtr(200), LatencyMs: tt.latencies[i]})
+			}
+
+			if trend := LatencyTrend(results, 4); trend != tt.expected {
+				t.Errorf("expected trend %q, got %q", tt.expected, trend)
+			}
+		})
+	}
+
+	t.Run("failed checks are ignored", func(t *testing.T) {
+		results := []models.CheckResult{
+			{LatencyMs: 5000, Error: stringPtr("timeout")},
+			{StatusCode: intPtr(200), LatencyMs: 100},
+			{StatusCode: intPtr(200), LatencyMs: 100},
+			{LatencyMs: 5000, Error: stringPtr("timeout")},
+			{StatusCode: intPtr(200), LatencyMs: 100},
+			{StatusCode: intPtr(200), LatencyMs: 100},
+		}
+
+		if trend := LatencyTrend(results, 2); trend != models.TrendStable {
+			t.Errorf("expected trend %q, got %q", models.TrendStable, trend)
+		}
+	})
+}
+
+func TestCheckQueue(t *testing.T) {
+	store := setupTestDB(t)
+
+	target, _, err := store.CreateTarget(context.Background(), "https://example.com", "https://example.com", nil)
+	if err != nil {
+		t.Fatalf("failed to create target: %v", err)
+	}
+
+	queued, err := store.EnqueueChecks(context.Background(), []string{target.ID})
+	if err != nil || queued != 1 {
+		t.Fatalf("expected 1 queued, got %d (%v)", queued, err)
+	}
+
+	// Re-enqueueing a target that is already queued is a no-op
+	if queued, _ := store.EnqueueChecks(context.Background(), []string{target.ID}); queued != 0 {
+		t.Errorf("expected duplicate enqueue to queue 0, got %d", queued)
+	}
+
+	claimed, err := store.ClaimChecks(context.Background(), "w1", 10, time.Minute)
+	if err != nil || len(claimed) != 1 || claimed[0].ID != target.ID {
+		t.Fatalf("expected w1 to claim the target, got %v (%v)", claimed, err)
+	}
+
+	if claimed, _ := store.ClaimChecks(context.Background(), "w2", 10, time.Minute); len(claimed) != 0 {
+		t.Errorf("expected nothing for w2 while w1 holds the lease, got %d", len(claimed))
+	}
+
+	// Only the lease holder can complete the entry
+	if err := store.CompleteCheck(context.Background(), target.ID, "w2"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if queued, _ := store.EnqueueChecks(context.Background(), []string{target.ID}); queued != 0 {
+		t.Error("expected entry to remain after completion by another worker")
+	}
+
+	if err := store.CompleteCheck(context.Background(), target.ID, "w1"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if queued, _ := store.EnqueueChecks(context.Background(), []string{target.ID}); queued != 1 {
+		t.Error("expected entry to be removed after completion")
+	}
+
+	t.Run("expired lease is reclaimable", func(t *testing.T) {
+		if claimed, _ := store.ClaimChecks(context.Background(), "w1", 10, -time.Second); len(claimed) != 1 {
+			t.Fatalf("expected w1 to claim the target, got %d", len(claimed))
+		}
+
+		claimed, err := store.ClaimChecks(context.Background(), "w2", 10, time.Minute)
+		if err != nil || len(claimed) != 1 {
+			t.Errorf("expected w2 to take over the expired lease, got %d (%v)", len(claimed), err)
+		}
+	})
+}
+
+func TestSaveCheckResultConcurrentSQLite(t *testing.T) {
+	// A file database with no busy timeout, so concurrent writers get
+	// "database is locked" immediately and rely on the retry
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "busy.db")+"?_busy_timeout=0")
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	defer db.Close()
+
+	store := New(db)
+	if err := store.Migrate(); err != nil {
+		t.Fatalf("failed to migrate database: %v", err)
+	}
+
+	target, _, err := store.CreateTarget(context.Background(), "https://example.com", "https://example.com", nil)
+	if err != nil {
+		t.Fatalf("failed to create target: %v", err)
+	}
+
+	const writers, writesEach = 8, 25
+	errs := make(chan error, writers*writesEach)
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < writesEach; i++ {
+				errs <- store.SaveCheckResult(context.Background(), target.ID, models.CheckResult{
+					CheckedAt:  time.Now().UTC(),
+					StatusCode: intPtr(200),
+					LatencyMs:  i,
+				})
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Errorf("unexpected save error: %v", err)
+		}
+	}
+
+	var count int
+	if err := db.QueryRow("SELECT COUNT(*) FROM check_results WHERE target_id = ?", target.ID).Scan(&count); err != nil {
+		t.Fatalf("failed to count results: %v", err)
+	}
+	if count != writers*writesEach {
+		t.Errorf("expected %d results, got %d", writers*writesEach, count)
+	}
+}
+
+func TestCreateTargetLostRace(t *testing.T) {
+	store := setupTestDB(t)
+
+	existing, _, err := store.CreateTarget(context.Background(), "https://race.example.com", "https://race.example.com", nil)
+	if err != nil {
+		t.Fatalf("failed to create target: %v", err)
+	}
+
+	// The first attempt loses the race as a concurrent create would: its
+	// insert of the URL hits the unique constraint
+	attempts := 0
+	var target *models.Target
+	var isNew bool
+	err = store.createTx(context.Background(), func(tx *tx) error {
+		attempts++
+		if attempts == 1 {
+			_, err := tx.Exec("INSERT INTO targets (id, url, canonical_url, created_at) VALUES (?, ?, ?, ?)",
+				GenerateID("t_"), existing.URL, existing.URL, time.Now().UTC())
+			return err
+		}
+		var err error
+		target, isNew, err = store.createTarget(context.Background(), tx, existing.URL, existing.URL, nil, models.TargetSettings{})
+		return err
 	})
+	if err != nil {
+		t.Fatalf("expected the unique violation to be retried, got %v", err)
+	}
+	if attempts != 2 {
+		t.Errorf("expected 2 attempts, got %d", attempts)
+	}
+	if isNew || target.ID != existing.ID {
+		t.Errorf("expected the existing target %s, got %s (new: %v)", existing.ID, target.ID, isNew)
+	}
+
+	// Only one retry: a violation that persists is returned
+	attempts = 0
+	err = store.createTx(context.Background(), func(tx *tx) error {
+		attempts++
+		_, err := tx.Exec("INSERT INTO targets (id, url, canonical_url, created_at) VALUES (?, ?, ?, ?)",
+			GenerateID("t_"), existing.URL, existing.URL, time.Now().UTC())
+		return err
+	})
+	if !isUniqueViolation(err) || attempts != 2 {
+		t.Errorf("expected the violation after 2 attempts, got %v after %d", err, attempts)
+	}
+}
+
+func TestIsUniqueViolation(t *testing.T) {
+	if !isUniqueViolation(errors.New("UNIQUE constraint failed: targets.canonical_url")) {
+		t.Error("expected a SQLite unique error to be a violation")
+	}
+	if !isUniqueViolation(fmt.Errorf("insert: %w", &pq.Error{Code: "23505"})) {
+		t.Error("expected a wrapped Postgres unique error to be a violation")
+	}
+	if isUniqueViolation(nil) || isUniqueViolation(&pq.Error{Code: "23503"}) || isUniqueViolation(errors.New("database is locked")) {
+		t.Error("expected other errors not to be violations")
+	}
+}
+
+func TestIsBusy(t *testing.T) {
+	if !isBusy(errors.New("database is locked")) || !isBusy(errors.New("database table is locked")) {
+		t.Error("expected lock errors to be busy")
+	}
+	if isBusy(nil) || isBusy(errors.New("UNIQUE constraint failed")) {
+		t.Error("expected other errors not to be busy")
+	}
+}
+
+func TestCancelledContext(t *testing.T) {
+	store := setupTestDB(t)
+
+	target, _, err := store.CreateTarget(context.Background(), "https://example.com", "https://example.com", nil)
+	if err != nil {
+		t.Fatalf("failed to create target: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := store.GetTarget(ctx, target.ID); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected a read to fail with %v, got %v", context.Canceled, err)
+	}
+	err = store.SaveCheckResult(ctx, target.ID, models.CheckResult{CheckedAt: time.Now(), StatusCode: intPtr(200)})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected a write to fail with %v, got %v", context.Canceled, err)
+	}
+	if result, err := store.GetLatestCheckResult(context.Background(), target.ID, true); err != nil || result != nil {
+		t.Errorf("expected nothing saved, got %v (%v)", result, err)
+	}
+
+	// A busy database isn't waited on once the context is done
+	attempts := 0
+	err = retryBusy(ctx, func() error {
+		attempts++
+		return errors.New("database is locked")
+	})
+	if !isBusy(err) || attempts != 1 {
+		t.Errorf("expected one attempt returning the busy error, got %d (%v)", attempts, err)
+	}
+}
+
+func TestLastCheckedAt(t *testing.T) {
+	store := setupTestDB(t)
+
+	target, _, err := store.CreateTarget(context.Background(), "https://example.com", "https://example.com", nil)
+	if err != nil {
+		t.Fatalf("failed to create target: %v", err)
+	}
+	if target.LastCheckedAt != nil {
+		t.Error("expected a new target to have no last_checked_at")
+	}
+
+	checkedAt := time.Now().UTC().Truncate(time.Second)
+	store.SaveCheckResult(context.Background(), target.ID, models.CheckResult{CheckedAt: checkedAt, StatusCode: intPtr(200)})
+
+	// A late result from before the latest one doesn't move it back
+	store.SaveCheckResult(context.Background(), target.ID, models.CheckResult{CheckedAt: checkedAt.Add(-time.Minute), StatusCode: intPtr(200)})
+
+	target, err = store.GetTarget(context.Background(), target.ID)
+	if err != nil {
+		t.Fatalf("failed to get target: %v", err)
+	}
+	if target.LastCheckedAt == nil || !target.LastCheckedAt.Equal(checkedAt) {
+		t.Errorf("expected last_checked_at %s, got %v", checkedAt, target.LastCheckedAt)
+	}
+}
+
+func TestTargetLastCheckSummary(t *testing.T) {
+	store := setupTestDB(t)
+
+	checked, _, err := store.CreateTarget(context.Background(), "https://example.com", "https://example.com", nil)
+	if err != nil {
+		t.Fatalf("failed to create target: %v", err)
+	}
+	never, _, err := store.CreateTarget(context.Background(), "https://example.org", "https://example.org", nil)
+	if err != nil {
+		t.Fatalf("failed to create target: %v", err)
+	}
+
+	checkedAt := time.Now().UTC().Truncate(time.Second)
+	failure := "connection refused"
+	store.SaveCheckResult(context.Background(), checked.ID, models.CheckResult{CheckedAt: checkedAt.Add(-time.Minute), StatusCode: intPtr(200)})
+	store.SaveCheckResult(context.Background(), checked.ID, models.CheckResult{CheckedAt: checkedAt, Error: &failure})
+
+	list, err := store.ListTargets(context.Background(), nil, 10, "")
+	if err != nil {
+		t.Fatalf("failed to list targets: %v", err)
+	}
+	if len(list.Items) != 2 {
+		t.Fatalf("expected 2 targets, got %d", len(list.Items))
+	}
+
+	got := list.Items[0]
+	if got.ID != checked.ID {
+		t.Fatalf("expected %s first, got %s", checked.ID, got.ID)
+	}
+	if got.LastCheckedAt == nil || !got.LastCheckedAt.Equal(checkedAt) {
+		t.Errorf("expected last_checked_at %s, got %v", checkedAt, got.LastCheckedAt)
+	}
+	if got.LastStatusCode != nil {
+		t.Errorf("expected no last_status_code for a failed check, got %d", *got.LastStatusCode)
+	}
+	if got.LastError == nil || *got.LastError != failure {
+		t.Errorf("expected last_error %q, got %v", failure, got.LastError)
+	}
+
+	if got := list.Items[1]; got.ID != never.ID {
+		t.Errorf("expected %s second, got %s", never.ID, got.ID)
+	}
+	if got := list.Items[1]; got.LastCheckedAt != nil || got.LastStatusCode != nil || got.LastError != nil {
+		t.Errorf("expected no summary for a target never checked, got %+v", got)
+	}
+
+	// GetTarget agrees, and deleting the results clears the summary
+	target, err := store.GetTarget(context.Background(), checked.ID)
+	if err != nil {
+		t.Fatalf("failed to get target: %v", err)
+	}
+	if target.LastError == nil || *target.LastError != failure {
+		t.Errorf("expected last_error %q from GetTarget, got %v", failure, target.LastError)
+	}
+
+	if _, err := store.DeleteCheckResults(context.Background(), checked.ID); err != nil {
+		t.Fatalf("failed to delete results: %v", err)
+	}
+	target, err = store.GetTarget(context.Background(), checked.ID)
+	if err != nil {
+		t.Fatalf("failed to get target: %v", err)
+	}
+	if target.LastCheckedAt != nil || target.LastStatusCode != nil || target.LastError != nil {
+		t.Errorf("expected the summary cleared with the results, got %+v", target)
+	}
+}
+
+func TestMigrations(t *testing.T) {
+	for i, m := range migrations {
+		if m.version != i+1 {
+			t.Fatalf("expected migration %d to have version %d, got %d", i, i+1, m.version)
+		}
+	}
+
+	store := setupTestDB(t)
+	latest := migrations[len(migrations)-1].version
+
+	applied, err := store.appliedMigrations()
+	if err != nil {
+		t.Fatalf("failed to read applied migrations: %v", err)
+	}
+	if len(applied) != len(migrations) || !applied[latest] {
+		t.Fatalf("expected all %d migrations recorded, got %v", len(migrations), applied)
+	}
+
+	// Running again is a no-op and keeps the data
+	target, _, err := store.CreateTarget(context.Background(), "https://example.com", "https://example.com", nil)
+	if err != nil {
+		t.Fatalf("failed to create target: %v", err)
+	}
+	if err := store.Migrate(); err != nil {
+		t.Fatalf("failed to migrate again: %v", err)
+	}
+	if got, err := store.GetTarget(context.Background(), target.ID); err != nil || got == nil {
+		t.Fatalf("expected the target to survive, got %v (%v)", got, err)
+	}
+
+	t.Run("database from before versioning", func(t *testing.T) {
+		// The full schema, but nothing recorded
+		if _, err := store.db.Exec("DELETE FROM schema_migrations"); err != nil {
+			t.Fatalf("failed to clear migrations: %v", err)
+		}
+		if err := store.Migrate(); err != nil {
+			t.Fatalf("failed to migrate: %v", err)
+		}
+
+		applied, err := store.appliedMigrations()
+		if err != nil || len(applied) != len(migrations) {
+			t.Errorf("expected all %d migrations recorded, got %v (%v)", len(migrations), applied, err)
+		}
+		if got, err := store.GetTarget(context.Background(), target.ID); err != nil || got == nil {
+			t.Errorf("expected the target to survive, got %v (%v)", got, err)
+		}
+	})
+
+	t.Run("failed step is rolled back", func(t *testing.T) {
+		saved := migrations
+		t.Cleanup(func() { migrations = saved })
+		migrations = append(saved[:len(saved):len(saved)],
+			migration{latest + 1, "add a column, then fail", func(t *tx) error {
+				if err := addColumn(t, column{"targets", "doomed", "TEXT"}); err != nil {
+					return err
+				}
+				_, err := t.Exec("SELECT * FROM no_such_table")
+				return err
+			}},
+		)
+
+		err := store.Migrate()
+		if err == nil || !strings.Contains(err.Error(), fmt.Sprintf("migration %d", latest+1)) {
+			t.Fatalf("expected migration %d to fail, got %v", latest+1, err)
+		}
+
+		applied, err := store.appliedMigrations()
+		if err != nil || applied[latest+1] {
+			t.Errorf("expected the failed migration not to be recorded, got %v (%v)", applied, err)
+		}
+		if _, err := store.db.Exec("SELECT doomed FROM targets"); err == nil {
+			t.Error("expected the column added by the failed migration to be rolled back")
+		}
+	})
+}
+
+func TestPausedMigrationDefault(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("failed to open test database: %v", err)
+	}
+	db.SetMaxOpenConns(1)
+
+	// A targets table from before the paused column existed
+	_, err = db.Exec(`CREATE TABLE targets (
+		id TEXT PRIMARY KEY,
+		url TEXT NOT NULL UNIQUE,
+		canonical_url TEXT NOT NULL UNIQUE,
+		created_at TIMESTAMP NOT NULL
+	);
+	INSERT INTO targets (id, url, canonical_url, created_at) VALUES ('t_old', 'https://example.com', 'https://example.com', CURRENT_TIMESTAMP)`)
+	if err != nil {
+		t.Fatalf("failed to create legacy table: %v", err)
+	}
+
+	store := New(db)
+	if err := store.Migrate(); err != nil {
+		t.Fatalf("failed to migrate: %v", err)
+	}
+
+	target, err := store.GetTarget(context.Background(), "t_old")
+	if err != nil || target == nil {
+		t.Fatalf("failed to get target: %v", err)
+	}
+	if target.Paused {
+		t.Error("expected existing targets to default to not paused")
+	}
+
+	paused := true
+	updated, err := store.UpdateTarget(context.Background(), "t_old", models.UpdateTargetRequest{Paused: &paused})
+	if err != nil || updated == nil || !updated.Paused {
+		t.Fatalf("expected target to be paused, got %+v (%v)", updated, err)
+	}
+
+	if missing, err := store.UpdateTarget(context.Background(), "t_missing", models.UpdateTargetRequest{Paused: &paused}); err != nil || missing != nil {
+		t.Errorf("expected nil for a missing target, got %+v (%v)", missing, err)
+	}
 }
 
 func intPtr(i int) *int {
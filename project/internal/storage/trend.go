@@ -0,0 +1,61 @@
+package storage
+
+import (
+	"sort"
+
+	"github.com/aarushishahhh/linkwatch/project/internal/models"
+)
+
+// trendThreshold is the relative change in median latency between the two
+// windows needed to call a trend improving or degrading.
+const trendThreshold = 0.2
+
+// LatencyTrend classifies latency over results ordered most recent first by
+// comparing the median of the latest window successful checks against the
+// median of the window before it. Failed checks are ignored since their
+// latency mostly reflects timeouts. Returns TrendUnknown without enough data.
+func LatencyTrend(results []models.CheckResult, window int) string {
+	var latencies []int
+	for _, result := range results {
+		if result.Error == nil {
+			latencies = append(latencies, result.LatencyMs)
+		}
+	}
+
+	if window <= 0 {
+		return models.TrendUnknown
+	}
+	if len(latencies) > 2*window {
+		latencies = latencies[:2*window]
+	}
+
+	half := len(latencies) / 2
+	if half < 2 {
+		return models.TrendUnknown
+	}
+
+	recent := median(latencies[:half])
+	earlier := median(latencies[half : 2*half])
+
+	switch {
+	case earlier == 0 && recent == 0:
+		return models.TrendStable
+	case recent > earlier*(1+trendThreshold):
+		return models.TrendDegrading
+	case recent < earlier*(1-trendThreshold):
+		return models.TrendImproving
+	default:
+		return models.TrendStable
+	}
+}
+
+func median(values []int) float64 {
+	sorted := append([]int(nil), values...)
+	sort.Ints(sorted)
+
+	mid := len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		return float64(sorted[mid-1]+sorted[mid]) / 2
+	}
+	return float64(sorted[mid])
+}
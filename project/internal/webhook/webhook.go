@@ -0,0 +1,285 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/aarushishahhh/linkwatch/project/internal/models"
+	"github.com/aarushishahhh/linkwatch/project/internal/policy"
+	"github.com/aarushishahhh/linkwatch/project/internal/storage"
+)
+
+const (
+	StateUp   = "up"
+	StateDown = "down"
+)
+
+// Transition describes a target moving between the up and down states.
+// It is the data passed to webhook payload templates.
+type Transition struct {
+	Event    string             `json:"event"`
+	From     string             `json:"from"`
+	To       string             `json:"to"`
+	Target   models.Target      `json:"target"`
+	Result   models.CheckResult `json:"result"`
+	Previous models.CheckResult `json:"previous"`
+}
+
+var funcs = template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		if err != nil {
+			return "", err
+		}
+		return string(b), nil
+	},
+}
+
+// ParseTemplate parses a payload template. An empty template falls back to
+// the generic JSON encoding of the transition.
+func ParseTemplate(text string) (*template.Template, error) {
+	if text == "" {
+		return nil, nil
+	}
+	return template.New("payload").Funcs(funcs).Option("missingkey=error").Parse(text)
+}
+
+// ValidateTemplate checks that the template parses and renders against a
+// sample transition, so field typos are caught at registration time.
+func ValidateTemplate(text string) error {
+	tmpl, err := ParseTemplate(text)
+	if err != nil {
+		return err
+	}
+	if tmpl == nil {
+		return nil
+	}
+
+	statusCode := 200
+	sample := Transition{
+		Event:    "target.up",
+		From:     StateDown,
+		To:       StateUp,
+		Target:   models.Target{ID: "t_sample", URL: "https://example.com", CreatedAt: time.Now().UTC()},
+		Result:   models.CheckResult{CheckedAt: time.Now().UTC(), StatusCode: &statusCode},
+		Previous: models.CheckResult{CheckedAt: time.Now().UTC()},
+	}
+
+	var buf bytes.Buffer
+	return tmpl.Execute(&buf, sample)
+}
+
+// RenderPayload renders the webhook body for a transition. Templates see
+// the target's sensitive headers redacted, as the JSON encoding does.
+func RenderPayload(text string, t Transition) ([]byte, error) {
+	t.Target.Headers = t.Target.Headers.Redact()
+
+	tmpl, err := ParseTemplate(text)
+	if err != nil {
+		return nil, err
+	}
+	if tmpl == nil {
+		return json.Marshal(t)
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, t); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// NewTransition builds a transition between two results, or returns nil when
+// the health state did not change.
+func NewTransition(target models.Target, previous, result models.CheckResult) *Transition {
+	from, to := TargetState(target, previous), TargetState(target, result)
+	if from == to {
+		return nil
+	}
+	return &Transition{
+		Event:    "target." + to,
+		From:     from,
+		To:       to,
+		Target:   target,
+		Result:   result,
+		Previous: previous,
+	}
+}
+
+// State classifies a result as up (2xx/3xx without error) or down.
+func State(result models.CheckResult) string {
+	return StateFor(result, nil)
+}
+
+// StateFor classifies a result against explicit success ranges, falling back
+// to the default 2xx/3xx rule when there are none. Errored checks are always
+// down.
+func StateFor(result models.CheckResult, success policy.StatusRanges) string {
+	if result.Error != nil || result.StatusCode == nil {
+		return StateDown
+	}
+	if result.NotModified {
+		return StateUp
+	}
+
+	code := *result.StatusCode
+	healthy := code >= 200 && code < 400
+	if len(success) > 0 {
+		healthy = success.Contains(code)
+	}
+
+	if healthy {
+		return StateUp
+	}
+	return StateDown
+}
+
+// TargetState classifies a result using the target's configured success
+// status ranges, if any.
+func TargetState(target models.Target, result models.CheckResult) string {
+	var success policy.StatusRanges
+	if target.SuccessStatus != nil {
+		// Validated at creation, so a parse error only means no override
+		success, _ = policy.ParseStatusRanges(*target.SuccessStatus)
+	}
+	return StateFor(result, success)
+}
+
+// QueueSize is how many transitions wait for delivery before further ones
+// are dropped
+const QueueSize = 256
+
+// Notifier delivers transitions to the webhooks registered for them.
+type Notifier struct {
+	store  *storage.Storage
+	client *http.Client
+
+	queue  chan Transition
+	start  sync.Once
+	done   chan struct{}
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// mu guards closed, so nothing is queued once the queue is closed
+	mu     sync.Mutex
+	closed bool
+}
+
+// NewNotifier returns a notifier whose deliveries time out after timeout.
+// With blockPrivate, webhooks resolving to private addresses are refused at
+// dial time, as checks are.
+func NewNotifier(store *storage.Storage, timeout time.Duration, blockPrivate bool) *Notifier {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if blockPrivate {
+		dialer := &net.Dialer{Timeout: timeout, Control: policy.DialControl}
+		transport.DialContext = dialer.DialContext
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Notifier{
+		store:  store,
+		client: &http.Client{Timeout: timeout, Transport: transport},
+		queue:  make(chan Transition, QueueSize),
+		done:   make(chan struct{}),
+		ctx:    ctx,
+		cancel: cancel,
+	}
+}
+
+// Enqueue queues the transition for delivery in the background, so a slow
+// receiver doesn't hold up the check that caused it. The transition is
+// dropped, and false returned, when the queue is full or closed.
+func (n *Notifier) Enqueue(t Transition) bool {
+	n.start.Do(func() { go n.deliver() })
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.closed {
+		slog.Warn("webhook notifier closed, transition dropped", "target_id", t.Target.ID, "event", t.Event)
+		return false
+	}
+	select {
+	case n.queue <- t:
+		return true
+	default:
+		slog.Warn("webhook queue full, transition dropped", "target_id", t.Target.ID, "event", t.Event)
+		return false
+	}
+}
+
+func (n *Notifier) deliver() {
+	defer close(n.done)
+	for t := range n.queue {
+		n.Notify(n.ctx, t)
+	}
+}
+
+// Close stops queueing transitions and waits for the queued ones to be
+// delivered. If ctx is done first, deliveries still in progress are
+// cancelled and ctx's error returned.
+func (n *Notifier) Close(ctx context.Context) error {
+	n.mu.Lock()
+	if !n.closed {
+		n.closed = true
+		close(n.queue)
+	}
+	n.mu.Unlock()
+	n.start.Do(func() { go n.deliver() })
+
+	select {
+	case <-n.done:
+		return nil
+	case <-ctx.Done():
+		n.cancel()
+		return ctx.Err()
+	}
+}
+
+// Notify delivers the transition to every webhook registered for the target.
+// Delivery failures are logged and do not stop other webhooks.
+func (n *Notifier) Notify(ctx context.Context, t Transition) {
+	webhooks, err := n.store.GetWebhooksForTarget(ctx, t.Target.ID)
+	if err != nil {
+		slog.Error("failed to get webhooks", "target_id", t.Target.ID, "error", err)
+		return
+	}
+
+	for _, wh := range webhooks {
+		if err := n.send(ctx, wh, t); err != nil {
+			slog.Error("failed to deliver webhook", "webhook_id", wh.ID, "target_id", t.Target.ID, "error", err)
+		}
+	}
+}
+
+func (n *Notifier) send(ctx context.Context, wh models.Webhook, t Transition) error {
+	payload, err := RenderPayload(wh.Template, t)
+	if err != nil {
+		return fmt.Errorf("render payload: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", wh.URL, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Linkwatch/1.0")
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status: %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,361 @@
+package webhook
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aarushishahhh/linkwatch/project/internal/models"
+	"github.com/aarushishahhh/linkwatch/project/internal/storage"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func setupTestStore(t *testing.T) *storage.Storage {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("failed to open test database: %v", err)
+	}
+
+	store := storage.New(db)
+	if err := store.Migrate(); err != nil {
+		t.Fatalf("failed to migrate test database: %v", err)
+	}
+
+	return store
+}
+
+func testTransition() Transition {
+	checkedAt := time.Date(2025, 8, 17, 12, 0, 0, 0, time.UTC)
+	errorMsg := "server error: 503"
+	statusCode := 503
+	okCode := 200
+
+	transition := NewTransition(
+		models.Target{ID: "t_123", URL: "https://example.com", CreatedAt: checkedAt.Add(-time.Hour)},
+		models.CheckResult{CheckedAt: checkedAt.Add(-time.Minute), StatusCode: &okCode, LatencyMs: 80},
+		models.CheckResult{CheckedAt: checkedAt, StatusCode: &statusCode, LatencyMs: 120, Error: &errorMsg},
+	)
+	return *transition
+}
+
+func TestNewTransition(t *testing.T) {
+	okCode := 200
+	target := models.Target{ID: "t_1", URL: "https://example.com"}
+	up := models.CheckResult{StatusCode: &okCode}
+	down := models.CheckResult{}
+
+	if NewTransition(target, up, up) != nil {
+		t.Error("expected no transition between two healthy results")
+	}
+
+	transition := NewTransition(target, up, down)
+	if transition == nil {
+		t.Fatal("expected a transition from up to down")
+	}
+
+	if transition.Event != "target.down" || transition.From != StateUp || transition.To != StateDown {
+		t.Errorf("unexpected transition: %+v", transition)
+	}
+}
+
+func TestTargetStateSuccessStatus(t *testing.T) {
+	success := "200,418"
+	target := models.Target{ID: "t_1", URL: "https://example.com", SuccessStatus: &success}
+
+	result := func(code int) models.CheckResult {
+		return models.CheckResult{StatusCode: &code}
+	}
+
+	tests := []struct {
+		code     int
+		expected string
+	}{
+		{200, StateUp},
+		{418, StateUp},
+		{204, StateDown}, // Outside the explicit list even though it's 2xx
+		{500, StateDown},
+	}
+
+	for _, tt := range tests {
+		if state := TargetState(target, result(tt.code)); state != tt.expected {
+			t.Errorf("status %d: expected %s, got %s", tt.code, tt.expected, state)
+		}
+	}
+
+	// The default rule still applies to targets without ranges
+	if state := TargetState(models.Target{ID: "t_2"}, result(418)); state != StateDown {
+		t.Errorf("expected 418 to be down by default, got %s", state)
+	}
+
+	// Transitions follow the target's ranges
+	if NewTransition(target, result(200), result(418)) != nil {
+		t.Error("expected no transition between 200 and 418")
+	}
+	if transition := NewTransition(target, result(418), result(500)); transition == nil || transition.To != StateDown {
+		t.Errorf("expected a transition to down, got %+v", transition)
+	}
+}
+
+func TestRenderPayload(t *testing.T) {
+	transition := testTransition()
+
+	t.Run("slack style template", func(t *testing.T) {
+		tmpl := `{"text": "{{.Target.URL}} is {{.To}} (status {{.Result.StatusCode}}, {{.Result.LatencyMs}}ms)"}`
+
+		payload, err := RenderPayload(tmpl, transition)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		var body map[string]string
+		if err := json.Unmarshal(payload, &body); err != nil {
+			t.Fatalf("payload is not valid JSON: %v (%s)", err, payload)
+		}
+
+		expected := "https://example.com is down (status 503, 120ms)"
+		if body["text"] != expected {
+			t.Errorf("expected text %q, got %q", expected, body["text"])
+		}
+	})
+
+	t.Run("sensitive headers are redacted", func(t *testing.T) {
+		transition := testTransition()
+		transition.Target.Headers = models.Headers{"Authorization": "Bearer s3cret", "Accept": "text/html"}
+
+		for _, tmpl := range []string{
+			`{{.Target.Headers}}`,
+			`{{index .Target.Headers "Authorization"}}`,
+			`{{range $name, $value := .Target.Headers}}{{$value}} {{end}}`,
+			`{{json .Target}}`,
+		} {
+			payload, err := RenderPayload(tmpl, transition)
+			if err != nil {
+				t.Fatalf("unexpected error for %s: %v", tmpl, err)
+			}
+			if strings.Contains(string(payload), "s3cret") {
+				t.Errorf("expected %s to redact the credential, got %s", tmpl, payload)
+			}
+		}
+
+		payload, _ := RenderPayload(`{{index .Target.Headers "Accept"}}`, transition)
+		if string(payload) != "text/html" {
+			t.Errorf("expected other headers to be kept, got %s", payload)
+		}
+		if transition.Target.Headers["Authorization"] != "Bearer s3cret" {
+			t.Error("expected the transition's own headers to be left alone")
+		}
+	})
+
+	t.Run("generic json template", func(t *testing.T) {
+		tmpl := `{"event": {{json .Event}}, "target_id": {{json .Target.ID}}, "result": {{json .Result}}}`
+
+		payload, err := RenderPayload(tmpl, transition)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		var body struct {
+			Event    string             `json:"event"`
+			TargetID string             `json:"target_id"`
+			Result   models.CheckResult `json:"result"`
+		}
+		if err := json.Unmarshal(payload, &body); err != nil {
+			t.Fatalf("payload is not valid JSON: %v (%s)", err, payload)
+		}
+
+		if body.Event != "target.down" {
+			t.Errorf("expected event target.down, got %q", body.Event)
+		}
+
+		if body.TargetID != "t_123" {
+			t.Errorf("expected target_id t_123, got %q", body.TargetID)
+		}
+
+		if body.Result.Error == nil || *body.Result.Error != "server error: 503" {
+			t.Errorf("expected result error to be rendered, got %v", body.Result.Error)
+		}
+	})
+
+	t.Run("empty template uses default payload", func(t *testing.T) {
+		payload, err := RenderPayload("", transition)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		var body Transition
+		if err := json.Unmarshal(payload, &body); err != nil {
+			t.Fatalf("payload is not valid JSON: %v", err)
+		}
+
+		if body.Target.ID != "t_123" || body.To != StateDown {
+			t.Errorf("unexpected default payload: %s", payload)
+		}
+	})
+}
+
+func TestValidateTemplate(t *testing.T) {
+	tests := []struct {
+		template string
+		hasError bool
+	}{
+		{"", false},
+		{`{"text": "{{.Target.URL}} is {{.To}}"}`, false},
+		{`{{json .Result}}`, false},
+		{`{"text": "{{.Target.URL"}`, true},    // parse error
+		{`{"text": "{{.Target.Nope}}"}`, true}, // unknown field
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.template, func(t *testing.T) {
+			err := ValidateTemplate(tt.template)
+			if tt.hasError && err == nil {
+				t.Errorf("expected error for template %q", tt.template)
+			}
+			if !tt.hasError && err != nil {
+				t.Errorf("unexpected error for template %q: %v", tt.template, err)
+			}
+		})
+	}
+}
+
+func TestNotify(t *testing.T) {
+	store := setupTestStore(t)
+
+	target, _, err := store.CreateTarget(context.Background(), "https://example.com", "https://example.com", nil)
+	if err != nil {
+		t.Fatalf("failed to create target: %v", err)
+	}
+
+	received := make(chan string, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received <- string(body)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	if _, err := store.CreateWebhook(context.Background(), server.URL, &target.ID, `{{.Target.ID}} {{.To}}`); err != nil {
+		t.Fatalf("failed to create webhook: %v", err)
+	}
+
+	transition := testTransition()
+	transition.Target = *target
+
+	NewNotifier(store, time.Second, false).Notify(context.Background(), transition)
+
+	select {
+	case body := <-received:
+		if body != target.ID+" down" {
+			t.Errorf("unexpected webhook body %q", body)
+		}
+	default:
+		t.Error("expected webhook to be delivered")
+	}
+}
+
+func TestEnqueue(t *testing.T) {
+	store := setupTestStore(t)
+
+	target, _, err := store.CreateTarget(context.Background(), "https://example.com", "https://example.com", nil)
+	if err != nil {
+		t.Fatalf("failed to create target: %v", err)
+	}
+
+	release := make(chan struct{})
+	received := make(chan string, QueueSize+2)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received <- string(body)
+		<-release
+	}))
+	defer server.Close()
+
+	if _, err := store.CreateWebhook(context.Background(), server.URL, &target.ID, `{{.To}}`); err != nil {
+		t.Fatalf("failed to create webhook: %v", err)
+	}
+	transition := testTransition()
+	transition.Target = *target
+
+	notifier := NewNotifier(store, 5*time.Second, false)
+
+	// The receiver holds the first delivery, but queueing doesn't wait on it
+	start := time.Now()
+	if !notifier.Enqueue(transition) {
+		t.Fatal("expected the transition to be queued")
+	}
+	<-received
+	for i := 0; i < QueueSize; i++ {
+		if !notifier.Enqueue(transition) {
+			t.Fatalf("expected transition %d to fit in the queue", i+1)
+		}
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("expected queueing not to wait for the receiver, took %s", elapsed)
+	}
+	if notifier.Enqueue(transition) {
+		t.Error("expected a transition past a full queue to be dropped")
+	}
+
+	// Closing waits for the queue to drain
+	close(release)
+	if err := notifier.Close(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if n := len(received); n != QueueSize {
+		t.Errorf("expected the %d queued transitions to be delivered, got %d", QueueSize, n)
+	}
+	if notifier.Enqueue(transition) {
+		t.Error("expected a closed notifier to drop transitions")
+	}
+
+	t.Run("close deadline cancels deliveries", func(t *testing.T) {
+		blocked := make(chan struct{})
+		slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			<-blocked
+		}))
+		defer slow.Close()
+		defer close(blocked)
+
+		store := setupTestStore(t)
+		if _, err := store.CreateWebhook(context.Background(), slow.URL, nil, ""); err != nil {
+			t.Fatalf("failed to create webhook: %v", err)
+		}
+		notifier := NewNotifier(store, 5*time.Second, false)
+		notifier.Enqueue(testTransition())
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		if err := notifier.Close(ctx); err != context.DeadlineExceeded {
+			t.Errorf("expected %v, got %v", context.DeadlineExceeded, err)
+		}
+	})
+}
+
+func TestNotifyBlockPrivate(t *testing.T) {
+	store := setupTestStore(t)
+
+	delivered := make(chan struct{}, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		delivered <- struct{}{}
+	}))
+	defer server.Close()
+
+	if _, err := store.CreateWebhook(context.Background(), server.URL, nil, ""); err != nil {
+		t.Fatalf("failed to create webhook: %v", err)
+	}
+
+	NewNotifier(store, time.Second, true).Notify(context.Background(), testTransition())
+
+	select {
+	case <-delivered:
+		t.Error("expected the webhook on a loopback address to be refused")
+	default:
+	}
+}